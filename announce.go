@@ -1,156 +1,266 @@
 package main
 
 import (
-	"bytes"
-	"encoding/json"
 	"fmt"
-	"log"
-	"net/http"
 	"strings"
+	"time"
 )
 
-// sendHAAnnouncement sends a TTS message via Home Assistant.
-func sendHAAnnouncement(message string) {
-	haURL := getSetting("ha_url")
-	haToken := getSetting("ha_token")
-	haEntity := getSetting("ha_media_player")
+// announceTitle heads announcements on backends that show a title.
+const announceTitle = "Star Tracker"
 
-	go func() {
-		payload := map[string]interface{}{
-			"entity_id": haEntity,
-			"message":   message,
-		}
-		body, _ := json.Marshal(payload)
-
-		url := strings.TrimRight(haURL, "/")
-		req, err := http.NewRequest("POST", url, bytes.NewReader(body))
-		if err != nil {
-			log.Printf("HA announce error: %v", err)
-			return
-		}
-		req.Header.Set("Authorization", "Bearer "+haToken)
-		req.Header.Set("Content-Type", "application/json")
-
-		resp, err := http.DefaultClient.Do(req)
-		if err != nil {
-			log.Printf("HA announce error: %v", err)
-			return
-		}
-		resp.Body.Close()
-		if resp.StatusCode >= 300 {
-			log.Printf("HA announce returned status %d", resp.StatusCode)
-		}
-	}()
+// announceLang returns the language announcements about user are rendered
+// in: their own preference, or else the family's ha_lang setting.
+func announceLang(user *User) string {
+	if validLanguage(user.Lang) {
+		return user.Lang
+	}
+	lang := getFamilySetting(user.FamilyID, "ha_lang")
+	if !validLanguage(lang) {
+		lang = defaultLanguage
+	}
+	return lang
 }
 
-func haEnabled() bool {
-	if getSetting("ha_enabled") != "1" {
-		return false
-	}
-	haURL := getSetting("ha_url")
-	haToken := getSetting("ha_token")
-	haEntity := getSetting("ha_media_player")
-	return haURL != "" && haToken != "" && haEntity != ""
+// announceSubject is what an announcement is about.
+type announceSubject struct {
+	User       *User
+	Event      string
+	ReasonID   *int
+	ReasonText string
+	Count      int // stars awarded or taken away, never negative
+	CurrencyID int
+	RewardID   int
+	RewardText string // used when the reward has no name of its own
+	Pending    int    // change to the balance not recorded yet, for samples
 }
 
-func announceStarIfEnabled(username string, reasonID *int, reasonText string, stars int) {
-	if !haEnabled() {
+func announceStarIfEnabled(username string, reasonID *int, reasonText string, stars, currencyID int) {
+	// Announcements go to the kid's own family
+	user, err := getUserByUsername(username)
+	if err != nil || !announcementsEnabled(user.FamilyID) {
 		return
 	}
 
-	lang := getSetting("ha_lang")
-	if lang == "" {
-		lang = "en"
+	s := announceSubject{User: user, Event: announceAward, ReasonID: reasonID, ReasonText: reasonText, Count: stars, CurrencyID: currencyID}
+	if stars < 0 {
+		s.Event = announcePenalty
+		s.Count = -stars
 	}
+	announce(s)
+}
 
-	displayName := username
+func announceRedemptionIfEnabled(username string, rewardID int, isAdmin bool) {
+	if isAdmin {
+		return
+	}
 	user, err := getUserByUsername(username)
-	if err == nil {
-		displayName = getUserText(user.ID, lang)
+	if err != nil || !announcementsEnabled(user.FamilyID) {
+		return
 	}
-
-	displayReason := getReasonText(reasonID, reasonText, lang)
-
-	absStars := stars
-	if absStars < 0 {
-		absStars = -absStars
+	reward, err := getRewardByID(rewardID)
+	if err != nil {
+		return
 	}
-
-	message := formatAnnounceMessage(lang, displayName, displayReason, stars, absStars)
-	sendHAAnnouncement(message)
+	announce(announceSubject{User: user, Event: announceRedemption, RewardID: reward.ID, CurrencyID: reward.CurrencyID})
 }
 
-func announceRedemptionIfEnabled(username string, rewardID int, isAdmin bool) {
-	if isAdmin {
+func announceGoalIfEnabled(username string, rewardID int) {
+	user, err := getUserByUsername(username)
+	if err != nil || !announcementsEnabled(user.FamilyID) {
 		return
 	}
-	if !haEnabled() {
+	reward, err := getRewardByID(rewardID)
+	if err != nil {
 		return
 	}
+	announce(announceSubject{User: user, Event: announceGoal, RewardID: reward.ID, CurrencyID: reward.CurrencyID})
+}
 
-	lang := getSetting("ha_lang")
-	if lang == "" {
-		lang = "en"
+// announce renders s from the family's template and queues it for the
+// backends routed for its event, unless the user has opted out of it.
+func announce(s announceSubject) {
+	if userAnnounceMuted(s.User, s.Event) {
+		return
 	}
+	lang := announceLang(s.User)
+	queueAnnouncement(&QueuedAnnouncement{
+		FamilyID: s.User.FamilyID,
+		Event:    s.Event,
+		Username: s.User.Username,
+		Lang:     lang,
+		Template: announceTemplate(s.User.FamilyID, s.Event, lang),
+		Values:   announceValues(s, lang),
+	})
+}
 
-	displayName := username
-	user, err := getUserByUsername(username)
-	if err == nil {
-		displayName = getUserText(user.ID, lang)
+// renderAnnouncement fills tmpl in for s.
+func renderAnnouncement(s announceSubject, tmpl, lang string) Announcement {
+	return Announcement{
+		Event:    s.Event,
+		Title:    announceTitle,
+		Message:  fillPlaceholders(tmpl, announceValues(s, lang)),
+		Lang:     lang,
+		Username: s.User.Username,
 	}
+}
 
-	displayReward := getRewardText(rewardID, lang)
+// announceValues are the placeholders a template can use: {name}, {reason},
+// {count}, {unit}, {currency}, {balance} (after the change), {reward}, and
+// {goal} and {remaining} for the kid's savings goal.
+func announceValues(s announceSubject, lang string) map[string]string {
+	values := map[string]string{
+		"name":      getUserText(s.User.ID, lang),
+		"reason":    getReasonText(s.ReasonID, s.ReasonText, lang),
+		"count":     numWord(s.Count, lang),
+		"unit":      announceUnit(s.CurrencyID, s.Count, lang),
+		"currency":  getCurrencyText(s.CurrencyID, lang),
+		"reward":    s.RewardText,
+		"goal":      "",
+		"remaining": "",
+	}
+	if s.RewardID > 0 {
+		if text := getRewardText(s.RewardID, lang); text != "" {
+			values["reward"] = text
+		}
+	}
+	balance, _ := getUserBalance(s.User.ID, s.CurrencyID)
+	values["balance"] = numWord(balance+s.Pending, lang)
+	if g := goalProgress(s.User.ID, time.Now()); g != nil {
+		values["goal"] = getRewardText(g.RewardID, lang)
+		values["remaining"] = numWord(g.Remaining, lang)
+	}
+	return values
+}
 
-	message := formatRedemptionMessage(lang, displayName, displayReward)
-	sendHAAnnouncement(message)
+// announceTemplateKey is the family setting holding a custom template for an
+// event in a language.
+func announceTemplateKey(event, lang string) string {
+	return "announce_template_" + event + "_" + lang
 }
 
-var numberWords = map[string][]string{
-	"en": {"zero", "one", "two", "three", "four", "five", "six", "seven",
-		"eight", "nine", "ten", "eleven", "twelve", "thirteen", "fourteen",
-		"fifteen", "sixteen", "seventeen", "eighteen", "nineteen", "twenty"},
-	"zh-CN": {"零", "一", "二", "三", "四", "五", "六", "七", "八", "九",
-		"十", "十一", "十二", "十三", "十四", "十五", "十六", "十七", "十八", "十九", "二十"},
-	"zh-TW": {"零", "一", "二", "三", "四", "五", "六", "七", "八", "九",
-		"十", "十一", "十二", "十三", "十四", "十五", "十六", "十七", "十八", "十九", "二十"},
+// announceTemplateKeys lists the template settings of every event and language.
+func announceTemplateKeys() []string {
+	var keys []string
+	for _, event := range announceEvents {
+		for _, lang := range getLanguages() {
+			keys = append(keys, announceTemplateKey(event, lang.Code))
+		}
+	}
+	return keys
 }
 
-func numWord(n int, lang string) string {
-	words, ok := numberWords[lang]
-	if ok && n >= 0 && n < len(words) {
-		return words[n]
+// announceTemplate returns the family's template for an event, or the
+// language's own sentence when the family hasn't written one.
+func announceTemplate(familyID int, event, lang string) string {
+	if tmpl := strings.TrimSpace(getFamilySetting(familyID, announceTemplateKey(event, lang))); tmpl != "" {
+		return tmpl
 	}
-	return fmt.Sprintf("%d", n)
+	return defaultAnnounceTemplate(event, lang)
+}
+
+// defaultAnnounceTemplate is the sentence a language file gives an event.
+func defaultAnnounceTemplate(event, lang string) string {
+	texts := getLanguage(lang).Announce
+	switch event {
+	case announcePenalty:
+		return texts.Penalty
+	case announceRedemption:
+		return texts.Redemption
+	case announceGoal:
+		return texts.Goal
+	}
+	return texts.Award
+}
+
+// announceTemplateView is one event's templates for the admin page.
+type announceTemplateView struct {
+	Event     string
+	Templates map[string]string // custom templates by language
+	Defaults  map[string]string // the language files' sentences
+}
+
+func announceTemplateViews(familyID int) []announceTemplateView {
+	var views []announceTemplateView
+	for _, event := range announceEvents {
+		v := announceTemplateView{Event: event, Templates: map[string]string{}, Defaults: map[string]string{}}
+		for _, lang := range getLanguages() {
+			v.Templates[lang.Code] = getFamilySetting(familyID, announceTemplateKey(event, lang.Code))
+			v.Defaults[lang.Code] = defaultAnnounceTemplate(event, lang.Code)
+		}
+		views = append(views, v)
+	}
+	return views
 }
 
-func formatAnnounceMessage(lang, name, reason string, stars, absStars int) string {
-	n := numWord(absStars, lang)
-	switch lang {
-	case "zh-CN":
-		if stars > 0 {
-			return fmt.Sprintf("%s因为%s获得了%s颗星星！", name, reason, n)
+// sampleAnnounceSubject makes up an event for previews and test announcements
+// from the family's first kid, reason and reward, so the sample reads like a
+// real one. user stands in when the family has no kids yet.
+func sampleAnnounceSubject(familyID int, user *User, event, lang string) announceSubject {
+	s := announceSubject{User: user, Event: event, Count: 2}
+	if users, err := getAllUsers(familyID); err == nil {
+		for i := range users {
+			if !users[i].IsAdmin {
+				s.User = &users[i]
+				break
+			}
 		}
-		return fmt.Sprintf("%s因为%s失去了%s颗星星！", name, reason, n)
-	case "zh-TW":
-		if stars > 0 {
-			return fmt.Sprintf("%s因為%s獲得了%s顆星星！", name, reason, n)
+	}
+	switch event {
+	case announceAward, announcePenalty:
+		s.ReasonText = translate(lang, "announce_sample_reason")
+		if reasons, err := getReasons(familyID); err == nil && len(reasons) > 0 {
+			s.ReasonID = &reasons[0].ID
+			s.CurrencyID = reasons[0].CurrencyID
+			if reasons[0].Stars != 0 {
+				s.Count = reasons[0].Stars
+				if s.Count < 0 {
+					s.Count = -s.Count
+				}
+			}
 		}
-		return fmt.Sprintf("%s因為%s失去了%s顆星星！", name, reason, n)
-	default: // en
-		if stars > 0 {
-			return fmt.Sprintf("%s got %s stars for %s!", name, n, reason)
+		s.Pending = s.Count
+		if event == announcePenalty {
+			s.Pending = -s.Count
+		}
+	default:
+		s.RewardText = translate(lang, "announce_sample_reward")
+		if rewards, err := getRewardsList(familyID); err == nil && len(rewards) > 0 {
+			s.RewardID = rewards[0].ID
+			s.CurrencyID = rewards[0].CurrencyID
+			if event == announceRedemption {
+				s.Pending = -rewards[0].Cost
+			}
 		}
-		return fmt.Sprintf("%s lost %s stars for %s!", name, n, reason)
 	}
+	return s
 }
 
-func formatRedemptionMessage(lang, name, reward string) string {
-	switch lang {
-	case "zh-CN":
-		return fmt.Sprintf("%s 兑换了%s！", name, reward)
-	case "zh-TW":
-		return fmt.Sprintf("%s 兌換了%s！", name, reward)
-	default:
-		return fmt.Sprintf("%s redeemed %s!", name, reward)
+// numWord spells out n in lang, or writes its digits when the language has
+// no speller.
+func numWord(n int, lang string) string {
+	l := getLanguage(lang)
+	if n >= 0 && n < len(l.NumberWords) {
+		return l.NumberWords[n]
+	}
+	if speller, ok := numberSpellers[l.Numbers]; ok {
+		return spellNumber(n, speller)
+	}
+	return fmt.Sprintf("%d", n)
+}
+
+// announceUnit names what a count of a currency is counted in: "stars" or the
+// currency's name, with any measure word the language puts in front. A count
+// of one star takes the singular where the language has one.
+func announceUnit(currencyID, count int, lang string) string {
+	texts := getLanguage(lang).Announce
+	unit := texts.CurrencyUnit
+	name := getCurrencyText(currencyID, lang)
+	if currencyID == 0 {
+		unit = texts.StarUnit
+		if count == 1 {
+			name = texts.Star
+		}
 	}
+	return fillPlaceholders(unit, map[string]string{"currency": name})
 }
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+)
+
+// Announcements go out through an outbox in SQLite, one delivery per backend
+// and target. Failed attempts are retried with exponential backoff, but only
+// for a minute or so: an announcement that comes much later than what it is
+// about does more to confuse than to inform.
+const (
+	announceMaxAttempts  = 5
+	announceBaseBackoff  = 5 * time.Second
+	announceMaxBackoff   = time.Minute
+	announceLogRetention = 7 * 24 * time.Hour
+)
+
+// announceClient bounds how long a backend may take to answer.
+var announceClient = &http.Client{Timeout: 10 * time.Second}
+
+// announceWake nudges the delivery worker when announcements are dispatched.
+var announceWake = make(chan struct{}, 1)
+
+func wakeAnnouncementWorker() {
+	select {
+	case announceWake <- struct{}{}:
+	default:
+	}
+}
+
+func runAnnouncementDeliveryWorker() {
+	ticker := time.NewTicker(announcePollInterval)
+	defer ticker.Stop()
+	for {
+		deliverDueAnnouncementDeliveries()
+		pruneAnnouncementDeliveries(time.Now().Add(-announceLogRetention))
+		select {
+		case <-ticker.C:
+		case <-announceWake:
+		}
+	}
+}
+
+func deliverDueAnnouncementDeliveries() {
+	deliveries, err := getDueAnnouncementDeliveries(time.Now(), 50)
+	if err != nil {
+		log.Printf("Failed to load announcement deliveries: %v", err)
+		return
+	}
+	for i := range deliveries {
+		attemptAnnouncementDelivery(&deliveries[i])
+	}
+}
+
+func attemptAnnouncementDelivery(d *AnnouncementDelivery) {
+	statusCode, sendErr := sendAnnouncementDelivery(d)
+	d.Attempts++
+	var err error
+	switch {
+	case sendErr == nil:
+		err = updateAnnouncementDelivery(d.ID, deliveryDelivered, d.Attempts, statusCode, "", nil)
+	case d.Attempts >= announceMaxAttempts:
+		log.Printf("%s announcement %d failed after %d attempts: %v", d.Kind, d.ID, d.Attempts, sendErr)
+		err = updateAnnouncementDelivery(d.ID, deliveryFailed, d.Attempts, statusCode, sendErr.Error(), nil)
+	default:
+		next := time.Now().Add(exponentialBackoff(d.Attempts, announceBaseBackoff, announceMaxBackoff))
+		err = updateAnnouncementDelivery(d.ID, deliveryPending, d.Attempts, statusCode, sendErr.Error(), &next)
+	}
+	if err != nil {
+		log.Printf("Failed to record announcement delivery %d: %v", d.ID, err)
+	}
+}
+
+// sendAnnouncementDelivery sends d with the backend's current settings and
+// returns the HTTP status it answered with, if any.
+func sendAnnouncementDelivery(d *AnnouncementDelivery) (int, error) {
+	b := announcerBackendByKind(d.Kind)
+	if b == nil {
+		return 0, fmt.Errorf("unknown backend %s", d.Kind)
+	}
+	cfg, ok := activeAnnouncerConfig(d.FamilyID, b, "")
+	if !ok {
+		return 0, fmt.Errorf("%s is switched off or incomplete", d.Kind)
+	}
+	if d.Target != "" {
+		cfg[b.Target] = d.Target
+	}
+	return b.New(cfg).Announce(Announcement{
+		Event:    d.Event,
+		Title:    d.Title,
+		Message:  d.Message,
+		Lang:     d.Lang,
+		Username: d.Username,
+	})
+}
+
+// failedAnnouncements returns the family's backends and targets whose last
+// announcement didn't get through, for the dashboard's warning.
+func failedAnnouncements(familyID int) []AnnouncementDelivery {
+	latest, _ := getLatestAnnouncementAttempts(familyID)
+	var failed []AnnouncementDelivery
+	for _, d := range latest {
+		if d.LastError != "" {
+			failed = append(failed, d)
+		}
+	}
+	return failed
+}
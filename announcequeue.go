@@ -0,0 +1,298 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Announcements wait in a queue before they go out: for a few seconds, so
+// that awarding several kids at once is announced once, and through the
+// family's quiet hours, so nothing is said at night. The queue is kept in
+// SQLite, so announcements held overnight survive a restart.
+const (
+	announceDefaultBatch = 5 * time.Second
+	announceMaxBatch     = 5 * time.Minute
+	announcePollInterval = time.Second
+)
+
+// startAnnouncements starts the workers that dispatch queued announcements
+// and deliver them.
+func startAnnouncements() {
+	go runAnnouncementWorker()
+	go runAnnouncementDeliveryWorker()
+}
+
+func runAnnouncementWorker() {
+	ticker := time.NewTicker(announcePollInterval)
+	defer ticker.Stop()
+	for range ticker.C {
+		deliverDueAnnouncements(time.Now())
+	}
+}
+
+// announceBatchWindow is how long a family's announcements wait for others
+// to go out with them.
+func announceBatchWindow(familyID int) time.Duration {
+	seconds, err := strconv.Atoi(getFamilySetting(familyID, "announce_batch_seconds"))
+	if err != nil || seconds < 0 {
+		return announceDefaultBatch
+	}
+	return time.Duration(seconds) * time.Second
+}
+
+// queueAnnouncement holds q for the family's batch window, or until quiet
+// hours end. An announcement made while a batch is waiting joins it.
+func queueAnnouncement(q *QueuedAnnouncement) {
+	now := time.Now()
+	q.DeliverAt = now.Add(announceBatchWindow(q.FamilyID))
+	if until, quiet := quietHoursUntil(q.FamilyID, now); quiet {
+		q.DeliverAt = until
+	} else if next := pendingAnnouncementBatch(q.FamilyID, now); next != nil && !next.After(q.DeliverAt) {
+		q.DeliverAt = *next
+	}
+	if err := addQueuedAnnouncement(q); err != nil {
+		log.Printf("Failed to queue %s announcement: %v", q.Event, err)
+	}
+}
+
+// rescheduleAnnouncements moves a family's held announcements to the end of
+// its quiet hours after they change, or sends them now if it isn't quiet.
+func rescheduleAnnouncements(familyID int) {
+	now := time.Now()
+	until, quiet := quietHoursUntil(familyID, now)
+	if !quiet {
+		until = now
+	}
+	if err := holdQueuedAnnouncements(familyID, until); err != nil {
+		log.Printf("Failed to reschedule announcements for family %d: %v", familyID, err)
+	}
+}
+
+func deliverDueAnnouncements(now time.Time) {
+	families, err := getDueAnnouncementFamilies(now)
+	if err != nil {
+		log.Printf("Failed to load queued announcements: %v", err)
+		return
+	}
+	for _, familyID := range families {
+		// Quiet hours may have started, or been set, since these were queued
+		if until, quiet := quietHoursUntil(familyID, now); quiet {
+			if err := holdQueuedAnnouncements(familyID, until); err != nil {
+				log.Printf("Failed to hold announcements for family %d: %v", familyID, err)
+			}
+			continue
+		}
+		queued, err := getQueuedAnnouncements(familyID)
+		if err != nil {
+			log.Printf("Failed to load queued announcements for family %d: %v", familyID, err)
+			continue
+		}
+		var due []QueuedAnnouncement
+		var ids []int
+		for _, q := range queued {
+			if !q.DeliverAt.After(now) {
+				due = append(due, q)
+				ids = append(ids, q.ID)
+			}
+		}
+		// Dropped before sending, so a failure can't announce them twice
+		if err := deleteQueuedAnnouncements(ids); err != nil {
+			log.Printf("Failed to dequeue announcements for family %d: %v", familyID, err)
+			continue
+		}
+		dispatchAnnouncements(familyID, due)
+	}
+}
+
+// batchAnnouncements merges queued announcements into one per language.
+// Announcements that differ only in who they are about share a sentence:
+// "theo and ray got two stars for tidying up!".
+func batchAnnouncements(queued []QueuedAnnouncement) []Announcement {
+	type sentence struct {
+		QueuedAnnouncement
+		names []string
+	}
+	var langs []string
+	sentences := make(map[string][]*sentence)
+	for _, q := range queued {
+		if _, ok := sentences[q.Lang]; !ok {
+			langs = append(langs, q.Lang)
+		}
+		merged := false
+		for _, s := range sentences[q.Lang] {
+			if sameSentence(s.QueuedAnnouncement, q) && !containsString(s.names, q.Values["name"]) {
+				s.names = append(s.names, q.Values["name"])
+				merged = true
+				break
+			}
+		}
+		if !merged {
+			sentences[q.Lang] = append(sentences[q.Lang], &sentence{q, []string{q.Values["name"]}})
+		}
+	}
+
+	var batches []Announcement
+	for _, lang := range langs {
+		var texts, events, usernames []string
+		for _, s := range sentences[lang] {
+			values := make(map[string]string, len(s.Values))
+			for k, v := range s.Values {
+				values[k] = v
+			}
+			values["name"] = joinNames(s.names, lang)
+			texts = append(texts, fillPlaceholders(s.Template, values))
+			if !containsString(events, s.Event) {
+				events = append(events, s.Event)
+			}
+		}
+		for _, q := range queued {
+			if q.Lang == lang && !containsString(usernames, q.Username) {
+				usernames = append(usernames, q.Username)
+			}
+		}
+		event := announceBatch
+		if len(events) == 1 {
+			event = events[0]
+		}
+		batches = append(batches, Announcement{
+			Event:    event,
+			Title:    announceTitle,
+			Message:  strings.Join(texts, " "),
+			Lang:     lang,
+			Username: strings.Join(usernames, ","),
+		})
+	}
+	return batches
+}
+
+// sameSentence reports whether b says what a does, about someone else: the
+// same template with the same values for every placeholder but {name}.
+func sameSentence(a, b QueuedAnnouncement) bool {
+	if a.Event != b.Event || a.Template != b.Template {
+		return false
+	}
+	for key, value := range a.Values {
+		if key != "name" && strings.Contains(a.Template, "{"+key+"}") && b.Values[key] != value {
+			return false
+		}
+	}
+	return true
+}
+
+// joinNames lists names the way lang does: "theo, ray and mia".
+func joinNames(names []string, lang string) string {
+	if len(names) == 0 {
+		return ""
+	}
+	texts := getLanguage(lang).Announce
+	list := names[0]
+	for i := 1; i < len(names)-1; i++ {
+		list = fillPlaceholders(texts.List, map[string]string{"first": list, "rest": names[i]})
+	}
+	if len(names) > 1 {
+		list = fillPlaceholders(texts.And, map[string]string{"first": list, "last": names[len(names)-1]})
+	}
+	return list
+}
+
+func containsString(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+func quietHoursKey(weekday time.Weekday) string {
+	return fmt.Sprintf("announce_quiet_%d", weekday)
+}
+
+// parseQuietHours parses a "22:00-07:00" window. A window that ends before it
+// starts runs past midnight.
+func parseQuietHours(window string) (startHour, startMinute, endHour, endMinute int, ok bool) {
+	from, until, found := strings.Cut(window, "-")
+	if !found || from == "" || until == "" {
+		return 0, 0, 0, 0, false
+	}
+	startHour, startMinute, ok = parseDueTime(from)
+	if !ok {
+		return 0, 0, 0, 0, false
+	}
+	endHour, endMinute, ok = parseDueTime(until)
+	return startHour, startMinute, endHour, endMinute, ok
+}
+
+// quietWindowEnd returns when the quiet hours t falls in end. The window of
+// the day before counts too, since it may run past midnight.
+func quietWindowEnd(familyID int, t time.Time) (time.Time, bool) {
+	for _, offset := range []int{-1, 0} {
+		day := t.AddDate(0, 0, offset)
+		sh, sm, eh, em, ok := parseQuietHours(getFamilySetting(familyID, quietHoursKey(day.Weekday())))
+		if !ok {
+			continue
+		}
+		y, m, d := day.Date()
+		start := time.Date(y, m, d, sh, sm, 0, 0, t.Location())
+		end := time.Date(y, m, d, eh, em, 0, 0, t.Location())
+		if !end.After(start) {
+			end = end.AddDate(0, 0, 1)
+		}
+		if !t.Before(start) && t.Before(end) {
+			return end, true
+		}
+	}
+	return time.Time{}, false
+}
+
+// quietHoursUntil reports whether t is in the family's quiet hours and when
+// they are over, following windows that run into each other.
+func quietHoursUntil(familyID int, t time.Time) (time.Time, bool) {
+	until, quiet := t, false
+	for i := 0; i < 8; i++ {
+		end, ok := quietWindowEnd(familyID, until)
+		if !ok {
+			break
+		}
+		until, quiet = end, true
+	}
+	return until, quiet
+}
+
+// quietHoursView is one day's quiet hours for the admin page.
+type quietHoursView struct {
+	Weekday    int
+	Start, End string
+}
+
+// quietHoursViews lists the week's quiet hours, Monday first.
+func quietHoursViews(familyID int) []quietHoursView {
+	var views []quietHoursView
+	for i := 1; i <= 7; i++ {
+		weekday := time.Weekday(i % 7)
+		v := quietHoursView{Weekday: int(weekday)}
+		if from, until, ok := strings.Cut(getFamilySetting(familyID, quietHoursKey(weekday)), "-"); ok {
+			v.Start, v.End = from, until
+		}
+		views = append(views, v)
+	}
+	return views
+}
+
+// heldAnnouncementView is a queued announcement as shown on the admin page.
+type heldAnnouncementView struct {
+	Message   string
+	DeliverAt time.Time
+}
+
+func heldAnnouncementViews(familyID int) []heldAnnouncementView {
+	queued, _ := getQueuedAnnouncements(familyID)
+	var views []heldAnnouncementView
+	for _, q := range queued {
+		views = append(views, heldAnnouncementView{fillPlaceholders(q.Template, q.Values), q.DeliverAt})
+	}
+	return views
+}
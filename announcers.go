@@ -0,0 +1,621 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+)
+
+// Announcement events that backends can be routed to.
+const (
+	announceAward      = "award"
+	announcePenalty    = "penalty"
+	announceRedemption = "redemption"
+	announceGoal       = "goal"
+)
+
+var announceEvents = []string{announceAward, announcePenalty, announceRedemption, announceGoal}
+
+// announceBatch is the event of a batch that mixes several events.
+const announceBatch = "batch"
+
+// Announcement is a rendered message ready to be delivered.
+type Announcement struct {
+	Event    string
+	Title    string
+	Message  string
+	Lang     string
+	Username string
+}
+
+// Announcer delivers announcements to one notification backend. Announce
+// returns the HTTP status the backend answered with, or 0 if it has none.
+type Announcer interface {
+	Announce(a Announcement) (int, error)
+}
+
+// announcerField is a setting a backend needs.
+type announcerField struct {
+	Name        string
+	SettingKey  string
+	Placeholder string
+	Secret      bool
+	Optional    bool
+}
+
+// announcerBackend describes a configurable backend and builds its Announcer
+// from the current settings. Target names the field a user can point
+// elsewhere, such as the speaker in their own room.
+type announcerBackend struct {
+	Kind   string
+	Fields []announcerField
+	Target string
+	New    func(cfg map[string]string) Announcer
+}
+
+func announcerBackendByKind(kind string) *announcerBackend {
+	for i := range announcerBackends {
+		if announcerBackends[i].Kind == kind {
+			return &announcerBackends[i]
+		}
+	}
+	return nil
+}
+
+func announcerSettingKey(kind, name string) string {
+	return "announce_" + kind + "_" + name
+}
+
+// announcerBackends lists every backend in the order shown on the admin page.
+// Home Assistant TTS keeps its original settings keys.
+var announcerBackends = []announcerBackend{
+	{
+		Kind: "ha_tts",
+		Fields: []announcerField{
+			{Name: "url", SettingKey: "ha_url", Placeholder: "https://home.example.com/api/services/tts/microsoft_say"},
+			{Name: "token", SettingKey: "ha_token", Placeholder: "eyJ...", Secret: true},
+			{Name: "media_player", SettingKey: "ha_media_player", Placeholder: "media_player.living_room"},
+		},
+		Target: "media_player",
+		New: func(cfg map[string]string) Announcer {
+			return &haTTSAnnouncer{url: cfg["url"], token: cfg["token"], entity: cfg["media_player"]}
+		},
+	},
+	{
+		Kind: "ha_notify",
+		Fields: []announcerField{
+			{Name: "url", SettingKey: "announce_ha_notify_url", Placeholder: "https://home.example.com"},
+			{Name: "token", SettingKey: "announce_ha_notify_token", Placeholder: "eyJ...", Secret: true},
+			{Name: "service", SettingKey: "announce_ha_notify_service", Placeholder: "mobile_app_phone", Optional: true},
+		},
+		Target: "service",
+		New: func(cfg map[string]string) Announcer {
+			return &haNotifyAnnouncer{url: cfg["url"], token: cfg["token"], service: cfg["service"]}
+		},
+	},
+	{
+		Kind: "ntfy",
+		Fields: []announcerField{
+			{Name: "url", SettingKey: "announce_ntfy_url", Placeholder: "https://ntfy.sh"},
+			{Name: "topic", SettingKey: "announce_ntfy_topic", Placeholder: "family-stars"},
+			{Name: "token", SettingKey: "announce_ntfy_token", Placeholder: "tk_...", Secret: true, Optional: true},
+		},
+		Target: "topic",
+		New: func(cfg map[string]string) Announcer {
+			return &ntfyAnnouncer{url: cfg["url"], topic: cfg["topic"], token: cfg["token"]}
+		},
+	},
+	{
+		Kind: "gotify",
+		Fields: []announcerField{
+			{Name: "url", SettingKey: "announce_gotify_url", Placeholder: "https://gotify.example.com"},
+			{Name: "token", SettingKey: "announce_gotify_token", Placeholder: "App token", Secret: true},
+		},
+		New: func(cfg map[string]string) Announcer {
+			return &gotifyAnnouncer{url: cfg["url"], token: cfg["token"]}
+		},
+	},
+	{
+		Kind: "mqtt",
+		Fields: []announcerField{
+			{Name: "broker", SettingKey: "announce_mqtt_broker", Placeholder: "tcp://192.168.1.10:1883"},
+			{Name: "topic", SettingKey: "announce_mqtt_topic", Placeholder: "star-app/announce"},
+			{Name: "username", SettingKey: "announce_mqtt_username", Optional: true},
+			{Name: "password", SettingKey: "announce_mqtt_password", Secret: true, Optional: true},
+		},
+		Target: "topic",
+		New: func(cfg map[string]string) Announcer {
+			return &mqttAnnouncer{broker: cfg["broker"], topic: cfg["topic"], username: cfg["username"], password: cfg["password"]}
+		},
+	},
+	{
+		Kind: "http",
+		Fields: []announcerField{
+			{Name: "url", SettingKey: "announce_http_url", Placeholder: "https://example.com/announce"},
+			{Name: "token", SettingKey: "announce_http_token", Placeholder: "Bearer token", Secret: true, Optional: true},
+		},
+		New: func(cfg map[string]string) Announcer {
+			return &httpAnnouncer{url: cfg["url"], token: cfg["token"]}
+		},
+	},
+}
+
+// announcerConfig reads a backend's field values from the family's settings.
+func announcerConfig(familyID int, b *announcerBackend) map[string]string {
+	cfg := make(map[string]string)
+	for _, f := range b.Fields {
+		cfg[f.Name] = strings.TrimSpace(getFamilySetting(familyID, f.SettingKey))
+	}
+	return cfg
+}
+
+// announcerConfigured reports whether every required field has a value.
+func announcerConfigured(b *announcerBackend, cfg map[string]string) bool {
+	for _, f := range b.Fields {
+		if !f.Optional && cfg[f.Name] == "" {
+			return false
+		}
+	}
+	return true
+}
+
+// announcerEnabled reports whether a backend is switched on. Home Assistant TTS
+// was the only backend before the flag existed, so it is on until saved otherwise.
+func announcerEnabled(familyID int, b *announcerBackend) bool {
+	enabled := getFamilySetting(familyID, announcerSettingKey(b.Kind, "enabled"))
+	if enabled == "" && b.Kind == "ha_tts" {
+		return true
+	}
+	return enabled == "1"
+}
+
+// announcerRoutes returns the events a backend announces. An empty route list means all events.
+func announcerRoutes(familyID int, b *announcerBackend) []string {
+	var routes []string
+	for _, e := range strings.Split(getFamilySetting(familyID, announcerSettingKey(b.Kind, "events")), ",") {
+		if e = strings.TrimSpace(e); e != "" {
+			routes = append(routes, e)
+		}
+	}
+	return routes
+}
+
+func announcerWantsEvent(familyID int, b *announcerBackend, event string) bool {
+	routes := announcerRoutes(familyID, b)
+	if len(routes) == 0 {
+		return true
+	}
+	for _, e := range routes {
+		if e == event {
+			return true
+		}
+	}
+	return false
+}
+
+func validAnnounceEvent(event string) bool {
+	for _, e := range announceEvents {
+		if e == event {
+			return true
+		}
+	}
+	return false
+}
+
+// activeAnnouncerConfig returns a backend's settings if it should receive an
+// event. Nothing is announced while the master switch is off.
+func activeAnnouncerConfig(familyID int, b *announcerBackend, event string) (map[string]string, bool) {
+	if getFamilySetting(familyID, "ha_enabled") != "1" {
+		return nil, false
+	}
+	if !announcerEnabled(familyID, b) || (event != "" && !announcerWantsEvent(familyID, b, event)) {
+		return nil, false
+	}
+	cfg := announcerConfig(familyID, b)
+	return cfg, announcerConfigured(b, cfg)
+}
+
+// activeAnnouncers builds the family's announcers that should receive an event.
+func activeAnnouncers(familyID int, event string) map[string]Announcer {
+	active := make(map[string]Announcer)
+	for i := range announcerBackends {
+		b := &announcerBackends[i]
+		if cfg, ok := activeAnnouncerConfig(familyID, b, event); ok {
+			active[b.Kind] = b.New(cfg)
+		}
+	}
+	return active
+}
+
+// announcementsEnabled reports whether any of the family's backends would deliver an announcement.
+func announcementsEnabled(familyID int) bool {
+	return len(activeAnnouncers(familyID, "")) > 0
+}
+
+// userAnnounceEvents reads a per-user list of events from key.
+func userAnnounceEvents(userID int, key string) []string {
+	var events []string
+	for _, e := range strings.Split(getUserSetting(userID, key), ",") {
+		if e = strings.TrimSpace(e); e != "" {
+			events = append(events, e)
+		}
+	}
+	return events
+}
+
+// userAnnounceMuted reports whether a user has opted out of announcements
+// about them for an event.
+func userAnnounceMuted(user *User, event string) bool {
+	return containsString(userAnnounceEvents(user.ID, "announce_muted"), event)
+}
+
+// userAnnounceTarget returns where b sends announcements about a user for an
+// event, or "" for the family's own setting. A user's targets apply to the
+// events they are chosen for, or to every event if none are.
+func userAnnounceTarget(username string, b *announcerBackend, event string) string {
+	if b.Target == "" {
+		return ""
+	}
+	user, err := getUserByUsername(username)
+	if err != nil {
+		return ""
+	}
+	if events := userAnnounceEvents(user.ID, "announce_target_events"); len(events) > 0 && !containsString(events, event) {
+		return ""
+	}
+	return strings.TrimSpace(getUserSetting(user.ID, announcerSettingKey(b.Kind, "target")))
+}
+
+// dispatchAnnouncements puts a batch of queued announcements in the outbox.
+// Each backend gets the ones routed to it, merged by batchAnnouncements per
+// target.
+func dispatchAnnouncements(familyID int, queued []QueuedAnnouncement) {
+	for i := range announcerBackends {
+		b := &announcerBackends[i]
+		if _, ok := activeAnnouncerConfig(familyID, b, ""); !ok {
+			continue
+		}
+		// Announcements about users with their own target go there instead
+		var targets []string
+		routed := make(map[string][]QueuedAnnouncement)
+		for _, q := range queued {
+			if !announcerWantsEvent(familyID, b, q.Event) {
+				continue
+			}
+			target := userAnnounceTarget(q.Username, b, q.Event)
+			if _, ok := routed[target]; !ok {
+				targets = append(targets, target)
+			}
+			routed[target] = append(routed[target], q)
+		}
+		for _, target := range targets {
+			for _, a := range batchAnnouncements(routed[target]) {
+				if err := addAnnouncementDelivery(familyID, b.Kind, target, a); err != nil {
+					log.Printf("Failed to queue %s announcement: %v", b.Kind, err)
+				}
+			}
+		}
+	}
+	wakeAnnouncementWorker()
+}
+
+// testAnnouncement delivers a to every family backend routed for its event and
+// reports how each one went, so a parent can check their setup.
+func testAnnouncement(familyID int, a Announcement) map[string]string {
+	results := make(map[string]string)
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for kind, announcer := range activeAnnouncers(familyID, a.Event) {
+		wg.Add(1)
+		go func(kind string, announcer Announcer) {
+			defer wg.Done()
+			result := "ok"
+			if _, err := announcer.Announce(a); err != nil {
+				result = err.Error()
+			}
+			mu.Lock()
+			results[kind] = result
+			mu.Unlock()
+		}(kind, announcer)
+	}
+	wg.Wait()
+	return results
+}
+
+// postAnnouncement sends req and treats any non-2xx response as an error.
+func postAnnouncement(req *http.Request) (int, error) {
+	resp, err := announceClient.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return resp.StatusCode, fmt.Errorf("returned status %d", resp.StatusCode)
+	}
+	return resp.StatusCode, nil
+}
+
+func newJSONRequest(target string, payload interface{}) (*http.Request, error) {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequest("POST", target, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	return req, nil
+}
+
+// haTTSAnnouncer speaks the message through a Home Assistant TTS service.
+type haTTSAnnouncer struct {
+	url, token, entity string
+}
+
+func (h *haTTSAnnouncer) Announce(a Announcement) (int, error) {
+	req, err := newJSONRequest(strings.TrimRight(h.url, "/"), map[string]interface{}{
+		"entity_id": h.entity,
+		"message":   a.Message,
+	})
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Authorization", "Bearer "+h.token)
+	return postAnnouncement(req)
+}
+
+// haNotifyAnnouncer sends the message through a Home Assistant notify service,
+// such as a phone's mobile_app one, or else creates a persistent notification.
+type haNotifyAnnouncer struct {
+	url, token, service string
+}
+
+func (h *haNotifyAnnouncer) Announce(a Announcement) (int, error) {
+	target := strings.TrimRight(h.url, "/") + "/api/services/persistent_notification/create"
+	if h.service != "" {
+		target = strings.TrimRight(h.url, "/") + "/api/services/notify/" + url.PathEscape(strings.TrimPrefix(h.service, "notify."))
+	}
+	req, err := newJSONRequest(target, map[string]interface{}{
+		"title":   a.Title,
+		"message": a.Message,
+	})
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Authorization", "Bearer "+h.token)
+	return postAnnouncement(req)
+}
+
+// ntfyAnnouncer publishes a push notification to an ntfy topic.
+type ntfyAnnouncer struct {
+	url, topic, token string
+}
+
+func (n *ntfyAnnouncer) Announce(a Announcement) (int, error) {
+	target := strings.TrimRight(n.url, "/") + "/" + url.PathEscape(n.topic)
+	req, err := http.NewRequest("POST", target, strings.NewReader(a.Message))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Title", a.Title)
+	req.Header.Set("Tags", "star")
+	if n.token != "" {
+		req.Header.Set("Authorization", "Bearer "+n.token)
+	}
+	return postAnnouncement(req)
+}
+
+// gotifyAnnouncer sends a message to a Gotify server using an application token.
+type gotifyAnnouncer struct {
+	url, token string
+}
+
+func (g *gotifyAnnouncer) Announce(a Announcement) (int, error) {
+	req, err := newJSONRequest(strings.TrimRight(g.url, "/")+"/message", map[string]interface{}{
+		"title":    a.Title,
+		"message":  a.Message,
+		"priority": 5,
+	})
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("X-Gotify-Key", g.token)
+	return postAnnouncement(req)
+}
+
+// mqttAnnouncer publishes the announcement as JSON to an MQTT topic.
+type mqttAnnouncer struct {
+	broker, topic, username, password string
+}
+
+func (m *mqttAnnouncer) Announce(a Announcement) (int, error) {
+	payload, err := json.Marshal(announcementPayload(a))
+	if err != nil {
+		return 0, err
+	}
+	suffix, err := randomHex(4)
+	if err != nil {
+		return 0, err
+	}
+	opts := mqtt.NewClientOptions().
+		AddBroker(m.broker).
+		SetClientID("star-app-announce-" + suffix).
+		SetConnectTimeout(10 * time.Second)
+	if m.username != "" {
+		opts.SetUsername(m.username)
+		opts.SetPassword(m.password)
+	}
+
+	client := mqtt.NewClient(opts)
+	token := client.Connect()
+	if !token.WaitTimeout(10 * time.Second) {
+		return 0, fmt.Errorf("timed out connecting to %s", m.broker)
+	}
+	if err := token.Error(); err != nil {
+		return 0, err
+	}
+	defer client.Disconnect(250)
+
+	token = client.Publish(m.topic, 1, false, payload)
+	if !token.WaitTimeout(10 * time.Second) {
+		return 0, fmt.Errorf("timed out publishing to %s", m.topic)
+	}
+	return 0, token.Error()
+}
+
+// httpAnnouncer posts the announcement as JSON to any URL.
+type httpAnnouncer struct {
+	url, token string
+}
+
+func (h *httpAnnouncer) Announce(a Announcement) (int, error) {
+	req, err := newJSONRequest(h.url, announcementPayload(a))
+	if err != nil {
+		return 0, err
+	}
+	if h.token != "" {
+		req.Header.Set("Authorization", "Bearer "+h.token)
+	}
+	return postAnnouncement(req)
+}
+
+// announcementPayload is the JSON body used by the MQTT and generic HTTP backends.
+func announcementPayload(a Announcement) map[string]interface{} {
+	return map[string]interface{}{
+		"event":    a.Event,
+		"title":    a.Title,
+		"message":  a.Message,
+		"lang":     a.Lang,
+		"username": a.Username,
+	}
+}
+
+// announcementSettingKeys lists every settings key used for announcements.
+func announcementSettingKeys() []string {
+	keys := []string{"ha_enabled", "ha_lang"}
+	for _, b := range announcerBackends {
+		keys = append(keys, announcerSettingKey(b.Kind, "enabled"), announcerSettingKey(b.Kind, "events"))
+		for _, f := range b.Fields {
+			keys = append(keys, f.SettingKey)
+		}
+	}
+	keys = append(keys, "announce_batch_seconds")
+	for d := time.Sunday; d <= time.Saturday; d++ {
+		keys = append(keys, quietHoursKey(d))
+	}
+	return append(keys, announceTemplateKeys()...)
+}
+
+// userAnnouncementSettingKeys lists every per-user settings key used for announcements.
+func userAnnouncementSettingKeys() []string {
+	keys := []string{"announce_muted", "announce_target_events"}
+	for _, b := range announcerBackends {
+		if b.Target != "" {
+			keys = append(keys, announcerSettingKey(b.Kind, "target"))
+		}
+	}
+	return keys
+}
+
+// announcementSecretKeys reports which settings keys hold credentials.
+func announcementSecretKeys() map[string]bool {
+	secrets := make(map[string]bool)
+	for _, b := range announcerBackends {
+		for _, f := range b.Fields {
+			if f.Secret {
+				secrets[f.SettingKey] = true
+			}
+		}
+	}
+	return secrets
+}
+
+type announcerFieldView struct {
+	announcerField
+	Value string
+}
+
+// announcerView is a backend's current configuration for the admin page.
+type announcerView struct {
+	Kind       string
+	Enabled    bool
+	Configured bool
+	Events     map[string]bool
+	Fields     []announcerFieldView
+}
+
+func announcerViews(familyID int) []announcerView {
+	var views []announcerView
+	for i := range announcerBackends {
+		b := &announcerBackends[i]
+		cfg := announcerConfig(familyID, b)
+		v := announcerView{
+			Kind:       b.Kind,
+			Enabled:    announcerEnabled(familyID, b),
+			Configured: announcerConfigured(b, cfg),
+			Events:     make(map[string]bool),
+		}
+		for _, e := range announcerRoutes(familyID, b) {
+			v.Events[e] = true
+		}
+		for _, f := range b.Fields {
+			v.Fields = append(v.Fields, announcerFieldView{announcerField: f, Value: cfg[f.Name]})
+		}
+		views = append(views, v)
+	}
+	return views
+}
+
+// userTargetView is a user's own target for one backend.
+type userTargetView struct {
+	Kind, Field, SettingKey string
+	Value, Default          string
+}
+
+// userAnnounceView is a user's announcement settings for the admin page.
+type userAnnounceView struct {
+	User         User
+	Muted        map[string]bool
+	TargetEvents map[string]bool
+	Targets      []userTargetView
+}
+
+func userAnnounceViews(familyID int, users []User) []userAnnounceView {
+	var views []userAnnounceView
+	for _, u := range users {
+		v := userAnnounceView{User: u, Muted: make(map[string]bool), TargetEvents: make(map[string]bool)}
+		for _, e := range userAnnounceEvents(u.ID, "announce_muted") {
+			v.Muted[e] = true
+		}
+		for _, e := range userAnnounceEvents(u.ID, "announce_target_events") {
+			v.TargetEvents[e] = true
+		}
+		for i := range announcerBackends {
+			b := &announcerBackends[i]
+			if b.Target == "" {
+				continue
+			}
+			key := announcerSettingKey(b.Kind, "target")
+			v.Targets = append(v.Targets, userTargetView{
+				Kind:       b.Kind,
+				Field:      b.Target,
+				SettingKey: key,
+				Value:      getUserSetting(u.ID, key),
+				Default:    announcerConfig(familyID, b)[b.Target],
+			})
+		}
+		views = append(views, v)
+	}
+	return views
+}
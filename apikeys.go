@@ -0,0 +1,68 @@
+package main
+
+import (
+	"net/http"
+	"time"
+)
+
+// API key scopes. A key may hold several; admin grants everything.
+const (
+	scopeRead   = "read"
+	scopeAward  = "award"
+	scopeRedeem = "redeem"
+	scopeAdmin  = "admin"
+)
+
+var apiKeyScopes = []string{scopeRead, scopeAward, scopeRedeem, scopeAdmin}
+
+func validAPIKeyScope(scope string) bool {
+	for _, s := range apiKeyScopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+func apiKeyHasScope(k *APIKey, scope string) bool {
+	for _, s := range k.Scopes {
+		if s == scope || s == scopeAdmin {
+			return true
+		}
+	}
+	return false
+}
+
+func apiKeyExpired(k *APIKey, now time.Time) bool {
+	return k.ExpiresAt != nil && !now.Before(*k.ExpiresAt)
+}
+
+// apiKeyAllowsUser reports whether the request's API key may see or act on a user.
+// Keys only reach their own family, and keys without a user list reach all of it;
+// session requests are never restricted.
+func apiKeyAllowsUser(r *http.Request, userID int) bool {
+	k := getContextAPIKey(r)
+	if k == nil {
+		return true
+	}
+	if !userInFamily(r, userID) {
+		return false
+	}
+	if len(k.UserIDs) == 0 {
+		return true
+	}
+	for _, id := range k.UserIDs {
+		if id == userID {
+			return true
+		}
+	}
+	return false
+}
+
+// apiKeyAwarder returns the parent API actions are attributed to, or 0 for legacy keys without an owner.
+func apiKeyAwarder(r *http.Request) int {
+	if k := getContextAPIKey(r); k != nil {
+		return k.OwnerID
+	}
+	return 0
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+)
+
+// recordAudit writes an audit log entry for an admin action. The actor is the
+// session user for web requests or the API key label for API requests.
+// before and after are JSON-encoded snapshots of the target; either may be nil.
+func recordAudit(r *http.Request, action, targetType string, targetID int, target string, before, after interface{}) {
+	e := AuditEntry{
+		Action:     action,
+		TargetType: targetType,
+		TargetID:   targetID,
+		Target:     target,
+		Source:     "system",
+		FamilyID:   getContextFamilyID(r),
+	}
+	if user := getContextUser(r); user != nil {
+		e.ActorID = user.ID
+		e.Actor = user.Username
+		e.Source = "web"
+	} else if key := getContextAPIKey(r); key != nil {
+		// API actions are attributed to the parent who owns the key
+		e.ActorID = key.OwnerID
+		e.Actor = key.OwnerName
+		e.Source = "api"
+		e.SourceLabel = key.Label
+	}
+	writeAudit(e, before, after)
+}
+
+// writeAudit stores e with JSON-encoded before and after snapshots. Callers
+// outside an HTTP request fill in the source and family themselves.
+func writeAudit(e AuditEntry, before, after interface{}) {
+	if before != nil {
+		e.Before, _ = json.Marshal(before)
+	}
+	if after != nil {
+		e.After, _ = json.Marshal(after)
+	}
+	if err := addAuditEntry(e); err != nil {
+		log.Printf("Failed to write audit entry %s: %v", e.Action, err)
+	}
+}
+
+// starSnapshot describes a star for the audit log.
+func starSnapshot(s *Star) map[string]interface{} {
+	return map[string]interface{}{
+		"star_id":  s.ID,
+		"reason":   getReasonText(s.ReasonID, s.ReasonText, "en"),
+		"stars":    s.Stars,
+		"currency": currencyKey(s.CurrencyID),
+	}
+}
+
+// currencySnapshot describes a currency for the audit log.
+func currencySnapshot(c *Currency) map[string]interface{} {
+	return map[string]interface{}{
+		"key":          c.Key,
+		"icon":         c.Icon,
+		"translations": c.Translations,
+	}
+}
+
+// rewardSnapshot describes a reward for the audit log.
+func rewardSnapshot(r *Reward) map[string]interface{} {
+	return map[string]interface{}{
+		"key":          r.Key,
+		"cost":         r.Cost,
+		"currency":     currencyKey(r.CurrencyID),
+		"icon":         r.Icon,
+		"adult_only":   r.ForAdults,
+		"translations": r.Translations,
+	}
+}
+
+// reasonSnapshot describes a reason for the audit log.
+func reasonSnapshot(r *Reason) map[string]interface{} {
+	return map[string]interface{}{
+		"key":          r.Key,
+		"stars":        r.Stars,
+		"currency":     currencyKey(r.CurrencyID),
+		"translations": r.Translations,
+	}
+}
+
+// userSnapshot describes a user and the history they hold for the audit log.
+func userSnapshot(u *User) map[string]interface{} {
+	balance, earned, _ := ledgerBalance(db, u.ID, 0)
+	stars, redemptions := getUserHistoryCounts(u.ID)
+	return map[string]interface{}{
+		"username":      u.Username,
+		"is_admin":      u.IsAdmin,
+		"translations":  u.Translations,
+		"current_stars": balance,
+		"star_count":    earned,
+		"stars":         stars,
+		"redemptions":   redemptions,
+	}
+}
+
+// settingsSnapshot describes a family's announcement settings without exposing credentials.
+func settingsSnapshot(familyID int) map[string]interface{} {
+	secrets := announcementSecretKeys()
+	snapshot := make(map[string]interface{})
+	for _, key := range announcementSettingKeys() {
+		if secrets[key] {
+			snapshot[key+"_set"] = getFamilySetting(familyID, key) != ""
+			continue
+		}
+		snapshot[key] = getFamilySetting(familyID, key)
+	}
+	return snapshot
+}
+
+// mqttSettingsSnapshot describes the MQTT settings without exposing the password.
+func mqttSettingsSnapshot() map[string]interface{} {
+	snapshot := make(map[string]interface{})
+	for _, key := range mqttSettingKeys {
+		if key == "mqtt_password" {
+			snapshot[key+"_set"] = getSetting(key) != ""
+			continue
+		}
+		snapshot[key] = getSetting(key)
+	}
+	return snapshot
+}
+
+// auditStarAward records a newly awarded star.
+func auditStarAward(r *http.Request, username string, starID int64) {
+	star, err := getStarByID(int(starID))
+	if err != nil {
+		return
+	}
+	recordAudit(r, "star.award", "user", star.UserID, username, nil, starSnapshot(star))
+}
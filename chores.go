@@ -0,0 +1,126 @@
+package main
+
+import (
+	"strings"
+	"time"
+)
+
+var choreSchedules = []string{"daily", "weekdays", "weekly"}
+
+func validChoreSchedule(schedule string) bool {
+	for _, s := range choreSchedules {
+		if s == schedule {
+			return true
+		}
+	}
+	return false
+}
+
+// parseDueTime parses an optional "HH:MM" deadline. An empty string is valid and means end of day.
+func parseDueTime(dueTime string) (hour, minute int, ok bool) {
+	dueTime = strings.TrimSpace(dueTime)
+	if dueTime == "" {
+		return 23, 59, true
+	}
+	t, err := time.Parse("15:04", dueTime)
+	if err != nil {
+		return 0, 0, false
+	}
+	return t.Hour(), t.Minute(), true
+}
+
+// choreOccurrence works out which period a chore falls in at the given time.
+// Daily and weekday chores have one period per day; weekly chores have one
+// period per week (starting Monday) and are due on their configured weekday.
+// active is false when the chore has no occurrence right now (weekday chores at
+// the weekend); overdue is true once the deadline for the period has passed.
+func choreOccurrence(c Chore, now time.Time) (period string, active, overdue bool) {
+	hour, minute, _ := parseDueTime(c.DueTime)
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+
+	switch c.Schedule {
+	case "weekly":
+		// Monday-based week so a Sunday chore is the last day of its week
+		offset := (int(today.Weekday()) + 6) % 7
+		weekStart := today.AddDate(0, 0, -offset)
+		dueOffset := (c.Weekday + 6) % 7
+		deadline := weekStart.AddDate(0, 0, dueOffset).Add(time.Duration(hour)*time.Hour + time.Duration(minute)*time.Minute)
+		return weekStart.Format("2006-01-02"), true, now.After(deadline)
+	case "weekdays":
+		if today.Weekday() == time.Saturday || today.Weekday() == time.Sunday {
+			return "", false, false
+		}
+	}
+
+	deadline := today.Add(time.Duration(hour)*time.Hour + time.Duration(minute)*time.Minute)
+	return today.Format("2006-01-02"), true, now.After(deadline)
+}
+
+// getChoreStatuses lists the current state of every active chore assignment
+// in a family. Pass userID 0 to include all users.
+func getChoreStatuses(familyID, userID int, now time.Time) ([]ChoreStatus, error) {
+	chores, err := getChores(familyID)
+	if err != nil {
+		return nil, err
+	}
+	users, err := getAllUsers(familyID)
+	if err != nil {
+		return nil, err
+	}
+	usernames := make(map[int]string, len(users))
+	for _, u := range users {
+		usernames[u.ID] = u.Username
+	}
+
+	var statuses []ChoreStatus
+	for _, c := range chores {
+		period, active, overdue := choreOccurrence(c, now)
+		if !active {
+			continue
+		}
+		for _, assignee := range c.UserIDs {
+			if userID != 0 && assignee != userID {
+				continue
+			}
+			s := ChoreStatus{
+				Chore:                c,
+				UserID:               assignee,
+				Username:             usernames[assignee],
+				UsernameTranslations: getUserTexts(assignee),
+				Period:               period,
+			}
+			s.CompletionID, s.State = getChoreCompletionState(c.ID, assignee, period)
+			if s.State == "" || s.State == "rejected" {
+				if overdue {
+					s.State = "overdue"
+				} else if s.State == "" {
+					s.State = "due"
+				}
+			}
+			statuses = append(statuses, s)
+		}
+	}
+	return statuses, nil
+}
+
+// approveChore awards the chore's reason to the kid and marks the completion approved.
+// reviewerID is 0 for automatically approved chores.
+func approveChore(completion *ChoreCompletion, reviewerID int) (int64, error) {
+	if completion.Status != "pending" {
+		return 0, errChoreCompletionReviewed
+	}
+	reasonID := completion.ReasonID
+	starID, err := approveChoreCompletion(completion.ID, completion.UserID, reasonID, reviewerID)
+	if err != nil {
+		return 0, err
+	}
+
+	stars, currencyID := 1, 0
+	if star, err := getStarByID(int(starID)); err == nil {
+		stars, currencyID = star.Stars, star.CurrencyID
+	}
+	publishStarAwarded(starID)
+	announceStarIfEnabled(completion.Username, &reasonID, "", stars, currencyID)
+	checkGoalReached(completion.Username)
+	return starID, nil
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"errors"
+	"sync"
+	"testing"
+)
+
+func TestChoreCompletionIsReviewedOnce(t *testing.T) {
+	openTestDB(t)
+	theo, err := getUserByUsername("theo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	dad, err := getUserByUsername("dad")
+	if err != nil {
+		t.Fatal(err)
+	}
+	starID, err := addStarWithID("theo", nil, "Fed the cat", 0, 3, dad.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	star, err := getStarByID(int(starID))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := addChore(*star.ReasonID, "daily", 0, "", false, []int{theo.ID}); err != nil {
+		t.Fatal(err)
+	}
+	chores, err := getChores(theo.FamilyID)
+	if err != nil || len(chores) != 1 {
+		t.Fatalf("chores = %v, %v", chores, err)
+	}
+	id, err := submitChoreCompletion(chores[0].ID, theo.ID, "2026-10-16")
+	if err != nil {
+		t.Fatal(err)
+	}
+	completion, err := getChoreCompletionByID(theo.FamilyID, int(id))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Parents double-clicking approve and reject alongside auto-approval
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	approved, rejected := 0, 0
+	for i := 0; i < 6; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			c := *completion
+			var err error
+			if i == 0 {
+				err = rejectChoreCompletion(c.ID, dad.ID)
+			} else {
+				_, err = approveChore(&c, (i%2)*dad.ID)
+			}
+			mu.Lock()
+			defer mu.Unlock()
+			switch {
+			case err == nil && i == 0:
+				rejected++
+			case err == nil:
+				approved++
+			case !errors.Is(err, errChoreCompletionReviewed):
+				t.Errorf("review %d: %v", i, err)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	if approved+rejected != 1 {
+		t.Fatalf("%d approvals and %d rejections went through, want one", approved, rejected)
+	}
+	if balance, _ := getUserBalance(theo.ID, star.CurrencyID); balance != 3+3*approved {
+		t.Errorf("balance = %d, want %d", balance, 3+3*approved)
+	}
+}
@@ -1,9 +1,11 @@
 package main
 
 import (
+	"context"
 	"crypto/sha256"
 	"database/sql"
 	"encoding/hex"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"os"
@@ -19,7 +21,15 @@ var db *sql.DB
 
 func initDB(dbPath string) error {
 	var err error
-	db, err = sql.Open("sqlite", dbPath)
+	// Wait on locks rather than failing, since the webhook worker writes
+	// alongside request handlers
+	dsn := dbPath
+	if strings.Contains(dsn, "?") {
+		dsn += "&_pragma=busy_timeout(5000)"
+	} else {
+		dsn += "?_pragma=busy_timeout(5000)"
+	}
+	db, err = sql.Open("sqlite", dsn)
 	if err != nil {
 		return err
 	}
@@ -80,6 +90,11 @@ func initDB(dbPath string) error {
 		label TEXT,
 		created_at DATETIME DEFAULT CURRENT_TIMESTAMP
 	);
+	CREATE TABLE IF NOT EXISTS api_key_users (
+		api_key_id INTEGER NOT NULL REFERENCES api_keys(id) ON DELETE CASCADE,
+		user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+		PRIMARY KEY (api_key_id, user_id)
+	);
 	CREATE TABLE IF NOT EXISTS sessions (
 		token TEXT PRIMARY KEY,
 		user_id INTEGER NOT NULL REFERENCES users(id),
@@ -108,6 +123,187 @@ func initDB(dbPath string) error {
 	CREATE TABLE IF NOT EXISTS settings (
 		key TEXT PRIMARY KEY,
 		value TEXT NOT NULL DEFAULT ''
+	);
+	CREATE TABLE IF NOT EXISTS chores (
+		id INTEGER PRIMARY KEY,
+		reason_id INTEGER NOT NULL REFERENCES reasons(id) ON DELETE CASCADE,
+		schedule TEXT NOT NULL DEFAULT 'daily',
+		weekday INTEGER NOT NULL DEFAULT 0,
+		due_time TEXT NOT NULL DEFAULT '',
+		auto_approve BOOLEAN DEFAULT FALSE,
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+	);
+	CREATE TABLE IF NOT EXISTS chore_assignments (
+		chore_id INTEGER NOT NULL REFERENCES chores(id) ON DELETE CASCADE,
+		user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+		PRIMARY KEY (chore_id, user_id)
+	);
+	CREATE TABLE IF NOT EXISTS chore_completions (
+		id INTEGER PRIMARY KEY,
+		chore_id INTEGER NOT NULL REFERENCES chores(id) ON DELETE CASCADE,
+		user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+		period TEXT NOT NULL,
+		status TEXT NOT NULL DEFAULT 'pending',
+		star_id INTEGER REFERENCES stars(id) ON DELETE SET NULL,
+		reviewed_by INTEGER REFERENCES users(id) ON DELETE SET NULL,
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		reviewed_at DATETIME,
+		UNIQUE(chore_id, user_id, period)
+	);
+	CREATE TABLE IF NOT EXISTS redemption_requests (
+		id INTEGER PRIMARY KEY,
+		user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+		reward_id INTEGER NOT NULL REFERENCES rewards(id) ON DELETE CASCADE,
+		cost INTEGER NOT NULL,
+		status TEXT NOT NULL DEFAULT 'pending',
+		redemption_id INTEGER REFERENCES redemptions(id) ON DELETE SET NULL,
+		decided_by INTEGER REFERENCES users(id) ON DELETE SET NULL,
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		decided_at DATETIME
+	);
+	CREATE TABLE IF NOT EXISTS ledger_entries (
+		id INTEGER PRIMARY KEY,
+		user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+		kind TEXT NOT NULL,
+		amount INTEGER NOT NULL,
+		balance INTEGER NOT NULL,
+		earned INTEGER NOT NULL,
+		star_id INTEGER REFERENCES stars(id) ON DELETE SET NULL,
+		redemption_id INTEGER REFERENCES redemptions(id) ON DELETE SET NULL,
+		reverses_id INTEGER REFERENCES ledger_entries(id) ON DELETE SET NULL,
+		note TEXT NOT NULL DEFAULT '',
+		created_by INTEGER REFERENCES users(id) ON DELETE SET NULL,
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+	);
+	CREATE INDEX IF NOT EXISTS idx_ledger_entries_user ON ledger_entries(user_id, id);
+	CREATE TABLE IF NOT EXISTS audit_log (
+		id INTEGER PRIMARY KEY,
+		actor_id INTEGER REFERENCES users(id) ON DELETE SET NULL,
+		actor TEXT NOT NULL DEFAULT '',
+		source TEXT NOT NULL,
+		source_label TEXT NOT NULL DEFAULT '',
+		action TEXT NOT NULL,
+		target_type TEXT NOT NULL DEFAULT '',
+		target_id INTEGER,
+		target TEXT NOT NULL DEFAULT '',
+		before_value TEXT,
+		after_value TEXT,
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+	);
+	CREATE INDEX IF NOT EXISTS idx_audit_log_action ON audit_log(action);
+	CREATE TABLE IF NOT EXISTS savings_goals (
+		user_id INTEGER PRIMARY KEY REFERENCES users(id) ON DELETE CASCADE,
+		reward_id INTEGER NOT NULL REFERENCES rewards(id) ON DELETE CASCADE,
+		reached_at DATETIME,
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+	);
+	CREATE TABLE IF NOT EXISTS webhooks (
+		id INTEGER PRIMARY KEY,
+		url TEXT NOT NULL,
+		secret TEXT NOT NULL,
+		events TEXT NOT NULL DEFAULT '',
+		enabled BOOLEAN NOT NULL DEFAULT TRUE,
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+	);
+	CREATE TABLE IF NOT EXISTS webhook_deliveries (
+		id INTEGER PRIMARY KEY,
+		webhook_id INTEGER NOT NULL REFERENCES webhooks(id) ON DELETE CASCADE,
+		event_id TEXT NOT NULL,
+		event_type TEXT NOT NULL,
+		payload TEXT NOT NULL,
+		status TEXT NOT NULL DEFAULT 'pending',
+		attempts INTEGER NOT NULL DEFAULT 0,
+		last_status INTEGER,
+		last_error TEXT NOT NULL DEFAULT '',
+		next_attempt_at DATETIME,
+		delivered_at DATETIME,
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+	);
+	CREATE INDEX IF NOT EXISTS idx_webhook_deliveries_due ON webhook_deliveries(status, next_attempt_at);
+	CREATE TABLE IF NOT EXISTS families (
+		id INTEGER PRIMARY KEY,
+		name TEXT NOT NULL,
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+	);
+	CREATE TABLE IF NOT EXISTS family_settings (
+		family_id INTEGER NOT NULL REFERENCES families(id) ON DELETE CASCADE,
+		key TEXT NOT NULL,
+		value TEXT NOT NULL DEFAULT '',
+		PRIMARY KEY (family_id, key)
+	);
+	CREATE TABLE IF NOT EXISTS user_settings (
+		user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+		key TEXT NOT NULL,
+		value TEXT NOT NULL DEFAULT '',
+		PRIMARY KEY (user_id, key)
+	);
+	CREATE TABLE IF NOT EXISTS currencies (
+		id INTEGER PRIMARY KEY,
+		family_id INTEGER NOT NULL REFERENCES families(id) ON DELETE CASCADE,
+		key TEXT NOT NULL,
+		icon TEXT NOT NULL DEFAULT '',
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		UNIQUE(family_id, key)
+	);
+	CREATE TABLE IF NOT EXISTS currency_translations (
+		id INTEGER PRIMARY KEY,
+		currency_id INTEGER NOT NULL REFERENCES currencies(id) ON DELETE CASCADE,
+		lang TEXT NOT NULL,
+		text TEXT NOT NULL,
+		UNIQUE(currency_id, lang)
+	);
+	CREATE TABLE IF NOT EXISTS announcement_queue (
+		id INTEGER PRIMARY KEY,
+		family_id INTEGER NOT NULL REFERENCES families(id) ON DELETE CASCADE,
+		event TEXT NOT NULL,
+		username TEXT NOT NULL,
+		lang TEXT NOT NULL,
+		template TEXT NOT NULL,
+		vals TEXT NOT NULL,
+		deliver_at DATETIME NOT NULL,
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+	);
+	CREATE INDEX IF NOT EXISTS idx_announcement_queue_due ON announcement_queue(deliver_at);
+	CREATE TABLE IF NOT EXISTS announcement_deliveries (
+		id INTEGER PRIMARY KEY,
+		family_id INTEGER NOT NULL REFERENCES families(id) ON DELETE CASCADE,
+		kind TEXT NOT NULL,
+		target TEXT NOT NULL DEFAULT '',
+		event TEXT NOT NULL,
+		title TEXT NOT NULL,
+		message TEXT NOT NULL,
+		lang TEXT NOT NULL,
+		username TEXT NOT NULL,
+		status TEXT NOT NULL DEFAULT 'pending',
+		attempts INTEGER NOT NULL DEFAULT 0,
+		last_status INTEGER,
+		last_error TEXT NOT NULL DEFAULT '',
+		next_attempt_at DATETIME,
+		delivered_at DATETIME,
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+	);
+	CREATE INDEX IF NOT EXISTS idx_announcement_deliveries_due ON announcement_deliveries(status, next_attempt_at);
+	CREATE TABLE IF NOT EXISTS event_log (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		event_id TEXT NOT NULL,
+		family_id INTEGER NOT NULL REFERENCES families(id) ON DELETE CASCADE,
+		type TEXT NOT NULL,
+		data TEXT NOT NULL,
+		created_at DATETIME NOT NULL
+	);
+	CREATE INDEX IF NOT EXISTS idx_event_log_family ON event_log(family_id, id);
+	CREATE TABLE IF NOT EXISTS login_attempts (
+		scope TEXT NOT NULL,
+		key TEXT NOT NULL,
+		failures INTEGER NOT NULL DEFAULT 0,
+		last_failure_at DATETIME NOT NULL,
+		locked_until DATETIME,
+		PRIMARY KEY (scope, key)
+	);
+	CREATE TABLE IF NOT EXISTS kid_login_devices (
+		token_hash TEXT PRIMARY KEY,
+		family_id INTEGER NOT NULL REFERENCES families(id) ON DELETE CASCADE,
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP
 	);`
 
 	_, err = db.Exec(schema)
@@ -291,6 +487,29 @@ func runMigrations() error {
 		}
 	}
 
+	// --- api_keys table migrations ---
+	// Keys created before scopes existed keep full access
+	if !columnExists("api_keys", "scopes") {
+		if _, err := db.Exec("ALTER TABLE api_keys ADD COLUMN scopes TEXT NOT NULL DEFAULT 'admin'"); err != nil {
+			return fmt.Errorf("failed to add scopes column to api_keys: %w", err)
+		}
+	}
+	if !columnExists("api_keys", "owner_id") {
+		if _, err := db.Exec("ALTER TABLE api_keys ADD COLUMN owner_id INTEGER REFERENCES users(id) ON DELETE SET NULL"); err != nil {
+			return fmt.Errorf("failed to add owner_id column to api_keys: %w", err)
+		}
+	}
+	if !columnExists("api_keys", "expires_at") {
+		if _, err := db.Exec("ALTER TABLE api_keys ADD COLUMN expires_at DATETIME"); err != nil {
+			return fmt.Errorf("failed to add expires_at column to api_keys: %w", err)
+		}
+	}
+	if !columnExists("api_keys", "last_used_at") {
+		if _, err := db.Exec("ALTER TABLE api_keys ADD COLUMN last_used_at DATETIME"); err != nil {
+			return fmt.Errorf("failed to add last_used_at column to api_keys: %w", err)
+		}
+	}
+
 	// --- settings table ---
 	_, err = db.Exec(`CREATE TABLE IF NOT EXISTS settings (
 		key TEXT PRIMARY KEY,
@@ -300,9 +519,166 @@ func runMigrations() error {
 		return fmt.Errorf("failed to create settings table: %w", err)
 	}
 
+	// --- ledger ---
+	// Stars and redemptions are voided rather than deleted so the ledger keeps its history
+	if !columnExists("stars", "voided_at") {
+		if _, err := db.Exec("ALTER TABLE stars ADD COLUMN voided_at DATETIME"); err != nil {
+			return fmt.Errorf("failed to add voided_at column to stars: %w", err)
+		}
+	}
+	if !columnExists("redemptions", "voided_at") {
+		if _, err := db.Exec("ALTER TABLE redemptions ADD COLUMN voided_at DATETIME"); err != nil {
+			return fmt.Errorf("failed to add voided_at column to redemptions: %w", err)
+		}
+	}
+	// --- families ---
+	// Everything created before families existed belongs to the first family
+	if _, err := db.Exec("INSERT OR IGNORE INTO families (id, name) VALUES (1, 'Home')"); err != nil {
+		return fmt.Errorf("failed to create default family: %w", err)
+	}
+	for _, table := range []string{"users", "api_keys", "webhooks", "sessions", "audit_log"} {
+		if !columnExists(table, "family_id") {
+			if _, err := db.Exec("ALTER TABLE " + table + " ADD COLUMN family_id INTEGER NOT NULL DEFAULT 1"); err != nil {
+				return fmt.Errorf("failed to add family_id column to %s: %w", table, err)
+			}
+		}
+	}
+	if !columnExists("users", "is_super_admin") {
+		if _, err := db.Exec("ALTER TABLE users ADD COLUMN is_super_admin BOOLEAN DEFAULT FALSE"); err != nil {
+			return fmt.Errorf("failed to add is_super_admin column to users: %w", err)
+		}
+		// The first parent runs the deployment
+		db.Exec("UPDATE users SET is_super_admin = 1 WHERE id = (SELECT MIN(id) FROM users WHERE is_admin = 1)")
+	}
+	// Reason and reward keys only need to be unique within a family, which
+	// needs a table rebuild since SQLite can't drop the old UNIQUE constraint.
+	// Duplicate keys left over from the text and name migrations get the id appended.
+	if !columnExists("reasons", "family_id") {
+		err := rebuildTable("reasons", `CREATE TABLE reasons_new (
+			id INTEGER PRIMARY KEY,
+			family_id INTEGER NOT NULL DEFAULT 1 REFERENCES families(id),
+			key TEXT NOT NULL,
+			stars INTEGER NOT NULL DEFAULT 1,
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			UNIQUE(family_id, key)
+		)`, `INSERT INTO reasons_new (id, key, stars, created_at)
+			SELECT id, COALESCE(key, 'reason') || CASE WHEN key IS NULL OR EXISTS (SELECT 1 FROM reasons r2 WHERE r2.key = reasons.key AND r2.id < reasons.id) THEN '_' || id ELSE '' END,
+				stars, created_at FROM reasons`)
+		if err != nil {
+			return fmt.Errorf("failed to add family_id column to reasons: %w", err)
+		}
+	}
+	if !columnExists("rewards", "family_id") {
+		createdAt := "created_at"
+		if !columnExists("rewards", "created_at") {
+			createdAt = "CURRENT_TIMESTAMP"
+		}
+		err := rebuildTable("rewards", `CREATE TABLE rewards_new (
+			id INTEGER PRIMARY KEY,
+			family_id INTEGER NOT NULL DEFAULT 1 REFERENCES families(id),
+			key TEXT NOT NULL,
+			cost INTEGER NOT NULL,
+			icon TEXT NOT NULL DEFAULT '',
+			adult_only BOOLEAN DEFAULT FALSE,
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			UNIQUE(family_id, key)
+		)`, `INSERT INTO rewards_new (id, key, cost, icon, adult_only, created_at)
+			SELECT id, COALESCE(key, 'reward') || CASE WHEN key IS NULL OR EXISTS (SELECT 1 FROM rewards r2 WHERE r2.key = rewards.key AND r2.id < rewards.id) THEN '_' || id ELSE '' END,
+				cost, icon, adult_only, `+createdAt+` FROM rewards`)
+		if err != nil {
+			return fmt.Errorf("failed to add family_id column to rewards: %w", err)
+		}
+	}
+	// Announcement settings moved from the deployment-wide settings table to the first family
+	keys := announcementSettingKeys()
+	placeholders := strings.TrimSuffix(strings.Repeat("?,", len(keys)), ",")
+	args := make([]interface{}, len(keys))
+	for i, key := range keys {
+		args[i] = key
+	}
+	if _, err := db.Exec("INSERT OR IGNORE INTO family_settings (family_id, key, value) SELECT 1, key, value FROM settings WHERE key IN ("+placeholders+")", args...); err != nil {
+		return fmt.Errorf("failed to move announcement settings: %w", err)
+	}
+	db.Exec("DELETE FROM settings WHERE key IN ("+placeholders+")", args...)
+
+	// --- currencies ---
+	// Currency 0 is the built-in stars, so everything recorded so far stays in stars
+	for _, table := range []string{"reasons", "rewards", "stars", "redemptions", "ledger_entries"} {
+		if !columnExists(table, "currency_id") {
+			if _, err := db.Exec("ALTER TABLE " + table + " ADD COLUMN currency_id INTEGER NOT NULL DEFAULT 0"); err != nil {
+				return fmt.Errorf("failed to add currency_id column to %s: %w", table, err)
+			}
+		}
+	}
+	if _, err := db.Exec("CREATE INDEX IF NOT EXISTS idx_ledger_entries_currency ON ledger_entries(user_id, currency_id, id)"); err != nil {
+		return fmt.Errorf("failed to create ledger currency index: %w", err)
+	}
+
+	// --- languages ---
+	// An empty preference means the browser's language is used
+	if !columnExists("users", "lang") {
+		if _, err := db.Exec("ALTER TABLE users ADD COLUMN lang TEXT NOT NULL DEFAULT ''"); err != nil {
+			return fmt.Errorf("failed to add lang column to users: %w", err)
+		}
+	}
+
+	// --- sessions ---
+	// Sessions created before these existed count as last seen when created
+	for _, col := range []string{"last_seen_at DATETIME", "user_agent TEXT NOT NULL DEFAULT ''", "ip TEXT NOT NULL DEFAULT ''"} {
+		name := strings.Fields(col)[0]
+		if !columnExists("sessions", name) {
+			if _, err := db.Exec("ALTER TABLE sessions ADD COLUMN " + col); err != nil {
+				return fmt.Errorf("failed to add %s column to sessions: %w", name, err)
+			}
+		}
+	}
+
+	// Backfill the ledger from existing stars and redemptions on first run,
+	// once every column the replay reads exists
+	var ledgerCount int
+	db.QueryRow("SELECT COUNT(*) FROM ledger_entries").Scan(&ledgerCount)
+	if ledgerCount == 0 {
+		if err := rebuildLedger(); err != nil {
+			return fmt.Errorf("failed to backfill ledger: %w", err)
+		}
+	}
+
 	return nil
 }
 
+// rebuildTable replaces table with one created by createSQL (as <table>_new)
+// and filled by copySQL, for schema changes ALTER TABLE can't make.
+func rebuildTable(table, createSQL, copySQL string) error {
+	ctx := context.Background()
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	// Dropping the old table must not cascade to the rows that reference it
+	if _, err := conn.ExecContext(ctx, "PRAGMA foreign_keys=OFF"); err != nil {
+		return err
+	}
+	defer conn.ExecContext(ctx, "PRAGMA foreign_keys=ON")
+
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	for _, stmt := range []string{
+		createSQL,
+		copySQL,
+		"DROP TABLE " + table,
+		"ALTER TABLE " + table + "_new RENAME TO " + table,
+	} {
+		if _, err := tx.ExecContext(ctx, stmt); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
 func seedUsers() error {
 	var count int
 	db.QueryRow("SELECT COUNT(*) FROM users").Scan(&count)
@@ -311,13 +687,14 @@ func seedUsers() error {
 	}
 
 	users := []struct {
-		username string
-		isAdmin  bool
+		username   string
+		isAdmin    bool
+		superAdmin bool
 	}{
-		{"dad", true},
-		{"mom", true},
-		{"theo", false},
-		{"ray", false},
+		{"dad", true, true},
+		{"mom", true, false},
+		{"theo", false, false},
+		{"ray", false, false},
 	}
 
 	defaultPassword := strings.TrimSpace(os.Getenv("STAR_APP_DEFAULT_PASSWORD"))
@@ -336,8 +713,8 @@ func seedUsers() error {
 		if err != nil {
 			return err
 		}
-		_, err = db.Exec("INSERT INTO users (username, password_hash, is_admin) VALUES (?, ?, ?)",
-			u.username, string(hash), u.isAdmin)
+		_, err = db.Exec("INSERT INTO users (family_id, username, password_hash, is_admin, is_super_admin) VALUES (1, ?, ?, ?, ?)",
+			u.username, string(hash), u.isAdmin, u.superAdmin)
 		if err != nil {
 			return err
 		}
@@ -365,7 +742,15 @@ func seedRewards() error {
 	if count > 0 {
 		return nil
 	}
+	if err := seedFamilyRewards(1); err != nil {
+		return err
+	}
+	fmt.Println("Seeded default rewards")
+	return nil
+}
 
+// seedFamilyRewards gives a family the default reward catalog.
+func seedFamilyRewards(familyID int) error {
 	rewards := []struct {
 		key  string
 		name string
@@ -381,7 +766,7 @@ func seedRewards() error {
 	}
 
 	for _, r := range rewards {
-		result, err := db.Exec("INSERT INTO rewards (key, cost, icon) VALUES (?, ?, ?)", r.key, r.cost, r.icon)
+		result, err := db.Exec("INSERT INTO rewards (family_id, key, cost, icon) VALUES (?, ?, ?, ?)", familyID, r.key, r.cost, r.icon)
 		if err != nil {
 			return err
 		}
@@ -389,23 +774,87 @@ func seedRewards() error {
 		// Add English translation
 		db.Exec("INSERT INTO reward_translations (reward_id, lang, text) VALUES (?, 'en', ?)", id, r.name)
 	}
-	fmt.Println("Seeded default rewards")
 	return nil
 }
 
-func addUser(username, password string, isAdmin bool) error {
+func addUser(familyID int, username, password string, isAdmin bool) error {
 	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
 	if err != nil {
 		return err
 	}
-	_, err = db.Exec("INSERT INTO users (username, password_hash, is_admin) VALUES (?, ?, ?)",
-		username, string(hash), isAdmin)
+	_, err = db.Exec("INSERT INTO users (family_id, username, password_hash, is_admin) VALUES (?, ?, ?, ?)",
+		familyID, username, string(hash), isAdmin)
 	return err
 }
 
+// createFamily adds a family with its first parent and the default rewards.
+// Usernames are unique across the deployment since login doesn't ask for a family.
+func createFamily(name, username, password string) (int, error) {
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return 0, err
+	}
+	tx, err := db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+	result, err := tx.Exec("INSERT INTO families (name) VALUES (?)", name)
+	if err != nil {
+		return 0, err
+	}
+	id64, _ := result.LastInsertId()
+	familyID := int(id64)
+	if _, err := tx.Exec("INSERT INTO users (family_id, username, password_hash, is_admin) VALUES (?, ?, ?, 1)",
+		familyID, username, string(hash)); err != nil {
+		return 0, err
+	}
+	if err := tx.Commit(); err != nil {
+		return 0, err
+	}
+	return familyID, seedFamilyRewards(familyID)
+}
+
+func getFamilies() ([]Family, error) {
+	rows, err := db.Query(`SELECT f.id, f.name, (SELECT COUNT(*) FROM users u WHERE u.family_id = f.id), f.created_at
+		FROM families f ORDER BY f.id`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var families []Family
+	for rows.Next() {
+		var f Family
+		var createdAt sql.NullString
+		if err := rows.Scan(&f.ID, &f.Name, &f.Members, &createdAt); err != nil {
+			continue
+		}
+		if t := parseNullTime(createdAt); t != nil {
+			f.CreatedAt = *t
+		}
+		families = append(families, f)
+	}
+	return families, nil
+}
+
+func getFamilyByID(id int) (*Family, error) {
+	f := &Family{}
+	var createdAt sql.NullString
+	err := db.QueryRow(`SELECT f.id, f.name, (SELECT COUNT(*) FROM users u WHERE u.family_id = f.id), f.created_at
+		FROM families f WHERE f.id = ?`, id).Scan(&f.ID, &f.Name, &f.Members, &createdAt)
+	if err != nil {
+		return nil, err
+	}
+	if t := parseNullTime(createdAt); t != nil {
+		f.CreatedAt = *t
+	}
+	return f, nil
+}
+
 func deleteUser(id int) error {
 	db.Exec("DELETE FROM sessions WHERE user_id = ?", id)
 	db.Exec("DELETE FROM user_translations WHERE user_id = ?", id)
+	db.Exec("DELETE FROM user_settings WHERE user_id = ?", id)
 	db.Exec("DELETE FROM redemptions WHERE user_id = ?", id)
 	db.Exec("DELETE FROM stars WHERE user_id = ?", id)
 	_, err := db.Exec("DELETE FROM users WHERE id = ?", id)
@@ -417,10 +866,23 @@ func updatePassword(userID int, newHash string) error {
 	return err
 }
 
+// updateUserLanguage saves a user's language preference; "" follows the browser.
+func updateUserLanguage(userID int, lang string) error {
+	_, err := db.Exec("UPDATE users SET lang = ? WHERE id = ?", lang, userID)
+	return err
+}
+
+// familyName returns a family's name for page headers.
+func familyName(id int) string {
+	var name string
+	db.QueryRow("SELECT name FROM families WHERE id = ?", id).Scan(&name)
+	return name
+}
+
 func getUserByUsername(username string) (*User, error) {
 	u := &User{}
-	err := db.QueryRow("SELECT id, username, password_hash, is_admin FROM users WHERE username = ?", username).
-		Scan(&u.ID, &u.Username, &u.PasswordHash, &u.IsAdmin)
+	err := db.QueryRow("SELECT id, family_id, username, password_hash, is_admin, COALESCE(is_super_admin, 0), lang FROM users WHERE username = ?", username).
+		Scan(&u.ID, &u.FamilyID, &u.Username, &u.PasswordHash, &u.IsAdmin, &u.IsSuperAdmin, &u.Lang)
 	if err != nil {
 		return nil, err
 	}
@@ -430,8 +892,8 @@ func getUserByUsername(username string) (*User, error) {
 func getUserByID(id int) (*User, error) {
 	u := &User{}
 	u.Translations = make(map[string]string)
-	err := db.QueryRow("SELECT id, username, password_hash, is_admin FROM users WHERE id = ?", id).
-		Scan(&u.ID, &u.Username, &u.PasswordHash, &u.IsAdmin)
+	err := db.QueryRow("SELECT id, family_id, username, password_hash, is_admin, COALESCE(is_super_admin, 0), lang FROM users WHERE id = ?", id).
+		Scan(&u.ID, &u.FamilyID, &u.Username, &u.PasswordHash, &u.IsAdmin, &u.IsSuperAdmin, &u.Lang)
 	if err != nil {
 		return nil, err
 	}
@@ -448,8 +910,15 @@ func getUserByID(id int) (*User, error) {
 	return u, nil
 }
 
-func getAllUsers() ([]User, error) {
-	rows, err := db.Query("SELECT id, username, password_hash, is_admin FROM users")
+// getAllUsers lists a family's users. Pass familyID 0 for every family.
+func getAllUsers(familyID int) ([]User, error) {
+	query := "SELECT id, family_id, username, password_hash, is_admin, COALESCE(is_super_admin, 0), lang FROM users"
+	var args []interface{}
+	if familyID > 0 {
+		query += " WHERE family_id = ?"
+		args = append(args, familyID)
+	}
+	rows, err := db.Query(query, args...)
 	if err != nil {
 		return nil, err
 	}
@@ -459,7 +928,7 @@ func getAllUsers() ([]User, error) {
 	for rows.Next() {
 		var u User
 		u.Translations = make(map[string]string)
-		rows.Scan(&u.ID, &u.Username, &u.PasswordHash, &u.IsAdmin)
+		rows.Scan(&u.ID, &u.FamilyID, &u.Username, &u.PasswordHash, &u.IsAdmin, &u.IsSuperAdmin, &u.Lang)
 
 		// Load all translations for this user
 		tRows, _ := db.Query("SELECT lang, text FROM user_translations WHERE user_id = ?", u.ID)
@@ -501,23 +970,62 @@ func getUserText(userID int, lang string) string {
 	return username
 }
 
-type UserStarCount struct {
-	UserID        int
-	Username      string
-	DisplayNameEN string
-	DisplayNameCN string
-	DisplayNameTW string
-	StarCount     int
-	CurrentStars  int
-	IsAdmin       bool
+// getUserTexts returns a user's display name in every language, falling back
+// to English and then the username.
+func getUserTexts(userID int) map[string]string {
+	var username string
+	db.QueryRow("SELECT username FROM users WHERE id = ?", userID).Scan(&username)
+	return resolveTranslations(loadTranslations("user_translations", "user_id", userID), username)
+}
+
+// loadTranslations reads the stored texts of one row from a *_translations table.
+func loadTranslations(table, column string, id int) map[string]string {
+	translations := make(map[string]string)
+	rows, err := db.Query("SELECT lang, text FROM "+table+" WHERE "+column+" = ?", id)
+	if err != nil {
+		return translations
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var lang, text string
+		rows.Scan(&lang, &text)
+		translations[lang] = text
+	}
+	return translations
 }
 
-func getUserStarCounts() ([]UserStarCount, error) {
+type UserStarCount struct {
+	UserID                  int
+	Username                string
+	DisplayNameTranslations map[string]string
+	StarCount               int
+	CurrentStars            int
+	ReservedStars           int
+	IsAdmin                 bool
+	Goal                    *SavingsGoal
+	Balances                []CurrencyBalance
+}
+
+// CurrencyBalance is a user's standing in one of the family's own currencies.
+type CurrencyBalance struct {
+	CurrencyID       int
+	Key              string
+	Icon             string
+	NameTranslations map[string]string
+	Balance          int
+	Earned           int
+	Reserved         int
+}
+
+// getUserStarCounts returns every member's stars, plus their balance in each
+// of the family's other currencies.
+func getUserStarCounts(familyID int) ([]UserStarCount, error) {
 	rows, err := db.Query(`
-		SELECT u.id, u.username, u.is_admin, COALESCE(SUM(s.stars), 0) as star_count,
-			COALESCE(SUM(s.stars), 0) - COALESCE((SELECT SUM(COALESCE(rd.cost, rw.cost)) FROM redemptions rd JOIN rewards rw ON rd.reward_id = rw.id WHERE rd.user_id = u.id), 0) as current_stars
-		FROM users u LEFT JOIN stars s ON u.id = s.user_id
-		GROUP BY u.id ORDER BY star_count DESC`)
+		SELECT u.id, u.username, u.is_admin, COALESCE(l.earned, 0) as star_count, COALESCE(l.balance, 0) as current_stars
+		FROM users u
+		LEFT JOIN ledger_entries l ON l.id = (SELECT MAX(id) FROM ledger_entries WHERE user_id = u.id AND currency_id = 0)
+		WHERE u.family_id = ?
+		ORDER BY star_count DESC`, familyID)
 	if err != nil {
 		return nil, err
 	}
@@ -527,20 +1035,45 @@ func getUserStarCounts() ([]UserStarCount, error) {
 	for rows.Next() {
 		var r UserStarCount
 		rows.Scan(&r.UserID, &r.Username, &r.IsAdmin, &r.StarCount, &r.CurrentStars)
+		results = append(results, r)
+	}
+	rows.Close()
 
-		// Get all translations for this user
-		r.DisplayNameEN = getUserText(r.UserID, "en")
-		r.DisplayNameCN = getUserText(r.UserID, "zh-CN")
-		r.DisplayNameTW = getUserText(r.UserID, "zh-TW")
+	currencies, _ := getCurrencies(familyID)
+	for i := range results {
+		r := &results[i]
+		r.ReservedStars = getUserReserved(r.UserID, 0)
 
-		results = append(results, r)
+		r.DisplayNameTranslations = getUserTexts(r.UserID)
+
+		r.Balances = getUserBalances(r.UserID, currencies)
+		r.Goal = goalProgress(r.UserID, time.Now())
 	}
 	return results, nil
 }
 
+// getUserBalances lists a user's balance in each of the given currencies.
+func getUserBalances(userID int, currencies []Currency) []CurrencyBalance {
+	balances := make([]CurrencyBalance, 0, len(currencies))
+	for _, c := range currencies {
+		b := CurrencyBalance{
+			CurrencyID:       c.ID,
+			Key:              c.Key,
+			Icon:             currencyIcon(c.ID),
+			NameTranslations: getCurrencyTexts(c.ID),
+			Reserved:         getUserReserved(userID, c.ID),
+		}
+		b.Balance, b.Earned, _ = ledgerBalance(db, userID, c.ID)
+		balances = append(balances, b)
+	}
+	return balances
+}
+
 // getUserReasonCounts returns map[userID]map[reasonID]count
-func getUserReasonCounts() (map[int]map[int]int, error) {
-	rows, err := db.Query(`SELECT user_id, reason_id, COUNT(*) FROM stars WHERE reason_id IS NOT NULL GROUP BY user_id, reason_id`)
+func getUserReasonCounts(familyID int) (map[int]map[int]int, error) {
+	rows, err := db.Query(`SELECT s.user_id, s.reason_id, COUNT(*) FROM stars s JOIN users u ON s.user_id = u.id
+		WHERE s.reason_id IS NOT NULL AND s.voided_at IS NULL AND u.family_id = ?
+		GROUP BY s.user_id, s.reason_id`, familyID)
 	if err != nil {
 		return nil, err
 	}
@@ -558,15 +1091,16 @@ func getUserReasonCounts() (map[int]map[int]int, error) {
 	return result, nil
 }
 
-func getStars(filterUsername string) ([]Star, error) {
-	query := `SELECT s.id, s.user_id, u.username, s.reason_id, COALESCE(r.key, ''), s.reason_text, s.stars, s.awarded_by, COALESCE(a.username,''), s.created_at
+func getStars(familyID int, filterUsername string) ([]Star, error) {
+	query := `SELECT s.id, s.user_id, u.username, s.reason_id, COALESCE(r.key, ''), s.reason_text, s.stars, s.currency_id, COALESCE(s.awarded_by, 0), COALESCE(a.username,''), s.created_at
 		FROM stars s
 		JOIN users u ON s.user_id = u.id
 		LEFT JOIN reasons r ON s.reason_id = r.id
-		LEFT JOIN users a ON s.awarded_by = a.id`
-	var args []interface{}
+		LEFT JOIN users a ON s.awarded_by = a.id
+		WHERE s.voided_at IS NULL AND u.family_id = ?`
+	args := []interface{}{familyID}
 	if filterUsername != "" {
-		query += " WHERE u.username = ?"
+		query += " AND u.username = ?"
 		args = append(args, filterUsername)
 	}
 	query += " ORDER BY s.created_at DESC"
@@ -583,22 +1117,18 @@ func getStars(filterUsername string) ([]Star, error) {
 		var reasonKey sql.NullString
 		var reasonText sql.NullString
 		var createdAtStr sql.NullString
-		err := rows.Scan(&s.ID, &s.UserID, &s.Username, &s.ReasonID, &reasonKey, &reasonText, &s.Stars, &s.AwardedBy, &s.AwardedByName, &createdAtStr)
+		err := rows.Scan(&s.ID, &s.UserID, &s.Username, &s.ReasonID, &reasonKey, &reasonText, &s.Stars, &s.CurrencyID, &s.AwardedBy, &s.AwardedByName, &createdAtStr)
 		if err != nil {
 			fmt.Printf("Error scanning star row: %v\n", err)
 			continue
 		}
 
 		// Get user translations
-		s.UsernameEN = getUserText(s.UserID, "en")
-		s.UsernameCN = getUserText(s.UserID, "zh-CN")
-		s.UsernameTW = getUserText(s.UserID, "zh-TW")
+		s.UsernameTranslations = getUserTexts(s.UserID)
 
 		// Get awarded_by user translations if awarded_by is set
 		if s.AwardedBy > 0 {
-			s.AwardedByNameEN = getUserText(s.AwardedBy, "en")
-			s.AwardedByNameCN = getUserText(s.AwardedBy, "zh-CN")
-			s.AwardedByNameTW = getUserText(s.AwardedBy, "zh-TW")
+			s.AwardedByTranslations = getUserTexts(s.AwardedBy)
 		}
 
 		if reasonKey.Valid {
@@ -625,11 +1155,13 @@ func getStars(filterUsername string) ([]Star, error) {
 }
 
 func addStar(username, reason string, awardedBy int) error {
-	_, err := addStarWithID(username, nil, reason, 1, awardedBy)
+	_, err := addStarWithID(username, nil, reason, 0, 1, awardedBy)
 	return err
 }
 
-func addStarWithID(username string, reasonID *int, reasonText string, stars int, awardedBy int) (int64, error) {
+// addStarWithID awards stars for a reason, given by ID or by its English text.
+// Unknown text creates a new reason in currencyID; existing reasons keep their own currency.
+func addStarWithID(username string, reasonID *int, reasonText string, currencyID int, stars int, awardedBy int) (int64, error) {
 	user, err := getUserByUsername(username)
 	if err != nil {
 		return 0, fmt.Errorf("user not found: %s", username)
@@ -637,22 +1169,20 @@ func addStarWithID(username string, reasonID *int, reasonText string, stars int,
 
 	// If reason ID provided, use it directly
 	if reasonID != nil && *reasonID > 0 {
+		var reasonStars int
+		err := db.QueryRow("SELECT stars FROM reasons WHERE id = ? AND family_id = ?", reasonID, user.FamilyID).Scan(&reasonStars)
+		if err != nil {
+			return 0, fmt.Errorf("reason not found: %d", *reasonID)
+		}
 		// Get the star count from the reason if not explicitly provided
 		if stars == 0 {
-			var reasonStars int
-			err := db.QueryRow("SELECT stars FROM reasons WHERE id = ?", reasonID).Scan(&reasonStars)
-			if err == nil && reasonStars != 0 {
+			if reasonStars != 0 {
 				stars = reasonStars
 			} else {
 				stars = 1
 			}
 		}
-		result, err := db.Exec("INSERT INTO stars (user_id, reason_id, stars, awarded_by) VALUES (?, ?, ?, ?)",
-			user.ID, reasonID, stars, awardedBy)
-		if err != nil {
-			return 0, err
-		}
-		return result.LastInsertId()
+		return insertStar(user.ID, *reasonID, stars, awardedBy)
 	}
 
 	// Default to 1 star for custom reasons if not specified
@@ -667,15 +1197,16 @@ func addStarWithID(username string, reasonID *int, reasonText string, stars int,
 
 	// Try to find existing reason by matching English translation
 	var existingID *int
-	err = db.QueryRow("SELECT r.id FROM reasons r JOIN reason_translations rt ON r.id = rt.reason_id WHERE rt.text = ? AND rt.lang = 'en'", reasonText).Scan(&existingID)
+	err = db.QueryRow("SELECT r.id FROM reasons r JOIN reason_translations rt ON r.id = rt.reason_id WHERE rt.text = ? AND rt.lang = 'en' AND r.family_id = ?",
+		reasonText, user.FamilyID).Scan(&existingID)
 
 	if err == nil && existingID != nil {
 		// Found existing reason, use it
 		reasonID = existingID
 	} else {
 		// Create new reason with specified star count
-		key := uniqueKey(sanitizeKey(reasonText), "reasons", "key")
-		result, err := db.Exec("INSERT INTO reasons (key, stars) VALUES (?, ?)", key, stars)
+		key := uniqueKey(user.FamilyID, sanitizeKey(reasonText), "reasons", "key")
+		result, err := db.Exec("INSERT INTO reasons (family_id, key, stars, currency_id) VALUES (?, ?, ?, ?)", user.FamilyID, key, stars, currencyID)
 		if err != nil {
 			return 0, err
 		}
@@ -687,12 +1218,7 @@ func addStarWithID(username string, reasonID *int, reasonText string, stars int,
 		db.Exec("INSERT INTO reason_translations (reason_id, lang, text) VALUES (?, 'en', ?)", rid, reasonText)
 	}
 
-	result, err := db.Exec("INSERT INTO stars (user_id, reason_id, stars, awarded_by) VALUES (?, ?, ?, ?)",
-		user.ID, reasonID, stars, awardedBy)
-	if err != nil {
-		return 0, err
-	}
-	return result.LastInsertId()
+	return insertStar(user.ID, *reasonID, stars, awardedBy)
 }
 
 func sanitizeKey(text string) string {
@@ -720,15 +1246,15 @@ func updateReasonTranslation(reasonID int, lang, text string) error {
 	return err
 }
 
-func updateReasonStars(reasonID int, stars int, retroactive bool) error {
+func updateReasonStars(reasonID int, stars int, retroactive bool, changedBy int) error {
 	// Update the reason's default star count
 	_, err := db.Exec("UPDATE reasons SET stars = ? WHERE id = ?", stars, reasonID)
 	if err != nil {
 		return err
 	}
 	if retroactive {
-		// Retroactively update all existing stars with this reason
-		_, err = db.Exec("UPDATE stars SET stars = ? WHERE reason_id = ?", stars, reasonID)
+		// Retroactively update existing stars, recording the difference in the ledger
+		err = adjustReasonStars(reasonID, stars, changedBy)
 	}
 	return err
 }
@@ -741,8 +1267,8 @@ func deleteReason(reasonID int) error {
 func getStarByID(id int) (*Star, error) {
 	var s Star
 	var reasonText sql.NullString
-	err := db.QueryRow("SELECT id, user_id, reason_id, reason_text, stars, awarded_by FROM stars WHERE id = ?", id).
-		Scan(&s.ID, &s.UserID, &s.ReasonID, &reasonText, &s.Stars, &s.AwardedBy)
+	err := db.QueryRow("SELECT id, user_id, reason_id, reason_text, stars, COALESCE(awarded_by, 0), currency_id FROM stars WHERE id = ? AND voided_at IS NULL", id).
+		Scan(&s.ID, &s.UserID, &s.ReasonID, &reasonText, &s.Stars, &s.AwardedBy, &s.CurrencyID)
 	if err != nil {
 		return nil, err
 	}
@@ -752,35 +1278,29 @@ func getStarByID(id int) (*Star, error) {
 	return &s, nil
 }
 
-func deleteStar(id int) error {
-	_, err := db.Exec("DELETE FROM stars WHERE id = ?", id)
-	return err
-}
-
 func getRedemptionByID(id int) (*Redemption, error) {
 	var r Redemption
-	err := db.QueryRow("SELECT id, user_id FROM redemptions WHERE id = ?", id).
-		Scan(&r.ID, &r.UserID)
+	err := db.QueryRow(`SELECT rd.id, rd.user_id, rd.reward_id, rw.key, COALESCE(rd.cost, rw.cost), rd.currency_id
+		FROM redemptions rd JOIN rewards rw ON rd.reward_id = rw.id
+		WHERE rd.id = ? AND rd.voided_at IS NULL`, id).
+		Scan(&r.ID, &r.UserID, &r.RewardID, &r.RewardKey, &r.Cost, &r.CurrencyID)
 	if err != nil {
 		return nil, err
 	}
+	r.CurrencyIcon = currencyIcon(r.CurrencyID)
 	return &r, nil
 }
 
-func deleteRedemption(id int) error {
-	_, err := db.Exec("DELETE FROM redemptions WHERE id = ?", id)
-	return err
-}
-
-func getReasons() ([]Reason, error) {
+func getReasons(familyID int) ([]Reason, error) {
 	// Get reasons with star count
 	rows, err := db.Query(`
-		SELECT r.id, r.key, r.stars, COUNT(s.id) as count
+		SELECT r.id, r.family_id, r.key, r.stars, r.currency_id, COUNT(s.id) as count
 		FROM reasons r
-		LEFT JOIN stars s ON r.id = s.reason_id
+		LEFT JOIN stars s ON r.id = s.reason_id AND s.voided_at IS NULL
+		WHERE r.family_id = ?
 		GROUP BY r.id, r.key, r.stars
 		ORDER BY count DESC
-	`)
+	`, familyID)
 	if err != nil {
 		return nil, err
 	}
@@ -790,7 +1310,8 @@ func getReasons() ([]Reason, error) {
 	for rows.Next() {
 		var r Reason
 		r.Translations = make(map[string]string)
-		rows.Scan(&r.ID, &r.Key, &r.Stars, &r.Count)
+		rows.Scan(&r.ID, &r.FamilyID, &r.Key, &r.Stars, &r.CurrencyID, &r.Count)
+		r.CurrencyIcon = currencyIcon(r.CurrencyID)
 
 		// Load all translations for this reason
 		tRows, _ := db.Query("SELECT lang, text FROM reason_translations WHERE reason_id = ?", r.ID)
@@ -806,6 +1327,29 @@ func getReasons() ([]Reason, error) {
 	return reasons, nil
 }
 
+func getReasonIDByKey(familyID int, key string) (int, error) {
+	var id int
+	err := db.QueryRow("SELECT id FROM reasons WHERE family_id = ? AND key = ?", familyID, key).Scan(&id)
+	return id, err
+}
+
+func getReasonByID(id int) (*Reason, error) {
+	r := &Reason{Translations: make(map[string]string)}
+	err := db.QueryRow("SELECT id, family_id, key, stars, currency_id FROM reasons WHERE id = ?", id).Scan(&r.ID, &r.FamilyID, &r.Key, &r.Stars, &r.CurrencyID)
+	if err != nil {
+		return nil, err
+	}
+	r.CurrencyIcon = currencyIcon(r.CurrencyID)
+	rows, _ := db.Query("SELECT lang, text FROM reason_translations WHERE reason_id = ?", id)
+	defer rows.Close()
+	for rows.Next() {
+		var lang, text string
+		rows.Scan(&lang, &text)
+		r.Translations[lang] = text
+	}
+	return r, nil
+}
+
 func getReasonText(reasonID *int, reasonText string, lang string) string {
 	if reasonID != nil && *reasonID > 0 {
 		var text string
@@ -823,28 +1367,115 @@ func getReasonText(reasonID *int, reasonText string, lang string) string {
 	return reasonText
 }
 
+// getReasonTexts returns a reason in every language. Free-text reasons have
+// only their own text.
+func getReasonTexts(reasonID *int, reasonText string) map[string]string {
+	if reasonID == nil || *reasonID <= 0 {
+		return resolveTranslations(nil, reasonText)
+	}
+	return resolveTranslations(loadTranslations("reason_translations", "reason_id", *reasonID), reasonText)
+}
+
 func hashAPIKey(key string) string {
 	h := sha256.Sum256([]byte(key))
 	return hex.EncodeToString(h[:])
 }
 
-func addAPIKey(keyHash, label string) error {
-	_, err := db.Exec("INSERT INTO api_keys (key_hash, label) VALUES (?, ?)", keyHash, label)
-	return err
-}
+func addAPIKey(familyID int, keyHash, label string, scopes []string, ownerID int, userIDs []int, expiresAt *time.Time) (int64, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
 
-func getAPIKeys() ([]APIKey, error) {
-	rows, err := db.Query("SELECT id, key_hash, label, created_at FROM api_keys")
+	var owner, expires interface{}
+	if ownerID > 0 {
+		owner = ownerID
+	}
+	if expiresAt != nil {
+		expires = expiresAt.UTC().Format("2006-01-02 15:04:05")
+	}
+	result, err := tx.Exec("INSERT INTO api_keys (family_id, key_hash, label, scopes, owner_id, expires_at) VALUES (?, ?, ?, ?, ?, ?)",
+		familyID, keyHash, label, strings.Join(scopes, ","), owner, expires)
 	if err != nil {
+		return 0, err
+	}
+	keyID, _ := result.LastInsertId()
+	for _, userID := range userIDs {
+		if _, err := tx.Exec("INSERT OR IGNORE INTO api_key_users (api_key_id, user_id) VALUES (?, ?)", keyID, userID); err != nil {
+			return 0, err
+		}
+	}
+	return keyID, tx.Commit()
+}
+
+const apiKeyColumns = `k.id, k.family_id, k.key_hash, COALESCE(k.label, ''), k.scopes, COALESCE(k.owner_id, 0), COALESCE(o.username, ''), k.expires_at, k.last_used_at, k.created_at
+	FROM api_keys k LEFT JOIN users o ON k.owner_id = o.id`
+
+func scanAPIKey(scan func(dest ...interface{}) error) (*APIKey, error) {
+	var k APIKey
+	var scopes string
+	var expiresAt, lastUsedAt, createdAt sql.NullString
+	if err := scan(&k.ID, &k.FamilyID, &k.KeyHash, &k.Label, &scopes, &k.OwnerID, &k.OwnerName, &expiresAt, &lastUsedAt, &createdAt); err != nil {
 		return nil, err
 	}
-	defer rows.Close()
+	for _, scope := range strings.Split(scopes, ",") {
+		if scope = strings.TrimSpace(scope); scope != "" {
+			k.Scopes = append(k.Scopes, scope)
+		}
+	}
+	k.ExpiresAt = parseNullTime(expiresAt)
+	k.LastUsedAt = parseNullTime(lastUsedAt)
+	if t := parseNullTime(createdAt); t != nil {
+		k.CreatedAt = *t
+	}
+
+	rows, err := db.Query("SELECT u.id, u.username FROM api_key_users ku JOIN users u ON ku.user_id = u.id WHERE ku.api_key_id = ? ORDER BY u.id", k.ID)
+	if err == nil {
+		defer rows.Close()
+		for rows.Next() {
+			var id int
+			var username string
+			rows.Scan(&id, &username)
+			k.UserIDs = append(k.UserIDs, id)
+			k.Usernames = append(k.Usernames, username)
+		}
+	}
+	return &k, nil
+}
 
+// parseNullTime parses an optional SQLite datetime column.
+func parseNullTime(v sql.NullString) *time.Time {
+	if !v.Valid || v.String == "" {
+		return nil
+	}
+	if t, err := time.Parse(time.RFC3339, v.String); err == nil {
+		return &t
+	}
+	if t, err := time.Parse("2006-01-02 15:04:05", v.String); err == nil {
+		return &t
+	}
+	return nil
+}
+
+func getAPIKeys(familyID int) ([]APIKey, error) {
+	rows, err := db.Query("SELECT "+apiKeyColumns+" WHERE k.family_id = ? ORDER BY k.id", familyID)
+	if err != nil {
+		return nil, err
+	}
 	var keys []APIKey
+	var scanErr error
 	for rows.Next() {
-		var k APIKey
-		rows.Scan(&k.ID, &k.KeyHash, &k.Label, &k.CreatedAt)
-		keys = append(keys, k)
+		k, err := scanAPIKey(rows.Scan)
+		if err != nil {
+			scanErr = err
+			continue
+		}
+		keys = append(keys, *k)
+	}
+	rows.Close()
+	if keys == nil && scanErr != nil {
+		return nil, scanErr
 	}
 	return keys, nil
 }
@@ -854,305 +1485,1090 @@ func deleteAPIKey(id int) error {
 	return err
 }
 
-func validateAPIKey(key string) bool {
-	h := hashAPIKey(key)
-	var count int
-	db.QueryRow("SELECT COUNT(*) FROM api_keys WHERE key_hash = ?", h).Scan(&count)
-	return count > 0
+func getAPIKeyByKey(key string) (*APIKey, error) {
+	return scanAPIKey(db.QueryRow("SELECT "+apiKeyColumns+" WHERE k.key_hash = ?", hashAPIKey(key)).Scan)
 }
 
-// Session management using DB
-func createSession(token string, userID int) error {
-	_, err := db.Exec("INSERT INTO sessions (token, user_id) VALUES (?, ?)", token, userID)
-	return err
+func getAPIKeyByID(id int) (*APIKey, error) {
+	return scanAPIKey(db.QueryRow("SELECT "+apiKeyColumns+" WHERE k.id = ?", id).Scan)
 }
 
-func getSession(token string) (int, error) {
-	var userID int
-	err := db.QueryRow("SELECT user_id FROM sessions WHERE token = ?", token).Scan(&userID)
-	return userID, err
+func touchAPIKey(id int) {
+	db.Exec("UPDATE api_keys SET last_used_at = CURRENT_TIMESTAMP WHERE id = ?", id)
 }
 
-func deleteSession(token string) error {
-	_, err := db.Exec("DELETE FROM sessions WHERE token = ?", token)
-	return err
+func addWebhook(familyID int, url, secret string, events []string) (int64, error) {
+	result, err := db.Exec("INSERT INTO webhooks (family_id, url, secret, events) VALUES (?, ?, ?, ?)", familyID, url, secret, strings.Join(events, ","))
+	if err != nil {
+		return 0, err
+	}
+	return result.LastInsertId()
+}
+
+func scanWebhook(scan func(dest ...interface{}) error) (*Webhook, error) {
+	var h Webhook
+	var events string
+	var createdAt sql.NullString
+	if err := scan(&h.ID, &h.FamilyID, &h.URL, &h.Secret, &events, &h.Enabled, &createdAt); err != nil {
+		return nil, err
+	}
+	for _, e := range strings.Split(events, ",") {
+		if e = strings.TrimSpace(e); e != "" {
+			h.Events = append(h.Events, e)
+		}
+	}
+	if t := parseNullTime(createdAt); t != nil {
+		h.CreatedAt = *t
+	}
+	return &h, nil
 }
 
-func getRewardsList() ([]Reward, error) {
-	rows, err := db.Query("SELECT id, key, cost, icon, COALESCE(adult_only, 0) FROM rewards ORDER BY cost ASC")
+func getWebhooks(familyID int) ([]Webhook, error) {
+	rows, err := db.Query("SELECT id, family_id, url, secret, events, enabled, created_at FROM webhooks WHERE family_id = ? ORDER BY id", familyID)
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
-
-	var rewards []Reward
+	var hooks []Webhook
 	for rows.Next() {
-		var r Reward
-		r.Translations = make(map[string]string)
-		rows.Scan(&r.ID, &r.Key, &r.Cost, &r.Icon, &r.ForAdults)
-
-		// Load all translations for this reward
-		tRows, _ := db.Query("SELECT lang, text FROM reward_translations WHERE reward_id = ?", r.ID)
-		for tRows.Next() {
-			var lang, text string
-			tRows.Scan(&lang, &text)
-			r.Translations[lang] = text
-			if lang == "en" {
-				r.Name = text // Keep Name field for backward compatibility
-			}
+		h, err := scanWebhook(rows.Scan)
+		if err != nil {
+			continue
 		}
-		tRows.Close()
-
-		rewards = append(rewards, r)
+		hooks = append(hooks, *h)
 	}
-	return rewards, nil
+	return hooks, nil
 }
 
-func uniqueKey(baseKey, table, column string) string {
-	key := baseKey
-	for i := 2; ; i++ {
-		var count int
-		db.QueryRow("SELECT COUNT(*) FROM "+table+" WHERE "+column+" = ?", key).Scan(&count)
-		if count == 0 {
-			return key
-		}
-		key = fmt.Sprintf("%s_%d", baseKey, i)
-	}
+func getWebhookByID(id int) (*Webhook, error) {
+	return scanWebhook(db.QueryRow("SELECT id, family_id, url, secret, events, enabled, created_at FROM webhooks WHERE id = ?", id).Scan)
 }
 
-func addReward(name string, cost int, icon string, adultOnly bool) error {
-	key := uniqueKey(sanitizeKey(name), "rewards", "key")
-	var result sql.Result
-	var err error
-	// Migrated databases may still have the old "name" column with NOT NULL
-	if columnExists("rewards", "name") {
-		result, err = db.Exec("INSERT INTO rewards (name, key, cost, icon, adult_only) VALUES (?, ?, ?, ?, ?)", name, key, cost, icon, adultOnly)
-	} else {
-		result, err = db.Exec("INSERT INTO rewards (key, cost, icon, adult_only) VALUES (?, ?, ?, ?)", key, cost, icon, adultOnly)
-	}
+func setWebhookEnabled(id int, enabled bool) error {
+	_, err := db.Exec("UPDATE webhooks SET enabled = ? WHERE id = ?", enabled, id)
+	return err
+}
+
+func deleteWebhook(id int) error {
+	tx, err := db.Begin()
 	if err != nil {
 		return err
 	}
-	id, _ := result.LastInsertId()
-	// Add English translation
-	_, err = db.Exec("INSERT INTO reward_translations (reward_id, lang, text) VALUES (?, 'en', ?)", id, name)
+	defer tx.Rollback()
+	if _, err := tx.Exec("DELETE FROM webhook_deliveries WHERE webhook_id = ?", id); err != nil {
+		return err
+	}
+	if _, err := tx.Exec("DELETE FROM webhooks WHERE id = ?", id); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func addWebhookDelivery(webhookID int, e Event, payload []byte) error {
+	_, err := db.Exec(`INSERT INTO webhook_deliveries (webhook_id, event_id, event_type, payload, next_attempt_at)
+		VALUES (?, ?, ?, ?, ?)`,
+		webhookID, e.ID, e.Type, string(payload), time.Now().UTC().Format("2006-01-02 15:04:05"))
 	return err
 }
 
-func updateReward(id int, name string, cost int, icon string) error {
-	_, err := db.Exec("UPDATE rewards SET cost = ?, icon = ? WHERE id = ?", cost, icon, id)
+const webhookDeliveryColumns = `d.id, d.webhook_id, w.url, d.event_id, d.event_type, d.payload, d.status, d.attempts,
+	COALESCE(d.last_status, 0), d.last_error, d.next_attempt_at, d.delivered_at, d.created_at
+	FROM webhook_deliveries d JOIN webhooks w ON d.webhook_id = w.id`
+
+func queryWebhookDeliveries(query string, args ...interface{}) ([]WebhookDelivery, error) {
+	rows, err := db.Query("SELECT "+webhookDeliveryColumns+" "+query, args...)
 	if err != nil {
-		return err
+		return nil, err
 	}
-	// Update English translation
-	_, err = db.Exec(`
-		INSERT INTO reward_translations (reward_id, lang, text) VALUES (?, 'en', ?)
-		ON CONFLICT(reward_id, lang) DO UPDATE SET text = ?
-	`, id, name, name)
-	return err
+	defer rows.Close()
+	var deliveries []WebhookDelivery
+	for rows.Next() {
+		var d WebhookDelivery
+		var nextAttemptAt, deliveredAt, createdAt sql.NullString
+		if err := rows.Scan(&d.ID, &d.WebhookID, &d.WebhookURL, &d.EventID, &d.EventType, &d.Payload, &d.Status, &d.Attempts,
+			&d.LastStatus, &d.LastError, &nextAttemptAt, &deliveredAt, &createdAt); err != nil {
+			continue
+		}
+		d.NextAttemptAt = parseNullTime(nextAttemptAt)
+		d.DeliveredAt = parseNullTime(deliveredAt)
+		if t := parseNullTime(createdAt); t != nil {
+			d.CreatedAt = *t
+		}
+		deliveries = append(deliveries, d)
+	}
+	return deliveries, nil
 }
 
-func updateRewardTranslation(rewardID int, lang, text string) error {
-	_, err := db.Exec(`
-		INSERT INTO reward_translations (reward_id, lang, text) VALUES (?, ?, ?)
-		ON CONFLICT(reward_id, lang) DO UPDATE SET text = ?
-	`, rewardID, lang, text, text)
-	return err
+// getDueWebhookDeliveries returns pending deliveries for enabled webhooks whose next attempt is due.
+func getDueWebhookDeliveries(now time.Time, limit int) ([]WebhookDelivery, error) {
+	return queryWebhookDeliveries(`WHERE d.status = ? AND w.enabled = 1 AND d.next_attempt_at <= ? ORDER BY d.id LIMIT ?`,
+		deliveryPending, now.UTC().Format("2006-01-02 15:04:05"), limit)
 }
 
-func updateRewardCost(rewardID int, cost int, retroactive bool) error {
-	if cost < 1 {
-		cost = 1
+// getWebhookDeliveries returns a family's most recent deliveries for the delivery log.
+func getWebhookDeliveries(familyID, limit int) ([]WebhookDelivery, error) {
+	return queryWebhookDeliveries("WHERE w.family_id = ? ORDER BY d.id DESC LIMIT ?", familyID, limit)
+}
+
+func getWebhookDeliveryByID(id int) (*WebhookDelivery, error) {
+	deliveries, err := queryWebhookDeliveries("WHERE d.id = ?", id)
+	if err != nil {
+		return nil, err
 	}
-	if !retroactive {
-		// Snapshot current cost into existing redemptions before changing
-		db.Exec("UPDATE redemptions SET cost = (SELECT cost FROM rewards WHERE id = ?) WHERE reward_id = ? AND cost IS NULL", rewardID, rewardID)
+	if len(deliveries) == 0 {
+		return nil, sql.ErrNoRows
 	}
-	_, err := db.Exec("UPDATE rewards SET cost = ? WHERE id = ?", cost, rewardID)
+	return &deliveries[0], nil
+}
+
+// updateWebhookDelivery records the outcome of an attempt. nextAttempt is nil
+// once the delivery has succeeded or given up.
+func updateWebhookDelivery(id int, status string, attempts, lastStatus int, lastError string, nextAttempt *time.Time) error {
+	var next, deliveredAt, code interface{}
+	if nextAttempt != nil {
+		next = nextAttempt.UTC().Format("2006-01-02 15:04:05")
+	}
+	if status == deliveryDelivered {
+		deliveredAt = time.Now().UTC().Format("2006-01-02 15:04:05")
+	}
+	if lastStatus > 0 {
+		code = lastStatus
+	}
+	_, err := db.Exec(`UPDATE webhook_deliveries SET status = ?, attempts = ?, last_status = ?, last_error = ?, next_attempt_at = ?, delivered_at = ?
+		WHERE id = ?`, status, attempts, code, lastError, next, deliveredAt, id)
 	return err
 }
 
-func getRewardText(rewardID int, lang string) string {
-	var text string
-	// Try to get translation in requested language
-	err := db.QueryRow("SELECT text FROM reward_translations WHERE reward_id = ? AND lang = ?", rewardID, lang).Scan(&text)
-	if err == nil && text != "" {
-		return text
+// retryWebhookDelivery queues a failed delivery for one more attempt.
+func retryWebhookDelivery(id int) error {
+	result, err := db.Exec("UPDATE webhook_deliveries SET status = ?, next_attempt_at = ? WHERE id = ? AND status = ?",
+		deliveryPending, time.Now().UTC().Format("2006-01-02 15:04:05"), id, deliveryFailed)
+	if err != nil {
+		return err
 	}
-	// Fallback to English
-	err = db.QueryRow("SELECT text FROM reward_translations WHERE reward_id = ? AND lang = 'en'", rewardID).Scan(&text)
-	if err == nil && text != "" {
-		return text
+	if n, _ := result.RowsAffected(); n == 0 {
+		return fmt.Errorf("delivery not found or not failed")
 	}
-	return ""
+	return nil
 }
 
-func updateRewardAdultOnly(rewardID int, adultOnly bool) error {
-	_, err := db.Exec("UPDATE rewards SET adult_only = ? WHERE id = ?", adultOnly, rewardID)
-	return err
+// pruneWebhookDeliveries drops finished deliveries older than before.
+func pruneWebhookDeliveries(before time.Time) {
+	db.Exec("DELETE FROM webhook_deliveries WHERE status != ? AND created_at < ?",
+		deliveryPending, before.UTC().Format("2006-01-02 15:04:05"))
 }
 
-func deleteRewardByID(id int) error {
-	_, err := db.Exec("DELETE FROM rewards WHERE id = ?", id)
+// addEventLog keeps a published event for the event feed.
+func addEventLog(e Event) error {
+	data, err := json.Marshal(e.Data)
+	if err != nil {
+		return err
+	}
+	_, err = db.Exec("INSERT INTO event_log (event_id, family_id, type, data, created_at) VALUES (?, ?, ?, ?, ?)",
+		e.ID, e.FamilyID, e.Type, string(data), e.CreatedAt.UTC().Format("2006-01-02 15:04:05"))
 	return err
 }
 
-func getRewardByID(id int) (*Reward, error) {
-	r := &Reward{}
-	r.Translations = make(map[string]string)
-	err := db.QueryRow("SELECT id, key, cost, icon, COALESCE(adult_only, 0) FROM rewards WHERE id = ?", id).
-		Scan(&r.ID, &r.Key, &r.Cost, &r.Icon, &r.ForAdults)
+// getEventLog returns up to limit of a family's events after cursor, oldest first.
+func getEventLog(familyID int, cursor int64, limit int) ([]LoggedEvent, error) {
+	rows, err := db.Query("SELECT id, event_id, family_id, type, data, created_at FROM event_log WHERE family_id = ? AND id > ? ORDER BY id LIMIT ?",
+		familyID, cursor, limit)
 	if err != nil {
 		return nil, err
 	}
-
-	// Load all translations
-	rows, _ := db.Query("SELECT lang, text FROM reward_translations WHERE reward_id = ?", id)
 	defer rows.Close()
+	var events []LoggedEvent
 	for rows.Next() {
-		var lang, text string
-		rows.Scan(&lang, &text)
-		r.Translations[lang] = text
-		if lang == "en" {
-			r.Name = text
+		var e LoggedEvent
+		var data string
+		var createdAt sql.NullString
+		if err := rows.Scan(&e.Cursor, &e.ID, &e.FamilyID, &e.Type, &data, &createdAt); err != nil {
+			continue
 		}
+		json.Unmarshal([]byte(data), &e.Data)
+		if t := parseNullTime(createdAt); t != nil {
+			e.CreatedAt = *t
+		}
+		events = append(events, e)
 	}
+	return events, nil
+}
 
-	return r, nil
+// latestEventCursor returns the cursor of a family's newest event, 0 if it has none.
+func latestEventCursor(familyID int) int64 {
+	var cursor sql.NullInt64
+	db.QueryRow("SELECT MAX(id) FROM event_log WHERE family_id = ?", familyID).Scan(&cursor)
+	return cursor.Int64
 }
 
-func getUserCurrentStars(userID int) (int, error) {
-	var current int
-	err := db.QueryRow(`
-		SELECT COALESCE(SUM(s.stars), 0) - COALESCE((SELECT SUM(COALESCE(rd.cost, rw.cost)) FROM redemptions rd JOIN rewards rw ON rd.reward_id = rw.id WHERE rd.user_id = ?), 0)
-		FROM stars s WHERE s.user_id = ?`, userID, userID).Scan(&current)
-	return current, err
+// pruneEventLog drops events older than before.
+func pruneEventLog(before time.Time) {
+	db.Exec("DELETE FROM event_log WHERE created_at < ?", before.UTC().Format("2006-01-02 15:04:05"))
 }
 
-func redeemReward(userID, rewardID int) error {
-	_, err := db.Exec("INSERT INTO redemptions (user_id, reward_id, cost) SELECT ?, ?, cost FROM rewards WHERE id = ?", userID, rewardID, rewardID)
+// addAnnouncementDelivery puts an announcement in the outbox for one backend.
+func addAnnouncementDelivery(familyID int, kind, target string, a Announcement) error {
+	_, err := db.Exec(`INSERT INTO announcement_deliveries (family_id, kind, target, event, title, message, lang, username, next_attempt_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		familyID, kind, target, a.Event, a.Title, a.Message, a.Lang, a.Username, time.Now().UTC().Format("2006-01-02 15:04:05"))
 	return err
 }
 
-func getSetting(key string) string {
-	var val string
-	db.QueryRow("SELECT value FROM settings WHERE key = ?", key).Scan(&val)
-	return val
-}
+const announcementDeliveryColumns = `id, family_id, kind, target, event, title, message, lang, username, status, attempts,
+	COALESCE(last_status, 0), last_error, next_attempt_at, delivered_at, created_at
+	FROM announcement_deliveries`
 
-func setSetting(key, value string) error {
-	_, err := db.Exec(`INSERT INTO settings (key, value) VALUES (?, ?)
-		ON CONFLICT(key) DO UPDATE SET value = ?`, key, value, value)
-	return err
+func queryAnnouncementDeliveries(query string, args ...interface{}) ([]AnnouncementDelivery, error) {
+	rows, err := db.Query("SELECT "+announcementDeliveryColumns+" "+query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var deliveries []AnnouncementDelivery
+	for rows.Next() {
+		var d AnnouncementDelivery
+		var nextAttemptAt, deliveredAt, createdAt sql.NullString
+		if err := rows.Scan(&d.ID, &d.FamilyID, &d.Kind, &d.Target, &d.Event, &d.Title, &d.Message, &d.Lang, &d.Username, &d.Status, &d.Attempts,
+			&d.LastStatus, &d.LastError, &nextAttemptAt, &deliveredAt, &createdAt); err != nil {
+			continue
+		}
+		d.NextAttemptAt = parseNullTime(nextAttemptAt)
+		d.DeliveredAt = parseNullTime(deliveredAt)
+		if t := parseNullTime(createdAt); t != nil {
+			d.CreatedAt = *t
+		}
+		deliveries = append(deliveries, d)
+	}
+	return deliveries, nil
 }
 
-func valueAsString(v interface{}) (string, bool) {
-	switch value := v.(type) {
-	case string:
-		return strings.TrimSpace(value), true
-	default:
-		return "", false
-	}
+// getDueAnnouncementDeliveries returns pending deliveries whose next attempt is due.
+func getDueAnnouncementDeliveries(now time.Time, limit int) ([]AnnouncementDelivery, error) {
+	return queryAnnouncementDeliveries("WHERE status = ? AND next_attempt_at <= ? ORDER BY id LIMIT ?",
+		deliveryPending, now.UTC().Format("2006-01-02 15:04:05"), limit)
 }
 
-func valueAsInt(v interface{}) (int, bool) {
-	switch value := v.(type) {
-	case int:
-		return value, true
-	case int64:
-		return int(value), true
-	case float64:
-		return int(value), true
-	case float32:
-		return int(value), true
-	default:
-		return 0, false
-	}
+// getAnnouncementDeliveries returns a family's most recent deliveries for the delivery log.
+func getAnnouncementDeliveries(familyID, limit int) ([]AnnouncementDelivery, error) {
+	return queryAnnouncementDeliveries("WHERE family_id = ? ORDER BY id DESC LIMIT ?", familyID, limit)
 }
 
-func valueAsBool(v interface{}) (bool, bool) {
-	switch value := v.(type) {
-	case bool:
-		return value, true
-	case string:
-		switch strings.ToLower(strings.TrimSpace(value)) {
-		case "1", "true", "yes", "on":
-			return true, true
-		case "0", "false", "no", "off":
-			return false, true
-		default:
-			return false, false
-		}
-	default:
-		return false, false
+func getAnnouncementDeliveryByID(id int) (*AnnouncementDelivery, error) {
+	deliveries, err := queryAnnouncementDeliveries("WHERE id = ?", id)
+	if err != nil {
+		return nil, err
 	}
+	if len(deliveries) == 0 {
+		return nil, sql.ErrNoRows
+	}
+	return &deliveries[0], nil
 }
 
-func valueAsMap(v interface{}) (map[string]interface{}, bool) {
-	value, ok := v.(map[string]interface{})
-	return value, ok
+// getLatestAnnouncementAttempts returns the last delivery attempted to each of
+// a family's backends and targets.
+func getLatestAnnouncementAttempts(familyID int) ([]AnnouncementDelivery, error) {
+	return queryAnnouncementDeliveries(`WHERE id IN (SELECT MAX(id) FROM announcement_deliveries
+		WHERE family_id = ? AND attempts > 0 GROUP BY kind, target) ORDER BY id`, familyID)
 }
 
-func valueAsSlice(v interface{}) ([]interface{}, bool) {
-	value, ok := v.([]interface{})
-	return value, ok
+// updateAnnouncementDelivery records the outcome of an attempt. nextAttempt is
+// nil once the delivery has succeeded or given up.
+func updateAnnouncementDelivery(id int, status string, attempts, lastStatus int, lastError string, nextAttempt *time.Time) error {
+	var next, deliveredAt, code interface{}
+	if nextAttempt != nil {
+		next = nextAttempt.UTC().Format("2006-01-02 15:04:05")
+	}
+	if status == deliveryDelivered {
+		deliveredAt = time.Now().UTC().Format("2006-01-02 15:04:05")
+	}
+	if lastStatus > 0 {
+		code = lastStatus
+	}
+	_, err := db.Exec(`UPDATE announcement_deliveries SET status = ?, attempts = ?, last_status = ?, last_error = ?, next_attempt_at = ?, delivered_at = ?
+		WHERE id = ?`, status, attempts, code, lastError, next, deliveredAt, id)
+	return err
 }
 
-func valueAsStringMap(v interface{}) map[string]string {
-	result := make(map[string]string)
-	entries, ok := valueAsMap(v)
-	if !ok {
-		return result
+// retryAnnouncementDelivery queues a failed delivery for one more attempt.
+func retryAnnouncementDelivery(id int) error {
+	result, err := db.Exec("UPDATE announcement_deliveries SET status = ?, next_attempt_at = ? WHERE id = ? AND status = ?",
+		deliveryPending, time.Now().UTC().Format("2006-01-02 15:04:05"), id, deliveryFailed)
+	if err != nil {
+		return err
 	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return fmt.Errorf("delivery not found or not failed")
+	}
+	return nil
+}
 
-	for lang, raw := range entries {
-		if text, ok := valueAsString(raw); ok && text != "" {
-			result[lang] = text
-		}
+// pruneAnnouncementDeliveries drops finished deliveries older than before.
+func pruneAnnouncementDeliveries(before time.Time) {
+	db.Exec("DELETE FROM announcement_deliveries WHERE status != ? AND created_at < ?",
+		deliveryPending, before.UTC().Format("2006-01-02 15:04:05"))
+}
+
+// addQueuedAnnouncement holds an announcement until deliverAt.
+func addQueuedAnnouncement(q *QueuedAnnouncement) error {
+	vals, err := json.Marshal(q.Values)
+	if err != nil {
+		return err
 	}
-	return result
+	_, err = db.Exec(`INSERT INTO announcement_queue (family_id, event, username, lang, template, vals, deliver_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?)`,
+		q.FamilyID, q.Event, q.Username, q.Lang, q.Template, string(vals), q.DeliverAt.UTC().Format("2006-01-02 15:04:05"))
+	return err
 }
 
-func parseImportedTime(v interface{}) (time.Time, bool) {
-	switch value := v.(type) {
-	case time.Time:
-		if value.IsZero() {
-			return time.Time{}, false
-		}
-		return value, true
-	case string:
-		if value == "" {
-			return time.Time{}, false
+func queryQueuedAnnouncements(query string, args ...interface{}) ([]QueuedAnnouncement, error) {
+	rows, err := db.Query("SELECT id, family_id, event, username, lang, template, vals, deliver_at, created_at FROM announcement_queue "+query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var queued []QueuedAnnouncement
+	for rows.Next() {
+		var q QueuedAnnouncement
+		var vals string
+		var deliverAt, createdAt sql.NullString
+		if err := rows.Scan(&q.ID, &q.FamilyID, &q.Event, &q.Username, &q.Lang, &q.Template, &vals, &deliverAt, &createdAt); err != nil {
+			continue
 		}
-		if t, err := time.Parse(time.RFC3339, value); err == nil {
-			return t, true
+		json.Unmarshal([]byte(vals), &q.Values)
+		if t := parseNullTime(deliverAt); t != nil {
+			q.DeliverAt = *t
 		}
-		if t, err := time.Parse("2006-01-02 15:04:05", value); err == nil {
-			return t, true
+		if t := parseNullTime(createdAt); t != nil {
+			q.CreatedAt = *t
 		}
+		queued = append(queued, q)
 	}
-	return time.Time{}, false
+	return queued, nil
 }
 
-func normalizeImportKey(rawKey, fallback string) string {
-	key := strings.TrimSpace(rawKey)
-	if key == "" {
-		key = sanitizeKey(fallback)
-	}
-	if key == "" {
-		key = "custom"
+// getDueAnnouncementFamilies lists the families with announcements due by now.
+func getDueAnnouncementFamilies(now time.Time) ([]int, error) {
+	rows, err := db.Query("SELECT DISTINCT family_id FROM announcement_queue WHERE deliver_at <= ?", now.UTC().Format("2006-01-02 15:04:05"))
+	if err != nil {
+		return nil, err
 	}
-	if strings.ContainsAny(key, " \t\r\n") {
-		key = sanitizeKey(key)
+	defer rows.Close()
+	var families []int
+	for rows.Next() {
+		var id int
+		if rows.Scan(&id) == nil {
+			families = append(families, id)
+		}
 	}
-	return key
+	return families, nil
 }
 
-func uniqueKeyTx(tx *sql.Tx, baseKey, table, column string) (string, error) {
-	key := baseKey
+// getQueuedAnnouncements returns a family's held announcements, oldest first.
+func getQueuedAnnouncements(familyID int) ([]QueuedAnnouncement, error) {
+	return queryQueuedAnnouncements("WHERE family_id = ? ORDER BY id", familyID)
+}
+
+// pendingAnnouncementBatch returns when the family's next batch goes out, if
+// one is already waiting after now.
+func pendingAnnouncementBatch(familyID int, now time.Time) *time.Time {
+	var next sql.NullString
+	db.QueryRow("SELECT MIN(deliver_at) FROM announcement_queue WHERE family_id = ? AND deliver_at > ?",
+		familyID, now.UTC().Format("2006-01-02 15:04:05")).Scan(&next)
+	return parseNullTime(next)
+}
+
+// holdQueuedAnnouncements moves all of a family's held announcements to until.
+func holdQueuedAnnouncements(familyID int, until time.Time) error {
+	_, err := db.Exec("UPDATE announcement_queue SET deliver_at = ? WHERE family_id = ?", until.UTC().Format("2006-01-02 15:04:05"), familyID)
+	return err
+}
+
+// deleteQueuedAnnouncements drops announcements once they have been sent.
+func deleteQueuedAnnouncements(ids []int) error {
+	if len(ids) == 0 {
+		return nil
+	}
+	args := make([]interface{}, len(ids))
+	for i, id := range ids {
+		args[i] = id
+	}
+	_, err := db.Exec("DELETE FROM announcement_queue WHERE id IN ("+strings.TrimSuffix(strings.Repeat("?,", len(ids)), ",")+")", args...)
+	return err
+}
+
+// Session management using DB
+func createSession(token string, userID, familyID int, userAgent, ip string) error {
+	now := time.Now().UTC().Format("2006-01-02 15:04:05")
+	_, err := db.Exec("INSERT INTO sessions (token, user_id, family_id, user_agent, ip, created_at, last_seen_at) VALUES (?, ?, ?, ?, ?, ?, ?)",
+		token, userID, familyID, userAgent, ip, now, now)
+	return err
+}
+
+const sessionColumns = "rowid, token, user_id, family_id, user_agent, ip, created_at, COALESCE(last_seen_at, created_at)"
+
+func scanSession(scan func(...interface{}) error) (*Session, error) {
+	s := &Session{}
+	var createdAt, lastSeenAt sql.NullString
+	if err := scan(&s.ID, &s.Token, &s.UserID, &s.FamilyID, &s.UserAgent, &s.IP, &createdAt, &lastSeenAt); err != nil {
+		return nil, err
+	}
+	if t := parseNullTime(createdAt); t != nil {
+		s.CreatedAt = *t
+	}
+	if t := parseNullTime(lastSeenAt); t != nil {
+		s.LastSeenAt = *t
+	}
+	return s, nil
+}
+
+// getSession returns the session with token, including the family it is
+// working in.
+func getSession(token string) (*Session, error) {
+	return scanSession(db.QueryRow("SELECT "+sessionColumns+" FROM sessions WHERE token = ?", token).Scan)
+}
+
+// getUserSessions lists a user's sessions, most recently seen first.
+func getUserSessions(userID int) ([]Session, error) {
+	rows, err := db.Query("SELECT "+sessionColumns+" FROM sessions WHERE user_id = ? ORDER BY COALESCE(last_seen_at, created_at) DESC", userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var sessions []Session
+	for rows.Next() {
+		s, err := scanSession(rows.Scan)
+		if err != nil {
+			return nil, err
+		}
+		sessions = append(sessions, *s)
+	}
+	return sessions, rows.Err()
+}
+
+// touchSession records that a session was just used, from ip.
+func touchSession(token, ip string) error {
+	_, err := db.Exec("UPDATE sessions SET last_seen_at = ?, ip = ? WHERE token = ?",
+		time.Now().UTC().Format("2006-01-02 15:04:05"), ip, token)
+	return err
+}
+
+// setSessionFamily moves a super-admin's session into another family.
+func setSessionFamily(token string, familyID int) error {
+	_, err := db.Exec("UPDATE sessions SET family_id = ? WHERE token = ?", familyID, token)
+	return err
+}
+
+func deleteSession(token string) error {
+	_, err := db.Exec("DELETE FROM sessions WHERE token = ?", token)
+	return err
+}
+
+// deleteUserSession signs one of a user's sessions out.
+func deleteUserSession(userID, id int) error {
+	result, err := db.Exec("DELETE FROM sessions WHERE user_id = ? AND rowid = ?", userID, id)
+	if err != nil {
+		return err
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// deleteOtherSessions signs a user out everywhere but the session with
+// token, or everywhere if token is "".
+func deleteOtherSessions(userID int, token string) error {
+	_, err := db.Exec("DELETE FROM sessions WHERE user_id = ? AND token != ?", userID, token)
+	return err
+}
+
+// pruneSessions drops sessions created before created or last seen before
+// seen.
+func pruneSessions(created, seen time.Time) {
+	db.Exec("DELETE FROM sessions WHERE created_at < ? OR COALESCE(last_seen_at, created_at) < ?",
+		created.UTC().Format("2006-01-02 15:04:05"), seen.UTC().Format("2006-01-02 15:04:05"))
+}
+
+// getLoginThrottle returns the failed logins counted for key, none if there
+// are none.
+func getLoginThrottle(scope, key string) (LoginThrottle, error) {
+	t := LoginThrottle{Scope: scope, Key: key}
+	var lastFailure, lockedUntil sql.NullString
+	err := db.QueryRow("SELECT failures, last_failure_at, locked_until FROM login_attempts WHERE scope = ? AND key = ?", scope, key).
+		Scan(&t.Failures, &lastFailure, &lockedUntil)
+	if err == sql.ErrNoRows {
+		return t, nil
+	}
+	if err != nil {
+		return t, err
+	}
+	if at := parseNullTime(lastFailure); at != nil {
+		t.LastFailureAt = *at
+	}
+	t.LockedUntil = parseNullTime(lockedUntil)
+	return t, nil
+}
+
+// getLoginThrottles lists the failed logins counted in scope.
+func getLoginThrottles(scope string) ([]LoginThrottle, error) {
+	rows, err := db.Query("SELECT key, failures, last_failure_at, locked_until FROM login_attempts WHERE scope = ? ORDER BY key", scope)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var throttles []LoginThrottle
+	for rows.Next() {
+		t := LoginThrottle{Scope: scope}
+		var lastFailure, lockedUntil sql.NullString
+		if err := rows.Scan(&t.Key, &t.Failures, &lastFailure, &lockedUntil); err != nil {
+			return nil, err
+		}
+		if at := parseNullTime(lastFailure); at != nil {
+			t.LastFailureAt = *at
+		}
+		t.LockedUntil = parseNullTime(lockedUntil)
+		throttles = append(throttles, t)
+	}
+	return throttles, rows.Err()
+}
+
+func saveLoginThrottle(t LoginThrottle) error {
+	var lockedUntil interface{}
+	if t.LockedUntil != nil {
+		lockedUntil = t.LockedUntil.UTC().Format("2006-01-02 15:04:05")
+	}
+	_, err := db.Exec(`INSERT INTO login_attempts (scope, key, failures, last_failure_at, locked_until) VALUES (?, ?, ?, ?, ?)
+		ON CONFLICT(scope, key) DO UPDATE SET failures = excluded.failures, last_failure_at = excluded.last_failure_at, locked_until = excluded.locked_until`,
+		t.Scope, t.Key, t.Failures, t.LastFailureAt.UTC().Format("2006-01-02 15:04:05"), lockedUntil)
+	return err
+}
+
+// clearLoginThrottle forgets the failed logins for key, unlocking it.
+func clearLoginThrottle(scope, key string) error {
+	_, err := db.Exec("DELETE FROM login_attempts WHERE scope = ? AND key = ?", scope, key)
+	return err
+}
+
+// pruneLoginThrottles drops the counts in scope whose last failure is older
+// than before and that are no longer locked.
+func pruneLoginThrottles(scope string, before time.Time) {
+	cutoff := before.UTC().Format("2006-01-02 15:04:05")
+	db.Exec("DELETE FROM login_attempts WHERE scope = ? AND last_failure_at < ? AND (locked_until IS NULL OR locked_until < ?)",
+		scope, cutoff, time.Now().UTC().Format("2006-01-02 15:04:05"))
+}
+
+// getPINLoginUserIDs lists a family's users with a PIN login set up, by
+// username.
+func getPINLoginUserIDs(familyID int) ([]int, error) {
+	rows, err := db.Query(`SELECT u.id FROM users u JOIN user_settings s ON s.user_id = u.id
+		WHERE u.family_id = ? AND s.key = 'login_mode' AND s.value != '' ORDER BY u.username`, familyID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var ids []int
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
+
+func addKidLoginDevice(tokenHash string, familyID int) error {
+	_, err := db.Exec("INSERT INTO kid_login_devices (token_hash, family_id) VALUES (?, ?)", tokenHash, familyID)
+	return err
+}
+
+// getKidLoginDeviceFamily returns the family a kid login device shows.
+func getKidLoginDeviceFamily(tokenHash string) (int, error) {
+	var familyID int
+	err := db.QueryRow("SELECT family_id FROM kid_login_devices WHERE token_hash = ?", tokenHash).Scan(&familyID)
+	return familyID, err
+}
+
+func countKidLoginDevices(familyID int) int {
+	var n int
+	db.QueryRow("SELECT COUNT(*) FROM kid_login_devices WHERE family_id = ?", familyID).Scan(&n)
+	return n
+}
+
+func deleteKidLoginDevice(tokenHash string, familyID int) error {
+	_, err := db.Exec("DELETE FROM kid_login_devices WHERE token_hash = ? AND family_id = ?", tokenHash, familyID)
+	return err
+}
+
+func deleteKidLoginDevices(familyID int) error {
+	_, err := db.Exec("DELETE FROM kid_login_devices WHERE family_id = ?", familyID)
+	return err
+}
+
+// superAdminFamilyID returns the family the super-admin belongs to.
+func superAdminFamilyID() int {
+	familyID := 1
+	db.QueryRow("SELECT family_id FROM users WHERE is_super_admin = 1 ORDER BY id LIMIT 1").Scan(&familyID)
+	return familyID
+}
+
+func getRewardsList(familyID int) ([]Reward, error) {
+	rows, err := db.Query("SELECT id, family_id, key, cost, icon, COALESCE(adult_only, 0), currency_id FROM rewards WHERE family_id = ? ORDER BY currency_id, cost ASC", familyID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var rewards []Reward
+	for rows.Next() {
+		var r Reward
+		r.Translations = make(map[string]string)
+		rows.Scan(&r.ID, &r.FamilyID, &r.Key, &r.Cost, &r.Icon, &r.ForAdults, &r.CurrencyID)
+		r.CurrencyIcon = currencyIcon(r.CurrencyID)
+
+		// Load all translations for this reward
+		tRows, _ := db.Query("SELECT lang, text FROM reward_translations WHERE reward_id = ?", r.ID)
+		for tRows.Next() {
+			var lang, text string
+			tRows.Scan(&lang, &text)
+			r.Translations[lang] = text
+			if lang == "en" {
+				r.Name = text // Keep Name field for backward compatibility
+			}
+		}
+		tRows.Close()
+
+		rewards = append(rewards, r)
+	}
+	return rewards, nil
+}
+
+// uniqueKey returns baseKey, numbered if needed to be unique within the family.
+func uniqueKey(familyID int, baseKey, table, column string) string {
+	key := baseKey
+	for i := 2; ; i++ {
+		var count int
+		db.QueryRow("SELECT COUNT(*) FROM "+table+" WHERE family_id = ? AND "+column+" = ?", familyID, key).Scan(&count)
+		if count == 0 {
+			return key
+		}
+		key = fmt.Sprintf("%s_%d", baseKey, i)
+	}
+}
+
+func addReward(familyID int, name string, cost int, icon string, adultOnly bool, currencyID int) error {
+	key := uniqueKey(familyID, sanitizeKey(name), "rewards", "key")
+	result, err := db.Exec("INSERT INTO rewards (family_id, key, cost, icon, adult_only, currency_id) VALUES (?, ?, ?, ?, ?, ?)", familyID, key, cost, icon, adultOnly, currencyID)
+	if err != nil {
+		return err
+	}
+	id, _ := result.LastInsertId()
+	// Add English translation
+	_, err = db.Exec("INSERT INTO reward_translations (reward_id, lang, text) VALUES (?, 'en', ?)", id, name)
+	return err
+}
+
+func updateReward(id int, name string, cost int, icon string) error {
+	_, err := db.Exec("UPDATE rewards SET cost = ?, icon = ? WHERE id = ?", cost, icon, id)
+	if err != nil {
+		return err
+	}
+	// Update English translation
+	_, err = db.Exec(`
+		INSERT INTO reward_translations (reward_id, lang, text) VALUES (?, 'en', ?)
+		ON CONFLICT(reward_id, lang) DO UPDATE SET text = ?
+	`, id, name, name)
+	return err
+}
+
+func updateRewardTranslation(rewardID int, lang, text string) error {
+	_, err := db.Exec(`
+		INSERT INTO reward_translations (reward_id, lang, text) VALUES (?, ?, ?)
+		ON CONFLICT(reward_id, lang) DO UPDATE SET text = ?
+	`, rewardID, lang, text, text)
+	return err
+}
+
+func updateRewardCost(rewardID int, cost int, retroactive bool, changedBy int) error {
+	if cost < 1 {
+		cost = 1
+	}
+	_, err := db.Exec("UPDATE rewards SET cost = ? WHERE id = ?", cost, rewardID)
+	if err != nil {
+		return err
+	}
+	if retroactive {
+		// Reprice existing redemptions, recording the difference in the ledger
+		err = adjustRewardCost(rewardID, cost, changedBy)
+	}
+	return err
+}
+
+func getRewardText(rewardID int, lang string) string {
+	var text string
+	// Try to get translation in requested language
+	err := db.QueryRow("SELECT text FROM reward_translations WHERE reward_id = ? AND lang = ?", rewardID, lang).Scan(&text)
+	if err == nil && text != "" {
+		return text
+	}
+	// Fallback to English
+	err = db.QueryRow("SELECT text FROM reward_translations WHERE reward_id = ? AND lang = 'en'", rewardID).Scan(&text)
+	if err == nil && text != "" {
+		return text
+	}
+	return ""
+}
+
+// getRewardTexts returns a reward's name in every language.
+func getRewardTexts(rewardID int) map[string]string {
+	return resolveTranslations(loadTranslations("reward_translations", "reward_id", rewardID), "")
+}
+
+func updateRewardAdultOnly(rewardID int, adultOnly bool) error {
+	_, err := db.Exec("UPDATE rewards SET adult_only = ? WHERE id = ?", adultOnly, rewardID)
+	return err
+}
+
+func deleteRewardByID(id int) error {
+	_, err := db.Exec("DELETE FROM rewards WHERE id = ?", id)
+	return err
+}
+
+func getRewardByID(id int) (*Reward, error) {
+	r := &Reward{}
+	r.Translations = make(map[string]string)
+	err := db.QueryRow("SELECT id, family_id, key, cost, icon, COALESCE(adult_only, 0), currency_id FROM rewards WHERE id = ?", id).
+		Scan(&r.ID, &r.FamilyID, &r.Key, &r.Cost, &r.Icon, &r.ForAdults, &r.CurrencyID)
+	if err != nil {
+		return nil, err
+	}
+	r.CurrencyIcon = currencyIcon(r.CurrencyID)
+
+	// Load all translations
+	rows, _ := db.Query("SELECT lang, text FROM reward_translations WHERE reward_id = ?", id)
+	defer rows.Close()
+	for rows.Next() {
+		var lang, text string
+		rows.Scan(&lang, &text)
+		r.Translations[lang] = text
+		if lang == "en" {
+			r.Name = text
+		}
+	}
+
+	return r, nil
+}
+
+// getUserBalance returns a user's spendable balance in a currency (0 for stars).
+func getUserBalance(userID, currencyID int) (int, error) {
+	balance, _, err := ledgerBalance(db, userID, currencyID)
+	return balance, err
+}
+
+// getUserReserved returns the amount of a currency held by pending redemption requests.
+func getUserReserved(userID, currencyID int) int {
+	return userReserved(db, userID, currencyID)
+}
+
+// starsIcon marks amounts in the built-in stars currency.
+const starsIcon = "⭐"
+
+func getCurrencies(familyID int) ([]Currency, error) {
+	rows, err := db.Query("SELECT id, family_id, key, icon FROM currencies WHERE family_id = ? ORDER BY id", familyID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var currencies []Currency
+	for rows.Next() {
+		var c Currency
+		rows.Scan(&c.ID, &c.FamilyID, &c.Key, &c.Icon)
+		currencies = append(currencies, c)
+	}
+	for i := range currencies {
+		currencies[i].Translations = getCurrencyTranslations(currencies[i].ID)
+	}
+	return currencies, nil
+}
+
+func getCurrencyByID(id int) (*Currency, error) {
+	c := &Currency{}
+	err := db.QueryRow("SELECT id, family_id, key, icon FROM currencies WHERE id = ?", id).
+		Scan(&c.ID, &c.FamilyID, &c.Key, &c.Icon)
+	if err != nil {
+		return nil, err
+	}
+	c.Translations = getCurrencyTranslations(id)
+	return c, nil
+}
+
+func getCurrencyTranslations(currencyID int) map[string]string {
+	translations := make(map[string]string)
+	rows, err := db.Query("SELECT lang, text FROM currency_translations WHERE currency_id = ?", currencyID)
+	if err != nil {
+		return translations
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var lang, text string
+		rows.Scan(&lang, &text)
+		translations[lang] = text
+	}
+	return translations
+}
+
+// getCurrencyIDByKey resolves a currency key within a family; "stars" is the built-in currency 0.
+func getCurrencyIDByKey(familyID int, key string) (int, error) {
+	if key == "" || key == "stars" {
+		return 0, nil
+	}
+	var id int
+	err := db.QueryRow("SELECT id FROM currencies WHERE family_id = ? AND key = ?", familyID, key).Scan(&id)
+	return id, err
+}
+
+// currencyKey returns the key of a currency, "stars" for the built-in one.
+func currencyKey(currencyID int) string {
+	if currencyID == 0 {
+		return "stars"
+	}
+	var key string
+	db.QueryRow("SELECT key FROM currencies WHERE id = ?", currencyID).Scan(&key)
+	return key
+}
+
+// currencyIcon returns the icon amounts in a currency are shown with.
+func currencyIcon(currencyID int) string {
+	if currencyID == 0 {
+		return starsIcon
+	}
+	var icon string
+	db.QueryRow("SELECT icon FROM currencies WHERE id = ?", currencyID).Scan(&icon)
+	if icon == "" {
+		return "🪙"
+	}
+	return icon
+}
+
+// getCurrencyText returns a currency's name in lang, falling back to English.
+func getCurrencyText(currencyID int, lang string) string {
+	if currencyID == 0 {
+		return getLanguage(lang).Announce.Stars
+	}
+	var text string
+	err := db.QueryRow("SELECT text FROM currency_translations WHERE currency_id = ? AND lang = ?", currencyID, lang).Scan(&text)
+	if err == nil && text != "" {
+		return text
+	}
+	db.QueryRow("SELECT text FROM currency_translations WHERE currency_id = ? AND lang = 'en'", currencyID).Scan(&text)
+	return text
+}
+
+// getCurrencyTexts returns a currency's name in every language.
+func getCurrencyTexts(currencyID int) map[string]string {
+	if currencyID == 0 {
+		texts := make(map[string]string, len(languageOrder))
+		for _, lang := range languageOrder {
+			texts[lang.Code] = lang.Announce.Stars
+		}
+		return texts
+	}
+	return resolveTranslations(getCurrencyTranslations(currencyID), currencyKey(currencyID))
+}
+
+func addCurrency(familyID int, name, icon string) (int, error) {
+	key := uniqueKey(familyID, strings.ToLower(sanitizeKey(name)), "currencies", "key")
+	// The built-in currency owns the stars key
+	if key == "stars" {
+		key = uniqueKey(familyID, "stars_2", "currencies", "key")
+	}
+	if icon == "" {
+		icon = "🪙"
+	}
+	result, err := db.Exec("INSERT INTO currencies (family_id, key, icon) VALUES (?, ?, ?)", familyID, key, icon)
+	if err != nil {
+		return 0, err
+	}
+	id, _ := result.LastInsertId()
+	_, err = db.Exec("INSERT INTO currency_translations (currency_id, lang, text) VALUES (?, 'en', ?)", id, name)
+	return int(id), err
+}
+
+func updateCurrencyTranslation(currencyID int, lang, text string) error {
+	_, err := db.Exec(`
+		INSERT INTO currency_translations (currency_id, lang, text) VALUES (?, ?, ?)
+		ON CONFLICT(currency_id, lang) DO UPDATE SET text = ?
+	`, currencyID, lang, text, text)
+	return err
+}
+
+func updateCurrencyIcon(currencyID int, icon string) error {
+	_, err := db.Exec("UPDATE currencies SET icon = ? WHERE id = ?", icon, currencyID)
+	return err
+}
+
+// deleteCurrency removes a currency nothing is priced in or has earned.
+func deleteCurrency(id int) error {
+	var used int
+	db.QueryRow(`SELECT (SELECT COUNT(*) FROM reasons WHERE currency_id = ?) + (SELECT COUNT(*) FROM rewards WHERE currency_id = ?)
+		+ (SELECT COUNT(*) FROM ledger_entries WHERE currency_id = ?)`, id, id, id).Scan(&used)
+	if used > 0 {
+		return errors.New("currency is still used by reasons, rewards or balances")
+	}
+	_, err := db.Exec("DELETE FROM currencies WHERE id = ?", id)
+	return err
+}
+
+func getSetting(key string) string {
+	var val string
+	db.QueryRow("SELECT value FROM settings WHERE key = ?", key).Scan(&val)
+	return val
+}
+
+func setSetting(key, value string) error {
+	_, err := db.Exec(`INSERT INTO settings (key, value) VALUES (?, ?)
+		ON CONFLICT(key) DO UPDATE SET value = ?`, key, value, value)
+	return err
+}
+
+// getFamilySetting reads one of a family's own settings, such as its announcement backends.
+func getFamilySetting(familyID int, key string) string {
+	var val string
+	db.QueryRow("SELECT value FROM family_settings WHERE family_id = ? AND key = ?", familyID, key).Scan(&val)
+	return val
+}
+
+func setFamilySetting(familyID int, key, value string) error {
+	_, err := db.Exec(`INSERT INTO family_settings (family_id, key, value) VALUES (?, ?, ?)
+		ON CONFLICT(family_id, key) DO UPDATE SET value = ?`, familyID, key, value, value)
+	return err
+}
+
+// getUserSetting reads one of a user's own settings, such as where their announcements go.
+func getUserSetting(userID int, key string) string {
+	var val string
+	db.QueryRow("SELECT value FROM user_settings WHERE user_id = ? AND key = ?", userID, key).Scan(&val)
+	return val
+}
+
+func setUserSetting(userID int, key, value string) error {
+	_, err := db.Exec(`INSERT INTO user_settings (user_id, key, value) VALUES (?, ?, ?)
+		ON CONFLICT(user_id, key) DO UPDATE SET value = ?`, userID, key, value, value)
+	return err
+}
+
+func valueAsString(v interface{}) (string, bool) {
+	switch value := v.(type) {
+	case string:
+		return strings.TrimSpace(value), true
+	default:
+		return "", false
+	}
+}
+
+func valueAsInt(v interface{}) (int, bool) {
+	switch value := v.(type) {
+	case int:
+		return value, true
+	case int64:
+		return int(value), true
+	case float64:
+		return int(value), true
+	case float32:
+		return int(value), true
+	default:
+		return 0, false
+	}
+}
+
+func valueAsBool(v interface{}) (bool, bool) {
+	switch value := v.(type) {
+	case bool:
+		return value, true
+	case string:
+		switch strings.ToLower(strings.TrimSpace(value)) {
+		case "1", "true", "yes", "on":
+			return true, true
+		case "0", "false", "no", "off":
+			return false, true
+		default:
+			return false, false
+		}
+	default:
+		return false, false
+	}
+}
+
+func valueAsMap(v interface{}) (map[string]interface{}, bool) {
+	value, ok := v.(map[string]interface{})
+	return value, ok
+}
+
+func valueAsSlice(v interface{}) ([]interface{}, bool) {
+	// Empty sections are exported as null
+	if v == nil {
+		return nil, true
+	}
+	value, ok := v.([]interface{})
+	return value, ok
+}
+
+func valueAsStringMap(v interface{}) map[string]string {
+	result := make(map[string]string)
+	entries, ok := valueAsMap(v)
+	if !ok {
+		return result
+	}
+
+	for lang, raw := range entries {
+		if text, ok := valueAsString(raw); ok && text != "" {
+			result[lang] = text
+		}
+	}
+	return result
+}
+
+func parseImportedTime(v interface{}) (time.Time, bool) {
+	switch value := v.(type) {
+	case time.Time:
+		if value.IsZero() {
+			return time.Time{}, false
+		}
+		return value, true
+	case string:
+		if value == "" {
+			return time.Time{}, false
+		}
+		if t, err := time.Parse(time.RFC3339, value); err == nil {
+			return t, true
+		}
+		if t, err := time.Parse("2006-01-02 15:04:05", value); err == nil {
+			return t, true
+		}
+	}
+	return time.Time{}, false
+}
+
+func normalizeImportKey(rawKey, fallback string) string {
+	key := strings.TrimSpace(rawKey)
+	if key == "" {
+		key = sanitizeKey(fallback)
+	}
+	if key == "" {
+		key = "custom"
+	}
+	if strings.ContainsAny(key, " \t\r\n") {
+		key = sanitizeKey(key)
+	}
+	return key
+}
+
+func uniqueKeyTx(tx *sql.Tx, familyID int, baseKey, table, column string) (string, error) {
+	key := baseKey
 	for i := 2; ; i++ {
 		var count int
-		if err := tx.QueryRow("SELECT COUNT(*) FROM "+table+" WHERE "+column+" = ?", key).Scan(&count); err != nil {
+		if err := tx.QueryRow("SELECT COUNT(*) FROM "+table+" WHERE family_id = ? AND "+column+" = ?", familyID, key).Scan(&count); err != nil {
 			return "", err
 		}
 		if count == 0 {
@@ -1162,7 +2578,8 @@ func uniqueKeyTx(tx *sql.Tx, baseKey, table, column string) (string, error) {
 	}
 }
 
-func lookupUserIDTx(tx *sql.Tx, cache map[string]int, username string) (int, error) {
+// lookupUserIDTx resolves a username within the family being imported.
+func lookupUserIDTx(tx *sql.Tx, familyID int, cache map[string]int, username string) (int, error) {
 	username = strings.TrimSpace(username)
 	if username == "" {
 		return 0, errors.New("empty username")
@@ -1172,7 +2589,7 @@ func lookupUserIDTx(tx *sql.Tx, cache map[string]int, username string) (int, err
 	}
 
 	var userID int
-	err := tx.QueryRow("SELECT id FROM users WHERE username = ?", username).Scan(&userID)
+	err := tx.QueryRow("SELECT id FROM users WHERE username = ? AND family_id = ?", username, familyID).Scan(&userID)
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
 			return 0, fmt.Errorf("user %q not found", username)
@@ -1183,24 +2600,47 @@ func lookupUserIDTx(tx *sql.Tx, cache map[string]int, username string) (int, err
 	return userID, nil
 }
 
-func exportAllData() (map[string]interface{}, error) {
+// exportAllData exports one family's data.
+func exportAllData(familyID int) (map[string]interface{}, error) {
 	data := make(map[string]interface{})
 
-	users, err := getAllUsers()
+	users, err := getAllUsers(familyID)
 	if err != nil {
 		return nil, fmt.Errorf("failed to export users: %w", err)
 	}
 	var userExport []map[string]interface{}
 	for _, u := range users {
+		settings := make(map[string]string)
+		for _, key := range userAnnouncementSettingKeys() {
+			if value := getUserSetting(u.ID, key); value != "" {
+				settings[key] = value
+			}
+		}
 		userExport = append(userExport, map[string]interface{}{
 			"username":     u.Username,
 			"is_admin":     u.IsAdmin,
+			"lang":         u.Lang,
 			"translations": u.Translations,
+			"settings":     settings,
 		})
 	}
 	data["users"] = userExport
 
-	stars, err := getStars("")
+	currencies, err := getCurrencies(familyID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to export currencies: %w", err)
+	}
+	var currencyExport []map[string]interface{}
+	for _, c := range currencies {
+		currencyExport = append(currencyExport, map[string]interface{}{
+			"key":          c.Key,
+			"icon":         c.Icon,
+			"translations": c.Translations,
+		})
+	}
+	data["currencies"] = currencyExport
+
+	stars, err := getStars(familyID, "")
 	if err != nil {
 		return nil, fmt.Errorf("failed to export stars: %w", err)
 	}
@@ -1213,13 +2653,14 @@ func exportAllData() (map[string]interface{}, error) {
 			"reason_text": s.ReasonText,
 			"reason_en":   getReasonText(s.ReasonID, s.ReasonText, "en"),
 			"stars":       s.Stars,
+			"currency":    currencyKey(s.CurrencyID),
 			"awarded_by":  s.AwardedByName,
 			"created_at":  s.CreatedAt,
 		})
 	}
 	data["stars"] = starExport
 
-	reasons, err := getReasons()
+	reasons, err := getReasons(familyID)
 	if err != nil {
 		return nil, fmt.Errorf("failed to export reasons: %w", err)
 	}
@@ -1230,11 +2671,12 @@ func exportAllData() (map[string]interface{}, error) {
 			"key":          r.Key,
 			"translations": r.Translations,
 			"stars":        r.Stars,
+			"currency":     currencyKey(r.CurrencyID),
 		})
 	}
 	data["reasons"] = reasonExport
 
-	rewards, err := getRewardsList()
+	rewards, err := getRewardsList(familyID)
 	if err != nil {
 		return nil, fmt.Errorf("failed to export rewards: %w", err)
 	}
@@ -1245,6 +2687,7 @@ func exportAllData() (map[string]interface{}, error) {
 			"key":          r.Key,
 			"name":         r.Name,
 			"cost":         r.Cost,
+			"currency":     currencyKey(r.CurrencyID),
 			"icon":         r.Icon,
 			"adult_only":   r.ForAdults,
 			"translations": r.Translations,
@@ -1252,7 +2695,7 @@ func exportAllData() (map[string]interface{}, error) {
 	}
 	data["rewards"] = rewardExport
 
-	redemptions, err := getRecentRedemptions(10000, 0)
+	redemptions, err := getRecentRedemptions(familyID, 10000, 0)
 	if err != nil {
 		return nil, fmt.Errorf("failed to export redemptions: %w", err)
 	}
@@ -1264,24 +2707,67 @@ func exportAllData() (map[string]interface{}, error) {
 			"reward_key":  r.RewardKey,
 			"reward_name": r.RewardName,
 			"cost":        r.Cost,
+			"currency":    currencyKey(r.CurrencyID),
 			"created_at":  r.CreatedAt,
 		})
 	}
 	data["redemptions"] = redemptionExport
 
-	settings := map[string]string{
-		"ha_enabled":      getSetting("ha_enabled"),
-		"ha_url":          getSetting("ha_url"),
-		"ha_token":        getSetting("ha_token"),
-		"ha_media_player": getSetting("ha_media_player"),
-		"ha_lang":         getSetting("ha_lang"),
+	chores, err := getChores(familyID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to export chores: %w", err)
+	}
+	var choreExport []map[string]interface{}
+	for _, c := range chores {
+		choreExport = append(choreExport, map[string]interface{}{
+			"reason_key":   c.ReasonKey,
+			"schedule":     c.Schedule,
+			"weekday":      c.Weekday,
+			"due_time":     c.DueTime,
+			"auto_approve": c.AutoApprove,
+			"users":        c.Usernames,
+		})
+	}
+	data["chores"] = choreExport
+
+	var goalExport []map[string]interface{}
+	for _, u := range users {
+		rewardID, _, err := getSavingsGoal(u.ID)
+		if err != nil {
+			continue
+		}
+		reward, err := getRewardByID(rewardID)
+		if err != nil {
+			continue
+		}
+		goalExport = append(goalExport, map[string]interface{}{
+			"username":   u.Username,
+			"reward_key": reward.Key,
+		})
+	}
+	data["goals"] = goalExport
+
+	// MQTT settings belong to the deployment rather than the family, so they are not exported
+	settings := make(map[string]string)
+	for _, key := range announcementSettingKeys() {
+		settings[key] = getFamilySetting(familyID, key)
 	}
 	data["settings"] = settings
 
 	return data, nil
 }
 
-func importAllData(data map[string]interface{}) error {
+// errImportPending refuses an import while kids are waiting on parents.
+var errImportPending = errors.New("redemption requests or chore check-offs are still pending")
+
+// importAllData replaces one family's reasons, rewards and history with an export.
+// Replacing reasons and rewards drops their redemption requests and chore
+// check-offs, which exports don't carry, so it is refused while any of those
+// are still pending.
+func importAllData(familyID int, data map[string]interface{}) error {
+	ledgerMu.Lock()
+	defer ledgerMu.Unlock()
+
 	tx, err := db.Begin()
 	if err != nil {
 		return fmt.Errorf("failed to start import transaction: %w", err)
@@ -1294,16 +2780,31 @@ func importAllData(data map[string]interface{}) error {
 		}
 	}()
 
+	var pending int
+	if err := tx.QueryRow(`SELECT
+		(SELECT COUNT(*) FROM redemption_requests WHERE status = 'pending' AND user_id IN (SELECT id FROM users WHERE family_id = ?))
+		+ (SELECT COUNT(*) FROM chore_completions WHERE status = 'pending' AND user_id IN (SELECT id FROM users WHERE family_id = ?))`,
+		familyID, familyID).Scan(&pending); err != nil {
+		return fmt.Errorf("failed to check pending requests: %w", err)
+	}
+	if pending > 0 {
+		return errImportPending
+	}
+
+	const familyUsers = "(SELECT id FROM users WHERE family_id = ?)"
 	queries := []string{
-		"DELETE FROM redemptions",
-		"DELETE FROM stars",
-		"DELETE FROM reason_translations",
-		"DELETE FROM reasons",
-		"DELETE FROM reward_translations",
-		"DELETE FROM rewards",
+		"DELETE FROM ledger_entries WHERE user_id IN " + familyUsers,
+		"DELETE FROM redemptions WHERE user_id IN " + familyUsers,
+		"DELETE FROM stars WHERE user_id IN " + familyUsers,
+		"DELETE FROM reason_translations WHERE reason_id IN (SELECT id FROM reasons WHERE family_id = ?)",
+		"DELETE FROM reasons WHERE family_id = ?",
+		"DELETE FROM reward_translations WHERE reward_id IN (SELECT id FROM rewards WHERE family_id = ?)",
+		"DELETE FROM rewards WHERE family_id = ?",
+		"DELETE FROM currency_translations WHERE currency_id IN (SELECT id FROM currencies WHERE family_id = ?)",
+		"DELETE FROM currencies WHERE family_id = ?",
 	}
 	for _, query := range queries {
-		if _, err := tx.Exec(query); err != nil {
+		if _, err := tx.Exec(query, familyID); err != nil {
 			return fmt.Errorf("failed to clear existing data: %w", err)
 		}
 	}
@@ -1315,16 +2816,29 @@ func importAllData(data map[string]interface{}) error {
 	rewardIDByKey := map[string]int{}
 	rewardIDByEN := map[string]int{}
 	rewardIDByLegacyID := map[int]int{}
+	currencyIDByKey := map[string]int{"": 0, "stars": 0}
+	reasonCurrency := map[int]int{}
+	rewardCurrency := map[int]int{}
+
+	// currencyOf maps an entry's currency key to the imported currency
+	currencyOf := func(entry map[string]interface{}) (int, error) {
+		key, _ := valueAsString(entry["currency"])
+		id, found := currencyIDByKey[key]
+		if !found {
+			return 0, fmt.Errorf("currency %q not found", key)
+		}
+		return id, nil
+	}
 
-	insertReason := func(rawKey string, stars int, translations map[string]string, legacyID int) (int, error) {
+	insertReason := func(rawKey string, stars, currencyID int, translations map[string]string, legacyID int) (int, error) {
 		enText := strings.TrimSpace(translations["en"])
 		keyBase := normalizeImportKey(rawKey, enText)
-		key, err := uniqueKeyTx(tx, keyBase, "reasons", "key")
+		key, err := uniqueKeyTx(tx, familyID, keyBase, "reasons", "key")
 		if err != nil {
 			return 0, err
 		}
 
-		result, err := tx.Exec("INSERT INTO reasons (key, stars) VALUES (?, ?)", key, stars)
+		result, err := tx.Exec("INSERT INTO reasons (family_id, key, stars, currency_id) VALUES (?, ?, ?, ?)", familyID, key, stars, currencyID)
 		if err != nil {
 			return 0, err
 		}
@@ -1356,20 +2870,20 @@ func importAllData(data map[string]interface{}) error {
 			reasonIDByKey[rawKey] = reasonID
 		}
 		reasonIDByEN[enText] = reasonID
+		reasonCurrency[reasonID] = currencyID
 		if legacyID > 0 {
 			reasonIDByLegacyID[legacyID] = reasonID
 		}
 		return reasonID, nil
 	}
 
-	rewardsHasLegacyName := columnExists("rewards", "name")
-	insertReward := func(rawKey string, cost int, icon string, adultOnly bool, translations map[string]string, legacyID int) (int, error) {
+	insertReward := func(rawKey string, cost, currencyID int, icon string, adultOnly bool, translations map[string]string, legacyID int) (int, error) {
 		if cost < 1 {
 			cost = 1
 		}
 		enText := strings.TrimSpace(translations["en"])
 		keyBase := normalizeImportKey(rawKey, enText)
-		key, err := uniqueKeyTx(tx, keyBase, "rewards", "key")
+		key, err := uniqueKeyTx(tx, familyID, keyBase, "rewards", "key")
 		if err != nil {
 			return 0, err
 		}
@@ -1382,12 +2896,7 @@ func importAllData(data map[string]interface{}) error {
 		}
 		translations["en"] = enText
 
-		var result sql.Result
-		if rewardsHasLegacyName {
-			result, err = tx.Exec("INSERT INTO rewards (name, key, cost, icon, adult_only) VALUES (?, ?, ?, ?, ?)", enText, key, cost, icon, adultOnly)
-		} else {
-			result, err = tx.Exec("INSERT INTO rewards (key, cost, icon, adult_only) VALUES (?, ?, ?, ?)", key, cost, icon, adultOnly)
-		}
+		result, err := tx.Exec("INSERT INTO rewards (family_id, key, cost, icon, adult_only, currency_id) VALUES (?, ?, ?, ?, ?, ?)", familyID, key, cost, icon, adultOnly, currencyID)
 		if err != nil {
 			return 0, err
 		}
@@ -1411,12 +2920,57 @@ func importAllData(data map[string]interface{}) error {
 			rewardIDByKey[rawKey] = rewardID
 		}
 		rewardIDByEN[enText] = rewardID
+		rewardCurrency[rewardID] = currencyID
 		if legacyID > 0 {
 			rewardIDByLegacyID[legacyID] = rewardID
 		}
 		return rewardID, nil
 	}
 
+	if rawCurrencies, ok := data["currencies"]; ok {
+		currencies, ok := valueAsSlice(rawCurrencies)
+		if !ok {
+			return errors.New("invalid currencies payload")
+		}
+		for i, item := range currencies {
+			entry, ok := valueAsMap(item)
+			if !ok {
+				return fmt.Errorf("invalid currencies entry at index %d", i)
+			}
+			rawKey, _ := valueAsString(entry["key"])
+			icon, _ := valueAsString(entry["icon"])
+			translations := valueAsStringMap(entry["translations"])
+			keyBase := normalizeImportKey(rawKey, translations["en"])
+			if keyBase == "stars" {
+				keyBase = "stars_2"
+			}
+			key, err := uniqueKeyTx(tx, familyID, keyBase, "currencies", "key")
+			if err != nil {
+				return fmt.Errorf("failed to import currency at index %d: %w", i, err)
+			}
+			result, err := tx.Exec("INSERT INTO currencies (family_id, key, icon) VALUES (?, ?, ?)", familyID, key, icon)
+			if err != nil {
+				return fmt.Errorf("failed to import currency at index %d: %w", i, err)
+			}
+			id64, _ := result.LastInsertId()
+			if translations["en"] == "" {
+				translations["en"] = key
+			}
+			for lang, text := range translations {
+				if strings.TrimSpace(lang) == "" || strings.TrimSpace(text) == "" {
+					continue
+				}
+				if _, err := tx.Exec("INSERT INTO currency_translations (currency_id, lang, text) VALUES (?, ?, ?)", id64, lang, text); err != nil {
+					return err
+				}
+			}
+			currencyIDByKey[key] = int(id64)
+			if rawKey != "" && rawKey != "stars" {
+				currencyIDByKey[rawKey] = int(id64)
+			}
+		}
+	}
+
 	if rawReasons, ok := data["reasons"]; ok {
 		reasons, ok := valueAsSlice(rawReasons)
 		if !ok {
@@ -1437,7 +2991,11 @@ func importAllData(data map[string]interface{}) error {
 				translations["en"] = enText
 			}
 			legacyID, _ := valueAsInt(entry["id"])
-			if _, err := insertReason(key, stars, translations, legacyID); err != nil {
+			currencyID, err := currencyOf(entry)
+			if err != nil {
+				return fmt.Errorf("failed to import reason at index %d: %w", i, err)
+			}
+			if _, err := insertReason(key, stars, currencyID, translations, legacyID); err != nil {
 				return fmt.Errorf("failed to import reason at index %d: %w", i, err)
 			}
 		}
@@ -1470,7 +3028,11 @@ func importAllData(data map[string]interface{}) error {
 				translations["en"] = legacyName
 			}
 			legacyID, _ := valueAsInt(entry["id"])
-			if _, err := insertReward(key, cost, icon, adultOnly, translations, legacyID); err != nil {
+			currencyID, err := currencyOf(entry)
+			if err != nil {
+				return fmt.Errorf("failed to import reward at index %d: %w", i, err)
+			}
+			if _, err := insertReward(key, cost, currencyID, icon, adultOnly, translations, legacyID); err != nil {
 				return fmt.Errorf("failed to import reward at index %d: %w", i, err)
 			}
 		}
@@ -1490,7 +3052,7 @@ func importAllData(data map[string]interface{}) error {
 			if username == "" {
 				continue
 			}
-			userID, err := lookupUserIDTx(tx, userIDCache, username)
+			userID, err := lookupUserIDTx(tx, familyID, userIDCache, username)
 			if err != nil {
 				return fmt.Errorf("failed to import user translations at index %d: %w", i, err)
 			}
@@ -1502,6 +3064,26 @@ func importAllData(data map[string]interface{}) error {
 					return err
 				}
 			}
+			if lang, _ := valueAsString(entry["lang"]); validLanguage(lang) {
+				if _, err := tx.Exec("UPDATE users SET lang = ? WHERE id = ?", lang, userID); err != nil {
+					return err
+				}
+			}
+			// Exports made before per-user settings existed leave them alone
+			if _, ok := entry["settings"]; ok {
+				// PIN logins aren't exported, so they are kept
+				settings := valueAsStringMap(entry["settings"])
+				for _, key := range userAnnouncementSettingKeys() {
+					if _, err := tx.Exec("DELETE FROM user_settings WHERE user_id = ? AND key = ?", userID, key); err != nil {
+						return err
+					}
+					if value := settings[key]; value != "" {
+						if _, err := tx.Exec("INSERT INTO user_settings (user_id, key, value) VALUES (?, ?, ?)", userID, key, value); err != nil {
+							return err
+						}
+					}
+				}
+			}
 		}
 	}
 
@@ -1517,7 +3099,7 @@ func importAllData(data map[string]interface{}) error {
 			}
 
 			username, _ := valueAsString(entry["username"])
-			userID, err := lookupUserIDTx(tx, userIDCache, username)
+			userID, err := lookupUserIDTx(tx, familyID, userIDCache, username)
 			if err != nil {
 				return fmt.Errorf("failed to import star at index %d: %w", i, err)
 			}
@@ -1552,7 +3134,11 @@ func importAllData(data map[string]interface{}) error {
 			}
 			if reasonID == nil && reasonText != "" {
 				reasonKey, _ := valueAsString(entry["reason_key"])
-				mapped, err := insertReason(reasonKey, starsValue, map[string]string{"en": reasonText}, 0)
+				currencyID, err := currencyOf(entry)
+				if err != nil {
+					return fmt.Errorf("failed to import star at index %d: %w", i, err)
+				}
+				mapped, err := insertReason(reasonKey, starsValue, currencyID, map[string]string{"en": reasonText}, 0)
 				if err != nil {
 					return fmt.Errorf("failed to create reason for star at index %d: %w", i, err)
 				}
@@ -1561,7 +3147,7 @@ func importAllData(data map[string]interface{}) error {
 
 			var awardedBy interface{}
 			if awardedByName, ok := valueAsString(entry["awarded_by"]); ok && awardedByName != "" {
-				awarderID, err := lookupUserIDTx(tx, userIDCache, awardedByName)
+				awarderID, err := lookupUserIDTx(tx, familyID, userIDCache, awardedByName)
 				if err != nil {
 					return fmt.Errorf("failed to import star awarder at index %d: %w", i, err)
 				}
@@ -1572,14 +3158,19 @@ func importAllData(data map[string]interface{}) error {
 			if reasonID == nil && reasonText != "" {
 				reasonTextValue = reasonText
 			}
+			// A star is always counted in its reason's currency
+			currencyID := 0
+			if reasonID != nil {
+				currencyID = reasonCurrency[*reasonID]
+			}
 
 			createdAt, hasCreatedAt := parseImportedTime(entry["created_at"])
 			if hasCreatedAt {
-				_, err = tx.Exec("INSERT INTO stars (user_id, reason_id, reason_text, stars, awarded_by, created_at) VALUES (?, ?, ?, ?, ?, ?)",
-					userID, reasonID, reasonTextValue, starsValue, awardedBy, createdAt.Format(time.RFC3339))
+				_, err = tx.Exec("INSERT INTO stars (user_id, reason_id, reason_text, stars, currency_id, awarded_by, created_at) VALUES (?, ?, ?, ?, ?, ?, ?)",
+					userID, reasonID, reasonTextValue, starsValue, currencyID, awardedBy, createdAt.Format(time.RFC3339))
 			} else {
-				_, err = tx.Exec("INSERT INTO stars (user_id, reason_id, reason_text, stars, awarded_by) VALUES (?, ?, ?, ?, ?)",
-					userID, reasonID, reasonTextValue, starsValue, awardedBy)
+				_, err = tx.Exec("INSERT INTO stars (user_id, reason_id, reason_text, stars, currency_id, awarded_by) VALUES (?, ?, ?, ?, ?, ?)",
+					userID, reasonID, reasonTextValue, starsValue, currencyID, awardedBy)
 			}
 			if err != nil {
 				return fmt.Errorf("failed to insert star at index %d: %w", i, err)
@@ -1599,7 +3190,7 @@ func importAllData(data map[string]interface{}) error {
 			}
 
 			username, _ := valueAsString(entry["username"])
-			userID, err := lookupUserIDTx(tx, userIDCache, username)
+			userID, err := lookupUserIDTx(tx, familyID, userIDCache, username)
 			if err != nil {
 				return fmt.Errorf("failed to import redemption at index %d: %w", i, err)
 			}
@@ -1627,13 +3218,14 @@ func importAllData(data map[string]interface{}) error {
 				cost = parsedCost
 			}
 
+			currencyID := rewardCurrency[rewardID]
 			createdAt, hasCreatedAt := parseImportedTime(entry["created_at"])
 			if hasCreatedAt {
-				_, err = tx.Exec("INSERT INTO redemptions (user_id, reward_id, cost, created_at) VALUES (?, ?, ?, ?)",
-					userID, rewardID, cost, createdAt.Format(time.RFC3339))
+				_, err = tx.Exec("INSERT INTO redemptions (user_id, reward_id, cost, currency_id, created_at) VALUES (?, ?, ?, ?, ?)",
+					userID, rewardID, cost, currencyID, createdAt.Format(time.RFC3339))
 			} else {
-				_, err = tx.Exec("INSERT INTO redemptions (user_id, reward_id, cost) VALUES (?, ?, ?)",
-					userID, rewardID, cost)
+				_, err = tx.Exec("INSERT INTO redemptions (user_id, reward_id, cost, currency_id) VALUES (?, ?, ?, ?)",
+					userID, rewardID, cost, currencyID)
 			}
 			if err != nil {
 				return fmt.Errorf("failed to insert redemption at index %d: %w", i, err)
@@ -1641,26 +3233,106 @@ func importAllData(data map[string]interface{}) error {
 		}
 	}
 
+	if rawChores, ok := data["chores"]; ok {
+		chores, ok := valueAsSlice(rawChores)
+		if !ok {
+			return errors.New("invalid chores payload")
+		}
+		for i, item := range chores {
+			entry, ok := valueAsMap(item)
+			if !ok {
+				return fmt.Errorf("invalid chores entry at index %d", i)
+			}
+			reasonKey, _ := valueAsString(entry["reason_key"])
+			reasonID, found := reasonIDByKey[reasonKey]
+			if !found {
+				return fmt.Errorf("failed to import chore at index %d: reason %q not found", i, reasonKey)
+			}
+			schedule, _ := valueAsString(entry["schedule"])
+			if !validChoreSchedule(schedule) {
+				schedule = "daily"
+			}
+			weekday, _ := valueAsInt(entry["weekday"])
+			dueTime, _ := valueAsString(entry["due_time"])
+			autoApprove, _ := valueAsBool(entry["auto_approve"])
+
+			result, err := tx.Exec("INSERT INTO chores (reason_id, schedule, weekday, due_time, auto_approve) VALUES (?, ?, ?, ?, ?)",
+				reasonID, schedule, weekday, dueTime, autoApprove)
+			if err != nil {
+				return fmt.Errorf("failed to insert chore at index %d: %w", i, err)
+			}
+			choreID, _ := result.LastInsertId()
+
+			usernames, _ := valueAsSlice(entry["users"])
+			for _, rawUsername := range usernames {
+				username, _ := valueAsString(rawUsername)
+				userID, err := lookupUserIDTx(tx, familyID, userIDCache, username)
+				if err != nil {
+					return fmt.Errorf("failed to import chore assignment at index %d: %w", i, err)
+				}
+				if _, err := tx.Exec("INSERT OR IGNORE INTO chore_assignments (chore_id, user_id) VALUES (?, ?)", choreID, userID); err != nil {
+					return err
+				}
+			}
+		}
+	}
+
+	if rawGoals, ok := data["goals"]; ok {
+		goals, ok := valueAsSlice(rawGoals)
+		if !ok {
+			return errors.New("invalid goals payload")
+		}
+		for i, item := range goals {
+			entry, ok := valueAsMap(item)
+			if !ok {
+				return fmt.Errorf("invalid goals entry at index %d", i)
+			}
+			username, _ := valueAsString(entry["username"])
+			userID, err := lookupUserIDTx(tx, familyID, userIDCache, username)
+			if err != nil {
+				return fmt.Errorf("failed to import goal at index %d: %w", i, err)
+			}
+			rewardKey, _ := valueAsString(entry["reward_key"])
+			rewardID, found := rewardIDByKey[rewardKey]
+			if !found {
+				return fmt.Errorf("failed to import goal at index %d: reward %q not found", i, rewardKey)
+			}
+			if _, err := tx.Exec("INSERT OR REPLACE INTO savings_goals (user_id, reward_id) VALUES (?, ?)", userID, rewardID); err != nil {
+				return fmt.Errorf("failed to insert goal at index %d: %w", i, err)
+			}
+		}
+	}
+
 	if rawSettings, ok := data["settings"]; ok {
 		settings, ok := valueAsMap(rawSettings)
 		if !ok {
 			return errors.New("invalid settings payload")
 		}
+		// Only the family's own settings are restored; deployment settings such as MQTT are skipped
+		familyKeys := make(map[string]bool)
+		for _, key := range announcementSettingKeys() {
+			familyKeys[key] = true
+		}
 		for key, rawValue := range settings {
-			if rawValue == nil {
+			if rawValue == nil || !familyKeys[key] {
 				continue
 			}
 			value, ok := valueAsString(rawValue)
 			if !ok {
 				value = strings.TrimSpace(fmt.Sprintf("%v", rawValue))
 			}
-			if _, err := tx.Exec(`INSERT INTO settings (key, value) VALUES (?, ?)
-				ON CONFLICT(key) DO UPDATE SET value = ?`, key, value, value); err != nil {
+			if _, err := tx.Exec(`INSERT INTO family_settings (family_id, key, value) VALUES (?, ?, ?)
+				ON CONFLICT(family_id, key) DO UPDATE SET value = ?`, familyID, key, value, value); err != nil {
 				return err
 			}
 		}
 	}
 
+	// Imported stars and redemptions replace the old history, so replay them into a fresh ledger
+	if err := rebuildLedgerTx(tx, familyID); err != nil {
+		return fmt.Errorf("failed to rebuild ledger: %w", err)
+	}
+
 	if err := tx.Commit(); err != nil {
 		return fmt.Errorf("failed to commit import: %w", err)
 	}
@@ -1668,14 +3340,15 @@ func importAllData(data map[string]interface{}) error {
 	return nil
 }
 
-func getRecentRedemptions(limit int, filterUserID int) ([]Redemption, error) {
-	query := `SELECT rd.id, rd.user_id, u.username, rd.reward_id, rw.key, COALESCE(rd.cost, rw.cost), rd.created_at
+func getRecentRedemptions(familyID, limit, filterUserID int) ([]Redemption, error) {
+	query := `SELECT rd.id, rd.user_id, u.username, rd.reward_id, rw.key, COALESCE(rd.cost, rw.cost), rd.currency_id, rd.created_at
 		FROM redemptions rd
 		JOIN users u ON rd.user_id = u.id
-		JOIN rewards rw ON rd.reward_id = rw.id`
-	var args []interface{}
+		JOIN rewards rw ON rd.reward_id = rw.id
+		WHERE rd.voided_at IS NULL AND u.family_id = ?`
+	args := []interface{}{familyID}
 	if filterUserID > 0 {
-		query += " WHERE rd.user_id = ?"
+		query += " AND rd.user_id = ?"
 		args = append(args, filterUserID)
 	}
 	query += " ORDER BY rd.created_at DESC LIMIT ?"
@@ -1690,22 +3363,17 @@ func getRecentRedemptions(limit int, filterUserID int) ([]Redemption, error) {
 	for rows.Next() {
 		var r Redemption
 		var createdAtStr sql.NullString
-		err := rows.Scan(&r.ID, &r.UserID, &r.Username, &r.RewardID, &r.RewardKey, &r.Cost, &createdAtStr)
+		err := rows.Scan(&r.ID, &r.UserID, &r.Username, &r.RewardID, &r.RewardKey, &r.Cost, &r.CurrencyID, &createdAtStr)
 		if err != nil {
 			fmt.Printf("Error scanning redemption row: %v\n", err)
 			continue
 		}
 
-		// Get all translations for this user
-		r.UsernameEN = getUserText(r.UserID, "en")
-		r.UsernameCN = getUserText(r.UserID, "zh-CN")
-		r.UsernameTW = getUserText(r.UserID, "zh-TW")
-
-		// Get all translations for this reward
-		r.RewardNameEN = getRewardText(r.RewardID, "en")
-		r.RewardNameCN = getRewardText(r.RewardID, "zh-CN")
-		r.RewardNameTW = getRewardText(r.RewardID, "zh-TW")
-		r.RewardName = r.RewardNameEN // Keep for backward compatibility
+		// Get all translations for this user and reward
+		r.UsernameTranslations = getUserTexts(r.UserID)
+		r.RewardTranslations = getRewardTexts(r.RewardID)
+		r.RewardName = r.RewardTranslations[defaultLanguage] // Keep for backward compatibility
+		r.CurrencyIcon = currencyIcon(r.CurrencyID)
 
 		// Parse the datetime string - modernc.org/sqlite returns RFC3339 format
 		if createdAtStr.Valid && createdAtStr.String != "" {
@@ -1720,3 +3388,549 @@ func getRecentRedemptions(limit int, filterUserID int) ([]Redemption, error) {
 	}
 	return results, nil
 }
+
+func addChore(reasonID int, schedule string, weekday int, dueTime string, autoApprove bool, userIDs []int) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.Exec("INSERT INTO chores (reason_id, schedule, weekday, due_time, auto_approve) VALUES (?, ?, ?, ?, ?)",
+		reasonID, schedule, weekday, dueTime, autoApprove)
+	if err != nil {
+		return err
+	}
+	choreID, _ := result.LastInsertId()
+	for _, userID := range userIDs {
+		if _, err := tx.Exec("INSERT OR IGNORE INTO chore_assignments (chore_id, user_id) VALUES (?, ?)", choreID, userID); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+func deleteChore(id int) error {
+	_, err := db.Exec("DELETE FROM chores WHERE id = ?", id)
+	return err
+}
+
+// getChores lists a family's chores. Chores belong to the family of their reason.
+func getChores(familyID int) ([]Chore, error) {
+	rows, err := db.Query(`SELECT c.id, c.reason_id, r.key, r.stars, r.currency_id, c.schedule, c.weekday, c.due_time, COALESCE(c.auto_approve, 0)
+		FROM chores c JOIN reasons r ON c.reason_id = r.id
+		WHERE r.family_id = ?
+		ORDER BY c.id`, familyID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var chores []Chore
+	for rows.Next() {
+		var c Chore
+		var currencyID int
+		c.Translations = make(map[string]string)
+		rows.Scan(&c.ID, &c.ReasonID, &c.ReasonKey, &c.Stars, &currencyID, &c.Schedule, &c.Weekday, &c.DueTime, &c.AutoApprove)
+		c.CurrencyIcon = currencyIcon(currencyID)
+
+		// Chores are labelled by their reason's translations
+		tRows, _ := db.Query("SELECT lang, text FROM reason_translations WHERE reason_id = ?", c.ReasonID)
+		for tRows.Next() {
+			var lang, text string
+			tRows.Scan(&lang, &text)
+			c.Translations[lang] = text
+		}
+		tRows.Close()
+
+		aRows, _ := db.Query(`SELECT ca.user_id, u.username FROM chore_assignments ca
+			JOIN users u ON ca.user_id = u.id
+			WHERE ca.chore_id = ? ORDER BY u.username`, c.ID)
+		for aRows.Next() {
+			var userID int
+			var username string
+			aRows.Scan(&userID, &username)
+			c.UserIDs = append(c.UserIDs, userID)
+			c.Usernames = append(c.Usernames, username)
+		}
+		aRows.Close()
+
+		chores = append(chores, c)
+	}
+	return chores, nil
+}
+
+func getChoreByID(familyID, id int) (*Chore, error) {
+	chores, err := getChores(familyID)
+	if err != nil {
+		return nil, err
+	}
+	for _, c := range chores {
+		if c.ID == id {
+			return &c, nil
+		}
+	}
+	return nil, sql.ErrNoRows
+}
+
+// getChoreCompletionState returns the completion id and status for a chore period, or 0 and "" if none.
+func getChoreCompletionState(choreID, userID int, period string) (int, string) {
+	var id int
+	var status string
+	db.QueryRow("SELECT id, status FROM chore_completions WHERE chore_id = ? AND user_id = ? AND period = ?",
+		choreID, userID, period).Scan(&id, &status)
+	return id, status
+}
+
+// submitChoreCompletion records a check-off for the period. A rejected check-off may be resubmitted.
+func submitChoreCompletion(choreID, userID int, period string) (int64, error) {
+	id, status := getChoreCompletionState(choreID, userID, period)
+	switch status {
+	case "":
+		result, err := db.Exec("INSERT INTO chore_completions (chore_id, user_id, period) VALUES (?, ?, ?)", choreID, userID, period)
+		if err != nil {
+			return 0, err
+		}
+		return result.LastInsertId()
+	case "rejected":
+		_, err := db.Exec("UPDATE chore_completions SET status = 'pending', reviewed_by = NULL, reviewed_at = NULL, created_at = CURRENT_TIMESTAMP WHERE id = ?", id)
+		return int64(id), err
+	default:
+		return 0, errors.New("chore already checked off")
+	}
+}
+
+func getChoreCompletionByID(familyID, id int) (*ChoreCompletion, error) {
+	var c ChoreCompletion
+	err := db.QueryRow(`SELECT cc.id, cc.chore_id, cc.user_id, u.username, ch.reason_id, cc.period, cc.status
+		FROM chore_completions cc
+		JOIN users u ON cc.user_id = u.id
+		JOIN chores ch ON cc.chore_id = ch.id
+		WHERE cc.id = ? AND u.family_id = ?`, id, familyID).
+		Scan(&c.ID, &c.ChoreID, &c.UserID, &c.Username, &c.ReasonID, &c.Period, &c.Status)
+	if err != nil {
+		return nil, err
+	}
+	return &c, nil
+}
+
+// errChoreCompletionReviewed is returned when a check-off was approved or
+// rejected by someone else first.
+var errChoreCompletionReviewed = errors.New("chore check-off was already reviewed")
+
+// approveChoreCompletion claims a pending check-off and awards its reason's
+// stars in one transaction, so it can only be approved once.
+func approveChoreCompletion(id, userID, reasonID, reviewedBy int) (int64, error) {
+	ledgerMu.Lock()
+	defer ledgerMu.Unlock()
+
+	tx, err := db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	var reviewer interface{}
+	if reviewedBy > 0 {
+		reviewer = reviewedBy
+	}
+	result, err := tx.Exec("UPDATE chore_completions SET status = 'approved', reviewed_by = ?, reviewed_at = CURRENT_TIMESTAMP WHERE id = ? AND status = 'pending'",
+		reviewer, id)
+	if err != nil {
+		return 0, err
+	}
+	if n, _ := result.RowsAffected(); n != 1 {
+		return 0, errChoreCompletionReviewed
+	}
+	var stars int
+	if err := tx.QueryRow("SELECT stars FROM reasons WHERE id = ?", reasonID).Scan(&stars); err != nil {
+		return 0, err
+	}
+	if stars == 0 {
+		stars = 1
+	}
+	starID, err := insertStarTx(tx, userID, reasonID, stars, reviewedBy)
+	if err != nil {
+		return 0, err
+	}
+	if _, err := tx.Exec("UPDATE chore_completions SET star_id = ? WHERE id = ?", starID, id); err != nil {
+		return 0, err
+	}
+	return starID, commitLedger(tx, userID)
+}
+
+// rejectChoreCompletion rejects a pending check-off.
+func rejectChoreCompletion(id int, reviewedBy int) error {
+	result, err := db.Exec("UPDATE chore_completions SET status = 'rejected', reviewed_by = ?, reviewed_at = CURRENT_TIMESTAMP WHERE id = ? AND status = 'pending'",
+		reviewedBy, id)
+	if err != nil {
+		return err
+	}
+	if n, _ := result.RowsAffected(); n != 1 {
+		return errChoreCompletionReviewed
+	}
+	return nil
+}
+
+func getPendingChoreCompletions(familyID int) ([]ChoreCompletion, error) {
+	rows, err := db.Query(`SELECT cc.id, cc.chore_id, cc.user_id, u.username, ch.reason_id, r.stars, r.currency_id, cc.period, cc.status, cc.created_at
+		FROM chore_completions cc
+		JOIN users u ON cc.user_id = u.id
+		JOIN chores ch ON cc.chore_id = ch.id
+		JOIN reasons r ON ch.reason_id = r.id
+		WHERE cc.status = 'pending' AND u.family_id = ?
+		ORDER BY cc.created_at ASC`, familyID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var results []ChoreCompletion
+	for rows.Next() {
+		var c ChoreCompletion
+		var currencyID int
+		var createdAtStr sql.NullString
+		if err := rows.Scan(&c.ID, &c.ChoreID, &c.UserID, &c.Username, &c.ReasonID, &c.Stars, &currencyID, &c.Period, &c.Status, &createdAtStr); err != nil {
+			fmt.Printf("Error scanning chore completion row: %v\n", err)
+			continue
+		}
+		c.CurrencyIcon = currencyIcon(currencyID)
+
+		c.UsernameTranslations = getUserTexts(c.UserID)
+
+		c.Translations = make(map[string]string)
+		tRows, _ := db.Query("SELECT lang, text FROM reason_translations WHERE reason_id = ?", c.ReasonID)
+		for tRows.Next() {
+			var lang, text string
+			tRows.Scan(&lang, &text)
+			c.Translations[lang] = text
+		}
+		tRows.Close()
+
+		if createdAtStr.Valid && createdAtStr.String != "" {
+			if t, err := time.Parse(time.RFC3339, createdAtStr.String); err == nil {
+				c.CreatedAt = t
+			} else if t, err := time.Parse("2006-01-02 15:04:05", createdAtStr.String); err == nil {
+				c.CreatedAt = t
+			}
+		}
+		results = append(results, c)
+	}
+	return results, nil
+}
+
+// addRedemptionRequest reserves cost of the reward's currency for a request,
+// failing with a *notEnoughError if the user can't spare it.
+func addRedemptionRequest(userID, rewardID, cost int) (int64, error) {
+	ledgerMu.Lock()
+	defer ledgerMu.Unlock()
+
+	tx, err := db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	var currencyID int
+	if err := tx.QueryRow("SELECT currency_id FROM rewards WHERE id = ?", rewardID).Scan(&currencyID); err != nil {
+		return 0, err
+	}
+	available, err := ledgerAvailable(tx, userID, currencyID)
+	if err != nil {
+		return 0, err
+	}
+	if available < cost {
+		return 0, &notEnoughError{Available: available}
+	}
+	result, err := tx.Exec("INSERT INTO redemption_requests (user_id, reward_id, cost) VALUES (?, ?, ?)", userID, rewardID, cost)
+	if err != nil {
+		return 0, err
+	}
+	requestID, _ := result.LastInsertId()
+	return requestID, tx.Commit()
+}
+
+func getRedemptionRequestByID(id int) (*RedemptionRequest, error) {
+	var r RedemptionRequest
+	err := db.QueryRow(`SELECT rq.id, rq.user_id, u.username, rq.reward_id, rq.cost, rq.status
+		FROM redemption_requests rq JOIN users u ON rq.user_id = u.id
+		WHERE rq.id = ?`, id).
+		Scan(&r.ID, &r.UserID, &r.Username, &r.RewardID, &r.Cost, &r.Status)
+	if err != nil {
+		return nil, err
+	}
+	return &r, nil
+}
+
+// errRequestDecided is returned when a redemption request was already
+// approved, rejected or cancelled.
+var errRequestDecided = errors.New("request is no longer pending")
+
+// approveRedemptionRequest claims a pending request and redeems its reward at
+// the current cost in one transaction, so it is only redeemed once. It fails
+// with a *notEnoughError if the kid can no longer afford it.
+func approveRedemptionRequest(id, decidedBy int) (int64, error) {
+	ledgerMu.Lock()
+	defer ledgerMu.Unlock()
+
+	tx, err := db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	var decider interface{}
+	if decidedBy > 0 {
+		decider = decidedBy
+	}
+	result, err := tx.Exec(`UPDATE redemption_requests SET status = 'approved', decided_by = ?, decided_at = CURRENT_TIMESTAMP
+		WHERE id = ? AND status = 'pending'`, decider, id)
+	if err != nil {
+		return 0, err
+	}
+	if n, _ := result.RowsAffected(); n != 1 {
+		return 0, errRequestDecided
+	}
+
+	// The claim released the request's own reservation
+	var userID, rewardID int
+	if err := tx.QueryRow("SELECT user_id, reward_id FROM redemption_requests WHERE id = ?", id).Scan(&userID, &rewardID); err != nil {
+		return 0, err
+	}
+	redemptionID, err := redeemRewardTx(tx, userID, rewardID, decidedBy)
+	if err != nil {
+		return 0, err
+	}
+	if _, err := tx.Exec("UPDATE redemption_requests SET redemption_id = ? WHERE id = ?", redemptionID, id); err != nil {
+		return 0, err
+	}
+	return redemptionID, commitLedger(tx, userID)
+}
+
+// decideRedemptionRequest moves a pending request to its final status.
+// It fails if another parent already decided the request.
+func decideRedemptionRequest(id int, status string, redemptionID int64, decidedBy int) error {
+	var redemption, decider interface{}
+	if redemptionID > 0 {
+		redemption = redemptionID
+	}
+	if decidedBy > 0 {
+		decider = decidedBy
+	}
+	result, err := db.Exec(`UPDATE redemption_requests SET status = ?, redemption_id = ?, decided_by = ?, decided_at = CURRENT_TIMESTAMP
+		WHERE id = ? AND status = 'pending'`, status, redemption, decider, id)
+	if err != nil {
+		return err
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return errRequestDecided
+	}
+	return nil
+}
+
+// getPendingRedemptionRequests lists a family's pending requests, oldest first. Pass userID 0 for all users.
+func getPendingRedemptionRequests(familyID, filterUserID int) ([]RedemptionRequest, error) {
+	query := `SELECT rq.id, rq.user_id, u.username, rq.reward_id, rw.icon, rq.cost, rw.currency_id, rq.status, rq.created_at
+		FROM redemption_requests rq
+		JOIN users u ON rq.user_id = u.id
+		JOIN rewards rw ON rq.reward_id = rw.id
+		WHERE rq.status = 'pending' AND u.family_id = ?`
+	args := []interface{}{familyID}
+	if filterUserID > 0 {
+		query += " AND rq.user_id = ?"
+		args = append(args, filterUserID)
+	}
+	query += " ORDER BY rq.created_at ASC"
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var results []RedemptionRequest
+	for rows.Next() {
+		var r RedemptionRequest
+		var currencyID int
+		var createdAtStr sql.NullString
+		if err := rows.Scan(&r.ID, &r.UserID, &r.Username, &r.RewardID, &r.RewardIcon, &r.Cost, &currencyID, &r.Status, &createdAtStr); err != nil {
+			fmt.Printf("Error scanning redemption request row: %v\n", err)
+			continue
+		}
+		r.CurrencyIcon = currencyIcon(currencyID)
+
+		r.UsernameTranslations = getUserTexts(r.UserID)
+		r.RewardTranslations = getRewardTexts(r.RewardID)
+
+		if createdAtStr.Valid && createdAtStr.String != "" {
+			if t, err := time.Parse(time.RFC3339, createdAtStr.String); err == nil {
+				r.CreatedAt = t
+			} else if t, err := time.Parse("2006-01-02 15:04:05", createdAtStr.String); err == nil {
+				r.CreatedAt = t
+			}
+		}
+		results = append(results, r)
+	}
+	return results, nil
+}
+
+func setSavingsGoal(userID, rewardID int) error {
+	_, err := db.Exec(`INSERT INTO savings_goals (user_id, reward_id) VALUES (?, ?)
+		ON CONFLICT(user_id) DO UPDATE SET reward_id = ?, reached_at = NULL, created_at = CURRENT_TIMESTAMP`,
+		userID, rewardID, rewardID)
+	return err
+}
+
+func clearSavingsGoal(userID int) error {
+	_, err := db.Exec("DELETE FROM savings_goals WHERE user_id = ?", userID)
+	return err
+}
+
+// getSavingsGoal returns the pinned reward and whether reaching it was already announced.
+func getSavingsGoal(userID int) (rewardID int, reached bool, err error) {
+	var reachedAt sql.NullString
+	err = db.QueryRow("SELECT reward_id, reached_at FROM savings_goals WHERE user_id = ?", userID).Scan(&rewardID, &reachedAt)
+	return rewardID, reachedAt.Valid, err
+}
+
+func setSavingsGoalReached(userID int, reached bool) error {
+	if reached {
+		_, err := db.Exec("UPDATE savings_goals SET reached_at = CURRENT_TIMESTAMP WHERE user_id = ?", userID)
+		return err
+	}
+	_, err := db.Exec("UPDATE savings_goals SET reached_at = NULL WHERE user_id = ?", userID)
+	return err
+}
+
+// getRecentEarnedTotal sums what a user earned in a currency (net of penalties and reversals) since the given time.
+func getRecentEarnedTotal(userID, currencyID int, since time.Time) int {
+	var total int
+	db.QueryRow("SELECT COALESCE(SUM(amount), 0) FROM ledger_entries WHERE user_id = ? AND currency_id = ? AND star_id IS NOT NULL AND datetime(created_at) >= datetime(?)",
+		userID, currencyID, since.UTC().Format("2006-01-02 15:04:05")).Scan(&total)
+	return total
+}
+
+func addAuditEntry(e AuditEntry) error {
+	var actorID, targetID interface{}
+	if e.ActorID > 0 {
+		actorID = e.ActorID
+	}
+	if e.TargetID > 0 {
+		targetID = e.TargetID
+	}
+	_, err := db.Exec(`INSERT INTO audit_log (family_id, actor_id, actor, source, source_label, action, target_type, target_id, target, before_value, after_value)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		e.FamilyID, actorID, e.Actor, e.Source, e.SourceLabel, e.Action, e.TargetType, targetID, e.Target, string(e.Before), string(e.After))
+	return err
+}
+
+// getAuditEntries lists audit entries newest first. Action matches as a prefix,
+// so "star" finds both "star.award" and "star.delete".
+func getAuditEntries(f AuditFilter) ([]AuditEntry, error) {
+	query := `SELECT id, family_id, COALESCE(actor_id, 0), actor, source, source_label, action, target_type, COALESCE(target_id, 0), target,
+		COALESCE(before_value, 'null'), COALESCE(after_value, 'null'), created_at
+		FROM audit_log WHERE family_id = ?`
+	args := []interface{}{f.FamilyID}
+	if f.Actor != "" {
+		query += " AND actor = ?"
+		args = append(args, f.Actor)
+	}
+	if f.Action != "" {
+		query += " AND action LIKE ?"
+		args = append(args, f.Action+"%")
+	}
+	if f.TargetType != "" {
+		query += " AND target_type = ?"
+		args = append(args, f.TargetType)
+	}
+	if f.Source != "" {
+		query += " AND source = ?"
+		args = append(args, f.Source)
+	}
+	if f.Limit <= 0 {
+		f.Limit = 200
+	}
+	query += " ORDER BY id DESC LIMIT ?"
+	args = append(args, f.Limit)
+
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var entries []AuditEntry
+	for rows.Next() {
+		var e AuditEntry
+		var before, after string
+		var createdAtStr sql.NullString
+		err := rows.Scan(&e.ID, &e.FamilyID, &e.ActorID, &e.Actor, &e.Source, &e.SourceLabel, &e.Action, &e.TargetType, &e.TargetID, &e.Target,
+			&before, &after, &createdAtStr)
+		if err != nil {
+			fmt.Printf("Error scanning audit row: %v\n", err)
+			continue
+		}
+		if before == "" {
+			before = "null"
+		}
+		if after == "" {
+			after = "null"
+		}
+		e.Before = []byte(before)
+		e.After = []byte(after)
+		if createdAtStr.Valid && createdAtStr.String != "" {
+			if t, err := time.Parse(time.RFC3339, createdAtStr.String); err == nil {
+				e.CreatedAt = t
+			} else if t, err := time.Parse("2006-01-02 15:04:05", createdAtStr.String); err == nil {
+				e.CreatedAt = t
+			}
+		}
+		entries = append(entries, e)
+	}
+	return entries, nil
+}
+
+// getAuditFacets returns the distinct actors, actions and target types for a family's audit filters.
+func getAuditFacets(familyID int) (actors, actions, targetTypes []string) {
+	distinct := func(column string) []string {
+		var values []string
+		rows, err := db.Query("SELECT DISTINCT "+column+" FROM audit_log WHERE family_id = ? AND "+column+" != '' ORDER BY "+column, familyID)
+		if err != nil {
+			return nil
+		}
+		defer rows.Close()
+		for rows.Next() {
+			var v string
+			rows.Scan(&v)
+			values = append(values, v)
+		}
+		return values
+	}
+	return distinct("actor"), distinct("action"), distinct("target_type")
+}
+
+// getDataSummary counts the family's rows an import replaces, for the audit log.
+func getDataSummary(familyID int) map[string]int {
+	summary := make(map[string]int)
+	for _, table := range []string{"users", "reasons", "rewards"} {
+		var n int
+		db.QueryRow("SELECT COUNT(*) FROM "+table+" WHERE family_id = ?", familyID).Scan(&n)
+		summary[table] = n
+	}
+	var chores int
+	db.QueryRow("SELECT COUNT(*) FROM chores c JOIN reasons r ON c.reason_id = r.id WHERE r.family_id = ?", familyID).Scan(&chores)
+	summary["chores"] = chores
+	// Voided stars and redemptions are not exported, so only count active ones
+	for _, table := range []string{"stars", "redemptions"} {
+		var n int
+		db.QueryRow("SELECT COUNT(*) FROM "+table+" t JOIN users u ON t.user_id = u.id WHERE t.voided_at IS NULL AND u.family_id = ?", familyID).Scan(&n)
+		summary[table] = n
+	}
+	return summary
+}
+
+// getUserHistoryCounts counts the star and redemption records held for a user.
+func getUserHistoryCounts(userID int) (stars, redemptions int) {
+	db.QueryRow("SELECT COUNT(*) FROM stars WHERE user_id = ? AND voided_at IS NULL", userID).Scan(&stars)
+	db.QueryRow("SELECT COUNT(*) FROM redemptions WHERE user_id = ? AND voided_at IS NULL", userID).Scan(&redemptions)
+	return stars, redemptions
+}
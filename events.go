@@ -0,0 +1,231 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"sync"
+	"time"
+)
+
+// Event types published when stars, redemptions or balances change.
+const (
+	eventStarAwarded       = "star.awarded"
+	eventStarDeleted       = "star.deleted"
+	eventRewardRedeemed    = "reward.redeemed"
+	eventRedemptionDeleted = "redemption.deleted"
+	eventBalanceChanged    = "balance.changed"
+	eventGoalReached       = "goal.reached"
+	eventSettingsChanged   = "settings.changed"
+	eventPing              = "ping"
+)
+
+// eventTypes lists the events integrations can subscribe to.
+var eventTypes = []string{
+	eventStarAwarded,
+	eventStarDeleted,
+	eventRewardRedeemed,
+	eventRedemptionDeleted,
+	eventBalanceChanged,
+	eventGoalReached,
+	eventSettingsChanged,
+}
+
+func validEventType(eventType string) bool {
+	for _, t := range eventTypes {
+		if t == eventType {
+			return true
+		}
+	}
+	return false
+}
+
+var (
+	eventSubscribersMu sync.Mutex
+	eventSubscribers   []func(Event)
+)
+
+// subscribeEvents registers fn to receive every published event.
+// Subscribers run synchronously on the publishing goroutine, so they
+// should queue work rather than block.
+func subscribeEvents(fn func(Event)) {
+	eventSubscribersMu.Lock()
+	defer eventSubscribersMu.Unlock()
+	eventSubscribers = append(eventSubscribers, fn)
+}
+
+func newEvent(familyID int, eventType string, data map[string]interface{}) (Event, error) {
+	id, err := randomHex(12)
+	if err != nil {
+		return Event{}, err
+	}
+	return Event{
+		ID:        "evt_" + id,
+		FamilyID:  familyID,
+		Type:      eventType,
+		Data:      data,
+		CreatedAt: time.Now().UTC(),
+	}, nil
+}
+
+// publishEvent fans a family's event out to all subscribers.
+func publishEvent(familyID int, eventType string, data map[string]interface{}) {
+	e, err := newEvent(familyID, eventType, data)
+	if err != nil {
+		log.Printf("Failed to create %s event: %v", eventType, err)
+		return
+	}
+	eventSubscribersMu.Lock()
+	subscribers := eventSubscribers
+	eventSubscribersMu.Unlock()
+	for _, fn := range subscribers {
+		fn(e)
+	}
+}
+
+// eventJSON encodes the envelope sent to integrations.
+func eventJSON(e Event) ([]byte, error) {
+	return json.Marshal(map[string]interface{}{
+		"id":         e.ID,
+		"type":       e.Type,
+		"created_at": e.CreatedAt.Format(time.RFC3339),
+		"data":       e.Data,
+	})
+}
+
+// userFamilyID returns the family a user belongs to, or 0 if the user is gone.
+func userFamilyID(userID int) int {
+	user, err := getUserByID(userID)
+	if err != nil {
+		return 0
+	}
+	return user.FamilyID
+}
+
+// starEventData describes a star for event payloads.
+func starEventData(s *Star) map[string]interface{} {
+	data := map[string]interface{}{
+		"star_id":    s.ID,
+		"user_id":    s.UserID,
+		"reason":     getReasonText(s.ReasonID, s.ReasonText, "en"),
+		"reason_id":  s.ReasonID,
+		"stars":      s.Stars,
+		"currency":   currencyKey(s.CurrencyID),
+		"awarded_by": nil,
+	}
+	if user, err := getUserByID(s.UserID); err == nil {
+		data["username"] = user.Username
+	}
+	if s.AwardedBy > 0 {
+		if awarder, err := getUserByID(s.AwardedBy); err == nil {
+			data["awarded_by"] = awarder.Username
+		}
+	}
+	return data
+}
+
+// redemptionEventData describes a redemption for event payloads.
+func redemptionEventData(rd *Redemption) map[string]interface{} {
+	data := map[string]interface{}{
+		"redemption_id": rd.ID,
+		"user_id":       rd.UserID,
+		"reward_id":     rd.RewardID,
+		"reward":        rd.RewardKey,
+		"reward_name":   getRewardText(rd.RewardID, "en"),
+		"cost":          rd.Cost,
+		"currency":      currencyKey(rd.CurrencyID),
+	}
+	if user, err := getUserByID(rd.UserID); err == nil {
+		data["username"] = user.Username
+	}
+	return data
+}
+
+func publishStarAwarded(starID int64) {
+	star, err := getStarByID(int(starID))
+	if err != nil {
+		return
+	}
+	publishEvent(userFamilyID(star.UserID), eventStarAwarded, starEventData(star))
+}
+
+// publishStarDeleted reports a removed star; star is the snapshot taken before it was voided.
+func publishStarDeleted(star *Star, deletedBy string) {
+	data := starEventData(star)
+	data["deleted_by"] = deletedBy
+	publishEvent(userFamilyID(star.UserID), eventStarDeleted, data)
+}
+
+func publishRewardRedeemed(redemptionID int64) {
+	redemption, err := getRedemptionByID(int(redemptionID))
+	if err != nil {
+		return
+	}
+	publishEvent(userFamilyID(redemption.UserID), eventRewardRedeemed, redemptionEventData(redemption))
+}
+
+// publishRedemptionDeleted reports a refunded redemption; redemption is the snapshot taken before it was voided.
+func publishRedemptionDeleted(redemption *Redemption, deletedBy string) {
+	data := redemptionEventData(redemption)
+	data["deleted_by"] = deletedBy
+	publishEvent(userFamilyID(redemption.UserID), eventRedemptionDeleted, data)
+}
+
+// publishBalanceChanged reports a user's balance after a ledger write. The
+// top-level figures are stars; balances holds the family's other currencies.
+func publishBalanceChanged(userID int) {
+	user, err := getUserByID(userID)
+	if err != nil {
+		return
+	}
+	balance, earned, err := ledgerBalance(db, userID, 0)
+	if err != nil {
+		return
+	}
+	data := map[string]interface{}{
+		"user_id":  user.ID,
+		"username": user.Username,
+		"balance":  balance,
+		"earned":   earned,
+		"reserved": getUserReserved(user.ID, 0),
+	}
+	if currencies, _ := getCurrencies(user.FamilyID); len(currencies) > 0 {
+		balances := make(map[string]interface{})
+		for _, b := range getUserBalances(user.ID, currencies) {
+			balances[b.Key] = map[string]interface{}{
+				"balance":  b.Balance,
+				"earned":   b.Earned,
+				"reserved": b.Reserved,
+			}
+		}
+		data["balances"] = balances
+	}
+	publishEvent(user.FamilyID, eventBalanceChanged, data)
+}
+
+func publishGoalReached(user *User, reward *Reward, balance int) {
+	publishEvent(user.FamilyID, eventGoalReached, map[string]interface{}{
+		"user_id":     user.ID,
+		"username":    user.Username,
+		"reward_id":   reward.ID,
+		"reward":      reward.Key,
+		"reward_name": getRewardText(reward.ID, "en"),
+		"cost":        reward.Cost,
+		"currency":    currencyKey(reward.CurrencyID),
+		"balance":     balance,
+	})
+}
+
+// publishSettingsChanged reports the settings of a family that differ between
+// two snapshots. Snapshots never hold credentials, so neither does the event.
+func publishSettingsChanged(familyID int, before, after map[string]interface{}) {
+	changed := make(map[string]interface{})
+	for key, value := range after {
+		if before[key] != value {
+			changed[key] = value
+		}
+	}
+	if len(changed) == 0 {
+		return
+	}
+	publishEvent(familyID, eventSettingsChanged, map[string]interface{}{"changed": changed})
+}
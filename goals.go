@@ -0,0 +1,94 @@
+package main
+
+import (
+	"math"
+	"time"
+)
+
+// goalRateWindow is how far back the earning rate for goal estimates looks.
+const goalRateWindow = 14 * 24 * time.Hour
+
+// goalProgress builds the savings goal summary for a user, or nil if no goal is pinned.
+// Progress is measured in the reward's currency.
+func goalProgress(userID int, now time.Time) *SavingsGoal {
+	rewardID, _, err := getSavingsGoal(userID)
+	if err != nil {
+		return nil
+	}
+	reward, err := getRewardByID(rewardID)
+	if err != nil {
+		return nil
+	}
+
+	g := &SavingsGoal{
+		RewardID:           reward.ID,
+		RewardKey:          reward.Key,
+		RewardName:         reward.Name,
+		RewardTranslations: getRewardTexts(reward.ID),
+		Icon:               reward.Icon,
+		Cost:               reward.Cost,
+		CurrencyID:         reward.CurrencyID,
+		CurrencyIcon:       reward.CurrencyIcon,
+	}
+
+	current, _ := getUserBalance(userID, reward.CurrencyID)
+	g.Remaining = reward.Cost - current
+	if g.Remaining <= 0 {
+		g.Remaining = 0
+		g.Reached = true
+		g.Percent = 100
+		return g
+	}
+	if current > 0 {
+		g.Percent = current * 100 / reward.Cost
+	}
+
+	// Estimate from the net earning rate over the recent window
+	earned := getRecentEarnedTotal(userID, reward.CurrencyID, now.Add(-goalRateWindow))
+	g.DailyRate = float64(earned) / goalRateWindow.Hours() * 24
+	if g.DailyRate > 0 {
+		days := int(math.Ceil(float64(g.Remaining) / g.DailyRate))
+		eta := now.AddDate(0, 0, days)
+		g.EstimatedDate = &eta
+	}
+	return g
+}
+
+// checkGoalReached announces when a user's balance first covers their savings goal.
+// Dropping back below the cost re-arms the announcement.
+func checkGoalReached(username string) {
+	user, err := getUserByUsername(username)
+	if err != nil {
+		return
+	}
+	rewardID, announced, err := getSavingsGoal(user.ID)
+	if err != nil {
+		return
+	}
+	reward, err := getRewardByID(rewardID)
+	if err != nil {
+		return
+	}
+	current, err := getUserBalance(user.ID, reward.CurrencyID)
+	if err != nil {
+		return
+	}
+
+	affordable := current >= reward.Cost
+	if affordable == announced {
+		return
+	}
+	setSavingsGoalReached(user.ID, affordable)
+	if affordable {
+		publishGoalReached(user, reward, current)
+		announceGoalIfEnabled(user.Username, reward.ID)
+	}
+}
+
+// clearGoalIfRedeemed drops the savings goal once the pinned reward has been redeemed.
+func clearGoalIfRedeemed(userID, rewardID int) {
+	goalRewardID, _, err := getSavingsGoal(userID)
+	if err == nil && goalRewardID == rewardID {
+		clearSavingsGoal(userID)
+	}
+}
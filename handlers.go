@@ -4,6 +4,7 @@ import (
 	"crypto/rand"
 	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"html/template"
 	"net/http"
@@ -31,48 +32,44 @@ func sessionCookieSecure(r *http.Request) bool {
 
 func handleDashboard(w http.ResponseWriter, r *http.Request) {
 	user := getContextUser(r)
+	familyID := getContextFamilyID(r)
 
-	counts, _ := getUserStarCounts()
-	rewards, _ := getRewardsList()
-	reasons, _ := getReasons()
-	userReasonCounts, _ := getUserReasonCounts()
+	counts, _ := getUserStarCounts(familyID)
+	rewards, _ := getRewardsList(familyID)
+	reasons, _ := getReasons(familyID)
+	currencies, _ := getCurrencies(familyID)
+	userReasonCounts, _ := getUserReasonCounts(familyID)
 	userReasonCountsJSON, _ := json.Marshal(userReasonCounts)
 
-	// Kids only see their own data; admins see everything
+	// Kids only see their own data; admins see everything in the family
 	var stars []Star
 	var redemptions []Redemption
 	if user.IsAdmin {
-		stars, _ = getStars("")
-		redemptions, _ = getRecentRedemptions(10, 0)
+		stars, _ = getStars(familyID, "")
+		redemptions, _ = getRecentRedemptions(familyID, 10, 0)
 	} else {
-		stars, _ = getStars(user.Username)
-		redemptions, _ = getRecentRedemptions(10, user.ID)
+		stars, _ = getStars(familyID, user.Username)
+		redemptions, _ = getRecentRedemptions(familyID, 10, user.ID)
 	}
 
 	// Load all translations for each star
 	type DisplayStar struct {
-		ID              int
-		Username        string
-		UsernameEN      string
-		UsernameCN      string
-		UsernameTW      string
-		Display         string
-		Stars           int
-		ReasonEN        string
-		ReasonCN        string
-		ReasonTW        string
-		AwardedByName   string
-		AwardedByNameEN string
-		AwardedByNameCN string
-		AwardedByNameTW string
-		CreatedAt       time.Time
-	}
+		ID                    int
+		Username              string
+		UsernameTranslations  map[string]string
+		Display               string
+		Stars                 int
+		ReasonTranslations    map[string]string
+		AwardedByName         string
+		AwardedByTranslations map[string]string
+		CreatedAt             time.Time
+	}
+	lang := requestLanguage(r)
 	var consolidated []DisplayStar
 	for i := 0; i < len(stars); {
 		// Get all translations for this reason
-		en := getReasonText(stars[i].ReasonID, stars[i].ReasonText, "en")
-		cn := getReasonText(stars[i].ReasonID, stars[i].ReasonText, "zh-CN")
-		tw := getReasonText(stars[i].ReasonID, stars[i].ReasonText, "zh-TW")
+		reasonTexts := getReasonTexts(stars[i].ReasonID, stars[i].ReasonText)
+		en := reasonTexts[defaultLanguage]
 
 		// Find consecutive identical awards
 		j := i + 1
@@ -82,7 +79,7 @@ func handleDashboard(w http.ResponseWriter, r *http.Request) {
 		}
 		count := j - i
 
-		display := en
+		display := reasonTexts[lang]
 		starCount := stars[i].Stars
 		if count > 1 {
 			// Sum up stars for consolidated entries
@@ -91,101 +88,223 @@ func handleDashboard(w http.ResponseWriter, r *http.Request) {
 				totalStars += stars[k].Stars
 			}
 			starCount = totalStars
-			display = fmt.Sprintf("%d × %s", count, en)
+			display = fmt.Sprintf("%d × %s", count, display)
 		}
 
 		ds := DisplayStar{
-			ID:              stars[i].ID,
-			Username:        stars[i].Username,
-			UsernameEN:      stars[i].UsernameEN,
-			UsernameCN:      stars[i].UsernameCN,
-			UsernameTW:      stars[i].UsernameTW,
-			Display:         display,
-			Stars:           starCount,
-			ReasonEN:        en,
-			ReasonCN:        cn,
-			ReasonTW:        tw,
-			AwardedByName:   stars[i].AwardedByName,
-			AwardedByNameEN: stars[i].AwardedByNameEN,
-			AwardedByNameCN: stars[i].AwardedByNameCN,
-			AwardedByNameTW: stars[i].AwardedByNameTW,
-			CreatedAt:       stars[i].CreatedAt,
+			ID:                    stars[i].ID,
+			Username:              stars[i].Username,
+			UsernameTranslations:  stars[i].UsernameTranslations,
+			Display:               display,
+			Stars:                 starCount,
+			ReasonTranslations:    reasonTexts,
+			AwardedByName:         stars[i].AwardedByName,
+			AwardedByTranslations: stars[i].AwardedByTranslations,
+			CreatedAt:             stars[i].CreatedAt,
 		}
 		consolidated = append(consolidated, ds)
 		i = j
 	}
 
-	data := map[string]interface{}{
-		"User":             user,
-		"StarCounts":       counts,
-		"Stars":            consolidated,
-		"Rewards":          rewards,
-		"Redemptions":      redemptions,
-		"Reasons":          reasons,
-		"HAEnabled":        getSetting("ha_enabled"),
-		"UserReasonCounts": template.JS(userReasonCountsJSON),
+	// Kids see their own chores and requests; admins see every assignment plus the approval queues
+	var chores []ChoreStatus
+	var pendingChores []ChoreCompletion
+	var redemptionRequests []RedemptionRequest
+	if user.IsAdmin {
+		chores, _ = getChoreStatuses(familyID, 0, time.Now())
+		pendingChores, _ = getPendingChoreCompletions(familyID)
+		redemptionRequests, _ = getPendingRedemptionRequests(familyID, 0)
+	} else {
+		chores, _ = getChoreStatuses(familyID, user.ID, time.Now())
+		redemptionRequests, _ = getPendingRedemptionRequests(familyID, user.ID)
 	}
-	templates["dashboard.html"].ExecuteTemplate(w, "dashboard.html", data)
+
+	data := map[string]interface{}{
+		"User":                user,
+		"StarCounts":          counts,
+		"Stars":               consolidated,
+		"Rewards":             rewards,
+		"Redemptions":         redemptions,
+		"Reasons":             reasons,
+		"Currencies":          currencies,
+		"Chores":              chores,
+		"PendingChores":       pendingChores,
+		"RedemptionRequests":  redemptionRequests,
+		"HAEnabled":           getFamilySetting(familyID, "ha_enabled"),
+		"FailedAnnouncements": failedAnnouncements(familyID),
+		"UserReasonCounts":    template.JS(userReasonCountsJSON),
+		"Family":              familyName(familyID),
+	}
+	renderPage(w, r, "dashboard.html", data)
 }
 
 func handleLoginPage(w http.ResponseWriter, r *http.Request) {
-	templates["login.html"].ExecuteTemplate(w, "login.html", nil)
+	renderPage(w, r, "login.html", loginPageData(r, r.URL.Query().Get("kid")))
+}
+
+// loginPageData lists the kid tiles for the login page, if kid login is on
+// for this device, with kid's PIN pad open if it is one of them.
+func loginPageData(r *http.Request, kid string) map[string]interface{} {
+	tiles := kidLoginTiles(kidLoginFamily(r))
+	data := map[string]interface{}{"Kids": tiles}
+	for _, tile := range tiles {
+		if tile.Username == kid {
+			data["Kid"] = tile
+			data["PadKeys"] = pinPadKeys(tile.Mode)
+		}
+	}
+	return data
+}
+
+// renderLoginBlocked answers a login attempt that has to wait until until,
+// showing page with the error.
+func renderLoginBlocked(w http.ResponseWriter, r *http.Request, page string, data map[string]interface{}, now, until time.Time, locked bool) {
+	wait := until.Sub(now)
+	msg := localize(r, "login_throttled", "seconds", strconv.Itoa(int(wait.Seconds())+1))
+	if locked {
+		msg = localize(r, "login_locked", "minutes", strconv.Itoa(int(wait.Minutes())+1))
+	}
+	data["Error"] = msg
+	w.Header().Set("Retry-After", strconv.Itoa(int(wait.Seconds())+1))
+	w.WriteHeader(http.StatusTooManyRequests)
+	renderPage(w, r, page, data)
 }
 
 func handleLogin(w http.ResponseWriter, r *http.Request) {
 	username := r.FormValue("username")
 	password := r.FormValue("password")
+	ip := clientIP(r)
+	data := loginPageData(r, "")
+
+	defer lockLogin("user:"+username, "ip:"+ip)()
+	now := time.Now()
+	// A blocked login isn't checked at all, so guessing right doesn't help
+	if until, locked := loginBlocked("user", username, ip, now); !until.IsZero() {
+		renderLoginBlocked(w, r, "login.html", data, now, until, locked)
+		return
+	}
 
 	user, err := getUserByUsername(username)
 	if err != nil {
-		templates["login.html"].ExecuteTemplate(w, "login.html", map[string]string{"Error": "Invalid credentials"})
+		recordLoginFailure("user", username, nil, ip, now)
+		data["Error"] = localize(r, "invalid_credentials")
+		renderPage(w, r, "login.html", data)
 		return
 	}
 
 	if bcrypt.CompareHashAndPassword([]byte(user.PasswordHash), []byte(password)) != nil {
-		templates["login.html"].ExecuteTemplate(w, "login.html", map[string]string{"Error": "Invalid credentials"})
+		recordLoginFailure("user", username, user, ip, now)
+		data["Error"] = localize(r, "invalid_credentials")
+		renderPage(w, r, "login.html", data)
+		return
+	}
+	clearLoginThrottle("user", username)
+	if twoFactorEnabled(user) {
+		token, err := newLoginChallenge(user, now)
+		if err != nil {
+			http.Error(w, localize(r, "failed_create_session"), http.StatusInternalServerError)
+			return
+		}
+		renderPage(w, r, "login.html", map[string]interface{}{"Challenge": token})
+		return
+	}
+	startSession(w, r, user, ip)
+}
+
+// handleTwoFactorLogin is the second step of a parent's login when they have
+// two-factor login on: a code from their authenticator app or a recovery code.
+// Wrong codes are counted apart from wrong passwords.
+func handleTwoFactorLogin(w http.ResponseWriter, r *http.Request) {
+	token := r.FormValue("challenge")
+	code := r.FormValue("code")
+	ip := clientIP(r)
+
+	challenge, ok := loginChallengeFor(token, time.Now())
+	var user *User
+	if ok {
+		user, _ = getUserByID(challenge.UserID)
+	}
+	if user == nil {
+		endLoginChallenge(token)
+		renderPage(w, r, "login.html", map[string]interface{}{"Error": localize(r, "login_expired")})
+		return
+	}
+
+	defer lockLogin("totp:"+user.Username, "ip:"+ip)()
+	now := time.Now()
+	// A parallel attempt may have used the challenge up meanwhile
+	if _, ok := loginChallengeFor(token, now); !ok {
+		renderPage(w, r, "login.html", map[string]interface{}{"Error": localize(r, "login_expired")})
+		return
+	}
+	data := map[string]interface{}{"Challenge": token}
+	if until, locked := loginBlocked("totp", user.Username, ip, now); !until.IsZero() {
+		renderLoginBlocked(w, r, "login.html", data, now, until, locked)
+		return
+	}
+	if !checkTwoFactorCode(user, code, now) {
+		recordLoginFailure("totp", user.Username, user, ip, now)
+		data["Error"] = localize(r, "invalid_two_factor_code")
+		renderPage(w, r, "login.html", data)
+		return
+	}
+	endLoginChallenge(token)
+	clearLoginThrottle("totp", user.Username)
+	startSession(w, r, user, ip)
+}
+
+// handlePINLogin logs a kid in with the PIN or picture sequence a parent set
+// up for them. PINs have their own, stricter, failure counts.
+func handlePINLogin(w http.ResponseWriter, r *http.Request) {
+	username := r.FormValue("username")
+	pin := r.FormValue("pin")
+	ip := clientIP(r)
+	data := loginPageData(r, username)
+
+	defer lockLogin("pin:"+username, "ip:"+ip)()
+	now := time.Now()
+	if until, locked := loginBlocked("pin", username, ip, now); !until.IsZero() {
+		renderLoginBlocked(w, r, "login.html", data, now, until, locked)
+		return
+	}
+
+	// Only kids with a tile on this device can log in with a PIN
+	user, err := getUserByUsername(username)
+	if err != nil || user.FamilyID != kidLoginFamily(r) {
+		user = nil
+	}
+	if user == nil || !checkLoginPIN(user, pin) {
+		recordLoginFailure("pin", username, user, ip, now)
+		data["Error"] = localize(r, "invalid_pin")
+		renderPage(w, r, "login.html", data)
 		return
 	}
+	clearLoginThrottle("pin", username)
+	startSession(w, r, user, ip)
+}
 
+// startSession logs user in on this browser and sends them to the dashboard.
+func startSession(w http.ResponseWriter, r *http.Request, user *User, ip string) {
 	token, err := randomHex(32)
 	if err != nil {
-		http.Error(w, "failed to create session", http.StatusInternalServerError)
+		http.Error(w, localize(r, "failed_create_session"), http.StatusInternalServerError)
 		return
 	}
-	if err := createSession(token, user.ID); err != nil {
-		http.Error(w, "failed to create session", http.StatusInternalServerError)
+	if err := createSession(token, user.ID, user.FamilyID, r.UserAgent(), ip); err != nil {
+		http.Error(w, localize(r, "failed_create_session"), http.StatusInternalServerError)
 		return
 	}
 
-	const sessionMaxAgeSeconds = 365 * 24 * 60 * 60
-
-	http.SetCookie(w, &http.Cookie{
-		Name:     "session",
-		Value:    token,
-		Path:     "/",
-		HttpOnly: true,
-		SameSite: http.SameSiteLaxMode,
-		Secure:   sessionCookieSecure(r),
-		MaxAge:   sessionMaxAgeSeconds,
-		Expires:  time.Now().Add(30 * 24 * time.Hour),
-	})
+	setSessionCookie(w, r, token)
 	http.Redirect(w, r, "/", http.StatusSeeOther)
 }
 
 func handleLogout(w http.ResponseWriter, r *http.Request) {
-	cookie, err := r.Cookie("session")
-	if err == nil {
-		deleteSession(cookie.Value)
+	if token := sessionToken(r); token != "" {
+		deleteSession(token)
+		closeRevokedStreams()
 	}
-	http.SetCookie(w, &http.Cookie{
-		Name:     "session",
-		Value:    "",
-		Path:     "/",
-		HttpOnly: true,
-		SameSite: http.SameSiteLaxMode,
-		Secure:   sessionCookieSecure(r),
-		MaxAge:   -1,
-	})
+	setSessionCookie(w, r, "")
 	http.Redirect(w, r, "/login", http.StatusSeeOther)
 }
 
@@ -197,12 +316,21 @@ func handleQuickStar(w http.ResponseWriter, r *http.Request) {
 	starsStr := r.FormValue("stars")
 
 	if username == "" || (reasonIDStr == "" && reasonText == "") {
-		http.Error(w, "username and reason required", http.StatusBadRequest)
+		http.Error(w, localize(r, "username_reason_required"), http.StatusBadRequest)
 		return
 	}
 
 	if username == user.Username {
-		http.Error(w, "cannot award stars to yourself", http.StatusBadRequest)
+		http.Error(w, localize(r, "cannot_award_self"), http.StatusBadRequest)
+		return
+	}
+	if _, err := getFamilyUser(r, username); err != nil {
+		http.Error(w, localize(r, "user_not_found_named", "username", username), http.StatusBadRequest)
+		return
+	}
+	currencyID, err := formCurrencyID(r)
+	if err != nil {
+		http.Error(w, localize(r, "currency_not_found"), http.StatusBadRequest)
 		return
 	}
 
@@ -221,99 +349,339 @@ func handleQuickStar(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
-	starID, err := addStarWithID(username, reasonID, reasonText, stars, user.ID)
+	starID, err := addStarWithID(username, reasonID, reasonText, currencyID, stars, user.ID)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
 
-	// Resolve actual star count for announcement
+	// Resolve actual star count and currency for announcement
 	actualStars := stars
-	if actualStars == 0 {
-		actualStars = 1
+	if star, err := getStarByID(int(starID)); err == nil {
+		actualStars, currencyID = star.Stars, star.CurrencyID
 	}
-	announceStarIfEnabled(username, reasonID, reasonText, actualStars)
+	auditStarAward(r, username, starID)
+	publishStarAwarded(starID)
+	announceStarIfEnabled(username, reasonID, reasonText, actualStars, currencyID)
+	checkGoalReached(username)
 
 	if r.Header.Get("Accept") == "application/json" {
-		counts, _ := getUserStarCounts()
+		counts, _ := getUserStarCounts(getContextFamilyID(r))
 		jsonResponse(w, map[string]interface{}{
 			"counts":    counts,
 			"awardedBy": user.Username,
 			"starId":    starID,
+			"icon":      currencyIcon(currencyID),
 		})
 		return
 	}
 	http.Redirect(w, r, "/", http.StatusSeeOther)
 }
 
+// notEnoughBalance explains that name can't afford reward.
+func notEnoughBalance(r *http.Request, name string, reward *Reward, available int) string {
+	return localize(r, "not_enough_balance",
+		"name", name,
+		"currency", getCurrencyText(reward.CurrencyID, requestLanguage(r)),
+		"has", strconv.Itoa(available),
+		"needs", strconv.Itoa(reward.Cost))
+}
+
 func handleRedeem(w http.ResponseWriter, r *http.Request) {
 	rewardIDStr := r.FormValue("reward_id")
 	username := r.FormValue("username")
 
 	rewardID, err := strconv.Atoi(rewardIDStr)
 	if err != nil || username == "" {
-		http.Error(w, "invalid request", http.StatusBadRequest)
+		http.Error(w, localize(r, "invalid_request"), http.StatusBadRequest)
 		return
 	}
 
-	user, err := getUserByUsername(username)
+	user, err := getFamilyUser(r, username)
 	if err != nil {
-		http.Error(w, "user not found", http.StatusBadRequest)
+		http.Error(w, localize(r, "user_not_found"), http.StatusBadRequest)
 		return
 	}
 
-	reward, err := getRewardByID(rewardID)
+	reward, err := getFamilyReward(r, rewardID)
 	if err != nil {
-		http.Error(w, "reward not found", http.StatusBadRequest)
+		http.Error(w, localize(r, "reward_not_found"), http.StatusBadRequest)
 		return
 	}
 
-	current, err := getUserCurrentStars(user.ID)
-	if err != nil || current < reward.Cost {
-		http.Error(w, fmt.Sprintf("%s doesn't have enough stars (has %d, needs %d)", username, current, reward.Cost), http.StatusBadRequest)
+	redemptionID, err := redeemReward(user.ID, reward.ID, getContextUser(r).ID)
+	var notEnough *notEnoughError
+	if errors.As(err, &notEnough) {
+		http.Error(w, notEnoughBalance(r, username, reward, notEnough.Available), http.StatusBadRequest)
 		return
 	}
-
-	redeemReward(user.ID, reward.ID)
+	if err != nil {
+		http.Error(w, localize(r, "failed_redeem"), http.StatusInternalServerError)
+		return
+	}
+	recordAudit(r, "redemption.create", "user", user.ID, user.Username, nil, map[string]interface{}{
+		"redemption_id": redemptionID,
+		"reward":        reward.Key,
+		"cost":          reward.Cost,
+	})
+	clearGoalIfRedeemed(user.ID, reward.ID)
+	publishRewardRedeemed(redemptionID)
 	announceRedemptionIfEnabled(username, reward.ID, user.IsAdmin)
 
 	if r.Header.Get("Accept") == "application/json" {
-		counts, _ := getUserStarCounts()
+		counts, _ := getUserStarCounts(getContextFamilyID(r))
 		jsonResponse(w, map[string]interface{}{
-			"counts":     counts,
-			"rewardName": reward.Name,
-			"cost":       reward.Cost,
+			"counts":       counts,
+			"rewardName":   reward.Name,
+			"cost":         reward.Cost,
+			"icon":         currencyIcon(reward.CurrencyID),
+			"redemptionId": redemptionID,
 		})
 		return
 	}
 	http.Redirect(w, r, "/", http.StatusSeeOther)
 }
 
+func handleRequestRedemption(w http.ResponseWriter, r *http.Request) {
+	user := getContextUser(r)
+	rewardID, err := strconv.Atoi(r.FormValue("reward_id"))
+	if err != nil {
+		http.Error(w, localize(r, "invalid_request"), http.StatusBadRequest)
+		return
+	}
+
+	reward, err := getFamilyReward(r, rewardID)
+	if err != nil {
+		http.Error(w, localize(r, "reward_not_found"), http.StatusBadRequest)
+		return
+	}
+	if reward.ForAdults && !user.IsAdmin {
+		http.Error(w, localize(r, "reward_adults_only"), http.StatusForbidden)
+		return
+	}
+
+	requestID, err := addRedemptionRequest(user.ID, reward.ID, reward.Cost)
+	var notEnough *notEnoughError
+	if errors.As(err, &notEnough) {
+		http.Error(w, notEnoughBalance(r, user.Username, reward, notEnough.Available), http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		http.Error(w, localize(r, "failed_create_request"), http.StatusInternalServerError)
+		return
+	}
+
+	counts, _ := getUserStarCounts(getContextFamilyID(r))
+	jsonResponse(w, map[string]interface{}{
+		"counts":    counts,
+		"requestId": requestID,
+	})
+}
+
+func handleCancelRedemptionRequest(w http.ResponseWriter, r *http.Request) {
+	user := getContextUser(r)
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		http.Error(w, localize(r, "invalid_id"), http.StatusBadRequest)
+		return
+	}
+
+	req, err := getRedemptionRequestByID(id)
+	if err != nil {
+		http.Error(w, localize(r, "request_not_found"), http.StatusNotFound)
+		return
+	}
+	if req.UserID != user.ID {
+		http.Error(w, localize(r, "cannot_cancel_others_request"), http.StatusForbidden)
+		return
+	}
+	if err := decideRedemptionRequest(req.ID, "cancelled", 0, 0); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	counts, _ := getUserStarCounts(getContextFamilyID(r))
+	jsonResponse(w, counts)
+}
+
+func handleReviewRedemptionRequest(approve bool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		user := getContextUser(r)
+		id, err := strconv.Atoi(r.PathValue("id"))
+		if err != nil {
+			http.Error(w, localize(r, "invalid_id"), http.StatusBadRequest)
+			return
+		}
+
+		req, err := getRedemptionRequestByID(id)
+		if err != nil || !userInFamily(r, req.UserID) {
+			http.Error(w, localize(r, "request_not_found"), http.StatusNotFound)
+			return
+		}
+		if req.UserID == user.ID {
+			http.Error(w, localize(r, "cannot_review_own_request"), http.StatusForbidden)
+			return
+		}
+		if req.Status != "pending" {
+			http.Error(w, localize(r, "request_already", "status", req.Status), http.StatusBadRequest)
+			return
+		}
+
+		if !approve {
+			if err := decideRedemptionRequest(req.ID, "rejected", 0, user.ID); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			recordAudit(r, "redemption_request.reject", "redemption_request", req.ID, req.Username,
+				map[string]interface{}{"status": "pending", "cost": req.Cost}, map[string]interface{}{"status": "rejected"})
+			counts, _ := getUserStarCounts(getContextFamilyID(r))
+			jsonResponse(w, counts)
+			return
+		}
+
+		kid, err := getUserByID(req.UserID)
+		if err != nil {
+			http.Error(w, localize(r, "user_not_found"), http.StatusBadRequest)
+			return
+		}
+		reward, err := getRewardByID(req.RewardID)
+		if err != nil {
+			http.Error(w, localize(r, "reward_not_found"), http.StatusBadRequest)
+			return
+		}
+
+		redemptionID, err := approveRedemptionRequest(req.ID, user.ID)
+		var notEnough *notEnoughError
+		if errors.As(err, &notEnough) {
+			http.Error(w, notEnoughBalance(r, kid.Username, reward, notEnough.Available), http.StatusBadRequest)
+			return
+		}
+		if errors.Is(err, errRequestDecided) {
+			status := req.Status
+			if current, err := getRedemptionRequestByID(req.ID); err == nil {
+				status = current.Status
+			}
+			http.Error(w, localize(r, "request_already", "status", status), http.StatusBadRequest)
+			return
+		}
+		if err != nil {
+			http.Error(w, localize(r, "failed_redeem"), http.StatusInternalServerError)
+			return
+		}
+		recordAudit(r, "redemption_request.approve", "redemption_request", req.ID, kid.Username,
+			map[string]interface{}{"status": "pending", "cost": req.Cost},
+			map[string]interface{}{"status": "approved", "redemption_id": redemptionID, "reward": reward.Key, "cost": reward.Cost})
+		clearGoalIfRedeemed(kid.ID, reward.ID)
+		publishRewardRedeemed(redemptionID)
+		announceRedemptionIfEnabled(kid.Username, reward.ID, kid.IsAdmin)
+
+		counts, _ := getUserStarCounts(getContextFamilyID(r))
+		jsonResponse(w, counts)
+	}
+}
+
+// goalTarget resolves whose savings goal a request refers to. Kids can only
+// manage their own goal; admins may pass a username to manage a kid's goal.
+func goalTarget(r *http.Request) (*User, error) {
+	user := getContextUser(r)
+	username := r.FormValue("username")
+	if username == "" || username == user.Username {
+		return user, nil
+	}
+	if !user.IsAdmin {
+		return nil, errors.New(localize(r, "cannot_change_others_goal"))
+	}
+	target, err := getFamilyUser(r, username)
+	if err != nil {
+		return nil, errors.New(localize(r, "user_not_found"))
+	}
+	return target, nil
+}
+
+func handleSetGoal(w http.ResponseWriter, r *http.Request) {
+	target, err := goalTarget(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	rewardID, err := strconv.Atoi(r.FormValue("reward_id"))
+	if err != nil {
+		http.Error(w, localize(r, "invalid_request"), http.StatusBadRequest)
+		return
+	}
+	reward, err := getFamilyReward(r, rewardID)
+	if err != nil {
+		http.Error(w, localize(r, "reward_not_found"), http.StatusBadRequest)
+		return
+	}
+	if reward.ForAdults && !target.IsAdmin {
+		http.Error(w, localize(r, "reward_adults_only"), http.StatusForbidden)
+		return
+	}
+
+	if err := setSavingsGoal(target.ID, reward.ID); err != nil {
+		http.Error(w, localize(r, "failed_set_goal"), http.StatusInternalServerError)
+		return
+	}
+	checkGoalReached(target.Username)
+
+	counts, _ := getUserStarCounts(getContextFamilyID(r))
+	jsonResponse(w, counts)
+}
+
+func handleClearGoal(w http.ResponseWriter, r *http.Request) {
+	target, err := goalTarget(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	clearSavingsGoal(target.ID)
+	counts, _ := getUserStarCounts(getContextFamilyID(r))
+	jsonResponse(w, counts)
+}
+
 func handleUpdateReasonTranslation(w http.ResponseWriter, r *http.Request) {
 	idStr := r.PathValue("id")
 	id, err := strconv.Atoi(idStr)
 	if err != nil {
-		http.Error(w, "invalid id", http.StatusBadRequest)
+		http.Error(w, localize(r, "invalid_id"), http.StatusBadRequest)
 		return
 	}
 	lang := r.FormValue("lang")
+	if lang != "" && !validLanguage(lang) {
+		http.Error(w, localize(r, "unsupported_language"), http.StatusBadRequest)
+		return
+	}
 	text := r.FormValue("text")
 	starsStr := r.FormValue("stars")
 
+	reason, err := getReasonByID(id)
+	if err != nil || reason.FamilyID != getContextFamilyID(r) {
+		http.Error(w, localize(r, "reason_not_found"), http.StatusNotFound)
+		return
+	}
+	before := reasonSnapshot(reason)
+
 	if lang != "" && text != "" {
 		updateReasonTranslation(id, lang, text)
 	}
 
+	retroactive := false
 	if starsStr != "" {
 		stars, err := strconv.Atoi(starsStr)
 		if err != nil {
-			http.Error(w, "invalid stars value", http.StatusBadRequest)
+			http.Error(w, localize(r, "invalid_stars"), http.StatusBadRequest)
 			return
 		}
-		retroactive := r.FormValue("retroactive") != "0"
-		updateReasonStars(id, stars, retroactive)
+		retroactive = r.FormValue("retroactive") != "0"
+		updateReasonStars(id, stars, retroactive, getContextUser(r).ID)
 	}
 
+	if updated, err := getReasonByID(id); err == nil {
+		after := reasonSnapshot(updated)
+		after["retroactive"] = retroactive
+		recordAudit(r, "reason.update", "reason", id, updated.Key, before, after)
+	}
 	jsonResponse(w, map[string]string{"status": "ok"})
 }
 
@@ -321,10 +689,19 @@ func handleDeleteReason(w http.ResponseWriter, r *http.Request) {
 	idStr := r.PathValue("id")
 	id, err := strconv.Atoi(idStr)
 	if err != nil {
-		http.Error(w, "invalid id", http.StatusBadRequest)
+		http.Error(w, localize(r, "invalid_id"), http.StatusBadRequest)
+		return
+	}
+	reason, err := getReasonByID(id)
+	if err != nil || reason.FamilyID != getContextFamilyID(r) {
+		http.Error(w, localize(r, "reason_not_found"), http.StatusNotFound)
+		return
+	}
+	if err := deleteReason(id); err != nil {
+		http.Error(w, localize(r, "failed_delete_reason")+": "+err.Error(), http.StatusBadRequest)
 		return
 	}
-	deleteReason(id)
+	recordAudit(r, "reason.delete", "reason", id, reason.Key, reasonSnapshot(reason), nil)
 	jsonResponse(w, map[string]string{"status": "ok"})
 }
 
@@ -332,395 +709,1763 @@ func handleUpdateUserTranslation(w http.ResponseWriter, r *http.Request) {
 	idStr := r.PathValue("id")
 	id, err := strconv.Atoi(idStr)
 	if err != nil {
-		http.Error(w, "invalid id", http.StatusBadRequest)
+		http.Error(w, localize(r, "invalid_id"), http.StatusBadRequest)
 		return
 	}
 	lang := r.FormValue("lang")
+	if lang != "" && !validLanguage(lang) {
+		http.Error(w, localize(r, "unsupported_language"), http.StatusBadRequest)
+		return
+	}
 	text := r.FormValue("text")
 
 	if lang == "" || text == "" {
-		http.Error(w, "lang and text required", http.StatusBadRequest)
+		http.Error(w, localize(r, "lang_text_required"), http.StatusBadRequest)
 		return
 	}
 
+	target, err := getUserByID(id)
+	if err != nil || target.FamilyID != getContextFamilyID(r) {
+		http.Error(w, localize(r, "user_not_found"), http.StatusNotFound)
+		return
+	}
 	updateUserTranslation(id, lang, text)
+	recordAudit(r, "user.update", "user", id, target.Username,
+		map[string]interface{}{lang: target.Translations[lang]}, map[string]interface{}{lang: text})
 	jsonResponse(w, map[string]string{"status": "ok"})
 }
 
-func handleDeleteStar(w http.ResponseWriter, r *http.Request) {
-	user := getContextUser(r)
-	idStr := r.PathValue("id")
-	id, err := strconv.Atoi(idStr)
+// handleUpdateUserLanguage sets the language a member sees the app and hears
+// announcements about them in; "" follows their browser and the family setting.
+func handleUpdateUserLanguage(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(r.PathValue("id"))
 	if err != nil {
-		http.Error(w, "invalid id", http.StatusBadRequest)
+		http.Error(w, localize(r, "invalid_id"), http.StatusBadRequest)
 		return
 	}
-
-	// Check if the star belongs to the current user
-	star, err := getStarByID(id)
-	if err != nil {
-		http.Error(w, "star not found", http.StatusNotFound)
+	lang := r.FormValue("lang")
+	if lang != "" && !validLanguage(lang) {
+		http.Error(w, localize(r, "unsupported_language"), http.StatusBadRequest)
 		return
 	}
-	if star.UserID == user.ID {
-		http.Error(w, "cannot delete your own stars", http.StatusForbidden)
+	target, err := getUserByID(id)
+	if err != nil || target.FamilyID != getContextFamilyID(r) {
+		http.Error(w, localize(r, "user_not_found"), http.StatusNotFound)
 		return
 	}
+	updateUserLanguage(id, lang)
+	recordAudit(r, "user.update", "user", id, target.Username,
+		map[string]interface{}{"lang": target.Lang}, map[string]interface{}{"lang": lang})
+	jsonResponse(w, map[string]string{"status": "ok"})
+}
 
-	deleteStar(id)
-	counts, _ := getUserStarCounts()
-	jsonResponse(w, counts)
+// userAnnounceSnapshot is a user's announcement settings, for the audit log.
+func userAnnounceSnapshot(userID int) map[string]interface{} {
+	snapshot := make(map[string]interface{})
+	for _, key := range userAnnouncementSettingKeys() {
+		snapshot[key] = getUserSetting(userID, key)
+	}
+	return snapshot
 }
 
-func handleDeleteRedemption(w http.ResponseWriter, r *http.Request) {
-	user := getContextUser(r)
-	idStr := r.PathValue("id")
-	id, err := strconv.Atoi(idStr)
+// handleUpdateUserAnnounce saves which events are announced about a user and
+// where: "announce" lists the events to announce, "target_events" the ones
+// sent to the user's own targets, given per backend as announce_<kind>_target.
+func handleUpdateUserAnnounce(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(r.PathValue("id"))
 	if err != nil {
-		http.Error(w, "invalid id", http.StatusBadRequest)
+		http.Error(w, localize(r, "invalid_id"), http.StatusBadRequest)
+		return
+	}
+	target, err := getUserByID(id)
+	if err != nil || target.FamilyID != getContextFamilyID(r) {
+		http.Error(w, localize(r, "user_not_found"), http.StatusNotFound)
 		return
 	}
+	r.ParseForm()
+	var muted, targetEvents []string
+	for _, e := range announceEvents {
+		if !containsString(r.Form["announce"], e) {
+			muted = append(muted, e)
+		}
+		if containsString(r.Form["target_events"], e) {
+			targetEvents = append(targetEvents, e)
+		}
+	}
+	before := userAnnounceSnapshot(id)
+	setUserSetting(id, "announce_muted", strings.Join(muted, ","))
+	setUserSetting(id, "announce_target_events", strings.Join(targetEvents, ","))
+	for _, b := range announcerBackends {
+		if b.Target != "" {
+			key := announcerSettingKey(b.Kind, "target")
+			setUserSetting(id, key, strings.TrimSpace(r.FormValue(key)))
+		}
+	}
+	recordAudit(r, "user.update", "user", id, target.Username, before, userAnnounceSnapshot(id))
+	http.Redirect(w, r, "/admin", http.StatusSeeOther)
+}
 
-	// Check if the redemption belongs to the current user
-	redemption, err := getRedemptionByID(id)
+// handleUnlockUser forgets a user's failed password, PIN and two-factor
+// logins, ending any lockout.
+func handleUnlockUser(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(r.PathValue("id"))
 	if err != nil {
-		http.Error(w, "redemption not found", http.StatusNotFound)
+		http.Error(w, localize(r, "invalid_id"), http.StatusBadRequest)
 		return
 	}
-	if redemption.UserID == user.ID {
-		http.Error(w, "cannot delete your own redemptions", http.StatusForbidden)
+	target, err := getUserByID(id)
+	if err != nil || target.FamilyID != getContextFamilyID(r) {
+		http.Error(w, localize(r, "user_not_found"), http.StatusNotFound)
 		return
 	}
-
-	deleteRedemption(id)
-	counts, _ := getUserStarCounts()
-	jsonResponse(w, counts)
-}
-
-func handleAccountPage(w http.ResponseWriter, r *http.Request) {
-	user := getContextUser(r)
-	templates["account.html"].ExecuteTemplate(w, "account.html", map[string]interface{}{"User": user})
+	for _, scope := range []string{"user", "pin", "totp"} {
+		before, _ := getLoginThrottle(scope, target.Username)
+		if before.Failures == 0 {
+			continue
+		}
+		if err := clearLoginThrottle(scope, target.Username); err != nil {
+			http.Error(w, localize(r, "failed_unlock"), http.StatusInternalServerError)
+			return
+		}
+		recordAudit(r, scope+".unlock", "user", id, target.Username, lockoutSnapshot(before, ""), nil)
+	}
+	http.Redirect(w, r, "/admin", http.StatusSeeOther)
 }
 
-func handleAccountPasswordChange(w http.ResponseWriter, r *http.Request) {
-	user := getContextUser(r)
-	current := r.FormValue("current")
-	newPw := r.FormValue("new")
-	confirm := r.FormValue("confirm")
-
-	data := map[string]interface{}{"User": user}
-
-	if bcrypt.CompareHashAndPassword([]byte(user.PasswordHash), []byte(current)) != nil {
-		data["Error"] = "Current password is incorrect"
-		templates["account.html"].ExecuteTemplate(w, "account.html", data)
+// handleResetTwoFactor turns off another parent's two-factor login, for when
+// they have lost both their authenticator and recovery codes.
+func handleResetTwoFactor(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		http.Error(w, localize(r, "invalid_id"), http.StatusBadRequest)
 		return
 	}
-
-	if len(newPw) < 6 {
-		data["Error"] = "New password must be at least 6 characters"
-		templates["account.html"].ExecuteTemplate(w, "account.html", data)
+	target, err := getUserByID(id)
+	if err != nil || target.FamilyID != getContextFamilyID(r) {
+		http.Error(w, localize(r, "user_not_found"), http.StatusNotFound)
 		return
 	}
-
-	if newPw != confirm {
-		data["Error"] = "New passwords do not match"
-		templates["account.html"].ExecuteTemplate(w, "account.html", data)
+	if target.ID == getContextUser(r).ID {
+		http.Error(w, localize(r, "two_factor_reset_self"), http.StatusBadRequest)
 		return
 	}
-
-	hash, err := bcrypt.GenerateFromPassword([]byte(newPw), bcrypt.DefaultCost)
-	if err != nil {
-		data["Error"] = "Failed to update password"
-		templates["account.html"].ExecuteTemplate(w, "account.html", data)
+	before := twoFactorSnapshot(target)
+	if err := disableTwoFactor(id); err != nil {
+		http.Error(w, localize(r, "failed_save_two_factor"), http.StatusInternalServerError)
 		return
 	}
+	recordAudit(r, "user.update", "user", id, target.Username, before, twoFactorSnapshot(target))
+	http.Redirect(w, r, "/admin", http.StatusSeeOther)
+}
 
-	updatePassword(user.ID, string(hash))
-	data["Success"] = "Password updated successfully"
-	templates["account.html"].ExecuteTemplate(w, "account.html", data)
+// handleSaveTwoFactorPolicy sets whether all of the family's parents must use
+// two-factor login. Those without it are sent to set it up.
+func handleSaveTwoFactorPolicy(w http.ResponseWriter, r *http.Request) {
+	familyID := getContextFamilyID(r)
+	required := "0"
+	if r.FormValue("require_2fa") == "1" {
+		required = "1"
+	}
+	before := map[string]interface{}{"require_2fa": getFamilySetting(familyID, "require_2fa")}
+	if err := setFamilySetting(familyID, "require_2fa", required); err != nil {
+		http.Error(w, localize(r, "failed_save_two_factor"), http.StatusInternalServerError)
+		return
+	}
+	after := map[string]interface{}{"require_2fa": required}
+	recordAudit(r, "settings.update", "settings", 0, "require_2fa", before, after)
+	publishSettingsChanged(familyID, before, after)
+	http.Redirect(w, r, "/admin", http.StatusSeeOther)
 }
 
-func handlePasswordPage(w http.ResponseWriter, r *http.Request) {
+// handleUpdateKidLogin sets up how a kid logs in besides their password: with
+// a PIN, a picture sequence, or not at all. Leaving the PIN empty keeps the
+// current one.
+func handleUpdateKidLogin(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		http.Error(w, localize(r, "invalid_id"), http.StatusBadRequest)
+		return
+	}
+	target, err := getUserByID(id)
+	if err != nil || target.FamilyID != getContextFamilyID(r) {
+		http.Error(w, localize(r, "user_not_found"), http.StatusNotFound)
+		return
+	}
+	if target.IsAdmin {
+		http.Error(w, localize(r, "pin_only_kids"), http.StatusBadRequest)
+		return
+	}
+	mode := r.FormValue("login_mode")
+	pin := strings.TrimSpace(r.FormValue("pin"))
+	if mode != "" && mode != loginModePIN && mode != loginModePicture {
+		http.Error(w, localize(r, "invalid_login_mode"), http.StatusBadRequest)
+		return
+	}
+	if mode != "" && pin == "" && mode == loginMode(target) {
+		http.Redirect(w, r, "/admin", http.StatusSeeOther)
+		return
+	}
+	if mode != "" && !validLoginPIN(mode, pin) {
+		http.Error(w, localize(r, "invalid_login_pin"), http.StatusBadRequest)
+		return
+	}
+	before := kidLoginSnapshot(target)
+	if err := setLoginPIN(id, mode, pin); err != nil {
+		http.Error(w, localize(r, "failed_save_pin"), http.StatusInternalServerError)
+		return
+	}
+	clearLoginThrottle("pin", target.Username)
+	recordAudit(r, "user.update", "user", id, target.Username, before, kidLoginSnapshot(target))
+	http.Redirect(w, r, "/admin", http.StatusSeeOther)
+}
+
+// handleAddKidLoginDevice turns kid login on for this device: the login page
+// shows the family's kid tiles here from now on.
+func handleAddKidLoginDevice(w http.ResponseWriter, r *http.Request) {
+	familyID := getContextFamilyID(r)
+	token, err := randomHex(32)
+	if err != nil {
+		http.Error(w, localize(r, "failed_save_kid_login_device"), http.StatusInternalServerError)
+		return
+	}
+	before := map[string]interface{}{"devices": countKidLoginDevices(familyID)}
+	// Replaces whatever this device showed before
+	deleteKidLoginDevice(hashAPIKey(kidLoginDeviceToken(r)), familyID)
+	if err := addKidLoginDevice(hashAPIKey(token), familyID); err != nil {
+		http.Error(w, localize(r, "failed_save_kid_login_device"), http.StatusInternalServerError)
+		return
+	}
+	recordAudit(r, "settings.update", "settings", 0, "kid_login_devices", before, map[string]interface{}{"devices": countKidLoginDevices(familyID)})
+	setKidLoginCookie(w, r, token)
+	http.Redirect(w, r, "/admin", http.StatusSeeOther)
+}
+
+// handleRemoveKidLoginDevices turns kid login off for this device, or with
+// all=1 for every device of the family.
+func handleRemoveKidLoginDevices(w http.ResponseWriter, r *http.Request) {
+	familyID := getContextFamilyID(r)
+	before := map[string]interface{}{"devices": countKidLoginDevices(familyID)}
+	var err error
+	if r.FormValue("all") == "1" {
+		err = deleteKidLoginDevices(familyID)
+	} else {
+		err = deleteKidLoginDevice(hashAPIKey(kidLoginDeviceToken(r)), familyID)
+	}
+	if err != nil {
+		http.Error(w, localize(r, "failed_save_kid_login_device"), http.StatusInternalServerError)
+		return
+	}
+	recordAudit(r, "settings.update", "settings", 0, "kid_login_devices", before, map[string]interface{}{"devices": countKidLoginDevices(familyID)})
+	if kidLoginFamily(r) == 0 {
+		setKidLoginCookie(w, r, "")
+	}
+	http.Redirect(w, r, "/admin", http.StatusSeeOther)
+}
+
+// handleUnlockIP forgets a client IP's failed logins, ending any lockout.
+func handleUnlockIP(w http.ResponseWriter, r *http.Request) {
+	ip := r.FormValue("ip")
+	before, _ := getLoginThrottle("ip", ip)
+	if before.Failures == 0 {
+		http.Error(w, localize(r, "lockout_not_found"), http.StatusNotFound)
+		return
+	}
+	if err := clearLoginThrottle("ip", ip); err != nil {
+		http.Error(w, localize(r, "failed_unlock"), http.StatusInternalServerError)
+		return
+	}
+	recordAudit(r, "ip.unlock", "ip", 0, ip, lockoutSnapshot(before, ip), nil)
+	http.Redirect(w, r, "/admin", http.StatusSeeOther)
+}
+
+func handleDeleteStar(w http.ResponseWriter, r *http.Request) {
+	user := getContextUser(r)
+	idStr := r.PathValue("id")
+	id, err := strconv.Atoi(idStr)
+	if err != nil {
+		http.Error(w, localize(r, "invalid_id"), http.StatusBadRequest)
+		return
+	}
+
+	// Check if the star belongs to the current user
+	star, err := getStarByID(id)
+	if err != nil || !userInFamily(r, star.UserID) {
+		http.Error(w, localize(r, "star_not_found"), http.StatusNotFound)
+		return
+	}
+	if star.UserID == user.ID {
+		http.Error(w, localize(r, "cannot_delete_own_stars"), http.StatusForbidden)
+		return
+	}
+
+	// The star is voided and reversed in the ledger rather than deleted
+	if err := voidStar(id, user.ID); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if owner, err := getUserByID(star.UserID); err == nil {
+		recordAudit(r, "star.delete", "user", owner.ID, owner.Username, starSnapshot(star), nil)
+	}
+	publishStarDeleted(star, user.Username)
+	counts, _ := getUserStarCounts(getContextFamilyID(r))
+	jsonResponse(w, counts)
+}
+
+func handleDeleteRedemption(w http.ResponseWriter, r *http.Request) {
 	user := getContextUser(r)
-	templates["password.html"].ExecuteTemplate(w, "password.html", map[string]interface{}{"User": user})
+	idStr := r.PathValue("id")
+	id, err := strconv.Atoi(idStr)
+	if err != nil {
+		http.Error(w, localize(r, "invalid_id"), http.StatusBadRequest)
+		return
+	}
+
+	// Check if the redemption belongs to the current user
+	redemption, err := getRedemptionByID(id)
+	if err != nil || !userInFamily(r, redemption.UserID) {
+		http.Error(w, localize(r, "redemption_not_found"), http.StatusNotFound)
+		return
+	}
+	if redemption.UserID == user.ID {
+		http.Error(w, localize(r, "cannot_delete_own_redemptions"), http.StatusForbidden)
+		return
+	}
+
+	// The redemption is voided and refunded in the ledger rather than deleted
+	if err := voidRedemption(id, user.ID); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if owner, err := getUserByID(redemption.UserID); err == nil {
+		recordAudit(r, "redemption.delete", "user", owner.ID, owner.Username, map[string]interface{}{
+			"redemption_id": redemption.ID,
+			"reward":        redemption.RewardKey,
+			"cost":          redemption.Cost,
+		}, nil)
+	}
+	publishRedemptionDeleted(redemption, user.Username)
+	counts, _ := getUserStarCounts(getContextFamilyID(r))
+	jsonResponse(w, counts)
 }
 
-func handlePasswordChange(w http.ResponseWriter, r *http.Request) {
+func handleCompleteChore(w http.ResponseWriter, r *http.Request) {
+	user := getContextUser(r)
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		http.Error(w, localize(r, "invalid_id"), http.StatusBadRequest)
+		return
+	}
+
+	chore, err := getChoreByID(getContextFamilyID(r), id)
+	if err != nil {
+		http.Error(w, localize(r, "chore_not_found"), http.StatusNotFound)
+		return
+	}
+	assigned := false
+	for _, userID := range chore.UserIDs {
+		if userID == user.ID {
+			assigned = true
+			break
+		}
+	}
+	if !assigned {
+		http.Error(w, localize(r, "chore_not_assigned"), http.StatusForbidden)
+		return
+	}
+
+	period, active, _ := choreOccurrence(*chore, time.Now())
+	if !active {
+		http.Error(w, localize(r, "chore_not_today"), http.StatusBadRequest)
+		return
+	}
+
+	completionID, err := submitChoreCompletion(chore.ID, user.ID, period)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	status := "pending"
+	if chore.AutoApprove {
+		completion, err := getChoreCompletionByID(getContextFamilyID(r), int(completionID))
+		if err == nil {
+			_, err = approveChore(completion, 0)
+		}
+		if err != nil {
+			http.Error(w, localize(r, "failed_award_chore")+": "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		status = "approved"
+	}
+
+	counts, _ := getUserStarCounts(getContextFamilyID(r))
+	jsonResponse(w, map[string]interface{}{
+		"status": status,
+		"counts": counts,
+	})
+}
+
+func handleReviewChore(approve bool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		user := getContextUser(r)
+		id, err := strconv.Atoi(r.PathValue("id"))
+		if err != nil {
+			http.Error(w, localize(r, "invalid_id"), http.StatusBadRequest)
+			return
+		}
+
+		completion, err := getChoreCompletionByID(getContextFamilyID(r), id)
+		if err != nil {
+			http.Error(w, localize(r, "chore_completion_not_found"), http.StatusNotFound)
+			return
+		}
+		if completion.UserID == user.ID {
+			http.Error(w, localize(r, "cannot_review_own_chores"), http.StatusForbidden)
+			return
+		}
+
+		if approve {
+			starID, err := approveChore(completion, user.ID)
+			if errors.Is(err, errChoreCompletionReviewed) {
+				choreCompletionReviewed(w, r, completion)
+				return
+			}
+			if err != nil {
+				http.Error(w, localize(r, "failed_award_chore")+": "+err.Error(), http.StatusInternalServerError)
+				return
+			}
+			recordAudit(r, "chore_completion.approve", "chore_completion", completion.ID, completion.Username,
+				map[string]interface{}{"status": "pending", "period": completion.Period},
+				map[string]interface{}{"status": "approved", "star_id": starID})
+		} else {
+			err := rejectChoreCompletion(completion.ID, user.ID)
+			if errors.Is(err, errChoreCompletionReviewed) {
+				choreCompletionReviewed(w, r, completion)
+				return
+			}
+			if err != nil {
+				http.Error(w, localize(r, "failed_reject_chore"), http.StatusInternalServerError)
+				return
+			}
+			recordAudit(r, "chore_completion.reject", "chore_completion", completion.ID, completion.Username,
+				map[string]interface{}{"status": "pending", "period": completion.Period},
+				map[string]interface{}{"status": "rejected"})
+		}
+
+		counts, _ := getUserStarCounts(getContextFamilyID(r))
+		jsonResponse(w, counts)
+	}
+}
+
+// choreCompletionReviewed answers a review of a check-off someone else
+// already decided, naming what they decided.
+func choreCompletionReviewed(w http.ResponseWriter, r *http.Request, completion *ChoreCompletion) {
+	status := completion.Status
+	if current, err := getChoreCompletionByID(getContextFamilyID(r), completion.ID); err == nil {
+		status = current.Status
+	}
+	http.Error(w, localize(r, "chore_completion_already", "status", status), http.StatusBadRequest)
+}
+
+func handleAccountPage(w http.ResponseWriter, r *http.Request) {
+	renderPage(w, r, "account.html", accountPageData(r))
+}
+
+func accountPageData(r *http.Request) map[string]interface{} {
+	user := getContextUser(r)
+	return map[string]interface{}{
+		"User":              user,
+		"Sessions":          sessionViews(user.ID, sessionToken(r)),
+		"TwoFactor":         twoFactorEnabled(user),
+		"TwoFactorRequired": twoFactorRequired(user.FamilyID),
+		"RecoveryCodesLeft": recoveryCodesLeft(user.ID),
+	}
+}
+
+// handleTwoFactorSetup starts turning on two-factor login with a new secret,
+// shown as a QR code until a code from it is confirmed.
+func handleTwoFactorSetup(w http.ResponseWriter, r *http.Request) {
+	user := getContextUser(r)
+	if !user.IsAdmin {
+		http.Error(w, localize(r, "two_factor_only_parents"), http.StatusForbidden)
+		return
+	}
+	// A new secret would get around the current one: turn it off first
+	if twoFactorEnabled(user) {
+		data := accountPageData(r)
+		data["Error"] = localize(r, "two_factor_already_on")
+		renderPage(w, r, "account.html", data)
+		return
+	}
+	secret, err := newTOTPSecret()
+	if err != nil {
+		http.Error(w, localize(r, "failed_save_two_factor"), http.StatusInternalServerError)
+		return
+	}
+	if err := setUserSetting(user.ID, "totp_pending", secret); err != nil {
+		http.Error(w, localize(r, "failed_save_two_factor"), http.StatusInternalServerError)
+		return
+	}
+	renderPage(w, r, "account.html", twoFactorSetupData(r, secret))
+}
+
+// twoFactorSetupData is the account page showing secret to scan.
+func twoFactorSetupData(r *http.Request, secret string) map[string]interface{} {
+	data := accountPageData(r)
+	data["TOTPSecret"] = secret
+	data["TOTPQR"] = totpQRCode(totpURI(getContextUser(r).Username, secret))
+	return data
+}
+
+// handleTwoFactorEnable turns two-factor login on once a code from the new
+// secret checks out, and shows the recovery codes.
+func handleTwoFactorEnable(w http.ResponseWriter, r *http.Request) {
+	user := getContextUser(r)
+	secret := getUserSetting(user.ID, "totp_pending")
+	if !user.IsAdmin || secret == "" || twoFactorEnabled(user) {
+		http.Redirect(w, r, "/account", http.StatusSeeOther)
+		return
+	}
+	step := matchTOTP(secret, strings.TrimSpace(r.FormValue("code")), time.Now(), 0)
+	if step == 0 {
+		data := twoFactorSetupData(r, secret)
+		data["Error"] = localize(r, "invalid_two_factor_code")
+		renderPage(w, r, "account.html", data)
+		return
+	}
+	before := twoFactorSnapshot(user)
+	codes, err := enableTwoFactor(user.ID, secret, step)
+	if err != nil {
+		http.Error(w, localize(r, "failed_save_two_factor"), http.StatusInternalServerError)
+		return
+	}
+	recordAudit(r, "user.update", "user", user.ID, user.Username, before, twoFactorSnapshot(user))
+	data := accountPageData(r)
+	data["RecoveryCodes"] = codes
+	data["Success"] = localize(r, "two_factor_enabled")
+	renderPage(w, r, "account.html", data)
+}
+
+// checkAccountPassword checks the password entered on the account page,
+// counting wrong ones like wrong passwords at login. When it isn't right it
+// shows the account page with the error and returns false.
+func checkAccountPassword(w http.ResponseWriter, r *http.Request, user *User, data map[string]interface{}) bool {
+	ip := clientIP(r)
+	defer lockLogin("user:"+user.Username, "ip:"+ip)()
+	now := time.Now()
+	if until, locked := loginBlocked("user", user.Username, ip, now); !until.IsZero() {
+		renderLoginBlocked(w, r, "account.html", data, now, until, locked)
+		return false
+	}
+	if bcrypt.CompareHashAndPassword([]byte(user.PasswordHash), []byte(r.FormValue("password"))) != nil {
+		recordLoginFailure("user", user.Username, user, ip, now)
+		data["Error"] = localize(r, "password_incorrect")
+		renderPage(w, r, "account.html", data)
+		return false
+	}
+	clearLoginThrottle("user", user.Username)
+	return true
+}
+
+// checkAccountTwoFactorCode checks the code entered on the account page,
+// counting wrong ones like wrong codes at login. When it isn't right it shows
+// the account page with the error and returns false.
+func checkAccountTwoFactorCode(w http.ResponseWriter, r *http.Request, user *User, data map[string]interface{}) bool {
+	ip := clientIP(r)
+	defer lockLogin("totp:"+user.Username, "ip:"+ip)()
+	now := time.Now()
+	if until, locked := loginBlocked("totp", user.Username, ip, now); !until.IsZero() {
+		renderLoginBlocked(w, r, "account.html", data, now, until, locked)
+		return false
+	}
+	if !checkTwoFactorCode(user, r.FormValue("code"), now) {
+		recordLoginFailure("totp", user.Username, user, ip, now)
+		data["Error"] = localize(r, "invalid_two_factor_code")
+		renderPage(w, r, "account.html", data)
+		return false
+	}
+	clearLoginThrottle("totp", user.Username)
+	return true
+}
+
+// handleTwoFactorDisable turns two-factor login off, given the password and a
+// current code. Not allowed while the family requires it.
+func handleTwoFactorDisable(w http.ResponseWriter, r *http.Request) {
+	user := getContextUser(r)
+	data := accountPageData(r)
+	if twoFactorRequired(user.FamilyID) {
+		data["Error"] = localize(r, "two_factor_required_by_family")
+		renderPage(w, r, "account.html", data)
+		return
+	}
+	if !checkAccountPassword(w, r, user, data) || !checkAccountTwoFactorCode(w, r, user, data) {
+		return
+	}
+	before := twoFactorSnapshot(user)
+	if err := disableTwoFactor(user.ID); err != nil {
+		http.Error(w, localize(r, "failed_save_two_factor"), http.StatusInternalServerError)
+		return
+	}
+	recordAudit(r, "user.update", "user", user.ID, user.Username, before, twoFactorSnapshot(user))
+	http.Redirect(w, r, "/account", http.StatusSeeOther)
+}
+
+// handleRecoveryCodes replaces the recovery codes, given a current code, and
+// shows the new ones.
+func handleRecoveryCodes(w http.ResponseWriter, r *http.Request) {
+	user := getContextUser(r)
+	data := accountPageData(r)
+	if !twoFactorEnabled(user) {
+		data["Error"] = localize(r, "invalid_two_factor_code")
+		renderPage(w, r, "account.html", data)
+		return
+	}
+	if !checkAccountTwoFactorCode(w, r, user, data) {
+		return
+	}
+	before := twoFactorSnapshot(user)
+	codes, err := newRecoveryCodes(user.ID)
+	if err != nil {
+		http.Error(w, localize(r, "failed_save_two_factor"), http.StatusInternalServerError)
+		return
+	}
+	recordAudit(r, "user.update", "user", user.ID, user.Username, before, twoFactorSnapshot(user))
+	data = accountPageData(r)
+	data["RecoveryCodes"] = codes
+	renderPage(w, r, "account.html", data)
+}
+
+// handleRevokeSession signs the user out on one of their other devices.
+func handleRevokeSession(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		http.Error(w, localize(r, "invalid_id"), http.StatusBadRequest)
+		return
+	}
+	if err := deleteUserSession(getContextUser(r).ID, id); err != nil {
+		http.Error(w, localize(r, "session_not_found"), http.StatusNotFound)
+		return
+	}
+	closeRevokedStreams()
+	http.Redirect(w, r, "/account", http.StatusSeeOther)
+}
+
+// handleRevokeOtherSessions signs the user out everywhere but here.
+func handleRevokeOtherSessions(w http.ResponseWriter, r *http.Request) {
+	if err := deleteOtherSessions(getContextUser(r).ID, sessionToken(r)); err != nil {
+		http.Error(w, localize(r, "failed_revoke_sessions"), http.StatusInternalServerError)
+		return
+	}
+	closeRevokedStreams()
+	http.Redirect(w, r, "/account", http.StatusSeeOther)
+}
+
+func handleAccountPasswordChange(w http.ResponseWriter, r *http.Request) {
 	user := getContextUser(r)
 	current := r.FormValue("current")
 	newPw := r.FormValue("new")
 	confirm := r.FormValue("confirm")
 
-	data := map[string]interface{}{"User": user}
+	data := accountPageData(r)
 
 	if bcrypt.CompareHashAndPassword([]byte(user.PasswordHash), []byte(current)) != nil {
-		data["Error"] = "Current password is incorrect"
-		templates["password.html"].ExecuteTemplate(w, "password.html", data)
+		data["Error"] = localize(r, "password_incorrect")
+		renderPage(w, r, "account.html", data)
 		return
 	}
 
 	if len(newPw) < 6 {
-		data["Error"] = "New password must be at least 6 characters"
-		templates["password.html"].ExecuteTemplate(w, "password.html", data)
+		data["Error"] = localize(r, "password_too_short")
+		renderPage(w, r, "account.html", data)
 		return
 	}
 
 	if newPw != confirm {
-		data["Error"] = "New passwords do not match"
-		templates["password.html"].ExecuteTemplate(w, "password.html", data)
+		data["Error"] = localize(r, "password_mismatch")
+		renderPage(w, r, "account.html", data)
 		return
 	}
 
 	hash, err := bcrypt.GenerateFromPassword([]byte(newPw), bcrypt.DefaultCost)
 	if err != nil {
-		data["Error"] = "Failed to update password"
-		templates["password.html"].ExecuteTemplate(w, "password.html", data)
+		data["Error"] = localize(r, "password_update_failed")
+		renderPage(w, r, "account.html", data)
 		return
 	}
 
 	updatePassword(user.ID, string(hash))
-	data["Success"] = "Password updated successfully"
-	templates["password.html"].ExecuteTemplate(w, "password.html", data)
+	// Anyone who knew the old password is signed out
+	deleteOtherSessions(user.ID, sessionToken(r))
+	closeRevokedStreams()
+	data["Sessions"] = sessionViews(user.ID, sessionToken(r))
+	data["Success"] = localize(r, "password_updated")
+	renderPage(w, r, "account.html", data)
 }
 
-func handleAdmin(w http.ResponseWriter, r *http.Request) {
-	user := getContextUser(r)
-	users, _ := getAllUsers()
-	reasons, _ := getReasons()
-	apiKeys, _ := getAPIKeys()
-	rewards, _ := getRewardsList()
+// handleSetLanguage saves the language picked in the switcher: as the user's
+// preference when logged in, and in a cookie for the login page.
+func handleSetLanguage(w http.ResponseWriter, r *http.Request) {
+	lang := r.FormValue("lang")
+	if !validLanguage(lang) {
+		http.Error(w, localize(r, "unsupported_language"), http.StatusBadRequest)
+		return
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name:     langCookie,
+		Value:    lang,
+		Path:     "/",
+		SameSite: http.SameSiteLaxMode,
+		Secure:   sessionCookieSecure(r),
+		MaxAge:   365 * 24 * 60 * 60,
+	})
+	if user, _, err := sessionUser(r); err == nil {
+		updateUserLanguage(user.ID, lang)
+	}
+	jsonResponse(w, map[string]string{"status": "ok"})
+}
 
-	data := map[string]interface{}{
-		"User":          user,
-		"Users":         users,
-		"Reasons":       reasons,
-		"APIKeys":       apiKeys,
-		"Rewards":       rewards,
-		"HAEnabled":     getSetting("ha_enabled"),
-		"HAUrl":         getSetting("ha_url"),
-		"HAToken":       getSetting("ha_token"),
-		"HAMediaPlayer": getSetting("ha_media_player"),
-		"HALang":        getSetting("ha_lang"),
+// handleAccountLanguage saves the user's language preference from the account
+// page, where "" goes back to following the browser.
+func handleAccountLanguage(w http.ResponseWriter, r *http.Request) {
+	user := getContextUser(r)
+	lang := r.FormValue("lang")
+	if lang != "" && !validLanguage(lang) {
+		http.Error(w, localize(r, "unsupported_language"), http.StatusBadRequest)
+		return
+	}
+	updateUserLanguage(user.ID, lang)
+	if lang == "" {
+		// The switcher's cookie would otherwise stand in for the preference
+		http.SetCookie(w, &http.Cookie{Name: langCookie, Path: "/", MaxAge: -1})
 	}
-	templates["admin.html"].ExecuteTemplate(w, "admin.html", data)
+	http.Redirect(w, r, "/account", http.StatusSeeOther)
 }
 
-func handleAddReward(w http.ResponseWriter, r *http.Request) {
-	name := r.FormValue("name")
-	icon := r.FormValue("icon")
-	cost, err := strconv.Atoi(r.FormValue("cost"))
-	if err != nil || name == "" || cost <= 0 {
-		http.Error(w, "invalid reward", http.StatusBadRequest)
+func handlePasswordPage(w http.ResponseWriter, r *http.Request) {
+	user := getContextUser(r)
+	renderPage(w, r, "password.html", map[string]interface{}{"User": user})
+}
+
+func handlePasswordChange(w http.ResponseWriter, r *http.Request) {
+	user := getContextUser(r)
+	current := r.FormValue("current")
+	newPw := r.FormValue("new")
+	confirm := r.FormValue("confirm")
+
+	data := map[string]interface{}{"User": user}
+
+	if bcrypt.CompareHashAndPassword([]byte(user.PasswordHash), []byte(current)) != nil {
+		data["Error"] = localize(r, "password_incorrect")
+		renderPage(w, r, "password.html", data)
 		return
 	}
-	adultOnly := r.FormValue("adult_only") == "1"
-	if err := addReward(name, cost, icon, adultOnly); err != nil {
-		http.Error(w, "failed to add reward: "+err.Error(), http.StatusInternalServerError)
+
+	if len(newPw) < 6 {
+		data["Error"] = localize(r, "password_too_short")
+		renderPage(w, r, "password.html", data)
 		return
 	}
-	http.Redirect(w, r, "/admin", http.StatusSeeOther)
+
+	if newPw != confirm {
+		data["Error"] = localize(r, "password_mismatch")
+		renderPage(w, r, "password.html", data)
+		return
+	}
+
+	hash, err := bcrypt.GenerateFromPassword([]byte(newPw), bcrypt.DefaultCost)
+	if err != nil {
+		data["Error"] = localize(r, "password_update_failed")
+		renderPage(w, r, "password.html", data)
+		return
+	}
+
+	updatePassword(user.ID, string(hash))
+	deleteOtherSessions(user.ID, sessionToken(r))
+	closeRevokedStreams()
+	data["Success"] = localize(r, "password_updated")
+	renderPage(w, r, "password.html", data)
+}
+
+func adminPageData(r *http.Request) map[string]interface{} {
+	user := getContextUser(r)
+	familyID := getContextFamilyID(r)
+	users, _ := getAllUsers(familyID)
+	reasons, _ := getReasons(familyID)
+	apiKeys, _ := getAPIKeys(familyID)
+	rewards, _ := getRewardsList(familyID)
+	currencies, _ := getCurrencies(familyID)
+	chores, _ := getChores(familyID)
+	webhooks, _ := getWebhooks(familyID)
+	deliveries, _ := getWebhookDeliveries(familyID, 25)
+	announceDeliveries, _ := getAnnouncementDeliveries(familyID, 25)
+	mqttConnected, mqttError := mqttStatus()
+
+	// Families and the deployment-wide MQTT settings are only shown to the super-admin
+	var families []Family
+	var mqttCfg mqttConfig
+	var ipLocks []loginLockView
+	if user.IsSuperAdmin {
+		families, _ = getFamilies()
+		mqttCfg = getMQTTConfig()
+		ipLocks = ipLockViews(time.Now())
+	}
+
+	return map[string]interface{}{
+		"User":               user,
+		"Family":             familyName(familyID),
+		"FamilyID":           familyID,
+		"Families":           families,
+		"Users":              users,
+		"Reasons":            reasons,
+		"APIKeys":            apiKeys,
+		"APIKeyScopes":       apiKeyScopes,
+		"Now":                time.Now(),
+		"Rewards":            rewards,
+		"Currencies":         currencies,
+		"Chores":             chores,
+		"Webhooks":           webhooks,
+		"Deliveries":         deliveries,
+		"EventTypes":         eventTypes,
+		"HAEnabled":          getFamilySetting(familyID, "ha_enabled"),
+		"HALang":             getFamilySetting(familyID, "ha_lang"),
+		"Announcers":         announcerViews(familyID),
+		"AnnounceEvents":     announceEvents,
+		"AnnounceTemplates":  announceTemplateViews(familyID),
+		"AnnounceBatch":      int(announceBatchWindow(familyID) / time.Second),
+		"QuietHours":         quietHoursViews(familyID),
+		"HeldAnnouncements":  heldAnnouncementViews(familyID),
+		"UserAnnouncements":  userAnnounceViews(familyID, users),
+		"AnnounceDeliveries": announceDeliveries,
+		"UserLocks":          userLockViews(users, time.Now()),
+		"KidLogins":          kidLoginViews(users),
+		"PicturePad":         pinPadKeys(loginModePicture),
+		"KidLoginHere":       kidLoginFamily(r) == familyID,
+		"KidLoginDevices":    countKidLoginDevices(familyID),
+		"TwoFactors":         twoFactorViews(users),
+		"TwoFactorRequired":  twoFactorRequired(familyID),
+		"IPLocks":            ipLocks,
+		"MQTT":               mqttCfg,
+		"MQTTConnected":      mqttConnected,
+		"MQTTError":          mqttError,
+	}
+}
+
+func handleAdmin(w http.ResponseWriter, r *http.Request) {
+	data := adminPageData(r)
+	renderPage(w, r, "admin.html", data)
+}
+
+// auditFilterFromQuery reads the audit log filters shared by the admin page and API.
+func auditFilterFromQuery(r *http.Request) (AuditFilter, error) {
+	q := r.URL.Query()
+	f := AuditFilter{
+		FamilyID:   getContextFamilyID(r),
+		Actor:      q.Get("actor"),
+		Action:     q.Get("action"),
+		TargetType: q.Get("target_type"),
+		Source:     q.Get("source"),
+		Limit:      200,
+	}
+	if limitStr := q.Get("limit"); limitStr != "" {
+		n, err := strconv.Atoi(limitStr)
+		if err != nil || n < 1 {
+			return f, errors.New(localize(r, "invalid_limit"))
+		}
+		f.Limit = n
+	}
+	return f, nil
+}
+
+func handleAuditPage(w http.ResponseWriter, r *http.Request) {
+	filter, err := auditFilterFromQuery(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	entries, _ := getAuditEntries(filter)
+	actors, actions, targetTypes := getAuditFacets(getContextFamilyID(r))
+
+	data := map[string]interface{}{
+		"User":        getContextUser(r),
+		"Entries":     entries,
+		"Filter":      filter,
+		"Actors":      actors,
+		"Actions":     actions,
+		"TargetTypes": targetTypes,
+	}
+	renderPage(w, r, "audit.html", data)
+}
+
+func handleAddReward(w http.ResponseWriter, r *http.Request) {
+	name := r.FormValue("name")
+	icon := r.FormValue("icon")
+	cost, err := strconv.Atoi(r.FormValue("cost"))
+	if err != nil || name == "" || cost <= 0 {
+		http.Error(w, localize(r, "invalid_reward"), http.StatusBadRequest)
+		return
+	}
+	adultOnly := r.FormValue("adult_only") == "1"
+	currencyID, err := formCurrencyID(r)
+	if err != nil {
+		http.Error(w, localize(r, "currency_not_found"), http.StatusBadRequest)
+		return
+	}
+	if err := addReward(getContextFamilyID(r), name, cost, icon, adultOnly, currencyID); err != nil {
+		http.Error(w, localize(r, "failed_add_reward")+": "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	recordAudit(r, "reward.create", "reward", 0, name, nil, map[string]interface{}{
+		"name":       name,
+		"cost":       cost,
+		"currency":   currencyKey(currencyID),
+		"icon":       icon,
+		"adult_only": adultOnly,
+	})
+	http.Redirect(w, r, "/admin", http.StatusSeeOther)
+}
+
+func handleUpdateReward(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		http.Error(w, localize(r, "invalid_id"), http.StatusBadRequest)
+		return
+	}
+	name := r.FormValue("name")
+	icon := r.FormValue("icon")
+	cost, err := strconv.Atoi(r.FormValue("cost"))
+	if err != nil || name == "" || cost <= 0 {
+		http.Error(w, localize(r, "invalid_reward"), http.StatusBadRequest)
+		return
+	}
+	reward, err := getFamilyReward(r, id)
+	if err != nil {
+		http.Error(w, localize(r, "reward_not_found"), http.StatusNotFound)
+		return
+	}
+	before := rewardSnapshot(reward)
+	updateReward(id, name, cost, icon)
+	if updated, err := getRewardByID(id); err == nil {
+		recordAudit(r, "reward.update", "reward", id, updated.Key, before, rewardSnapshot(updated))
+	}
+	http.Redirect(w, r, "/admin", http.StatusSeeOther)
+}
+
+func handleUpdateRewardTranslation(w http.ResponseWriter, r *http.Request) {
+	idStr := r.PathValue("id")
+	id, err := strconv.Atoi(idStr)
+	if err != nil {
+		http.Error(w, localize(r, "invalid_id"), http.StatusBadRequest)
+		return
+	}
+	lang := r.FormValue("lang")
+	if lang != "" && !validLanguage(lang) {
+		http.Error(w, localize(r, "unsupported_language"), http.StatusBadRequest)
+		return
+	}
+	text := r.FormValue("text")
+	costStr := r.FormValue("cost")
+	adultOnlyStr := r.FormValue("adult_only")
+
+	reward, err := getFamilyReward(r, id)
+	if err != nil {
+		http.Error(w, localize(r, "reward_not_found"), http.StatusNotFound)
+		return
+	}
+	before := rewardSnapshot(reward)
+
+	if lang != "" && text != "" {
+		updateRewardTranslation(id, lang, text)
+	}
+
+	retroactive := false
+	if costStr != "" {
+		cost, err := strconv.Atoi(costStr)
+		if err != nil || cost < 1 {
+			http.Error(w, localize(r, "invalid_cost"), http.StatusBadRequest)
+			return
+		}
+		retroactive = r.FormValue("retroactive") != "0"
+		updateRewardCost(id, cost, retroactive, getContextUser(r).ID)
+	}
+
+	if adultOnlyStr != "" {
+		updateRewardAdultOnly(id, adultOnlyStr == "1")
+	}
+
+	if updated, err := getRewardByID(id); err == nil {
+		after := rewardSnapshot(updated)
+		after["retroactive"] = retroactive
+		recordAudit(r, "reward.update", "reward", id, updated.Key, before, after)
+	}
+
+	jsonResponse(w, map[string]string{"status": "ok"})
+}
+
+func handleDeleteReward(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		http.Error(w, localize(r, "invalid_id"), http.StatusBadRequest)
+		return
+	}
+	reward, err := getFamilyReward(r, id)
+	if err != nil {
+		http.Error(w, localize(r, "reward_not_found"), http.StatusNotFound)
+		return
+	}
+	if err := deleteRewardByID(id); err != nil {
+		http.Error(w, localize(r, "failed_delete_reward")+": "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	recordAudit(r, "reward.delete", "reward", id, reward.Key, rewardSnapshot(reward), nil)
+	w.WriteHeader(http.StatusOK)
+}
+
+// formCurrencyID reads the currency_id form value, which must name one of the
+// family's currencies. Empty or 0 is stars.
+func formCurrencyID(r *http.Request) (int, error) {
+	value := r.FormValue("currency_id")
+	if value == "" || value == "0" {
+		return 0, nil
+	}
+	id, err := strconv.Atoi(value)
+	if err != nil {
+		return 0, err
+	}
+	if _, err := getFamilyCurrency(r, id); err != nil {
+		return 0, err
+	}
+	return id, nil
+}
+
+func handleAddCurrency(w http.ResponseWriter, r *http.Request) {
+	name := strings.TrimSpace(r.FormValue("name"))
+	icon := strings.TrimSpace(r.FormValue("icon"))
+	if name == "" {
+		http.Error(w, localize(r, "currency_name_required"), http.StatusBadRequest)
+		return
+	}
+	id, err := addCurrency(getContextFamilyID(r), name, icon)
+	if err != nil {
+		http.Error(w, localize(r, "failed_add_currency")+": "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if created, err := getCurrencyByID(id); err == nil {
+		recordAudit(r, "currency.create", "currency", id, created.Key, nil, currencySnapshot(created))
+	}
+	http.Redirect(w, r, "/admin", http.StatusSeeOther)
+}
+
+// handleUpdateCurrency changes a currency's icon or one of its translations.
+func handleUpdateCurrency(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		http.Error(w, localize(r, "invalid_id"), http.StatusBadRequest)
+		return
+	}
+	currency, err := getFamilyCurrency(r, id)
+	if err != nil {
+		http.Error(w, localize(r, "currency_not_found"), http.StatusNotFound)
+		return
+	}
+	before := currencySnapshot(currency)
+
+	lang := r.FormValue("lang")
+	if lang != "" && !validLanguage(lang) {
+		http.Error(w, localize(r, "unsupported_language"), http.StatusBadRequest)
+		return
+	}
+	text := strings.TrimSpace(r.FormValue("text"))
+	if lang != "" && text != "" {
+		updateCurrencyTranslation(id, lang, text)
+	}
+	if icon := strings.TrimSpace(r.FormValue("icon")); icon != "" {
+		updateCurrencyIcon(id, icon)
+	}
+
+	if updated, err := getCurrencyByID(id); err == nil {
+		recordAudit(r, "currency.update", "currency", id, updated.Key, before, currencySnapshot(updated))
+	}
+	jsonResponse(w, map[string]string{"status": "ok"})
+}
+
+func handleDeleteCurrency(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		http.Error(w, localize(r, "invalid_id"), http.StatusBadRequest)
+		return
+	}
+	currency, err := getFamilyCurrency(r, id)
+	if err != nil {
+		http.Error(w, localize(r, "currency_not_found"), http.StatusNotFound)
+		return
+	}
+	if err := deleteCurrency(id); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	recordAudit(r, "currency.delete", "currency", id, currency.Key, currencySnapshot(currency), nil)
+	w.WriteHeader(http.StatusOK)
+}
+
+func handleAddChore(w http.ResponseWriter, r *http.Request) {
+	r.ParseForm()
+	reasonID, err := strconv.Atoi(r.FormValue("reason_id"))
+	if err != nil {
+		http.Error(w, localize(r, "reason_required"), http.StatusBadRequest)
+		return
+	}
+	schedule := r.FormValue("schedule")
+	if !validChoreSchedule(schedule) {
+		http.Error(w, localize(r, "invalid_schedule"), http.StatusBadRequest)
+		return
+	}
+	weekday, _ := strconv.Atoi(r.FormValue("weekday"))
+	if weekday < 0 || weekday > 6 {
+		http.Error(w, localize(r, "invalid_weekday"), http.StatusBadRequest)
+		return
+	}
+	dueTime := strings.TrimSpace(r.FormValue("due_time"))
+	if _, _, ok := parseDueTime(dueTime); !ok {
+		http.Error(w, localize(r, "invalid_due_time"), http.StatusBadRequest)
+		return
+	}
+
+	if reason, err := getReasonByID(reasonID); err != nil || reason.FamilyID != getContextFamilyID(r) {
+		http.Error(w, localize(r, "reason_not_found"), http.StatusBadRequest)
+		return
+	}
+
+	var userIDs []int
+	for _, v := range r.Form["user_id"] {
+		if id, err := strconv.Atoi(v); err == nil && userInFamily(r, id) {
+			userIDs = append(userIDs, id)
+		}
+	}
+	if len(userIDs) == 0 {
+		http.Error(w, localize(r, "chore_needs_kid"), http.StatusBadRequest)
+		return
+	}
+
+	autoApprove := r.FormValue("auto_approve") == "1"
+	if err := addChore(reasonID, schedule, weekday, dueTime, autoApprove, userIDs); err != nil {
+		http.Error(w, localize(r, "failed_add_chore")+": "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	recordAudit(r, "chore.create", "chore", 0, "", nil, map[string]interface{}{
+		"reason_id":    reasonID,
+		"schedule":     schedule,
+		"weekday":      weekday,
+		"due_time":     dueTime,
+		"auto_approve": autoApprove,
+		"user_ids":     userIDs,
+	})
+	http.Redirect(w, r, "/admin", http.StatusSeeOther)
+}
+
+func handleDeleteChore(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		http.Error(w, localize(r, "invalid_id"), http.StatusBadRequest)
+		return
+	}
+	chore, err := getChoreByID(getContextFamilyID(r), id)
+	if err != nil {
+		http.Error(w, localize(r, "chore_not_found"), http.StatusNotFound)
+		return
+	}
+	deleteChore(id)
+	recordAudit(r, "chore.delete", "chore", id, chore.ReasonKey, map[string]interface{}{
+		"reason":       chore.ReasonKey,
+		"schedule":     chore.Schedule,
+		"weekday":      chore.Weekday,
+		"due_time":     chore.DueTime,
+		"auto_approve": chore.AutoApprove,
+		"users":        chore.Usernames,
+	}, nil)
+	w.WriteHeader(http.StatusOK)
+}
+
+// handleAddFamily creates a family together with its first admin.
+func handleAddFamily(w http.ResponseWriter, r *http.Request) {
+	name := strings.TrimSpace(r.FormValue("name"))
+	username := r.FormValue("username")
+	password := r.FormValue("password")
+
+	if name == "" || username == "" || password == "" {
+		http.Error(w, localize(r, "family_fields_required"), http.StatusBadRequest)
+		return
+	}
+
+	id, err := createFamily(name, username, password)
+	if err != nil {
+		http.Error(w, localize(r, "failed_add_family")+": "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	recordAudit(r, "family.create", "family", id, name, nil, map[string]interface{}{
+		"name":  name,
+		"admin": username,
+	})
+	http.Redirect(w, r, "/admin", http.StatusSeeOther)
+}
+
+// handleSwitchFamily points the super-admin's session at another family.
+func handleSwitchFamily(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		http.Error(w, localize(r, "invalid_id"), http.StatusBadRequest)
+		return
+	}
+	if _, err := getFamilyByID(id); err != nil {
+		http.Error(w, localize(r, "family_not_found"), http.StatusNotFound)
+		return
+	}
+	cookie, err := r.Cookie("session")
+	if err != nil {
+		http.Redirect(w, r, "/login", http.StatusSeeOther)
+		return
+	}
+	if err := setSessionFamily(cookie.Value, id); err != nil {
+		http.Error(w, localize(r, "failed_switch_family"), http.StatusInternalServerError)
+		return
+	}
+	http.Redirect(w, r, "/", http.StatusSeeOther)
+}
+
+func handleAddUser(w http.ResponseWriter, r *http.Request) {
+	username := r.FormValue("username")
+	password := r.FormValue("password")
+	role := r.FormValue("role")
+
+	if username == "" || password == "" {
+		http.Error(w, localize(r, "username_password_required"), http.StatusBadRequest)
+		return
+	}
+
+	isAdmin := role == "admin"
+	if err := addUser(getContextFamilyID(r), username, password, isAdmin); err != nil {
+		http.Error(w, localize(r, "failed_add_user")+": "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if created, err := getUserByUsername(username); err == nil {
+		recordAudit(r, "user.create", "user", created.ID, username, nil, map[string]interface{}{
+			"username": username,
+			"is_admin": isAdmin,
+		})
+		mqttPublishUser(created)
+	}
+	http.Redirect(w, r, "/admin", http.StatusSeeOther)
+}
+
+func handleDeleteUser(w http.ResponseWriter, r *http.Request) {
+	user := getContextUser(r)
+	idStr := r.PathValue("id")
+	id, err := strconv.Atoi(idStr)
+	if err != nil {
+		http.Error(w, localize(r, "invalid_id"), http.StatusBadRequest)
+		return
+	}
+
+	if id == user.ID {
+		http.Error(w, localize(r, "cannot_delete_own_account"), http.StatusBadRequest)
+		return
+	}
+
+	target, err := getUserByID(id)
+	if err != nil || target.FamilyID != getContextFamilyID(r) {
+		http.Error(w, localize(r, "user_not_found"), http.StatusNotFound)
+		return
+	}
+	if target.IsSuperAdmin {
+		http.Error(w, localize(r, "cannot_delete_super_admin"), http.StatusBadRequest)
+		return
+	}
+	// Snapshot before the user's stars and redemptions are wiped
+	before := userSnapshot(target)
+	if err := deleteUser(id); err != nil {
+		http.Error(w, localize(r, "failed_delete_user")+": "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	recordAudit(r, "user.delete", "user", id, target.Username, before, nil)
+	mqttRemoveUser(target)
+	closeRevokedStreams()
+	w.WriteHeader(http.StatusOK)
+}
+
+func handleAddStar(w http.ResponseWriter, r *http.Request) {
+	user := getContextUser(r)
+	username := r.FormValue("username")
+	reason := r.FormValue("reason")
+	starsStr := r.FormValue("stars")
+
+	if username == "" || reason == "" {
+		http.Error(w, localize(r, "username_reason_required"), http.StatusBadRequest)
+		return
+	}
+	if _, err := getFamilyUser(r, username); err != nil {
+		http.Error(w, localize(r, "user_not_found_named", "username", username), http.StatusBadRequest)
+		return
+	}
+
+	currencyID, err := formCurrencyID(r)
+	if err != nil {
+		http.Error(w, localize(r, "currency_not_found"), http.StatusBadRequest)
+		return
+	}
+
+	stars := 0
+	if starsStr != "" {
+		if s, err := strconv.Atoi(starsStr); err == nil {
+			stars = s
+		}
+	}
+
+	starID, err := addStarWithID(username, nil, reason, currencyID, stars, user.ID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	auditStarAward(r, username, starID)
+	publishStarAwarded(starID)
+
+	actualStars := stars
+	if star, err := getStarByID(int(starID)); err == nil {
+		actualStars, currencyID = star.Stars, star.CurrencyID
+	}
+	announceStarIfEnabled(username, nil, reason, actualStars, currencyID)
+	checkGoalReached(username)
+
+	http.Redirect(w, r, "/admin", http.StatusSeeOther)
+}
+
+func handleGenerateAPIKey(w http.ResponseWriter, r *http.Request) {
+	user := getContextUser(r)
+	r.ParseForm()
+	label := r.FormValue("label")
+
+	var scopes []string
+	for _, scope := range r.Form["scope"] {
+		if !validAPIKeyScope(scope) {
+			http.Error(w, localize(r, "invalid_scope", "scope", scope), http.StatusBadRequest)
+			return
+		}
+		scopes = append(scopes, scope)
+	}
+	if len(scopes) == 0 {
+		http.Error(w, localize(r, "scope_required"), http.StatusBadRequest)
+		return
+	}
+
+	// API actions are attributed to the owning parent
+	ownerID := user.ID
+	if ownerStr := r.FormValue("owner_id"); ownerStr != "" {
+		id, err := strconv.Atoi(ownerStr)
+		if err != nil {
+			http.Error(w, localize(r, "invalid_owner"), http.StatusBadRequest)
+			return
+		}
+		owner, err := getUserByID(id)
+		if err != nil || !owner.IsAdmin || owner.FamilyID != getContextFamilyID(r) {
+			http.Error(w, localize(r, "owner_must_be_parent"), http.StatusBadRequest)
+			return
+		}
+		ownerID = owner.ID
+	}
+
+	var userIDs []int
+	for _, v := range r.Form["user_id"] {
+		if id, err := strconv.Atoi(v); err == nil && userInFamily(r, id) {
+			userIDs = append(userIDs, id)
+		}
+	}
+
+	// Keys stay valid through the end of the chosen day
+	var expiresAt *time.Time
+	if expiresStr := r.FormValue("expires"); expiresStr != "" {
+		day, err := time.ParseInLocation("2006-01-02", expiresStr, time.Local)
+		if err != nil {
+			http.Error(w, localize(r, "invalid_expiry"), http.StatusBadRequest)
+			return
+		}
+		expiry := day.AddDate(0, 0, 1)
+		expiresAt = &expiry
+	}
+
+	key, err := randomHex(32)
+	if err != nil {
+		http.Error(w, localize(r, "failed_create_api_key"), http.StatusInternalServerError)
+		return
+	}
+	keyHash := hashAPIKey(key)
+
+	keyID, err := addAPIKey(getContextFamilyID(r), keyHash, label, scopes, ownerID, userIDs, expiresAt)
+	if err != nil {
+		http.Error(w, localize(r, "failed_create_api_key"), http.StatusInternalServerError)
+		return
+	}
+	if created, err := getAPIKeyByID(int(keyID)); err == nil {
+		recordAudit(r, "apikey.create", "apikey", created.ID, label, nil, map[string]interface{}{
+			"label":      created.Label,
+			"scopes":     created.Scopes,
+			"owner":      created.OwnerName,
+			"users":      created.Usernames,
+			"expires_at": created.ExpiresAt,
+		})
+	}
+
+	// Show the key once
+	data := adminPageData(r)
+	data["NewKey"] = key
+	renderPage(w, r, "admin.html", data)
 }
 
-func handleUpdateReward(w http.ResponseWriter, r *http.Request) {
-	id, err := strconv.Atoi(r.PathValue("id"))
+func handleDeleteAPIKey(w http.ResponseWriter, r *http.Request) {
+	idStr := r.PathValue("id")
+	id, err := strconv.Atoi(idStr)
 	if err != nil {
-		http.Error(w, "invalid id", http.StatusBadRequest)
+		http.Error(w, localize(r, "invalid_id"), http.StatusBadRequest)
 		return
 	}
-	name := r.FormValue("name")
-	icon := r.FormValue("icon")
-	cost, err := strconv.Atoi(r.FormValue("cost"))
-	if err != nil || name == "" || cost <= 0 {
-		http.Error(w, "invalid reward", http.StatusBadRequest)
+	key, err := getAPIKeyByID(id)
+	if err != nil || key.FamilyID != getContextFamilyID(r) {
+		http.Error(w, localize(r, "api_key_not_found"), http.StatusNotFound)
 		return
 	}
-	updateReward(id, name, cost, icon)
+	deleteAPIKey(id)
+	recordAudit(r, "apikey.delete", "apikey", id, key.Label, map[string]interface{}{
+		"label":  key.Label,
+		"scopes": key.Scopes,
+		"owner":  key.OwnerName,
+		"users":  key.Usernames,
+	}, nil)
 	http.Redirect(w, r, "/admin", http.StatusSeeOther)
 }
 
-func handleUpdateRewardTranslation(w http.ResponseWriter, r *http.Request) {
-	idStr := r.PathValue("id")
-	id, err := strconv.Atoi(idStr)
-	if err != nil {
-		http.Error(w, "invalid id", http.StatusBadRequest)
+func handleAddWebhook(w http.ResponseWriter, r *http.Request) {
+	r.ParseForm()
+	hookURL := strings.TrimSpace(r.FormValue("url"))
+	if !validWebhookURL(hookURL) {
+		http.Error(w, localize(r, "invalid_webhook_url"), http.StatusBadRequest)
+		return
+	}
+	if !webhookURLAllowed(getContextFamilyID(r), hookURL) {
+		http.Error(w, localize(r, "webhook_url_not_public"), http.StatusBadRequest)
 		return
 	}
-	lang := r.FormValue("lang")
-	text := r.FormValue("text")
-	costStr := r.FormValue("cost")
-	adultOnlyStr := r.FormValue("adult_only")
 
-	if lang != "" && text != "" {
-		updateRewardTranslation(id, lang, text)
+	// No events selected subscribes the webhook to everything
+	var events []string
+	for _, e := range r.Form["event"] {
+		if !validEventType(e) {
+			http.Error(w, localize(r, "invalid_event", "event", e), http.StatusBadRequest)
+			return
+		}
+		events = append(events, e)
 	}
 
-	if costStr != "" {
-		cost, err := strconv.Atoi(costStr)
-		if err != nil || cost < 1 {
-			http.Error(w, "invalid cost value", http.StatusBadRequest)
+	secret := strings.TrimSpace(r.FormValue("secret"))
+	if secret == "" {
+		generated, err := randomHex(24)
+		if err != nil {
+			http.Error(w, localize(r, "failed_create_webhook"), http.StatusInternalServerError)
 			return
 		}
-		retroactive := r.FormValue("retroactive") != "0"
-		updateRewardCost(id, cost, retroactive)
+		secret = "whsec_" + generated
 	}
 
-	if adultOnlyStr != "" {
-		updateRewardAdultOnly(id, adultOnlyStr == "1")
+	id, err := addWebhook(getContextFamilyID(r), hookURL, secret, events)
+	if err != nil {
+		http.Error(w, localize(r, "failed_create_webhook"), http.StatusInternalServerError)
+		return
 	}
+	recordAudit(r, "webhook.create", "webhook", int(id), hookURL, nil, map[string]interface{}{
+		"url":    hookURL,
+		"events": events,
+	})
 
-	jsonResponse(w, map[string]string{"status": "ok"})
+	// Show the secret once
+	data := adminPageData(r)
+	data["NewWebhookSecret"] = secret
+	renderPage(w, r, "admin.html", data)
 }
 
-func handleDeleteReward(w http.ResponseWriter, r *http.Request) {
+func handleDeleteWebhook(w http.ResponseWriter, r *http.Request) {
 	id, err := strconv.Atoi(r.PathValue("id"))
 	if err != nil {
-		http.Error(w, "invalid id", http.StatusBadRequest)
+		http.Error(w, localize(r, "invalid_id"), http.StatusBadRequest)
 		return
 	}
-	deleteRewardByID(id)
-	w.WriteHeader(http.StatusOK)
-}
-
-func handleAddUser(w http.ResponseWriter, r *http.Request) {
-	username := r.FormValue("username")
-	password := r.FormValue("password")
-	role := r.FormValue("role")
-
-	if username == "" || password == "" {
-		http.Error(w, "username and password required", http.StatusBadRequest)
+	hook, err := getWebhookByID(id)
+	if err != nil || hook.FamilyID != getContextFamilyID(r) {
+		http.Error(w, localize(r, "webhook_not_found"), http.StatusNotFound)
 		return
 	}
-
-	isAdmin := role == "admin"
-	if err := addUser(username, password, isAdmin); err != nil {
-		http.Error(w, "failed to add user: "+err.Error(), http.StatusInternalServerError)
+	if err := deleteWebhook(id); err != nil {
+		http.Error(w, localize(r, "failed_delete_webhook"), http.StatusInternalServerError)
 		return
 	}
+	recordAudit(r, "webhook.delete", "webhook", id, hook.URL, map[string]interface{}{
+		"url":     hook.URL,
+		"events":  hook.Events,
+		"enabled": hook.Enabled,
+	}, nil)
 	http.Redirect(w, r, "/admin", http.StatusSeeOther)
 }
 
-func handleDeleteUser(w http.ResponseWriter, r *http.Request) {
-	user := getContextUser(r)
-	idStr := r.PathValue("id")
-	id, err := strconv.Atoi(idStr)
+func handleToggleWebhook(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(r.PathValue("id"))
 	if err != nil {
-		http.Error(w, "invalid id", http.StatusBadRequest)
+		http.Error(w, localize(r, "invalid_id"), http.StatusBadRequest)
 		return
 	}
-
-	if id == user.ID {
-		http.Error(w, "cannot delete your own account", http.StatusBadRequest)
+	hook, err := getWebhookByID(id)
+	if err != nil || hook.FamilyID != getContextFamilyID(r) {
+		http.Error(w, localize(r, "webhook_not_found"), http.StatusNotFound)
 		return
 	}
-
-	deleteUser(id)
-	w.WriteHeader(http.StatusOK)
+	if err := setWebhookEnabled(id, !hook.Enabled); err != nil {
+		http.Error(w, localize(r, "failed_update_webhook"), http.StatusInternalServerError)
+		return
+	}
+	recordAudit(r, "webhook.update", "webhook", id, hook.URL,
+		map[string]interface{}{"enabled": hook.Enabled}, map[string]interface{}{"enabled": !hook.Enabled})
+	if !hook.Enabled {
+		// Deliveries queued before the pause go out now
+		wakeWebhookWorker()
+	}
+	jsonResponse(w, map[string]bool{"enabled": !hook.Enabled})
 }
 
-func handleAddStar(w http.ResponseWriter, r *http.Request) {
-	user := getContextUser(r)
-	username := r.FormValue("username")
-	reason := r.FormValue("reason")
-	starsStr := r.FormValue("stars")
-
-	if username == "" || reason == "" {
-		http.Error(w, "username and reason required", http.StatusBadRequest)
+func handleTestWebhook(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		http.Error(w, localize(r, "invalid_id"), http.StatusBadRequest)
 		return
 	}
-
-	stars := 0
-	if starsStr != "" {
-		if s, err := strconv.Atoi(starsStr); err == nil {
-			stars = s
-		}
+	hook, err := getWebhookByID(id)
+	if err != nil || hook.FamilyID != getContextFamilyID(r) {
+		http.Error(w, localize(r, "webhook_not_found"), http.StatusNotFound)
+		return
 	}
+	if err := queueWebhookPing(hook); err != nil {
+		http.Error(w, localize(r, "failed_queue_test"), http.StatusInternalServerError)
+		return
+	}
+	jsonResponse(w, map[string]string{"status": "ok"})
+}
 
-	_, err := addStarWithID(username, nil, reason, stars, user.ID)
+func handleRetryWebhookDelivery(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		http.Error(w, localize(r, "invalid_id"), http.StatusBadRequest)
+		return
+	}
+	delivery, err := getWebhookDeliveryByID(id)
 	if err != nil {
+		http.Error(w, localize(r, "delivery_not_found"), http.StatusNotFound)
+		return
+	}
+	if hook, err := getWebhookByID(delivery.WebhookID); err != nil || hook.FamilyID != getContextFamilyID(r) {
+		http.Error(w, localize(r, "delivery_not_found"), http.StatusNotFound)
+		return
+	}
+	if err := retryWebhookDelivery(id); err != nil {
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
+	recordAudit(r, "webhook.retry", "webhook", delivery.WebhookID, delivery.WebhookURL, nil, map[string]interface{}{
+		"delivery_id": delivery.ID,
+		"event":       delivery.EventType,
+		"event_id":    delivery.EventID,
+	})
+	wakeWebhookWorker()
+	jsonResponse(w, map[string]string{"status": "ok"})
+}
 
-	actualStars := stars
-	if actualStars == 0 {
-		actualStars = 1
+func handleSaveSettings(w http.ResponseWriter, r *http.Request) {
+	r.ParseForm()
+	if lang := r.FormValue("ha_lang"); lang != "" && !validLanguage(lang) {
+		http.Error(w, localize(r, "unsupported_language"), http.StatusBadRequest)
+		return
+	}
+	batch := strings.TrimSpace(r.FormValue("announce_batch_seconds"))
+	if seconds, err := strconv.Atoi(batch); batch != "" && (err != nil || seconds < 0 || time.Duration(seconds)*time.Second > announceMaxBatch) {
+		http.Error(w, localize(r, "invalid_batch_window"), http.StatusBadRequest)
+		return
 	}
-	announceStarIfEnabled(username, nil, reason, actualStars)
+	quiet := make(map[time.Weekday]string)
+	for d := time.Sunday; d <= time.Saturday; d++ {
+		from := strings.TrimSpace(r.FormValue(quietHoursKey(d) + "_start"))
+		until := strings.TrimSpace(r.FormValue(quietHoursKey(d) + "_end"))
+		if from == "" && until == "" {
+			continue
+		}
+		quiet[d] = from + "-" + until
+		if _, _, _, _, ok := parseQuietHours(quiet[d]); !ok {
+			http.Error(w, localize(r, "invalid_quiet_hours"), http.StatusBadRequest)
+			return
+		}
+	}
+	familyID := getContextFamilyID(r)
+	before := settingsSnapshot(familyID)
+	if r.FormValue("ha_enabled") == "1" {
+		setFamilySetting(familyID, "ha_enabled", "1")
+	} else {
+		setFamilySetting(familyID, "ha_enabled", "0")
+	}
+	setFamilySetting(familyID, "ha_lang", r.FormValue("ha_lang"))
+	for _, b := range announcerBackends {
+		enabled := "0"
+		if r.FormValue(announcerSettingKey(b.Kind, "enabled")) == "1" {
+			enabled = "1"
+		}
+		var events []string
+		for _, e := range r.Form[announcerSettingKey(b.Kind, "events")] {
+			if validAnnounceEvent(e) {
+				events = append(events, e)
+			}
+		}
+		setFamilySetting(familyID, announcerSettingKey(b.Kind, "enabled"), enabled)
+		setFamilySetting(familyID, announcerSettingKey(b.Kind, "events"), strings.Join(events, ","))
+		for _, f := range b.Fields {
+			setFamilySetting(familyID, f.SettingKey, strings.TrimSpace(r.FormValue(f.SettingKey)))
+		}
+	}
+	setFamilySetting(familyID, "announce_batch_seconds", batch)
+	for d := time.Sunday; d <= time.Saturday; d++ {
+		setFamilySetting(familyID, quietHoursKey(d), quiet[d])
+	}
+	for _, key := range announceTemplateKeys() {
+		setFamilySetting(familyID, key, strings.TrimSpace(r.FormValue(key)))
+	}
+	rescheduleAnnouncements(familyID)
+	after := settingsSnapshot(familyID)
+	recordAudit(r, "settings.update", "settings", 0, "announcements", before, after)
+	publishSettingsChanged(familyID, before, after)
+	http.Redirect(w, r, "/admin", http.StatusSeeOther)
+}
 
+func handleSaveMQTT(w http.ResponseWriter, r *http.Request) {
+	before := mqttSettingsSnapshot()
+	broker := strings.TrimSpace(r.FormValue("mqtt_broker"))
+	if broker != "" && !strings.Contains(broker, "://") {
+		broker = "tcp://" + broker
+	}
+	enabled, commands := "0", "0"
+	if r.FormValue("mqtt_enabled") == "1" {
+		enabled = "1"
+	}
+	if r.FormValue("mqtt_commands") == "1" {
+		commands = "1"
+	}
+	setSetting("mqtt_enabled", enabled)
+	setSetting("mqtt_broker", broker)
+	setSetting("mqtt_username", strings.TrimSpace(r.FormValue("mqtt_username")))
+	setSetting("mqtt_password", r.FormValue("mqtt_password"))
+	setSetting("mqtt_base_topic", strings.Trim(strings.TrimSpace(r.FormValue("mqtt_base_topic")), "/"))
+	setSetting("mqtt_discovery_prefix", strings.Trim(strings.TrimSpace(r.FormValue("mqtt_discovery_prefix")), "/"))
+	setSetting("mqtt_commands", commands)
+	after := mqttSettingsSnapshot()
+	// The broker is shared by every family, so this goes in the audit log only
+	recordAudit(r, "settings.update", "settings", 0, "mqtt", before, after)
+	restartMQTT()
 	http.Redirect(w, r, "/admin", http.StatusSeeOther)
 }
 
-func handleGenerateAPIKey(w http.ResponseWriter, r *http.Request) {
-	label := r.FormValue("label")
-	key, err := randomHex(32)
+func handleToggleAnnounce(w http.ResponseWriter, r *http.Request) {
+	familyID := getContextFamilyID(r)
+	current := getFamilySetting(familyID, "ha_enabled")
+	if current == "1" {
+		setFamilySetting(familyID, "ha_enabled", "0")
+	} else {
+		setFamilySetting(familyID, "ha_enabled", "1")
+	}
+	before := map[string]interface{}{"ha_enabled": current}
+	after := map[string]interface{}{"ha_enabled": getFamilySetting(familyID, "ha_enabled")}
+	recordAudit(r, "settings.update", "settings", 0, "ha_enabled", before, after)
+	publishSettingsChanged(familyID, before, after)
+	jsonResponse(w, map[string]string{"ha_enabled": getFamilySetting(familyID, "ha_enabled")})
+}
+
+func handleRetryAnnouncementDelivery(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(r.PathValue("id"))
 	if err != nil {
-		http.Error(w, "failed to create API key", http.StatusInternalServerError)
+		http.Error(w, localize(r, "invalid_id"), http.StatusBadRequest)
 		return
 	}
-	keyHash := hashAPIKey(key)
-
-	if err := addAPIKey(keyHash, label); err != nil {
-		http.Error(w, "failed to create API key", http.StatusInternalServerError)
+	delivery, err := getAnnouncementDeliveryByID(id)
+	if err != nil || delivery.FamilyID != getContextFamilyID(r) {
+		http.Error(w, localize(r, "delivery_not_found"), http.StatusNotFound)
 		return
 	}
+	if err := retryAnnouncementDelivery(id); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	recordAudit(r, "announcement.retry", "announcement", delivery.ID, delivery.Kind, nil, map[string]interface{}{
+		"event":   delivery.Event,
+		"message": delivery.Message,
+		"target":  delivery.Target,
+	})
+	wakeAnnouncementWorker()
+	jsonResponse(w, map[string]string{"status": "ok"})
+}
 
-	// Show the key once
-	user := getContextUser(r)
-	users, _ := getAllUsers()
-	reasons, _ := getReasons()
-	apiKeys, _ := getAPIKeys()
-
-	data := map[string]interface{}{
-		"User":    user,
-		"Users":   users,
-		"Reasons": reasons,
-		"APIKeys": apiKeys,
-		"NewKey":  key,
+// announcementFromForm renders a sample announcement for the form's event and
+// language, from the submitted template or else the saved one.
+func announcementFromForm(r *http.Request) (Announcement, error) {
+	r.ParseForm()
+	event, lang := r.FormValue("event"), r.FormValue("lang")
+	if !validAnnounceEvent(event) {
+		return Announcement{}, errors.New(localize(r, "invalid_announce_event"))
+	}
+	if !validLanguage(lang) {
+		return Announcement{}, errors.New(localize(r, "unsupported_language"))
+	}
+	familyID := getContextFamilyID(r)
+	tmpl := announceTemplate(familyID, event, lang)
+	if _, ok := r.Form["template"]; ok {
+		// An emptied field previews the language's own sentence
+		if tmpl = strings.TrimSpace(r.FormValue("template")); tmpl == "" {
+			tmpl = defaultAnnounceTemplate(event, lang)
+		}
 	}
-	templates["admin.html"].ExecuteTemplate(w, "admin.html", data)
+	s := sampleAnnounceSubject(familyID, getContextUser(r), event, lang)
+	return renderAnnouncement(s, tmpl, lang), nil
 }
 
-func handleDeleteAPIKey(w http.ResponseWriter, r *http.Request) {
-	idStr := r.PathValue("id")
-	id, err := strconv.Atoi(idStr)
+func handleAnnouncePreview(w http.ResponseWriter, r *http.Request) {
+	a, err := announcementFromForm(r)
 	if err != nil {
-		http.Error(w, "invalid id", http.StatusBadRequest)
+		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
-	deleteAPIKey(id)
-	http.Redirect(w, r, "/admin", http.StatusSeeOther)
+	jsonResponse(w, map[string]string{"message": a.Message})
 }
 
-func handleSaveSettings(w http.ResponseWriter, r *http.Request) {
-	if r.FormValue("ha_enabled") == "1" {
-		setSetting("ha_enabled", "1")
-	} else {
-		setSetting("ha_enabled", "0")
+// handleAnnounceTest sends a sample announcement through the family's
+// backends and reports each one's result.
+func handleAnnounceTest(w http.ResponseWriter, r *http.Request) {
+	a, err := announcementFromForm(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
 	}
-	setSetting("ha_url", r.FormValue("ha_url"))
-	setSetting("ha_token", r.FormValue("ha_token"))
-	setSetting("ha_media_player", r.FormValue("ha_media_player"))
-	setSetting("ha_lang", r.FormValue("ha_lang"))
-	http.Redirect(w, r, "/admin", http.StatusSeeOther)
-}
-
-func handleToggleAnnounce(w http.ResponseWriter, r *http.Request) {
-	current := getSetting("ha_enabled")
-	if current == "1" {
-		setSetting("ha_enabled", "0")
-	} else {
-		setSetting("ha_enabled", "1")
+	results := testAnnouncement(getContextFamilyID(r), a)
+	if len(results) == 0 {
+		http.Error(w, localize(r, "no_announcers_for_event"), http.StatusBadRequest)
+		return
 	}
-	jsonResponse(w, map[string]string{"ha_enabled": getSetting("ha_enabled")})
+	jsonResponse(w, map[string]interface{}{"message": a.Message, "results": results})
 }
 
 func handleExport(w http.ResponseWriter, r *http.Request) {
-	data, err := exportAllData()
+	data, err := exportAllData(getContextFamilyID(r))
 	if err != nil {
-		http.Error(w, "Failed to export data: "+err.Error(), http.StatusInternalServerError)
+		http.Error(w, localize(r, "failed_export")+": "+err.Error(), http.StatusInternalServerError)
 		return
 	}
 
+	recordAudit(r, "data.export", "data", 0, "", nil, nil)
 	w.Header().Set("Content-Type", "application/json")
 	w.Header().Set("Content-Disposition", "attachment; filename=star-app-export.json")
 	json.NewEncoder(w).Encode(data)
@@ -729,32 +2474,67 @@ func handleExport(w http.ResponseWriter, r *http.Request) {
 func handleImport(w http.ResponseWriter, r *http.Request) {
 	file, _, err := r.FormFile("file")
 	if err != nil {
-		http.Error(w, "Failed to read file", http.StatusBadRequest)
+		http.Error(w, localize(r, "failed_read_file"), http.StatusBadRequest)
 		return
 	}
 	defer file.Close()
 
 	var data map[string]interface{}
 	if err := json.NewDecoder(file).Decode(&data); err != nil {
-		http.Error(w, "Invalid JSON file", http.StatusBadRequest)
+		http.Error(w, localize(r, "invalid_json_file"), http.StatusBadRequest)
 		return
 	}
 
-	if err := importAllData(data); err != nil {
-		http.Error(w, "Failed to import data: "+err.Error(), http.StatusInternalServerError)
+	familyID := getContextFamilyID(r)
+	before := getDataSummary(familyID)
+	if err := importAllData(familyID, data); err != nil {
+		if errors.Is(err, errImportPending) {
+			http.Error(w, localize(r, "import_pending"), http.StatusConflict)
+			return
+		}
+		http.Error(w, localize(r, "failed_import")+": "+err.Error(), http.StatusInternalServerError)
 		return
 	}
+	recordAudit(r, "data.import", "data", 0, "", before, getDataSummary(familyID))
+	// The imported history changes every kid's balance
+	if users, err := getAllUsers(familyID); err == nil {
+		for i := range users {
+			mqttPublishUser(&users[i])
+		}
+	}
 
 	http.Redirect(w, r, "/admin", http.StatusSeeOther)
 }
 
 // API handlers
 
+// apiFilterUser resolves the optional ?user= filter to a user ID (0 for none),
+// rejecting users the API key is not allowed to see.
+func apiFilterUser(w http.ResponseWriter, r *http.Request) (int, bool) {
+	username := r.URL.Query().Get("user")
+	if username == "" {
+		return 0, true
+	}
+	user, err := getFamilyUser(r, username)
+	if err != nil {
+		jsonError(w, localize(r, "user_not_found"), http.StatusBadRequest)
+		return 0, false
+	}
+	if !apiKeyAllowsUser(r, user.ID) {
+		jsonError(w, localize(r, "api_key_user_not_allowed"), http.StatusForbidden)
+		return 0, false
+	}
+	return user.ID, true
+}
+
 func handleAPIGetStars(w http.ResponseWriter, r *http.Request) {
+	if _, ok := apiFilterUser(w, r); !ok {
+		return
+	}
 	username := r.URL.Query().Get("user")
-	stars, err := getStars(username)
+	stars, err := getStars(getContextFamilyID(r), username)
 	if err != nil {
-		jsonError(w, "failed to get stars", http.StatusInternalServerError)
+		jsonError(w, localize(r, "failed_get_stars"), http.StatusInternalServerError)
 		return
 	}
 	if stars == nil {
@@ -763,80 +2543,113 @@ func handleAPIGetStars(w http.ResponseWriter, r *http.Request) {
 
 	// Enrich with reason translations (consistent with web dashboard)
 	type APIStar struct {
-		ID              int       `json:"id"`
-		UserID          int       `json:"user_id"`
-		Username        string    `json:"username"`
-		UsernameEN      string    `json:"username_en"`
-		UsernameCN      string    `json:"username_cn"`
-		UsernameTW      string    `json:"username_tw"`
-		ReasonID        *int      `json:"reason_id"`
-		ReasonText      string    `json:"reason_text"`
-		ReasonEN        string    `json:"reason_en"`
-		ReasonCN        string    `json:"reason_cn"`
-		ReasonTW        string    `json:"reason_tw"`
-		Stars           int       `json:"stars"`
-		AwardedBy       int       `json:"awarded_by"`
-		AwardedByName   string    `json:"awarded_by_name"`
-		AwardedByNameEN string    `json:"awarded_by_name_en"`
-		AwardedByNameCN string    `json:"awarded_by_name_cn"`
-		AwardedByNameTW string    `json:"awarded_by_name_tw"`
-		CreatedAt       time.Time `json:"created_at"`
+		ID                        int               `json:"id"`
+		UserID                    int               `json:"user_id"`
+		Username                  string            `json:"username"`
+		UsernameTranslations      map[string]string `json:"username_translations"`
+		ReasonID                  *int              `json:"reason_id"`
+		ReasonText                string            `json:"reason_text"`
+		ReasonTranslations        map[string]string `json:"reason_translations"`
+		Stars                     int               `json:"stars"`
+		Currency                  string            `json:"currency"`
+		AwardedBy                 int               `json:"awarded_by"`
+		AwardedByName             string            `json:"awarded_by_name"`
+		AwardedByNameTranslations map[string]string `json:"awarded_by_name_translations"`
+		CreatedAt                 time.Time         `json:"created_at"`
 	}
 	result := make([]APIStar, 0, len(stars))
 	for _, s := range stars {
+		if !apiKeyAllowsUser(r, s.UserID) {
+			continue
+		}
 		result = append(result, APIStar{
-			ID:              s.ID,
-			UserID:          s.UserID,
-			Username:        s.Username,
-			UsernameEN:      s.UsernameEN,
-			UsernameCN:      s.UsernameCN,
-			UsernameTW:      s.UsernameTW,
-			ReasonID:        s.ReasonID,
-			ReasonText:      s.ReasonText,
-			ReasonEN:        getReasonText(s.ReasonID, s.ReasonText, "en"),
-			ReasonCN:        getReasonText(s.ReasonID, s.ReasonText, "zh-CN"),
-			ReasonTW:        getReasonText(s.ReasonID, s.ReasonText, "zh-TW"),
-			Stars:           s.Stars,
-			AwardedBy:       s.AwardedBy,
-			AwardedByName:   s.AwardedByName,
-			AwardedByNameEN: s.AwardedByNameEN,
-			AwardedByNameCN: s.AwardedByNameCN,
-			AwardedByNameTW: s.AwardedByNameTW,
-			CreatedAt:       s.CreatedAt,
+			ID:                        s.ID,
+			UserID:                    s.UserID,
+			Username:                  s.Username,
+			UsernameTranslations:      s.UsernameTranslations,
+			ReasonID:                  s.ReasonID,
+			ReasonText:                s.ReasonText,
+			ReasonTranslations:        getReasonTexts(s.ReasonID, s.ReasonText),
+			Stars:                     s.Stars,
+			Currency:                  currencyKey(s.CurrencyID),
+			AwardedBy:                 s.AwardedBy,
+			AwardedByName:             s.AwardedByName,
+			AwardedByNameTranslations: s.AwardedByTranslations,
+			CreatedAt:                 s.CreatedAt,
 		})
 	}
 	jsonResponse(w, result)
 }
 
+// awardIntegrationStar records a star requested by an integration (REST API or
+// MQTT command) and publishes, announces and checks goals like a web award.
+// currencyID only applies when reason creates a new reason.
+func awardIntegrationStar(username string, reasonID *int, reason string, currencyID, stars, awardedBy int) (int64, error) {
+	starID, err := addStarWithID(username, reasonID, reason, currencyID, stars, awardedBy)
+	if err != nil {
+		return 0, err
+	}
+	publishStarAwarded(starID)
+
+	actualStars := stars
+	if star, err := getStarByID(int(starID)); err == nil {
+		actualStars, currencyID = star.Stars, star.CurrencyID
+	}
+	announceStarIfEnabled(username, reasonID, reason, actualStars, currencyID)
+	checkGoalReached(username)
+	return starID, nil
+}
+
 func handleAPIAddStar(w http.ResponseWriter, r *http.Request) {
 	var req struct {
-		Username string `json:"username"`
-		ReasonID *int   `json:"reason_id"`
-		Reason   string `json:"reason"`
-		Stars    int    `json:"stars"`
+		Username  string `json:"username"`
+		ReasonID  *int   `json:"reason_id"`
+		ReasonKey string `json:"reason_key"`
+		Reason    string `json:"reason"`
+		Currency  string `json:"currency"`
+		Stars     int    `json:"stars"`
 	}
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		jsonError(w, "invalid JSON", http.StatusBadRequest)
+		jsonError(w, localize(r, "invalid_json"), http.StatusBadRequest)
 		return
 	}
+	if req.ReasonID == nil && req.ReasonKey != "" {
+		reasonID, err := getReasonIDByKey(getContextFamilyID(r), req.ReasonKey)
+		if err != nil {
+			jsonError(w, localize(r, "reason_not_found"), http.StatusBadRequest)
+			return
+		}
+		req.ReasonID = &reasonID
+	}
 	if req.Username == "" || (req.ReasonID == nil && req.Reason == "") {
-		jsonError(w, "username and reason (or reason_id) required", http.StatusBadRequest)
+		jsonError(w, localize(r, "username_reason_id_required"), http.StatusBadRequest)
+		return
+	}
+	currencyID, err := getCurrencyIDByKey(getContextFamilyID(r), req.Currency)
+	if err != nil {
+		jsonError(w, localize(r, "currency_not_found_named", "currency", req.Currency), http.StatusBadRequest)
 		return
 	}
 
-	starID, err := addStarWithID(req.Username, req.ReasonID, req.Reason, req.Stars, 0)
+	target, err := getFamilyUser(r, req.Username)
 	if err != nil {
-		jsonError(w, err.Error(), http.StatusBadRequest)
+		jsonError(w, localize(r, "user_not_found_named", "username", req.Username), http.StatusBadRequest)
+		return
+	}
+	if !apiKeyAllowsUser(r, target.ID) {
+		jsonError(w, localize(r, "api_key_user_not_allowed"), http.StatusForbidden)
 		return
 	}
 
-	actualStars := req.Stars
-	if actualStars == 0 {
-		actualStars = 1
+	// Attribute the award to the parent who owns the key
+	starID, err := awardIntegrationStar(req.Username, req.ReasonID, req.Reason, currencyID, req.Stars, apiKeyAwarder(r))
+	if err != nil {
+		jsonError(w, err.Error(), http.StatusBadRequest)
+		return
 	}
-	announceStarIfEnabled(req.Username, req.ReasonID, req.Reason, actualStars)
+	auditStarAward(r, req.Username, starID)
 
-	counts, _ := getUserStarCounts()
+	counts, _ := getUserStarCounts(getContextFamilyID(r))
 	jsonResponse(w, map[string]interface{}{
 		"status": "ok",
 		"counts": counts,
@@ -844,19 +2657,90 @@ func handleAPIAddStar(w http.ResponseWriter, r *http.Request) {
 	})
 }
 
+func handleAPIRedeem(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Username  string `json:"username"`
+		RewardID  int    `json:"reward_id"`
+		RewardKey string `json:"reward_key"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		jsonError(w, localize(r, "invalid_json"), http.StatusBadRequest)
+		return
+	}
+	if req.RewardID == 0 && req.RewardKey != "" {
+		reward, err := getFamilyRewardByKey(r, req.RewardKey)
+		if err != nil {
+			jsonError(w, localize(r, "reward_not_found"), http.StatusBadRequest)
+			return
+		}
+		req.RewardID = reward.ID
+	}
+	if req.Username == "" || req.RewardID == 0 {
+		jsonError(w, localize(r, "username_reward_required"), http.StatusBadRequest)
+		return
+	}
+
+	user, err := getFamilyUser(r, req.Username)
+	if err != nil {
+		jsonError(w, localize(r, "user_not_found_named", "username", req.Username), http.StatusBadRequest)
+		return
+	}
+	if !apiKeyAllowsUser(r, user.ID) {
+		jsonError(w, localize(r, "api_key_user_not_allowed"), http.StatusForbidden)
+		return
+	}
+	reward, err := getFamilyReward(r, req.RewardID)
+	if err != nil {
+		jsonError(w, localize(r, "reward_not_found"), http.StatusBadRequest)
+		return
+	}
+
+	redemptionID, err := redeemReward(user.ID, reward.ID, apiKeyAwarder(r))
+	var notEnough *notEnoughError
+	if errors.As(err, &notEnough) {
+		jsonError(w, notEnoughBalance(r, user.Username, reward, notEnough.Available), http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		jsonError(w, localize(r, "failed_redeem"), http.StatusInternalServerError)
+		return
+	}
+	recordAudit(r, "redemption.create", "user", user.ID, user.Username, nil, map[string]interface{}{
+		"redemption_id": redemptionID,
+		"reward":        reward.Key,
+		"cost":          reward.Cost,
+	})
+	clearGoalIfRedeemed(user.ID, reward.ID)
+	publishRewardRedeemed(redemptionID)
+	announceRedemptionIfEnabled(user.Username, reward.ID, user.IsAdmin)
+
+	counts, _ := getUserStarCounts(getContextFamilyID(r))
+	jsonResponse(w, map[string]interface{}{
+		"status":       "ok",
+		"counts":       counts,
+		"redemptionId": redemptionID,
+	})
+}
+
 func handleAPIGetUsers(w http.ResponseWriter, r *http.Request) {
-	counts, err := getUserStarCounts()
+	counts, err := getUserStarCounts(getContextFamilyID(r))
 	if err != nil {
-		jsonError(w, "failed to get users", http.StatusInternalServerError)
+		jsonError(w, localize(r, "failed_get_users"), http.StatusInternalServerError)
 		return
 	}
-	jsonResponse(w, counts)
+	visible := make([]UserStarCount, 0, len(counts))
+	for _, c := range counts {
+		if apiKeyAllowsUser(r, c.UserID) {
+			visible = append(visible, c)
+		}
+	}
+	jsonResponse(w, visible)
 }
 
 func handleAPIGetReasons(w http.ResponseWriter, r *http.Request) {
-	reasons, err := getReasons()
+	reasons, err := getReasons(getContextFamilyID(r))
 	if err != nil {
-		jsonError(w, "failed to get reasons", http.StatusInternalServerError)
+		jsonError(w, localize(r, "failed_get_reasons"), http.StatusInternalServerError)
 		return
 	}
 	if reasons == nil {
@@ -866,9 +2750,9 @@ func handleAPIGetReasons(w http.ResponseWriter, r *http.Request) {
 }
 
 func handleAPIGetRewards(w http.ResponseWriter, r *http.Request) {
-	rewards, err := getRewardsList()
+	rewards, err := getRewardsList(getContextFamilyID(r))
 	if err != nil {
-		jsonError(w, "failed to get rewards", http.StatusInternalServerError)
+		jsonError(w, localize(r, "failed_get_rewards"), http.StatusInternalServerError)
 		return
 	}
 	if rewards == nil {
@@ -877,26 +2761,90 @@ func handleAPIGetRewards(w http.ResponseWriter, r *http.Request) {
 	jsonResponse(w, rewards)
 }
 
+func handleAPIGetCurrencies(w http.ResponseWriter, r *http.Request) {
+	currencies, err := getCurrencies(getContextFamilyID(r))
+	if err != nil {
+		jsonError(w, localize(r, "failed_get_currencies"), http.StatusInternalServerError)
+		return
+	}
+	if currencies == nil {
+		currencies = []Currency{}
+	}
+	jsonResponse(w, currencies)
+}
+
 func handleAPIGetRedemptions(w http.ResponseWriter, r *http.Request) {
-	username := r.URL.Query().Get("user")
-	filterUserID := 0
-	if username != "" {
-		user, err := getUserByUsername(username)
-		if err != nil {
-			jsonError(w, "user not found", http.StatusBadRequest)
+	filterUserID, ok := apiFilterUser(w, r)
+	if !ok {
+		return
+	}
+	redemptions, err := getRecentRedemptions(getContextFamilyID(r), 10000, filterUserID)
+	if err != nil {
+		jsonError(w, localize(r, "failed_get_redemptions"), http.StatusInternalServerError)
+		return
+	}
+	visible := make([]Redemption, 0, len(redemptions))
+	for _, rd := range redemptions {
+		if apiKeyAllowsUser(r, rd.UserID) {
+			visible = append(visible, rd)
+		}
+	}
+	jsonResponse(w, visible)
+}
+
+func handleAPIGetLedger(w http.ResponseWriter, r *http.Request) {
+	filterUserID, ok := apiFilterUser(w, r)
+	if !ok {
+		return
+	}
+	limit := 100
+	if limitStr := r.URL.Query().Get("limit"); limitStr != "" {
+		n, err := strconv.Atoi(limitStr)
+		if err != nil || n < 1 {
+			jsonError(w, localize(r, "invalid_limit"), http.StatusBadRequest)
 			return
 		}
-		filterUserID = user.ID
+		limit = n
+	}
+	entries, err := getLedgerEntries(getContextFamilyID(r), filterUserID, limit)
+	if err != nil {
+		jsonError(w, localize(r, "failed_get_ledger"), http.StatusInternalServerError)
+		return
+	}
+	visible := make([]LedgerEntry, 0, len(entries))
+	for _, e := range entries {
+		if apiKeyAllowsUser(r, e.UserID) {
+			visible = append(visible, e)
+		}
+	}
+	jsonResponse(w, visible)
+}
+
+func handleAPIGetAudit(w http.ResponseWriter, r *http.Request) {
+	filter, err := auditFilterFromQuery(r)
+	if err != nil {
+		jsonError(w, err.Error(), http.StatusBadRequest)
+		return
 	}
-	redemptions, err := getRecentRedemptions(10000, filterUserID)
+	entries, err := getAuditEntries(filter)
 	if err != nil {
-		jsonError(w, "failed to get redemptions", http.StatusInternalServerError)
+		jsonError(w, localize(r, "failed_get_audit"), http.StatusInternalServerError)
 		return
 	}
-	if redemptions == nil {
-		redemptions = []Redemption{}
+	if entries == nil {
+		entries = []AuditEntry{}
+	}
+	jsonResponse(w, entries)
+}
+
+// renderPage renders a page template in the request's language.
+func renderPage(w http.ResponseWriter, r *http.Request, page string, data map[string]interface{}) {
+	if data == nil {
+		data = map[string]interface{}{}
 	}
-	jsonResponse(w, redemptions)
+	data["Lang"] = requestLanguage(r)
+	data["CSRFToken"] = csrfToken(sessionToken(r))
+	templates[page].ExecuteTemplate(w, page, data)
 }
 
 func jsonResponse(w http.ResponseWriter, data interface{}) {
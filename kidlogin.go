@@ -0,0 +1,203 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Kids a parent has set up can log in by tapping their tile on the login page
+// and entering a short PIN or a sequence of pictures. Either is kept as a
+// bcrypt hash of digits: a picture sequence is the numbers of its pictures.
+//
+// The tiles only show on devices a parent has turned kid login on for, such as
+// the family tablet, and only with that family's kids: the device keeps a
+// random token in a cookie, stored hashed like API keys.
+const (
+	loginModePIN     = "pin"
+	loginModePicture = "picture"
+
+	kidLoginCookie         = "kid_login_device"
+	kidLoginDeviceLifetime = 400 * 24 * time.Hour
+)
+
+// loginPictures are the pictures of a picture sequence, numbered from 1.
+var loginPictures = []string{"🐶", "🐱", "🐰", "🦊", "🐸", "🐼", "🦁", "🐵", "🐧"}
+
+// validLoginPIN reports whether pin will do for mode: 4 to 8 digits, or 3 to
+// 6 pictures.
+func validLoginPIN(mode, pin string) bool {
+	minLen, maxLen, digits := 4, 8, "0123456789"
+	if mode == loginModePicture {
+		minLen, maxLen, digits = 3, 6, "123456789"[:len(loginPictures)]
+	}
+	if len(pin) < minLen || len(pin) > maxLen {
+		return false
+	}
+	for _, c := range pin {
+		if !strings.ContainsRune(digits, c) {
+			return false
+		}
+	}
+	return true
+}
+
+// loginMode is how a user logs in besides their password, "" for not at all.
+// Parents always use their password.
+func loginMode(u *User) string {
+	if u.IsAdmin {
+		return ""
+	}
+	switch mode := getUserSetting(u.ID, "login_mode"); mode {
+	case loginModePIN, loginModePicture:
+		return mode
+	}
+	return ""
+}
+
+// setLoginPIN turns a kid's PIN login on with pin, or off if mode is "".
+func setLoginPIN(userID int, mode, pin string) error {
+	if mode == "" {
+		if err := setUserSetting(userID, "login_mode", ""); err != nil {
+			return err
+		}
+		return setUserSetting(userID, "login_pin", "")
+	}
+	hash, err := bcrypt.GenerateFromPassword([]byte(pin), bcrypt.DefaultCost)
+	if err != nil {
+		return err
+	}
+	if err := setUserSetting(userID, "login_pin", string(hash)); err != nil {
+		return err
+	}
+	return setUserSetting(userID, "login_mode", mode)
+}
+
+func checkLoginPIN(u *User, pin string) bool {
+	hash := getUserSetting(u.ID, "login_pin")
+	return loginMode(u) != "" && hash != "" && bcrypt.CompareHashAndPassword([]byte(hash), []byte(pin)) == nil
+}
+
+// padKey is a key of the pad a PIN or picture sequence is entered on.
+type padKey struct {
+	Value string
+	Label string
+}
+
+// pinPadKeys lays out the pad for mode: the pictures, or the digits 1 to 9
+// and 0.
+func pinPadKeys(mode string) []padKey {
+	var keys []padKey
+	if mode == loginModePicture {
+		for i, picture := range loginPictures {
+			keys = append(keys, padKey{strconv.Itoa(i + 1), picture})
+		}
+		return keys
+	}
+	for _, d := range "1234567890" {
+		keys = append(keys, padKey{string(d), string(d)})
+	}
+	return keys
+}
+
+// kidTile is a kid shown on the login page.
+type kidTile struct {
+	Username     string
+	Translations map[string]string
+	Mode         string
+}
+
+// Initial is the letter shown on the kid's tile.
+func (t kidTile) Initial() string {
+	for _, c := range t.Username {
+		return strings.ToUpper(string(c))
+	}
+	return ""
+}
+
+// kidLoginDeviceToken is the kid login token this browser sent, "" if none.
+func kidLoginDeviceToken(r *http.Request) string {
+	if c, err := r.Cookie(kidLoginCookie); err == nil {
+		return c.Value
+	}
+	return ""
+}
+
+// kidLoginFamily returns the family whose kids can log in on this device, 0
+// if kid login isn't on here.
+func kidLoginFamily(r *http.Request) int {
+	token := kidLoginDeviceToken(r)
+	if token == "" {
+		return 0
+	}
+	familyID, err := getKidLoginDeviceFamily(hashAPIKey(token))
+	if err != nil {
+		return 0
+	}
+	return familyID
+}
+
+// setKidLoginCookie gives the browser its kid login token, or clears it when
+// token is "".
+func setKidLoginCookie(w http.ResponseWriter, r *http.Request, token string) {
+	maxAge := int(kidLoginDeviceLifetime / time.Second)
+	if token == "" {
+		maxAge = -1
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name:     kidLoginCookie,
+		Value:    token,
+		Path:     "/",
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+		Secure:   sessionCookieSecure(r),
+		MaxAge:   maxAge,
+	})
+}
+
+// kidLoginTiles lists the kids of familyID who can log in with a PIN.
+func kidLoginTiles(familyID int) []kidTile {
+	if familyID == 0 {
+		return nil
+	}
+	ids, _ := getPINLoginUserIDs(familyID)
+	var tiles []kidTile
+	for _, id := range ids {
+		u, err := getUserByID(id)
+		if err != nil {
+			continue
+		}
+		if mode := loginMode(u); mode != "" {
+			tiles = append(tiles, kidTile{u.Username, u.Translations, mode})
+		}
+	}
+	return tiles
+}
+
+// kidLoginView is a kid's PIN login setting for the admin page.
+type kidLoginView struct {
+	UserID   int
+	Username string
+	Mode     string
+}
+
+func kidLoginViews(users []User) []kidLoginView {
+	var views []kidLoginView
+	for i := range users {
+		if !users[i].IsAdmin {
+			views = append(views, kidLoginView{users[i].ID, users[i].Username, loginMode(&users[i])})
+		}
+	}
+	return views
+}
+
+// kidLoginSnapshot describes a kid's PIN login for the audit log, without the PIN.
+func kidLoginSnapshot(u *User) map[string]interface{} {
+	return map[string]interface{}{
+		"login_mode": loginMode(u),
+		"pin_set":    getUserSetting(u.ID, "login_pin") != "",
+	}
+}
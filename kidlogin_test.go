@@ -0,0 +1,51 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestKidLoginTilesPerDevice(t *testing.T) {
+	openTestDB(t)
+	theo, err := getUserByUsername("theo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := setLoginPIN(theo.ID, loginModePIN, "1234"); err != nil {
+		t.Fatal(err)
+	}
+	otherFamily, err := createFamily("Other", "other-parent", "test-password")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addKidLoginDevice(hashAPIKey("home-tablet"), theo.FamilyID)
+	addKidLoginDevice(hashAPIKey("other-tablet"), otherFamily)
+
+	tests := []struct {
+		device string
+		want   []string
+	}{
+		{"", nil},
+		{"unknown", nil},
+		{"home-tablet", []string{"theo"}},
+		{"other-tablet", nil},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest("GET", "/login?kid=theo", nil)
+		if tt.device != "" {
+			r.AddCookie(&http.Cookie{Name: kidLoginCookie, Value: tt.device})
+		}
+		data := loginPageData(r, "theo")
+		var got []string
+		for _, tile := range data["Kids"].([]kidTile) {
+			got = append(got, tile.Username)
+		}
+		if len(got) != len(tt.want) || (len(got) == 1 && got[0] != tt.want[0]) {
+			t.Errorf("device %q shows %v, want %v", tt.device, got, tt.want)
+		}
+		if _, open := data["Kid"]; open != (tt.want != nil) {
+			t.Errorf("device %q opens theo's PIN pad = %v", tt.device, open)
+		}
+	}
+}
@@ -0,0 +1,343 @@
+package main
+
+import (
+	"embed"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Each file in languages/ registers one language: its UI string catalog, the
+// sentences announcements are built from and the words numbers are spoken
+// as. Adding a language is adding a file; missing strings fall back to English.
+//
+//go:embed languages/*.json
+var languageFS embed.FS
+
+// defaultLanguage is the fallback for anything a language leaves out.
+const defaultLanguage = "en"
+
+// langCookie remembers the switcher's choice for visitors who aren't logged in.
+const langCookie = "lang"
+
+// Language is one language the app can be shown and announced in.
+type Language struct {
+	Code        string            `json:"code"`         // BCP 47 tag, e.g. "zh-CN"
+	Name        string            `json:"name"`         // native name, shown in pickers
+	Label       string            `json:"label"`        // short label for the language switcher
+	Locale      string            `json:"locale"`       // locale dates are formatted in
+	Aliases     []string          `json:"aliases"`      // other tags browsers send for it, e.g. "zh-Hant"
+	Numbers     string            `json:"numbers"`      // how counts are spelled out, see numberSpellers
+	NumberWords []string          `json:"number_words"` // words for 0, 1, 2, … that replace the spelled-out ones
+	Announce    AnnounceTexts     `json:"announce"`
+	UI          map[string]string `json:"ui"`
+	Messages    map[string]string `json:"messages"` // server-side messages, such as errors
+}
+
+// AnnounceTexts are the sentences announcements are built from. {name},
+// {reason}, {count}, {unit}, {reward} and {currency} are filled in.
+type AnnounceTexts struct {
+	Award        string `json:"award"`
+	Penalty      string `json:"penalty"`
+	Redemption   string `json:"redemption"`
+	Goal         string `json:"goal"`
+	Stars        string `json:"stars"`         // name of the built-in currency
+	Star         string `json:"star"`          // the same after a count of one, if the language says it differently
+	StarUnit     string `json:"star_unit"`     // counted stars: {currency} plus any measure word
+	CurrencyUnit string `json:"currency_unit"` // the same for other currencies
+	And          string `json:"and"`           // joins the last two names of a batch: {first} and {last}
+	List         string `json:"list"`          // joins the others: {first}, {rest}
+}
+
+var (
+	languages = map[string]*Language{}
+	// languageTags maps lower-cased codes and aliases to codes
+	languageTags  = map[string]string{}
+	languageOrder []*Language
+	// languageScript is the registry as served to the browser
+	languageScript []byte
+)
+
+// loadLanguages reads the embedded language files. English must be present,
+// since every other language falls back to it.
+func loadLanguages() error {
+	files, err := languageFS.ReadDir("languages")
+	if err != nil {
+		return err
+	}
+	for _, f := range files {
+		data, err := languageFS.ReadFile(path.Join("languages", f.Name()))
+		if err != nil {
+			return err
+		}
+		lang := &Language{}
+		if err := json.Unmarshal(data, lang); err != nil {
+			return fmt.Errorf("%s: %w", f.Name(), err)
+		}
+		if lang.Code == "" {
+			return fmt.Errorf("%s: missing code", f.Name())
+		}
+		if _, ok := numberSpellers[lang.Numbers]; lang.Numbers != "" && !ok {
+			return fmt.Errorf("%s: unknown numbers %q", f.Name(), lang.Numbers)
+		}
+		languages[lang.Code] = lang
+		languageOrder = append(languageOrder, lang)
+		languageTags[strings.ToLower(lang.Code)] = lang.Code
+		for _, alias := range lang.Aliases {
+			languageTags[strings.ToLower(alias)] = lang.Code
+		}
+	}
+
+	en, ok := languages[defaultLanguage]
+	if !ok {
+		return fmt.Errorf("languages/%s.json is required", defaultLanguage)
+	}
+	for _, lang := range languageOrder {
+		if lang.Name == "" {
+			lang.Name = lang.Code
+		}
+		if lang.Label == "" {
+			lang.Label = lang.Code
+		}
+		if lang.Locale == "" {
+			lang.Locale = lang.Code
+		}
+		if lang.UI == nil {
+			lang.UI = map[string]string{}
+		}
+		if lang.Messages == nil {
+			lang.Messages = map[string]string{}
+		}
+		fillCatalog(lang.UI, en.UI)
+		fillCatalog(lang.Messages, en.Messages)
+		fillAnnounceTexts(&lang.Announce, en.Announce)
+	}
+	// English first, then the rest by code
+	sort.SliceStable(languageOrder, func(i, j int) bool {
+		if languageOrder[i].Code == defaultLanguage || languageOrder[j].Code == defaultLanguage {
+			return languageOrder[i].Code == defaultLanguage
+		}
+		return languageOrder[i].Code < languageOrder[j].Code
+	})
+
+	return buildLanguageScript()
+}
+
+func fillCatalog(catalog, en map[string]string) {
+	for key, text := range en {
+		if catalog[key] == "" {
+			catalog[key] = text
+		}
+	}
+}
+
+func fillAnnounceTexts(t *AnnounceTexts, en AnnounceTexts) {
+	fill := func(s *string, fallback string) {
+		if *s == "" {
+			*s = fallback
+		}
+	}
+	fill(&t.Award, en.Award)
+	fill(&t.Penalty, en.Penalty)
+	fill(&t.Redemption, en.Redemption)
+	fill(&t.Goal, en.Goal)
+	fill(&t.Stars, en.Stars)
+	fill(&t.Star, t.Stars)
+	fill(&t.StarUnit, en.StarUnit)
+	fill(&t.CurrencyUnit, en.CurrencyUnit)
+	fill(&t.And, en.And)
+	fill(&t.List, en.List)
+}
+
+// buildLanguageScript renders the languages and their UI catalogs as the
+// script the pages load before i18n.js.
+func buildLanguageScript() error {
+	type scriptLanguage struct {
+		Code   string `json:"code"`
+		Name   string `json:"name"`
+		Label  string `json:"label"`
+		Locale string `json:"locale"`
+	}
+	list := make([]scriptLanguage, 0, len(languageOrder))
+	catalogs := make(map[string]map[string]string, len(languageOrder))
+	for _, lang := range languageOrder {
+		list = append(list, scriptLanguage{lang.Code, lang.Name, lang.Label, lang.Locale})
+		catalogs[lang.Code] = lang.UI
+	}
+	listJSON, err := json.Marshal(list)
+	if err != nil {
+		return err
+	}
+	catalogJSON, err := json.Marshal(catalogs)
+	if err != nil {
+		return err
+	}
+	languageScript = []byte(fmt.Sprintf("var languages = %s;\nvar translations = %s;\n", listJSON, catalogJSON))
+	return nil
+}
+
+// getLanguages lists the registered languages, English first.
+func getLanguages() []*Language {
+	return languageOrder
+}
+
+// getLanguage returns a registered language, or English for unknown codes.
+func getLanguage(code string) *Language {
+	if lang, ok := languages[code]; ok {
+		return lang
+	}
+	return languages[defaultLanguage]
+}
+
+func validLanguage(code string) bool {
+	_, ok := languages[code]
+	return ok
+}
+
+// Text returns the UI string or server message for key.
+func (l *Language) Text(key string) string {
+	if text, ok := l.UI[key]; ok {
+		return text
+	}
+	if text, ok := l.Messages[key]; ok {
+		return text
+	}
+	return key
+}
+
+// requestLanguage picks the language to answer a request in: the user's saved
+// preference, then the switcher's cookie, then Accept-Language. Requests made
+// with an API key only go by Accept-Language, so integrations get English
+// unless they ask otherwise.
+func requestLanguage(r *http.Request) string {
+	if getContextAPIKey(r) == nil {
+		if user := getContextUser(r); user != nil && validLanguage(user.Lang) {
+			return user.Lang
+		}
+		if c, err := r.Cookie(langCookie); err == nil && validLanguage(c.Value) {
+			return c.Value
+		}
+	}
+	return matchAcceptLanguage(r.Header.Get("Accept-Language"))
+}
+
+// matchAcceptLanguage returns the registered language an Accept-Language
+// header prefers most. Tags are shortened until they match, so "es-MX" gets
+// Spanish and "zh-Hant-HK" whatever claims "zh-Hant".
+func matchAcceptLanguage(header string) string {
+	type accepted struct {
+		tag string
+		q   float64
+	}
+	var tags []accepted
+	for _, part := range strings.Split(header, ",") {
+		tag, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		q := 1.0
+		if v, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			parsed, err := strconv.ParseFloat(v, 64)
+			if err != nil {
+				continue
+			}
+			q = parsed
+		}
+		if tag == "" || tag == "*" || q <= 0 {
+			continue
+		}
+		tags = append(tags, accepted{strings.ToLower(tag), q})
+	}
+	sort.SliceStable(tags, func(i, j int) bool { return tags[i].q > tags[j].q })
+
+	for _, a := range tags {
+		for tag := a.tag; tag != ""; {
+			if code, ok := languageTags[tag]; ok {
+				return code
+			}
+			i := strings.LastIndex(tag, "-")
+			if i < 0 {
+				break
+			}
+			tag = tag[:i]
+		}
+	}
+	return defaultLanguage
+}
+
+// localize returns the message for key in the request's language, with
+// placeholders filled from pairs of name and value.
+func localize(r *http.Request, key string, pairs ...string) string {
+	text := getLanguage(requestLanguage(r)).Text(key)
+	if len(pairs) == 0 {
+		return text
+	}
+	values := make(map[string]string, len(pairs)/2)
+	for i := 0; i+1 < len(pairs); i += 2 {
+		values[pairs[i]] = pairs[i+1]
+	}
+	return fillPlaceholders(text, values)
+}
+
+// textIn picks lang from stored translations, falling back to English.
+func textIn(stored map[string]string, lang string) string {
+	if text := stored[lang]; text != "" {
+		return text
+	}
+	return stored[defaultLanguage]
+}
+
+// translate is the t template function: a UI string in lang.
+func translate(lang, key string) string {
+	return getLanguage(lang).Text(key)
+}
+
+// resolveTranslations fills in every registered language from stored texts,
+// falling back to English and then to fallback.
+func resolveTranslations(stored map[string]string, fallback string) map[string]string {
+	if en := stored[defaultLanguage]; en != "" {
+		fallback = en
+	}
+	resolved := make(map[string]string, len(languageOrder))
+	for _, lang := range languageOrder {
+		if text := stored[lang.Code]; text != "" {
+			resolved[lang.Code] = text
+		} else {
+			resolved[lang.Code] = fallback
+		}
+	}
+	return resolved
+}
+
+// fillPlaceholders replaces each {key} in text with its value.
+func fillPlaceholders(text string, values map[string]string) string {
+	pairs := make([]string, 0, len(values)*2)
+	for key, value := range values {
+		pairs = append(pairs, "{"+key+"}", value)
+	}
+	return strings.NewReplacer(pairs...).Replace(text)
+}
+
+// handleLanguageScript serves the registered languages and their UI catalogs.
+func handleLanguageScript(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/javascript; charset=utf-8")
+	w.Write(languageScript)
+}
+
+// translationsAttr encodes a text's translations for a data-tr attribute,
+// which i18n.js reads when switching language.
+func translationsAttr(stored map[string]string, fallback ...string) string {
+	fb := ""
+	if len(fallback) > 0 {
+		fb = fallback[0]
+	}
+	data, _ := json.Marshal(resolveTranslations(stored, fb))
+	return string(data)
+}
+
+// langColumns counts the columns of a table with one column per language
+// alongside others, for colspans.
+func langColumns(others int) int {
+	return others + len(languageOrder)
+}
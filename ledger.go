@@ -0,0 +1,503 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// Balances come from an append-only ledger. Every award, penalty, redemption,
+// reversal and adjustment is written as an entry carrying the running balance
+// (stars available to spend) and running earned total (lifetime stars), so the
+// latest entry for a user is their current state. Each currency keeps its own
+// running totals.
+//
+// ledgerMu serialises writers so running totals are computed from the true
+// previous entry.
+var ledgerMu sync.Mutex
+
+// Ledger entry kinds
+const (
+	ledgerAward      = "award"
+	ledgerPenalty    = "penalty"
+	ledgerRedemption = "redemption"
+	ledgerReversal   = "reversal"
+	ledgerAdjustment = "adjustment"
+)
+
+type ledgerQuerier interface {
+	QueryRow(query string, args ...interface{}) *sql.Row
+}
+
+// notEnoughError is returned when a user can't afford what they asked for.
+type notEnoughError struct {
+	Available int // what the user can still spend
+}
+
+func (e *notEnoughError) Error() string {
+	return fmt.Sprintf("not enough balance: %d available", e.Available)
+}
+
+// ledgerBalance returns a user's balance and lifetime earned total in a currency from their latest entry.
+func ledgerBalance(q ledgerQuerier, userID, currencyID int) (balance, earned int, err error) {
+	err = q.QueryRow("SELECT balance, earned FROM ledger_entries WHERE user_id = ? AND currency_id = ? ORDER BY id DESC LIMIT 1", userID, currencyID).
+		Scan(&balance, &earned)
+	if err == sql.ErrNoRows {
+		return 0, 0, nil
+	}
+	return balance, earned, err
+}
+
+// userReserved returns the amount of a currency held by a user's pending redemption requests.
+// A reward's currency never changes, so requests take theirs from the reward.
+func userReserved(q ledgerQuerier, userID, currencyID int) int {
+	var reserved int
+	q.QueryRow(`SELECT COALESCE(SUM(rq.cost), 0) FROM redemption_requests rq JOIN rewards rw ON rq.reward_id = rw.id
+		WHERE rq.user_id = ? AND rq.status = 'pending' AND rw.currency_id = ?`, userID, currencyID).Scan(&reserved)
+	return reserved
+}
+
+// ledgerAvailable returns what a user can spend in a currency: their balance
+// less what pending redemption requests hold. ledgerMu must be held for the
+// answer to stay true.
+func ledgerAvailable(q ledgerQuerier, userID, currencyID int) (int, error) {
+	balance, _, err := ledgerBalance(q, userID, currencyID)
+	if err != nil {
+		return 0, err
+	}
+	return balance - userReserved(q, userID, currencyID), nil
+}
+
+// appendLedgerEntry writes e after the user's latest entry in its currency, filling in its running totals and ID.
+// Entries tied to a star count towards the earned total; redemptions only move the balance.
+func appendLedgerEntry(tx *sql.Tx, e *LedgerEntry) error {
+	balance, earned, err := ledgerBalance(tx, e.UserID, e.CurrencyID)
+	if err != nil {
+		return err
+	}
+	e.Balance = balance + e.Amount
+	e.Earned = earned
+	if e.StarID != nil {
+		e.Earned += e.Amount
+	}
+
+	var createdBy interface{}
+	if e.CreatedBy > 0 {
+		createdBy = e.CreatedBy
+	}
+	var createdAt interface{}
+	if !e.CreatedAt.IsZero() {
+		createdAt = e.CreatedAt.UTC().Format("2006-01-02 15:04:05")
+	}
+	result, err := tx.Exec(`INSERT INTO ledger_entries (user_id, currency_id, kind, amount, balance, earned, star_id, redemption_id, reverses_id, note, created_by, created_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, COALESCE(?, CURRENT_TIMESTAMP))`,
+		e.UserID, e.CurrencyID, e.Kind, e.Amount, e.Balance, e.Earned, e.StarID, e.RedemptionID, e.ReversesID, e.Note, createdBy, createdAt)
+	if err != nil {
+		return err
+	}
+	id, _ := result.LastInsertId()
+	e.ID = int(id)
+	return nil
+}
+
+func starEntryKind(stars int) string {
+	if stars < 0 {
+		return ledgerPenalty
+	}
+	return ledgerAward
+}
+
+// commitLedger commits a ledger write and publishes the new balance of each
+// affected user once.
+func commitLedger(tx *sql.Tx, userIDs ...int) error {
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	seen := make(map[int]bool)
+	for _, id := range userIDs {
+		if !seen[id] {
+			seen[id] = true
+			publishBalanceChanged(id)
+		}
+	}
+	return nil
+}
+
+// insertStar records a star in its reason's currency and its ledger entry together.
+func insertStar(userID, reasonID, stars, awardedBy int) (int64, error) {
+	ledgerMu.Lock()
+	defer ledgerMu.Unlock()
+
+	tx, err := db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	starID, err := insertStarTx(tx, userID, reasonID, stars, awardedBy)
+	if err != nil {
+		return 0, err
+	}
+	return starID, commitLedger(tx, userID)
+}
+
+// insertStarTx is insertStar within tx. ledgerMu must be held.
+func insertStarTx(tx *sql.Tx, userID, reasonID, stars, awardedBy int) (int64, error) {
+	var currencyID int
+	if err := tx.QueryRow("SELECT currency_id FROM reasons WHERE id = ?", reasonID).Scan(&currencyID); err != nil {
+		return 0, err
+	}
+	// API awards have no awarding user
+	var awardedByValue interface{}
+	if awardedBy > 0 {
+		awardedByValue = awardedBy
+	}
+	result, err := tx.Exec("INSERT INTO stars (user_id, reason_id, stars, awarded_by, currency_id) VALUES (?, ?, ?, ?, ?)",
+		userID, reasonID, stars, awardedByValue, currencyID)
+	if err != nil {
+		return 0, err
+	}
+	starID, _ := result.LastInsertId()
+	sid := int(starID)
+	err = appendLedgerEntry(tx, &LedgerEntry{
+		UserID:     userID,
+		CurrencyID: currencyID,
+		Kind:       starEntryKind(stars),
+		Amount:     stars,
+		StarID:     &sid,
+		CreatedBy:  awardedBy,
+	})
+	if err != nil {
+		return 0, err
+	}
+	return starID, nil
+}
+
+// redeemReward records a redemption at the reward's current cost and debits the ledger
+// in the reward's currency. Stars held by pending redemption requests can't be
+// spent twice: it fails with a *notEnoughError if the rest doesn't cover the cost.
+func redeemReward(userID, rewardID, redeemedBy int) (int64, error) {
+	ledgerMu.Lock()
+	defer ledgerMu.Unlock()
+
+	tx, err := db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	redemptionID, err := redeemRewardTx(tx, userID, rewardID, redeemedBy)
+	if err != nil {
+		return 0, err
+	}
+	return redemptionID, commitLedger(tx, userID)
+}
+
+// redeemRewardTx is redeemReward within tx. ledgerMu must be held.
+func redeemRewardTx(tx *sql.Tx, userID, rewardID, redeemedBy int) (int64, error) {
+	var cost, currencyID int
+	if err := tx.QueryRow("SELECT cost, currency_id FROM rewards WHERE id = ?", rewardID).Scan(&cost, &currencyID); err != nil {
+		return 0, err
+	}
+	available, err := ledgerAvailable(tx, userID, currencyID)
+	if err != nil {
+		return 0, err
+	}
+	if available < cost {
+		return 0, &notEnoughError{Available: available}
+	}
+	result, err := tx.Exec("INSERT INTO redemptions (user_id, reward_id, cost, currency_id) VALUES (?, ?, ?, ?)", userID, rewardID, cost, currencyID)
+	if err != nil {
+		return 0, err
+	}
+	redemptionID, _ := result.LastInsertId()
+	rid := int(redemptionID)
+	err = appendLedgerEntry(tx, &LedgerEntry{
+		UserID:       userID,
+		CurrencyID:   currencyID,
+		Kind:         ledgerRedemption,
+		Amount:       -cost,
+		RedemptionID: &rid,
+		CreatedBy:    redeemedBy,
+	})
+	if err != nil {
+		return 0, err
+	}
+	return redemptionID, nil
+}
+
+// voidStar removes a star from the history and appends a reversal cancelling
+// everything the ledger recorded for it.
+func voidStar(id, voidedBy int) error {
+	return voidLedgerSource("stars", "star_id", id, voidedBy)
+}
+
+// voidRedemption removes a redemption from the history and refunds its cost.
+func voidRedemption(id, voidedBy int) error {
+	return voidLedgerSource("redemptions", "redemption_id", id, voidedBy)
+}
+
+func voidLedgerSource(table, column string, id, voidedBy int) error {
+	ledgerMu.Lock()
+	defer ledgerMu.Unlock()
+
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.Exec("UPDATE "+table+" SET voided_at = CURRENT_TIMESTAMP WHERE id = ? AND voided_at IS NULL", id)
+	if err != nil {
+		return err
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return errors.New("entry not found or already removed")
+	}
+
+	// Net out the original entry and any adjustments made to it since
+	var userID, currencyID, net int
+	var originalID sql.NullInt64
+	err = tx.QueryRow("SELECT user_id, currency_id, COALESCE(SUM(amount), 0), MIN(id) FROM ledger_entries WHERE "+column+" = ? GROUP BY user_id, currency_id", id).
+		Scan(&userID, &currencyID, &net, &originalID)
+	if err == sql.ErrNoRows {
+		return tx.Commit()
+	}
+	if err != nil {
+		return err
+	}
+
+	sourceID := id
+	e := &LedgerEntry{
+		UserID:     userID,
+		CurrencyID: currencyID,
+		Kind:       ledgerReversal,
+		Amount:     -net,
+		CreatedBy:  voidedBy,
+	}
+	if originalID.Valid {
+		orig := int(originalID.Int64)
+		e.ReversesID = &orig
+	}
+	if column == "star_id" {
+		e.StarID = &sourceID
+	} else {
+		e.RedemptionID = &sourceID
+	}
+	if err := appendLedgerEntry(tx, e); err != nil {
+		return err
+	}
+	return commitLedger(tx, userID)
+}
+
+// adjustReasonStars sets every active star for a reason to the new value and
+// appends an adjustment for each star whose value changed.
+func adjustReasonStars(reasonID, stars, changedBy int) error {
+	ledgerMu.Lock()
+	defer ledgerMu.Unlock()
+
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	type change struct{ starID, userID, currencyID, old int }
+	var changes []change
+	rows, err := tx.Query("SELECT id, user_id, currency_id, stars FROM stars WHERE reason_id = ? AND stars != ? AND voided_at IS NULL ORDER BY id", reasonID, stars)
+	if err != nil {
+		return err
+	}
+	for rows.Next() {
+		var c change
+		rows.Scan(&c.starID, &c.userID, &c.currencyID, &c.old)
+		changes = append(changes, c)
+	}
+	rows.Close()
+
+	var userIDs []int
+	for _, c := range changes {
+		if _, err := tx.Exec("UPDATE stars SET stars = ? WHERE id = ?", stars, c.starID); err != nil {
+			return err
+		}
+		sid := c.starID
+		err := appendLedgerEntry(tx, &LedgerEntry{
+			UserID:     c.userID,
+			CurrencyID: c.currencyID,
+			Kind:       ledgerAdjustment,
+			Amount:     stars - c.old,
+			StarID:     &sid,
+			Note:       fmt.Sprintf("reason stars changed from %d to %d", c.old, stars),
+			CreatedBy:  changedBy,
+		})
+		if err != nil {
+			return err
+		}
+		userIDs = append(userIDs, c.userID)
+	}
+	return commitLedger(tx, userIDs...)
+}
+
+// adjustRewardCost reprices every active redemption of a reward and appends an
+// adjustment for each one whose cost changed.
+func adjustRewardCost(rewardID, cost, changedBy int) error {
+	ledgerMu.Lock()
+	defer ledgerMu.Unlock()
+
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	type change struct{ redemptionID, userID, currencyID, old int }
+	var changes []change
+	rows, err := tx.Query("SELECT id, user_id, currency_id, cost FROM redemptions WHERE reward_id = ? AND cost != ? AND voided_at IS NULL ORDER BY id", rewardID, cost)
+	if err != nil {
+		return err
+	}
+	for rows.Next() {
+		var c change
+		rows.Scan(&c.redemptionID, &c.userID, &c.currencyID, &c.old)
+		changes = append(changes, c)
+	}
+	rows.Close()
+
+	var userIDs []int
+	for _, c := range changes {
+		if _, err := tx.Exec("UPDATE redemptions SET cost = ? WHERE id = ?", cost, c.redemptionID); err != nil {
+			return err
+		}
+		rid := c.redemptionID
+		err := appendLedgerEntry(tx, &LedgerEntry{
+			UserID:       c.userID,
+			CurrencyID:   c.currencyID,
+			Kind:         ledgerAdjustment,
+			Amount:       c.old - cost,
+			RedemptionID: &rid,
+			Note:         fmt.Sprintf("reward cost changed from %d to %d", c.old, cost),
+			CreatedBy:    changedBy,
+		})
+		if err != nil {
+			return err
+		}
+		userIDs = append(userIDs, c.userID)
+	}
+	return commitLedger(tx, userIDs...)
+}
+
+// rebuildLedger replaces the ledger with a replay of all active stars and redemptions.
+func rebuildLedger() error {
+	ledgerMu.Lock()
+	defer ledgerMu.Unlock()
+
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := rebuildLedgerTx(tx, 0); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// rebuildLedgerTx replays a family's active stars and redemptions in
+// chronological order. Pass familyID 0 to rebuild every family.
+// Redemptions without a snapshot cost are pinned to the reward's current cost
+// first so the ledger and the redemption history agree.
+func rebuildLedgerTx(tx *sql.Tx, familyID int) error {
+	users := "(SELECT id FROM users WHERE family_id = ? OR ? = 0)"
+	if _, err := tx.Exec("DELETE FROM ledger_entries WHERE user_id IN "+users, familyID, familyID); err != nil {
+		return err
+	}
+	if _, err := tx.Exec("UPDATE redemptions SET cost = (SELECT cost FROM rewards WHERE rewards.id = redemptions.reward_id) WHERE cost IS NULL"); err != nil {
+		return err
+	}
+
+	rows, err := tx.Query(`
+		SELECT 'star', id, user_id, currency_id, stars, COALESCE(awarded_by, 0), datetime(created_at) FROM stars WHERE voided_at IS NULL AND user_id IN `+users+`
+		UNION ALL
+		SELECT 'redemption', id, user_id, currency_id, -cost, 0, datetime(created_at) FROM redemptions WHERE voided_at IS NULL AND user_id IN `+users+`
+		ORDER BY 7, 1 DESC, 2`, familyID, familyID, familyID, familyID)
+	if err != nil {
+		return err
+	}
+	var entries []*LedgerEntry
+	for rows.Next() {
+		var source string
+		var id int
+		var createdAtStr sql.NullString
+		e := &LedgerEntry{}
+		if err := rows.Scan(&source, &id, &e.UserID, &e.CurrencyID, &e.Amount, &e.CreatedBy, &createdAtStr); err != nil {
+			rows.Close()
+			return err
+		}
+		if source == "star" {
+			e.Kind = starEntryKind(e.Amount)
+			e.StarID = &id
+		} else {
+			e.Kind = ledgerRedemption
+			e.RedemptionID = &id
+		}
+		if createdAtStr.Valid {
+			if t, err := time.Parse("2006-01-02 15:04:05", createdAtStr.String); err == nil {
+				e.CreatedAt = t
+			}
+		}
+		entries = append(entries, e)
+	}
+	rows.Close()
+
+	for _, e := range entries {
+		if err := appendLedgerEntry(tx, e); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// getLedgerEntries lists a family's ledger entries newest first. Pass userID 0 to include all users.
+func getLedgerEntries(familyID, userID, limit int) ([]LedgerEntry, error) {
+	query := `SELECT l.id, l.user_id, u.username, l.currency_id, l.kind, l.amount, l.balance, l.earned, l.star_id, l.redemption_id, l.reverses_id, l.note, COALESCE(l.created_by, 0), l.created_at
+		FROM ledger_entries l
+		JOIN users u ON l.user_id = u.id
+		WHERE u.family_id = ?`
+	args := []interface{}{familyID}
+	if userID > 0 {
+		query += " AND l.user_id = ?"
+		args = append(args, userID)
+	}
+	query += " ORDER BY l.id DESC LIMIT ?"
+	args = append(args, limit)
+
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var entries []LedgerEntry
+	for rows.Next() {
+		var e LedgerEntry
+		var createdAtStr sql.NullString
+		err := rows.Scan(&e.ID, &e.UserID, &e.Username, &e.CurrencyID, &e.Kind, &e.Amount, &e.Balance, &e.Earned,
+			&e.StarID, &e.RedemptionID, &e.ReversesID, &e.Note, &e.CreatedBy, &createdAtStr)
+		if err != nil {
+			fmt.Printf("Error scanning ledger row: %v\n", err)
+			continue
+		}
+		if createdAtStr.Valid && createdAtStr.String != "" {
+			if t, err := time.Parse(time.RFC3339, createdAtStr.String); err == nil {
+				e.CreatedAt = t
+			} else if t, err := time.Parse("2006-01-02 15:04:05", createdAtStr.String); err == nil {
+				e.CreatedAt = t
+			}
+		}
+		entries = append(entries, e)
+	}
+	return entries, nil
+}
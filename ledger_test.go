@@ -0,0 +1,127 @@
+package main
+
+import (
+	"errors"
+	"sync"
+	"testing"
+)
+
+// setupRedemption gives theo 5 stars and adds a reward costing 2 of them.
+func setupRedemption(t *testing.T) (theo, dad *User, reward *Reward) {
+	t.Helper()
+	openTestDB(t)
+	var err error
+	if theo, err = getUserByUsername("theo"); err != nil {
+		t.Fatal(err)
+	}
+	if dad, err = getUserByUsername("dad"); err != nil {
+		t.Fatal(err)
+	}
+	starID, err := addStarWithID("theo", nil, "Fed the cat", 0, 5, dad.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	star, err := getStarByID(int(starID))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := addReward(theo.FamilyID, "Ice cream", 2, "🍦", false, star.CurrencyID); err != nil {
+		t.Fatal(err)
+	}
+	rewards, err := getRewardsList(theo.FamilyID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rewards) != 1 {
+		t.Fatalf("rewards = %v", rewards)
+	}
+	if reward, err = getRewardByID(rewards[0].ID); err != nil {
+		t.Fatal(err)
+	}
+	return theo, dad, reward
+}
+
+// concurrently runs f n times at once and counts the calls that succeeded,
+// failing the test on errors other than a *notEnoughError or allowed.
+func concurrently(t *testing.T, n int, allowed error, f func() error) int {
+	t.Helper()
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	succeeded := 0
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			err := f()
+			mu.Lock()
+			defer mu.Unlock()
+			var notEnough *notEnoughError
+			switch {
+			case err == nil:
+				succeeded++
+			case errors.As(err, &notEnough), allowed != nil && errors.Is(err, allowed):
+			default:
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+	return succeeded
+}
+
+func TestRedemptionRequestsCantOverReserve(t *testing.T) {
+	theo, _, reward := setupRedemption(t)
+	made := concurrently(t, 6, nil, func() error {
+		_, err := addRedemptionRequest(theo.ID, reward.ID, reward.Cost)
+		return err
+	})
+	if made != 2 {
+		t.Errorf("%d requests made, want 2", made)
+	}
+	if reserved := getUserReserved(theo.ID, reward.CurrencyID); reserved != 4 {
+		t.Errorf("reserved = %d, want 4", reserved)
+	}
+}
+
+func TestRedemptionRequestIsRedeemedOnce(t *testing.T) {
+	theo, dad, reward := setupRedemption(t)
+	requestID, err := addRedemptionRequest(theo.ID, reward.ID, reward.Cost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	approved := concurrently(t, 5, errRequestDecided, func() error {
+		_, err := approveRedemptionRequest(int(requestID), dad.ID)
+		return err
+	})
+	if approved != 1 {
+		t.Errorf("%d approvals went through, want 1", approved)
+	}
+	if balance, _ := getUserBalance(theo.ID, reward.CurrencyID); balance != 3 {
+		t.Errorf("balance = %d, want 3", balance)
+	}
+	entries, err := getLedgerEntries(theo.FamilyID, theo.ID, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 2 {
+		t.Errorf("ledger has %d entries, want the award and one redemption", len(entries))
+	}
+}
+
+func TestRedeemCantOverspend(t *testing.T) {
+	theo, dad, reward := setupRedemption(t)
+	if _, err := addRedemptionRequest(theo.ID, reward.ID, reward.Cost); err != nil {
+		t.Fatal(err)
+	}
+	// 5 stars with 2 held by the request leave one more redemption
+	redeemed := concurrently(t, 5, nil, func() error {
+		_, err := redeemReward(theo.ID, reward.ID, dad.ID)
+		return err
+	})
+	if redeemed != 1 {
+		t.Errorf("%d redemptions went through, want 1", redeemed)
+	}
+	if balance, _ := getUserBalance(theo.ID, reward.CurrencyID); balance != 3 {
+		t.Errorf("balance = %d, want 3", balance)
+	}
+}
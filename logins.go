@@ -0,0 +1,341 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Failed logins are counted per username, per kid PIN, per parent's
+// two-factor code and per client IP. After a few, each further attempt has to
+// wait longer, and enough of them lock the username, PIN, code or IP out for a
+// while. An admin can unlock a user early.
+const (
+	loginBaseDelay = time.Second
+	loginMaxDelay  = time.Minute
+)
+
+// loginPolicy is how strictly the failures counted in one scope are treated.
+type loginPolicy struct {
+	freeFailures int           // failures before attempts have to wait
+	lockAfter    int           // failures that lock the key out
+	lockout      time.Duration // how long a lockout lasts
+	window       time.Duration // how long after the last failure they are forgotten
+}
+
+var loginPolicies = map[string]loginPolicy{
+	"user": {freeFailures: 3, lockAfter: 10, lockout: 15 * time.Minute, window: time.Hour},
+	"ip":   {freeFailures: 3, lockAfter: 30, lockout: 15 * time.Minute, window: time.Hour},
+	// A kid's PIN is short enough to guess, so it gets few tries and they
+	// are remembered for a day
+	"pin": {freeFailures: 2, lockAfter: 5, lockout: time.Hour, window: 24 * time.Hour},
+	// Wrong two-factor codes come after a right password
+	"totp": {freeFailures: 3, lockAfter: 5, lockout: time.Hour, window: 24 * time.Hour},
+}
+
+// Login attempts take a lock for each throttle key they count against, so
+// parallel guesses at one username, PIN or address can't all get in before
+// the first failure is counted, while logins elsewhere go ahead.
+var (
+	loginLocksMu sync.Mutex
+	loginLocks   = map[string]*loginLock{}
+)
+
+type loginLock struct {
+	sync.Mutex
+	waiting int // attempts holding or waiting for the lock
+}
+
+// lockLogin locks the throttle keys ("scope:key") of an attempt and returns
+// the function that unlocks them. Keys are locked in order, so attempts
+// sharing some keys can't deadlock.
+func lockLogin(keys ...string) (unlock func()) {
+	sort.Strings(keys)
+	keys = slices.Compact(keys)
+	locks := make([]*loginLock, len(keys))
+	loginLocksMu.Lock()
+	for i, key := range keys {
+		l := loginLocks[key]
+		if l == nil {
+			l = &loginLock{}
+			loginLocks[key] = l
+		}
+		l.waiting++
+		locks[i] = l
+	}
+	loginLocksMu.Unlock()
+	for _, l := range locks {
+		l.Lock()
+	}
+	return func() {
+		for i := len(locks) - 1; i >= 0; i-- {
+			locks[i].Unlock()
+		}
+		loginLocksMu.Lock()
+		for i, key := range keys {
+			if locks[i].waiting--; locks[i].waiting == 0 {
+				delete(loginLocks, key)
+			}
+		}
+		loginLocksMu.Unlock()
+	}
+}
+
+// startLoginThrottle forgets failed logins once they are older than their
+// policy's window.
+func startLoginThrottle() {
+	go func() {
+		for {
+			for scope, p := range loginPolicies {
+				pruneLoginThrottles(scope, time.Now().Add(-p.window))
+			}
+			time.Sleep(time.Hour)
+		}
+	}()
+}
+
+// delay is how long to wait after the given number of failures.
+func (p loginPolicy) delay(failures int) time.Duration {
+	if failures < p.freeFailures {
+		return 0
+	}
+	return exponentialBackoff(failures-p.freeFailures+1, loginBaseDelay, loginMaxDelay)
+}
+
+// blockedUntil returns when the next attempt may be made, zero if it may be
+// made now, and whether that is because of a lockout.
+func (t LoginThrottle) blockedUntil(now time.Time) (time.Time, bool) {
+	if t.LockedUntil != nil && t.LockedUntil.After(now) {
+		return *t.LockedUntil, true
+	}
+	if until := t.LastFailureAt.Add(loginPolicies[t.Scope].delay(t.Failures)); t.Failures > 0 && until.After(now) {
+		return until, false
+	}
+	return time.Time{}, false
+}
+
+// fail counts a failed attempt at now, starting over if the last one was
+// longer ago than the policy's window. It reports whether this failure locks
+// the key out, which happens again on each failure past lockAfter.
+func (t LoginThrottle) fail(now time.Time) (LoginThrottle, bool) {
+	p := loginPolicies[t.Scope]
+	if now.Sub(t.LastFailureAt) > p.window {
+		t.Failures = 0
+		t.LockedUntil = nil
+	}
+	t.Failures++
+	t.LastFailureAt = now
+	if t.Failures < p.lockAfter {
+		return t, false
+	}
+	until := now.Add(p.lockout)
+	t.LockedUntil = &until
+	return t, true
+}
+
+// recent reports whether t's failures are still remembered at now.
+func (t LoginThrottle) recent(now time.Time) bool {
+	return now.Sub(t.LastFailureAt) <= loginPolicies[t.Scope].window
+}
+
+// trustedProxies are the reverse proxies whose X-Forwarded-For is believed,
+// set with -trusted-proxies. Without any, the header is ignored, since
+// clients can send it themselves.
+var trustedProxies []*net.IPNet
+
+// parseTrustedProxies reads a comma-separated list of addresses and CIDR
+// ranges, such as "127.0.0.1,10.0.0.0/8".
+func parseTrustedProxies(list string) ([]*net.IPNet, error) {
+	var nets []*net.IPNet
+	for _, entry := range strings.Split(list, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		if !strings.Contains(entry, "/") {
+			ip := net.ParseIP(entry)
+			if ip == nil {
+				return nil, fmt.Errorf("invalid trusted proxy %q", entry)
+			}
+			bits := 8 * net.IPv6len
+			if ip.To4() != nil {
+				ip, bits = ip.To4(), 8*net.IPv4len
+			}
+			nets = append(nets, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, ipNet, err := net.ParseCIDR(entry)
+		if err != nil {
+			return nil, fmt.Errorf("invalid trusted proxy %q", entry)
+		}
+		nets = append(nets, ipNet)
+	}
+	return nets, nil
+}
+
+func trustedProxy(addr string) bool {
+	ip := net.ParseIP(addr)
+	if ip == nil {
+		return false
+	}
+	for _, n := range trustedProxies {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// clientIP is the address a request came from. When it came through a
+// trusted proxy, that is the last address in X-Forwarded-For that isn't
+// another trusted proxy; otherwise X-Forwarded-For is ignored.
+func clientIP(r *http.Request) string {
+	ip, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		ip = r.RemoteAddr
+	}
+	if !trustedProxy(ip) {
+		return ip
+	}
+	hops := strings.Split(strings.Join(r.Header.Values("X-Forwarded-For"), ","), ",")
+	for i := len(hops) - 1; i >= 0; i-- {
+		hop := strings.TrimSpace(hops[i])
+		if hop == "" {
+			continue
+		}
+		ip = hop
+		if !trustedProxy(hop) {
+			break
+		}
+	}
+	return ip
+}
+
+// loginBlocked reports whether a login as username from ip has to wait, until
+// when, and whether that is because of a lockout. scope is the kind of login,
+// "user" for passwords, "pin" or "totp".
+func loginBlocked(scope, username, ip string, now time.Time) (until time.Time, locked bool) {
+	for _, t := range []struct{ scope, key string }{{scope, username}, {"ip", ip}} {
+		throttle, err := getLoginThrottle(t.scope, t.key)
+		if err != nil {
+			log.Printf("Failed to check failed logins for %s %s: %v", t.scope, t.key, err)
+			continue
+		}
+		if u, l := throttle.blockedUntil(now); u.After(until) {
+			until, locked = u, l
+		}
+	}
+	return until, locked
+}
+
+// recordLoginFailure counts a failed login of the scope's kind as username
+// from ip, and audits the lockouts it causes. user is nil when there is no
+// such user.
+func recordLoginFailure(scope, username string, user *User, ip string, now time.Time) {
+	for _, t := range []struct{ scope, key string }{{scope, username}, {"ip", ip}} {
+		throttle, err := getLoginThrottle(t.scope, t.key)
+		if err != nil {
+			log.Printf("Failed to load failed logins for %s %s: %v", t.scope, t.key, err)
+			continue
+		}
+		throttle, locked := throttle.fail(now)
+		if err := saveLoginThrottle(throttle); err != nil {
+			log.Printf("Failed to count failed login for %s %s: %v", t.scope, t.key, err)
+			continue
+		}
+		if locked {
+			auditLockout(throttle, user, ip)
+		}
+	}
+}
+
+// auditLockout records a lockout in the family of the user whose login
+// failed, or the super-admin's if there is no such user.
+func auditLockout(t LoginThrottle, user *User, ip string) {
+	targetType := "user"
+	if t.Scope == "ip" {
+		targetType = "ip"
+	}
+	e := AuditEntry{
+		Action:      t.Scope + ".lockout",
+		TargetType:  targetType,
+		Target:      t.Key,
+		Source:      "web",
+		SourceLabel: ip,
+		FamilyID:    superAdminFamilyID(),
+	}
+	if user != nil {
+		e.FamilyID = user.FamilyID
+		if targetType == "user" {
+			e.TargetID = user.ID
+		}
+	}
+	writeAudit(e, nil, lockoutSnapshot(t, ip))
+}
+
+// lockoutSnapshot describes a lockout for the audit log.
+func lockoutSnapshot(t LoginThrottle, ip string) map[string]interface{} {
+	snapshot := map[string]interface{}{"failures": t.Failures}
+	if ip != "" {
+		snapshot["ip"] = ip
+	}
+	if t.LockedUntil != nil {
+		snapshot["locked_until"] = t.LockedUntil.UTC().Format(time.RFC3339)
+	}
+	return snapshot
+}
+
+// loginLockView is a user or IP with recent failed logins, for the admin page.
+// Scope tells password failures from PIN and two-factor ones.
+type loginLockView struct {
+	UserID      int
+	Scope       string
+	Key         string
+	Failures    int
+	LockedUntil *time.Time
+}
+
+// userLockViews lists the family's users with recent failed logins.
+func userLockViews(users []User, now time.Time) []loginLockView {
+	var views []loginLockView
+	for _, scope := range []string{"user", "pin", "totp"} {
+		throttles, _ := getLoginThrottles(scope)
+		for _, t := range throttles {
+			if !t.recent(now) {
+				continue
+			}
+			for _, u := range users {
+				if u.Username == t.Key {
+					views = append(views, lockView(t, u.ID, now))
+				}
+			}
+		}
+	}
+	return views
+}
+
+// ipLockViews lists the client IPs with recent failed logins.
+func ipLockViews(now time.Time) []loginLockView {
+	throttles, _ := getLoginThrottles("ip")
+	var views []loginLockView
+	for _, t := range throttles {
+		if t.recent(now) {
+			views = append(views, lockView(t, 0, now))
+		}
+	}
+	return views
+}
+
+func lockView(t LoginThrottle, userID int, now time.Time) loginLockView {
+	v := loginLockView{UserID: userID, Scope: t.Scope, Key: t.Key, Failures: t.Failures}
+	if t.LockedUntil != nil && t.LockedUntil.After(now) {
+		v.LockedUntil = t.LockedUntil
+	}
+	return v
+}
@@ -0,0 +1,191 @@
+package main
+
+import (
+	"net"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestClientIP(t *testing.T) {
+	proxies, err := parseTrustedProxies("127.0.0.1, 10.0.0.0/8")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func(saved []*net.IPNet) { trustedProxies = saved }(trustedProxies)
+	trustedProxies = proxies
+
+	tests := []struct {
+		remote, forwarded, want string
+	}{
+		{"203.0.113.5:4000", "", "203.0.113.5"},
+		// Only a trusted proxy's X-Forwarded-For counts
+		{"203.0.113.5:4000", "198.51.100.7", "203.0.113.5"},
+		{"127.0.0.1:4000", "198.51.100.7", "198.51.100.7"},
+		// A client can't get past the proxy by adding entries of its own
+		{"127.0.0.1:4000", "192.0.2.1, 198.51.100.7", "198.51.100.7"},
+		{"127.0.0.1:4000", "198.51.100.7, 10.1.2.3", "198.51.100.7"},
+		{"127.0.0.1:4000", "", "127.0.0.1"},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest("POST", "/login", nil)
+		r.RemoteAddr = tt.remote
+		if tt.forwarded != "" {
+			r.Header.Set("X-Forwarded-For", tt.forwarded)
+		}
+		if got := clientIP(r); got != tt.want {
+			t.Errorf("clientIP(%s, X-Forwarded-For %q) = %s, want %s", tt.remote, tt.forwarded, got, tt.want)
+		}
+	}
+
+	if _, err := parseTrustedProxies("not-an-ip"); err == nil {
+		t.Error("parseTrustedProxies accepted an invalid address")
+	}
+}
+
+func TestLoginPolicyDelay(t *testing.T) {
+	p := loginPolicies["user"]
+	tests := []struct {
+		failures int
+		want     time.Duration
+	}{
+		{0, 0},
+		{1, 0},
+		{2, 0},
+		{3, time.Second},
+		{4, 2 * time.Second},
+		{5, 4 * time.Second},
+		{8, 32 * time.Second},
+		{9, time.Minute},
+		{50, time.Minute},
+	}
+	for _, tt := range tests {
+		if got := p.delay(tt.failures); got != tt.want {
+			t.Errorf("delay(%d) = %v, want %v", tt.failures, got, tt.want)
+		}
+	}
+}
+
+func TestLoginThrottleLockout(t *testing.T) {
+	start := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+	now := start
+	throttle := LoginThrottle{Scope: "user", Key: "theo"}
+
+	// Free failures don't block the next attempt
+	for i := 1; i <= 2; i++ {
+		var locked bool
+		if throttle, locked = throttle.fail(now); locked {
+			t.Fatalf("failure %d locked", i)
+		}
+		if until, _ := throttle.blockedUntil(now); !until.IsZero() {
+			t.Fatalf("blocked after %d free failures until %v", i, until)
+		}
+	}
+
+	// Then each failure backs off, without locking
+	for i := 3; i < 10; i++ {
+		var locked bool
+		if throttle, locked = throttle.fail(now); locked {
+			t.Fatalf("failure %d locked", i)
+		}
+		until, locked := throttle.blockedUntil(now)
+		if want := now.Add(loginPolicies["user"].delay(i)); !until.Equal(want) || locked {
+			t.Fatalf("after %d failures blocked until %v (locked %v), want %v", i, until, locked, want)
+		}
+		now = until
+		if until, _ := throttle.blockedUntil(now); !until.IsZero() {
+			t.Fatalf("still blocked once the delay after %d failures passed", i)
+		}
+	}
+
+	// lockAfter failures lock the key out
+	throttle, locked := throttle.fail(now)
+	if !locked || throttle.Failures != 10 {
+		t.Fatalf("failure 10 locked = %v with %d failures", locked, throttle.Failures)
+	}
+	until, locked := throttle.blockedUntil(now.Add(time.Minute))
+	if want := now.Add(15 * time.Minute); !locked || !until.Equal(want) {
+		t.Fatalf("blocked until %v (locked %v), want lockout until %v", until, locked, want)
+	}
+
+	// Each failure past lockAfter locks it again
+	now = now.Add(16 * time.Minute)
+	if _, locked := throttle.blockedUntil(now); locked {
+		t.Fatal("still locked after the lockout")
+	}
+	throttle, locked = throttle.fail(now)
+	if !locked || throttle.Failures != 11 || !throttle.LockedUntil.Equal(now.Add(15*time.Minute)) {
+		t.Fatalf("failure 11 locked = %v until %v", locked, throttle.LockedUntil)
+	}
+
+	// Failures are forgotten a window after the last one
+	if !throttle.recent(now.Add(time.Hour)) || throttle.recent(now.Add(time.Hour+time.Second)) {
+		t.Error("recent doesn't match the policy's window")
+	}
+	now = now.Add(time.Hour + time.Second)
+	throttle, locked = throttle.fail(now)
+	if locked || throttle.Failures != 1 || throttle.LockedUntil != nil {
+		t.Fatalf("after the window: %d failures, locked %v until %v", throttle.Failures, locked, throttle.LockedUntil)
+	}
+	if until, _ := throttle.blockedUntil(now); !until.IsZero() {
+		t.Errorf("blocked after the window until %v", until)
+	}
+}
+
+func TestPINThrottleIsStricter(t *testing.T) {
+	now := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+	throttle := LoginThrottle{Scope: "pin", Key: "theo"}
+	for i := 1; i <= 5; i++ {
+		var locked bool
+		throttle, locked = throttle.fail(now)
+		if locked != (i == 5) {
+			t.Fatalf("PIN failure %d locked = %v", i, locked)
+		}
+		if until, _ := throttle.blockedUntil(now); until.IsZero() != (i < 2) {
+			t.Fatalf("PIN failure %d blocked until %v", i, until)
+		}
+	}
+	if !throttle.LockedUntil.Equal(now.Add(time.Hour)) {
+		t.Errorf("PIN locked until %v, want an hour", throttle.LockedUntil)
+	}
+	// A PIN's failures are remembered for a day
+	if !throttle.recent(now.Add(23 * time.Hour)) {
+		t.Error("PIN failures forgotten within a day")
+	}
+}
+
+func TestLockLogin(t *testing.T) {
+	unlock := lockLogin("user:theo", "ip:203.0.113.5")
+
+	// Another key goes ahead
+	done := make(chan struct{})
+	go func() {
+		lockLogin("user:ray", "ip:198.51.100.7")()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("a login with other keys waited")
+	}
+
+	// A shared key waits
+	done = make(chan struct{})
+	go func() {
+		lockLogin("ip:203.0.113.5", "user:ray")()
+		close(done)
+	}()
+	select {
+	case <-done:
+		t.Fatal("a login sharing the address didn't wait")
+	case <-time.After(50 * time.Millisecond):
+	}
+	unlock()
+	<-done
+
+	loginLocksMu.Lock()
+	defer loginLocksMu.Unlock()
+	if len(loginLocks) != 0 {
+		t.Errorf("%d locks left after unlocking", len(loginLocks))
+	}
+}
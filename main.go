@@ -18,11 +18,30 @@ var staticFS embed.FS
 
 var templates map[string]*template.Template
 
+var templateFuncs = template.FuncMap{
+	"languages":   getLanguages,
+	"langColumns": langColumns,
+	"t":           translate,
+	"textIn":      textIn,
+	"tr":          translationsAttr,
+}
+
 func main() {
 	port := flag.Int("port", 8080, "HTTP port")
 	dbPath := flag.String("db", "stars.db", "SQLite database path")
+	mqttEmbedded := flag.String("mqtt-embedded", "", "Run an embedded MQTT broker on this address (e.g. :1883) for local testing")
+	proxies := flag.String("trusted-proxies", "", "Comma-separated reverse proxy addresses or CIDR ranges whose X-Forwarded-For is trusted")
 	flag.Parse()
 
+	var err error
+	if trustedProxies, err = parseTrustedProxies(*proxies); err != nil {
+		log.Fatal(err)
+	}
+
+	if err := loadLanguages(); err != nil {
+		log.Fatal("Failed to load languages:", err)
+	}
+
 	if err := initDB(*dbPath); err != nil {
 		log.Fatal("Failed to init database:", err)
 	}
@@ -35,9 +54,25 @@ func main() {
 		log.Fatal("Failed to seed rewards:", err)
 	}
 
+	if *mqttEmbedded != "" {
+		_, addr, err := startEmbeddedMQTTBroker(*mqttEmbedded)
+		if err != nil {
+			log.Fatal("Failed to start embedded MQTT broker:", err)
+		}
+		log.Printf("Embedded MQTT broker listening on %s", addr)
+	}
+
+	startEventLog()
+	startLoginThrottle()
+	startSessionCleanup()
+	startWebhooks()
+	startAnnouncements()
+	startMQTT()
+	startStream()
+
 	templates = make(map[string]*template.Template)
-	for _, page := range []string{"login.html", "dashboard.html", "admin.html", "password.html", "account.html"} {
-		templates[page] = template.Must(template.ParseFS(templateFS, "templates/layout.html", "templates/"+page))
+	for _, page := range []string{"login.html", "dashboard.html", "admin.html", "password.html", "account.html", "audit.html"} {
+		templates[page] = template.Must(template.New(page).Funcs(templateFuncs).ParseFS(templateFS, "templates/layout.html", "templates/"+page))
 	}
 
 	mux := http.NewServeMux()
@@ -45,45 +80,105 @@ func main() {
 	// Static files
 	staticSub, _ := fs.Sub(staticFS, "static")
 	mux.Handle("GET /static/", http.StripPrefix("/static/", http.FileServer(http.FS(staticSub))))
+	mux.HandleFunc("GET /languages.js", handleLanguageScript)
+	mux.HandleFunc("POST /language", csrfProtect(handleSetLanguage))
 
 	// Web routes
 	mux.HandleFunc("GET /{$}", authWeb(handleDashboard))
 	mux.HandleFunc("GET /login", handleLoginPage)
-	mux.HandleFunc("POST /login", handleLogin)
+	mux.HandleFunc("POST /login", csrfProtect(handleLogin))
+	mux.HandleFunc("POST /login/pin", csrfProtect(handlePINLogin))
+	mux.HandleFunc("POST /login/2fa", csrfProtect(handleTwoFactorLogin))
 	mux.HandleFunc("POST /logout", authWeb(handleLogout))
 	mux.HandleFunc("GET /account", authWeb(handleAccountPage))
 	mux.HandleFunc("POST /account/password", authWeb(handleAccountPasswordChange))
+	mux.HandleFunc("POST /account/language", authWeb(handleAccountLanguage))
+	mux.HandleFunc("POST /account/session/{id}/revoke", authWeb(handleRevokeSession))
+	mux.HandleFunc("POST /account/sessions/revoke", authWeb(handleRevokeOtherSessions))
+	mux.HandleFunc("POST /account/2fa/setup", authWeb(handleTwoFactorSetup))
+	mux.HandleFunc("POST /account/2fa/enable", authWeb(handleTwoFactorEnable))
+	mux.HandleFunc("POST /account/2fa/disable", authWeb(handleTwoFactorDisable))
+	mux.HandleFunc("POST /account/2fa/recovery", authWeb(handleRecoveryCodes))
 	mux.HandleFunc("GET /password", authWeb(handlePasswordPage))
 	mux.HandleFunc("POST /password", authWeb(handlePasswordChange))
 	mux.HandleFunc("POST /star", authAdmin(handleQuickStar))
 	mux.HandleFunc("POST /redeem", authAdmin(handleRedeem))
+	mux.HandleFunc("POST /redeem/request", authWeb(handleRequestRedemption))
+	mux.HandleFunc("DELETE /redeem/request/{id}", authWeb(handleCancelRedemptionRequest))
+	mux.HandleFunc("POST /redeem/request/{id}/approve", authAdmin(handleReviewRedemptionRequest(true)))
+	mux.HandleFunc("POST /redeem/request/{id}/reject", authAdmin(handleReviewRedemptionRequest(false)))
 	mux.HandleFunc("DELETE /star/{id}", authAdmin(handleDeleteStar))
 	mux.HandleFunc("DELETE /redemption/{id}", authAdmin(handleDeleteRedemption))
+	mux.HandleFunc("POST /goal", authWeb(handleSetGoal))
+	mux.HandleFunc("DELETE /goal", authWeb(handleClearGoal))
+	mux.HandleFunc("POST /chore/{id}/done", authWeb(handleCompleteChore))
+	mux.HandleFunc("POST /chore/completion/{id}/approve", authAdmin(handleReviewChore(true)))
+	mux.HandleFunc("POST /chore/completion/{id}/reject", authAdmin(handleReviewChore(false)))
 	mux.HandleFunc("GET /admin", authAdmin(handleAdmin))
 	mux.HandleFunc("POST /admin/star", authAdmin(handleAddStar))
 	mux.HandleFunc("POST /admin/apikey", authAdmin(handleGenerateAPIKey))
 	mux.HandleFunc("DELETE /admin/apikey/{id}", authAdmin(handleDeleteAPIKey))
+	mux.HandleFunc("POST /admin/webhook", authAdmin(handleAddWebhook))
+	mux.HandleFunc("DELETE /admin/webhook/{id}", authAdmin(handleDeleteWebhook))
+	mux.HandleFunc("POST /admin/webhook/{id}/toggle", authAdmin(handleToggleWebhook))
+	mux.HandleFunc("POST /admin/webhook/{id}/test", authAdmin(handleTestWebhook))
+	mux.HandleFunc("POST /admin/webhook/delivery/{id}/retry", authAdmin(handleRetryWebhookDelivery))
 	mux.HandleFunc("POST /admin/reward", authAdmin(handleAddReward))
 	mux.HandleFunc("POST /admin/reward/{id}", authAdmin(handleUpdateReward))
 	mux.HandleFunc("PUT /admin/reward/{id}", authAdmin(handleUpdateRewardTranslation))
 	mux.HandleFunc("DELETE /admin/reward/{id}", authAdmin(handleDeleteReward))
+	mux.HandleFunc("POST /admin/currency", authAdmin(handleAddCurrency))
+	mux.HandleFunc("PUT /admin/currency/{id}", authAdmin(handleUpdateCurrency))
+	mux.HandleFunc("DELETE /admin/currency/{id}", authAdmin(handleDeleteCurrency))
+	mux.HandleFunc("POST /admin/chore", authAdmin(handleAddChore))
+	mux.HandleFunc("DELETE /admin/chore/{id}", authAdmin(handleDeleteChore))
 	mux.HandleFunc("POST /admin/settings", authAdmin(handleSaveSettings))
+	mux.HandleFunc("POST /admin/mqtt", authSuperAdmin(handleSaveMQTT))
 	mux.HandleFunc("POST /admin/toggle-announce", authAdmin(handleToggleAnnounce))
+	mux.HandleFunc("POST /admin/announce/preview", authAdmin(handleAnnouncePreview))
+	mux.HandleFunc("POST /admin/announce/test", authAdmin(handleAnnounceTest))
+	mux.HandleFunc("POST /admin/announce/delivery/{id}/retry", authAdmin(handleRetryAnnouncementDelivery))
 	mux.HandleFunc("PUT /admin/reason/{id}", authAdmin(handleUpdateReasonTranslation))
 	mux.HandleFunc("DELETE /admin/reason/{id}", authAdmin(handleDeleteReason))
 	mux.HandleFunc("POST /admin/user", authAdmin(handleAddUser))
 	mux.HandleFunc("DELETE /admin/user/{id}", authAdmin(handleDeleteUser))
 	mux.HandleFunc("PUT /admin/user/{id}", authAdmin(handleUpdateUserTranslation))
+	mux.HandleFunc("PUT /admin/user/{id}/language", authAdmin(handleUpdateUserLanguage))
+	mux.HandleFunc("POST /admin/user/{id}/announce", authAdmin(handleUpdateUserAnnounce))
+	mux.HandleFunc("POST /admin/user/{id}/unlock", authAdmin(handleUnlockUser))
+	mux.HandleFunc("POST /admin/user/{id}/login", authAdmin(handleUpdateKidLogin))
+	mux.HandleFunc("POST /admin/user/{id}/2fa/reset", authAdmin(handleResetTwoFactor))
+	mux.HandleFunc("POST /admin/2fa", authAdmin(handleSaveTwoFactorPolicy))
+	mux.HandleFunc("POST /admin/kid-login/device", authAdmin(handleAddKidLoginDevice))
+	mux.HandleFunc("POST /admin/kid-login/device/remove", authAdmin(handleRemoveKidLoginDevices))
+	mux.HandleFunc("POST /admin/family", authSuperAdmin(handleAddFamily))
+	mux.HandleFunc("POST /admin/family/{id}/switch", authSuperAdmin(handleSwitchFamily))
+	mux.HandleFunc("POST /admin/lockout/ip/unlock", authSuperAdmin(handleUnlockIP))
+	mux.HandleFunc("GET /admin/audit", authAdmin(handleAuditPage))
 	mux.HandleFunc("GET /admin/export", authAdmin(handleExport))
 	mux.HandleFunc("POST /admin/import", authAdmin(handleImport))
 
-	// API routes
-	mux.HandleFunc("GET /api/stars", authAPI(handleAPIGetStars))
-	mux.HandleFunc("POST /api/stars", authAPI(handleAPIAddStar))
-	mux.HandleFunc("GET /api/users", authAPI(handleAPIGetUsers))
-	mux.HandleFunc("GET /api/reasons", authAPI(handleAPIGetReasons))
-	mux.HandleFunc("GET /api/rewards", authAPI(handleAPIGetRewards))
-	mux.HandleFunc("GET /api/redemptions", authAPI(handleAPIGetRedemptions))
+	// API routes. /api/v1 serves the same endpoints under a stable version;
+	// the unversioned paths stay for existing integrations.
+	for _, prefix := range []string{"/api", "/api/v1"} {
+		mux.HandleFunc("GET "+prefix+"/stars", authAPI(scopeRead, handleAPIGetStars))
+		mux.HandleFunc("POST "+prefix+"/stars", authAPI(scopeAward, handleAPIAddStar))
+		mux.HandleFunc("POST "+prefix+"/redemptions", authAPI(scopeRedeem, handleAPIRedeem))
+		mux.HandleFunc("GET "+prefix+"/users", authAPI(scopeRead, handleAPIGetUsers))
+		mux.HandleFunc("GET "+prefix+"/reasons", authAPI(scopeRead, handleAPIGetReasons))
+		mux.HandleFunc("GET "+prefix+"/reasons/{key}", authAPI(scopeRead, handleAPIGetReason))
+		mux.HandleFunc("GET "+prefix+"/rewards", authAPI(scopeRead, handleAPIGetRewards))
+		mux.HandleFunc("GET "+prefix+"/rewards/{key}", authAPI(scopeRead, handleAPIGetReward))
+		mux.HandleFunc("GET "+prefix+"/currencies", authAPI(scopeRead, handleAPIGetCurrencies))
+		mux.HandleFunc("GET "+prefix+"/redemptions", authAPI(scopeRead, handleAPIGetRedemptions))
+		mux.HandleFunc("GET "+prefix+"/ledger", authAPI(scopeRead, handleAPIGetLedger))
+		mux.HandleFunc("GET "+prefix+"/audit", authAPI(scopeAdmin, handleAPIGetAudit))
+	}
+	mux.HandleFunc("POST /api/redeem", authAPI(scopeRedeem, handleAPIRedeem))
+	mux.HandleFunc("GET /api/events", authWebOrAPI(scopeRead, handleEventStream))
+	mux.HandleFunc("GET /api/v1/info", authAPI("", handleAPIInfo))
+	mux.HandleFunc("GET /api/v1/events", authAPI(scopeRead, handleAPIGetEvents))
+	mux.HandleFunc("GET /api/v1/events/stream", authWebOrAPI(scopeRead, handleEventStream))
 
 	addr := fmt.Sprintf(":%d", *port)
 	log.Printf("Star Tracker listening on %s", addr)
@@ -0,0 +1,23 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+// openTestDB points the package at a fresh database with the default users
+// seeded, closed when the test ends.
+func openTestDB(t *testing.T) {
+	t.Helper()
+	if err := loadLanguages(); err != nil {
+		t.Fatal(err)
+	}
+	if err := initDB(filepath.Join(t.TempDir(), "stars.db")); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	t.Setenv("STAR_APP_DEFAULT_PASSWORD", "test-password")
+	if err := seedUsers(); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -2,12 +2,23 @@ package main
 
 import (
 	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"database/sql"
+	"encoding/hex"
 	"net/http"
+	"net/url"
+	"strings"
+	"time"
 )
 
 type contextKey string
 
-const userContextKey contextKey = "user"
+const (
+	userContextKey   contextKey = "user"
+	apiKeyContextKey contextKey = "apikey"
+	familyContextKey contextKey = "family"
+)
 
 func getContextUser(r *http.Request) *User {
 	if u, ok := r.Context().Value(userContextKey).(*User); ok {
@@ -16,29 +27,236 @@ func getContextUser(r *http.Request) *User {
 	return nil
 }
 
-// authWeb requires a valid session cookie. Redirects to /login if not authenticated.
-func authWeb(next http.HandlerFunc) http.HandlerFunc {
+func getContextAPIKey(r *http.Request) *APIKey {
+	if k, ok := r.Context().Value(apiKeyContextKey).(*APIKey); ok {
+		return k
+	}
+	return nil
+}
+
+// getContextFamilyID returns the family a request works in: the session's
+// family for web requests or the API key's family for API requests.
+func getContextFamilyID(r *http.Request) int {
+	if id, ok := r.Context().Value(familyContextKey).(int); ok {
+		return id
+	}
+	return 0
+}
+
+// userInFamily reports whether a user belongs to the request's family.
+func userInFamily(r *http.Request, userID int) bool {
+	u, err := getUserByID(userID)
+	return err == nil && u.FamilyID == getContextFamilyID(r)
+}
+
+// getFamilyUser looks up a user by username within the request's family.
+func getFamilyUser(r *http.Request, username string) (*User, error) {
+	u, err := getUserByUsername(username)
+	if err != nil {
+		return nil, err
+	}
+	if u.FamilyID != getContextFamilyID(r) {
+		return nil, sql.ErrNoRows
+	}
+	return u, nil
+}
+
+// getFamilyReward looks up a reward by id within the request's family.
+func getFamilyReward(r *http.Request, id int) (*Reward, error) {
+	reward, err := getRewardByID(id)
+	if err != nil {
+		return nil, err
+	}
+	if reward.FamilyID != getContextFamilyID(r) {
+		return nil, sql.ErrNoRows
+	}
+	return reward, nil
+}
+
+// getFamilyRewardByKey looks up a reward by key within the request's family.
+func getFamilyRewardByKey(r *http.Request, key string) (*Reward, error) {
+	rewards, err := getRewardsList(getContextFamilyID(r))
+	if err != nil {
+		return nil, err
+	}
+	for i := range rewards {
+		if rewards[i].Key == key {
+			return &rewards[i], nil
+		}
+	}
+	return nil, sql.ErrNoRows
+}
+
+// getFamilyCurrency looks up a currency by id within the request's family.
+func getFamilyCurrency(r *http.Request, id int) (*Currency, error) {
+	currency, err := getCurrencyByID(id)
+	if err != nil {
+		return nil, err
+	}
+	if currency.FamilyID != getContextFamilyID(r) {
+		return nil, sql.ErrNoRows
+	}
+	return currency, nil
+}
+
+// sessionUser returns the user logged in with the request's session cookie and
+// the family the session works in. Only super-admins may switch families, so
+// everyone else always works in their own. Expired sessions are deleted.
+func sessionUser(r *http.Request) (*User, int, error) {
+	cookie, err := r.Cookie("session")
+	if err != nil {
+		return nil, 0, err
+	}
+	session, err := getSession(cookie.Value)
+	if err != nil {
+		return nil, 0, err
+	}
+	now := time.Now()
+	if session.expired(now) {
+		deleteSession(session.Token)
+		return nil, 0, errSessionExpired
+	}
+	if ip := clientIP(r); now.Sub(session.LastSeenAt) > sessionTouchEvery || ip != session.IP {
+		touchSession(session.Token, ip)
+	}
+	user, err := getUserByID(session.UserID)
+	if err != nil {
+		return nil, 0, err
+	}
+	familyID := session.FamilyID
+	if !user.IsSuperAdmin {
+		familyID = user.FamilyID
+	}
+	return user, familyID, nil
+}
+
+// withSession stores the session user and family in the request context.
+func withSession(r *http.Request, user *User, familyID int) *http.Request {
+	ctx := context.WithValue(r.Context(), userContextKey, user)
+	ctx = context.WithValue(ctx, familyContextKey, familyID)
+	return r.WithContext(ctx)
+}
+
+// csrfToken is the token a session's pages put in their forms and send as
+// X-CSRF-Token. It is derived from the session token, so it needs no storage
+// and changes with every login.
+func csrfToken(session string) string {
+	if session == "" {
+		return ""
+	}
+	sum := sha256.Sum256([]byte("csrf:" + session))
+	return hex.EncodeToString(sum[:])
+}
+
+// sameOrigin reports whether a request's Origin, or else its Referer, is this
+// site. Requests carrying neither, which browsers don't send cross-site
+// changes without, pass.
+func sameOrigin(r *http.Request) bool {
+	source := r.Header.Get("Origin")
+	if source == "" {
+		source = r.Header.Get("Referer")
+	}
+	if source == "" {
+		return true
+	}
+	u, err := url.Parse(source)
+	if err != nil || u.Host == "" {
+		return false
+	}
+	return strings.EqualFold(u.Host, r.Host) || strings.EqualFold(u.Host, r.Header.Get("X-Forwarded-Host"))
+}
+
+// validCSRF checks a request that changes something: it must come from this
+// site, and if it carries a session cookie, with that session's CSRF token
+// in the X-CSRF-Token header or the csrf_token form field.
+func validCSRF(r *http.Request) bool {
+	switch r.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		return true
+	}
+	if !sameOrigin(r) {
+		return false
+	}
+	expected := csrfToken(sessionToken(r))
+	if expected == "" {
+		return true
+	}
+	token := r.Header.Get("X-CSRF-Token")
+	if token == "" {
+		token = r.FormValue("csrf_token")
+	}
+	return subtle.ConstantTimeCompare([]byte(token), []byte(expected)) == 1
+}
+
+// csrfProtect rejects requests that fail validCSRF, for the routes that
+// change something without requiring a login.
+func csrfProtect(next http.HandlerFunc) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		cookie, err := r.Cookie("session")
-		if err != nil {
-			http.Redirect(w, r, "/login", http.StatusSeeOther)
+		if !validCSRF(r) {
+			http.Error(w, localize(r, "csrf_failed"), http.StatusForbidden)
 			return
 		}
+		next(w, r)
+	}
+}
 
-		userID, err := getSession(cookie.Value)
+// authWeb requires a valid session cookie, and a CSRF token for requests that
+// change something. Redirects to /login if not authenticated. Parents who
+// have to set up two-factor login are kept to their account page until they do.
+func authWeb(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		user, familyID, err := sessionUser(r)
 		if err != nil {
 			http.Redirect(w, r, "/login", http.StatusSeeOther)
 			return
 		}
+		r = withSession(r, user, familyID)
+		if !validCSRF(r) {
+			http.Error(w, localize(r, "csrf_failed"), http.StatusForbidden)
+			return
+		}
+		if needsTwoFactorSetup(user) && !twoFactorSetupPath(r.URL.Path) {
+			if r.Method == http.MethodGet {
+				http.Redirect(w, r, "/account", http.StatusSeeOther)
+			} else {
+				http.Error(w, localize(r, "two_factor_setup_required"), http.StatusForbidden)
+			}
+			return
+		}
+		next(w, r)
+	}
+}
 
-		user, err := getUserByID(userID)
+// twoFactorSetupPath reports whether a parent who still has to set up
+// two-factor login may use path: the account page, to set it up, and logout.
+func twoFactorSetupPath(path string) bool {
+	return path == "/account" || strings.HasPrefix(path, "/account/") || path == "/logout"
+}
+
+// authWebOrAPI accepts an API key holding scope in the X-API-Key header, or else
+// a session cookie, for endpoints shared by the dashboard and integrations.
+func authWebOrAPI(scope string, next http.HandlerFunc) http.HandlerFunc {
+	api := authAPI(scope, next)
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-API-Key") != "" {
+			api(w, r)
+			return
+		}
+		user, familyID, err := sessionUser(r)
 		if err != nil {
-			http.Redirect(w, r, "/login", http.StatusSeeOther)
+			jsonError(w, localize(r, "unauthorized"), http.StatusUnauthorized)
 			return
 		}
-
-		ctx := context.WithValue(r.Context(), userContextKey, user)
-		next(w, r.WithContext(ctx))
+		r = withSession(r, user, familyID)
+		if !validCSRF(r) {
+			jsonError(w, localize(r, "csrf_failed"), http.StatusForbidden)
+			return
+		}
+		if needsTwoFactorSetup(user) {
+			jsonError(w, localize(r, "two_factor_setup_required"), http.StatusForbidden)
+			return
+		}
+		next(w, r)
 	}
 }
 
@@ -47,23 +265,50 @@ func authAdmin(next http.HandlerFunc) http.HandlerFunc {
 	return authWeb(func(w http.ResponseWriter, r *http.Request) {
 		user := getContextUser(r)
 		if user == nil || !user.IsAdmin {
-			http.Error(w, "Forbidden", http.StatusForbidden)
+			http.Error(w, localize(r, "forbidden"), http.StatusForbidden)
+			return
+		}
+		next(w, r)
+	})
+}
+
+// authSuperAdmin requires a valid session cookie for the deployment's super-admin.
+func authSuperAdmin(next http.HandlerFunc) http.HandlerFunc {
+	return authWeb(func(w http.ResponseWriter, r *http.Request) {
+		user := getContextUser(r)
+		if user == nil || !user.IsSuperAdmin {
+			http.Error(w, localize(r, "forbidden"), http.StatusForbidden)
 			return
 		}
 		next(w, r)
 	})
 }
 
-// authAPI requires a valid API key in the X-API-Key header.
-func authAPI(next http.HandlerFunc) http.HandlerFunc {
+// authAPI requires a valid, unexpired API key in the X-API-Key header that holds the given scope.
+// An empty scope accepts any valid key.
+func authAPI(scope string, next http.HandlerFunc) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		key := r.Header.Get("X-API-Key")
-		if key == "" || !validateAPIKey(key) {
-			w.Header().Set("Content-Type", "application/json")
-			w.WriteHeader(http.StatusUnauthorized)
-			w.Write([]byte(`{"error":"unauthorized"}`))
+		if key == "" {
+			jsonError(w, localize(r, "unauthorized"), http.StatusUnauthorized)
 			return
 		}
-		next(w, r)
+		apiKey, err := getAPIKeyByKey(key)
+		if err != nil {
+			jsonError(w, localize(r, "unauthorized"), http.StatusUnauthorized)
+			return
+		}
+		if apiKeyExpired(apiKey, time.Now()) {
+			jsonError(w, localize(r, "api_key_expired"), http.StatusUnauthorized)
+			return
+		}
+		if scope != "" && !apiKeyHasScope(apiKey, scope) {
+			jsonError(w, localize(r, "api_key_missing_scope", "scope", scope), http.StatusForbidden)
+			return
+		}
+		touchAPIKey(apiKey.ID)
+		ctx := context.WithValue(r.Context(), apiKeyContextKey, apiKey)
+		ctx = context.WithValue(ctx, familyContextKey, apiKey.FamilyID)
+		next(w, r.WithContext(ctx))
 	}
 }
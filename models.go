@@ -1,48 +1,79 @@
 package main
 
-import "time"
+import (
+	"encoding/json"
+	"time"
+)
 
 type User struct {
 	ID           int
+	FamilyID     int
 	Username     string
 	PasswordHash string
 	IsAdmin      bool
+	IsSuperAdmin bool
+	Lang         string // preferred language, "" to follow the browser
 	Translations map[string]string
 }
 
+type Family struct {
+	ID        int
+	Name      string
+	Members   int
+	CreatedAt time.Time
+}
+
 type Star struct {
-	ID              int
-	UserID          int
-	Username        string
-	UsernameEN      string
-	UsernameCN      string
-	UsernameTW      string
-	ReasonID        *int
-	ReasonKey       string
-	ReasonText      string
-	Reason          string
-	Stars           int
-	AwardedBy       int
-	AwardedByName   string
-	AwardedByNameEN string
-	AwardedByNameCN string
-	AwardedByNameTW string
-	CreatedAt       time.Time
+	ID                    int
+	UserID                int
+	CurrencyID            int
+	Username              string
+	UsernameTranslations  map[string]string
+	ReasonID              *int
+	ReasonKey             string
+	ReasonText            string
+	Reason                string
+	Stars                 int
+	AwardedBy             int
+	AwardedByName         string
+	AwardedByTranslations map[string]string
+	CreatedAt             time.Time
 }
 
 type Reason struct {
 	ID           int
+	FamilyID     int
 	Key          string
 	Translations map[string]string
 	Count        int
 	Stars        int
+	CurrencyID   int
+	CurrencyIcon string
+}
+
+// Currency is a parent-defined kind of points. Currency ID 0 is the built-in
+// stars, which every family has without a row of its own.
+type Currency struct {
+	ID           int
+	FamilyID     int
+	Key          string
+	Icon         string
+	Translations map[string]string
 }
 
 type APIKey struct {
-	ID        int
-	KeyHash   string
-	Label     string
-	CreatedAt time.Time
+	ID         int
+	FamilyID   int
+	KeyHash    string
+	Label      string
+	Scopes     []string
+	OwnerID    int
+	OwnerName  string
+	UserIDs    []int
+	Usernames  []string
+	ExpiresAt  *time.Time
+	LastUsedAt *time.Time
+	CreatedAt  time.Time
 }
 
 type SessionData struct {
@@ -52,27 +83,239 @@ type SessionData struct {
 
 type Reward struct {
 	ID           int
+	FamilyID     int
 	Key          string
 	Name         string
 	Cost         int
 	Icon         string
 	ForAdults    bool
+	CurrencyID   int
+	CurrencyIcon string
 	Translations map[string]string
 }
 
 type Redemption struct {
+	ID                   int
+	UserID               int
+	Username             string
+	UsernameTranslations map[string]string
+	RewardID             int
+	RewardKey            string
+	RewardName           string
+	RewardTranslations   map[string]string
+	Cost                 int
+	CurrencyID           int
+	CurrencyIcon         string
+	CreatedAt            time.Time
+}
+
+type Chore struct {
+	ID           int
+	ReasonID     int
+	ReasonKey    string
+	Translations map[string]string
+	Stars        int
+	CurrencyIcon string
+	Schedule     string // "daily", "weekdays" or "weekly"
+	Weekday      int    // due day for weekly chores (0 = Sunday)
+	DueTime      string // optional "HH:MM" deadline
+	AutoApprove  bool
+	UserIDs      []int
+	Usernames    []string
+}
+
+type ChoreStatus struct {
+	Chore                Chore
+	UserID               int
+	Username             string
+	UsernameTranslations map[string]string
+	Period               string
+	State                string // "due", "overdue", "pending", "approved" or "rejected"
+	CompletionID         int
+}
+
+type ChoreCompletion struct {
+	ID                   int
+	ChoreID              int
+	UserID               int
+	Username             string
+	UsernameTranslations map[string]string
+	ReasonID             int
+	Translations         map[string]string
+	Stars                int
+	CurrencyIcon         string
+	Period               string
+	Status               string
+	CreatedAt            time.Time
+}
+
+type RedemptionRequest struct {
+	ID                   int
+	UserID               int
+	Username             string
+	UsernameTranslations map[string]string
+	RewardID             int
+	RewardIcon           string
+	RewardTranslations   map[string]string
+	Cost                 int
+	CurrencyIcon         string
+	Status               string // "pending", "approved", "rejected" or "cancelled"
+	CreatedAt            time.Time
+}
+
+type SavingsGoal struct {
+	RewardID           int
+	RewardKey          string
+	RewardName         string
+	RewardTranslations map[string]string
+	Icon               string
+	Cost               int
+	CurrencyID         int
+	CurrencyIcon       string
+	Remaining          int
+	Percent            int
+	Reached            bool
+	DailyRate          float64
+	EstimatedDate      *time.Time
+}
+
+type LedgerEntry struct {
 	ID           int
 	UserID       int
 	Username     string
-	UsernameEN   string
-	UsernameCN   string
-	UsernameTW   string
-	RewardID     int
-	RewardKey    string
-	RewardName   string
-	RewardNameEN string
-	RewardNameCN string
-	RewardNameTW string
-	Cost         int
+	CurrencyID   int
+	Kind         string
+	Amount       int
+	Balance      int
+	Earned       int
+	StarID       *int
+	RedemptionID *int
+	ReversesID   *int
+	Note         string
+	CreatedBy    int
 	CreatedAt    time.Time
 }
+
+type AuditEntry struct {
+	ID          int
+	FamilyID    int
+	ActorID     int
+	Actor       string
+	Source      string
+	SourceLabel string
+	Action      string
+	TargetType  string
+	TargetID    int
+	Target      string
+	Before      json.RawMessage
+	After       json.RawMessage
+	CreatedAt   time.Time
+}
+
+type AuditFilter struct {
+	FamilyID   int
+	Actor      string
+	Action     string
+	TargetType string
+	Source     string
+	Limit      int
+}
+
+type Event struct {
+	ID        string
+	FamilyID  int
+	Type      string
+	Data      map[string]interface{}
+	CreatedAt time.Time
+}
+
+// LoggedEvent is an event as kept for the event feed. Cursor orders the
+// feed and is never reused.
+type LoggedEvent struct {
+	Event
+	Cursor int64
+}
+
+type Webhook struct {
+	ID        int
+	FamilyID  int
+	URL       string
+	Secret    string
+	Events    []string
+	Enabled   bool
+	CreatedAt time.Time
+}
+
+type WebhookDelivery struct {
+	ID            int
+	WebhookID     int
+	WebhookURL    string
+	EventID       string
+	EventType     string
+	Payload       string
+	Status        string
+	Attempts      int
+	LastStatus    int
+	LastError     string
+	NextAttemptAt *time.Time
+	DeliveredAt   *time.Time
+	CreatedAt     time.Time
+}
+
+// AnnouncementDelivery is an announcement in the outbox of one backend.
+// Target is the user's own target for it, "" for the family's.
+type AnnouncementDelivery struct {
+	ID            int
+	FamilyID      int
+	Kind          string
+	Target        string
+	Event         string
+	Title         string
+	Message       string
+	Lang          string
+	Username      string
+	Status        string
+	Attempts      int
+	LastStatus    int
+	LastError     string
+	NextAttemptAt *time.Time
+	DeliveredAt   *time.Time
+	CreatedAt     time.Time
+}
+
+// QueuedAnnouncement is a rendered announcement held for its batch window or
+// until quiet hours end. Values fill Template's placeholders.
+type QueuedAnnouncement struct {
+	ID        int
+	FamilyID  int
+	Event     string
+	Username  string
+	Lang      string
+	Template  string
+	Values    map[string]string
+	DeliverAt time.Time
+	CreatedAt time.Time
+}
+
+// LoginThrottle counts the failed logins for one username or client IP.
+// Scope is "user" or "ip".
+type LoginThrottle struct {
+	Scope         string
+	Key           string
+	Failures      int
+	LastFailureAt time.Time
+	LockedUntil   *time.Time
+}
+
+// Session is a device a user is logged in on. ID is the row id, which is
+// shown instead of the token.
+type Session struct {
+	ID         int
+	Token      string
+	UserID     int
+	FamilyID   int
+	UserAgent  string
+	IP         string
+	CreatedAt  time.Time
+	LastSeenAt time.Time
+}
@@ -0,0 +1,378 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"log/slog"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+	mochi "github.com/mochi-mqtt/server/v2"
+	"github.com/mochi-mqtt/server/v2/hooks/auth"
+	"github.com/mochi-mqtt/server/v2/listeners"
+)
+
+// The MQTT integration keeps one connection to the configured broker. It
+// publishes a retained state topic per kid after every balance change,
+// Home Assistant discovery configs so each kid shows up as sensors, and
+// optionally accepts award commands on <base>/award.
+//
+// Topics, with the default base topic "star-app":
+//
+//	star-app/status            online/offline (retained, last will)
+//	star-app/<username>        {"CurrentStars":..,"StarCount":..,"ReservedStars":..} (retained)
+//	star-app/award             award commands
+//	star-app/award/result      outcome of each command
+var (
+	mqttMu        sync.Mutex
+	mqttClient    mqtt.Client
+	mqttLastError string
+)
+
+// mqttSettingKeys lists every settings key used by the MQTT integration.
+var mqttSettingKeys = []string{
+	"mqtt_enabled", "mqtt_broker", "mqtt_username", "mqtt_password",
+	"mqtt_base_topic", "mqtt_discovery_prefix", "mqtt_commands",
+}
+
+type mqttConfig struct {
+	Enabled         bool
+	Broker          string
+	Username        string
+	Password        string
+	BaseTopic       string
+	DiscoveryPrefix string
+	Commands        bool
+}
+
+func getMQTTConfig() mqttConfig {
+	cfg := mqttConfig{
+		Enabled:         getSetting("mqtt_enabled") == "1",
+		Broker:          strings.TrimSpace(getSetting("mqtt_broker")),
+		Username:        getSetting("mqtt_username"),
+		Password:        getSetting("mqtt_password"),
+		BaseTopic:       strings.Trim(strings.TrimSpace(getSetting("mqtt_base_topic")), "/"),
+		DiscoveryPrefix: strings.Trim(strings.TrimSpace(getSetting("mqtt_discovery_prefix")), "/"),
+		Commands:        getSetting("mqtt_commands") == "1",
+	}
+	if cfg.BaseTopic == "" {
+		cfg.BaseTopic = "star-app"
+	}
+	if cfg.DiscoveryPrefix == "" {
+		cfg.DiscoveryPrefix = "homeassistant"
+	}
+	return cfg
+}
+
+// mqttTopicSegment makes a username safe to use as a single topic level.
+func mqttTopicSegment(s string) string {
+	return strings.Map(func(r rune) rune {
+		switch r {
+		case '/', '+', '#', ' ':
+			return '_'
+		}
+		return r
+	}, s)
+}
+
+// startMQTT subscribes the integration to events and connects if it is enabled.
+func startMQTT() {
+	subscribeEvents(mqttHandleEvent)
+	restartMQTT()
+}
+
+// startEmbeddedMQTTBroker runs an in-process broker that accepts any client,
+// for trying and testing the integration without a separate broker. It
+// returns the address it listens on, which has the port picked when addr
+// leaves it to the system (":0").
+func startEmbeddedMQTTBroker(addr string) (*mochi.Server, string, error) {
+	// Client disconnects are logged as warnings, so only show errors
+	logger := slog.New(slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: slog.LevelError}))
+	server := mochi.New(&mochi.Options{InlineClient: true, Logger: logger})
+	if err := server.AddHook(new(auth.AllowHook), nil); err != nil {
+		return nil, "", err
+	}
+	listener := listeners.NewTCP(listeners.Config{ID: "embedded", Address: addr})
+	if err := server.AddListener(listener); err != nil {
+		return nil, "", err
+	}
+	if err := server.Serve(); err != nil {
+		return nil, "", err
+	}
+	return server, listener.Address(), nil
+}
+
+// restartMQTT drops any existing connection and reconnects with the current settings.
+func restartMQTT() {
+	mqttMu.Lock()
+	old := mqttClient
+	mqttClient = nil
+	mqttLastError = ""
+	mqttMu.Unlock()
+	if old != nil {
+		// Publish the offline state ourselves since a clean disconnect skips the last will
+		cfg := getMQTTConfig()
+		old.Publish(cfg.BaseTopic+"/status", 1, true, "offline").WaitTimeout(time.Second)
+		old.Disconnect(250)
+	}
+
+	cfg := getMQTTConfig()
+	if !cfg.Enabled || cfg.Broker == "" {
+		return
+	}
+
+	clientID := "star-app"
+	if suffix, err := randomHex(4); err == nil {
+		clientID += "-" + suffix
+	}
+	opts := mqtt.NewClientOptions().
+		AddBroker(cfg.Broker).
+		SetClientID(clientID).
+		SetConnectTimeout(10*time.Second).
+		SetConnectRetry(true).
+		SetConnectRetryInterval(30*time.Second).
+		SetAutoReconnect(true).
+		SetOrderMatters(false).
+		SetWill(cfg.BaseTopic+"/status", "offline", 1, true)
+	if cfg.Username != "" {
+		opts.SetUsername(cfg.Username)
+		opts.SetPassword(cfg.Password)
+	}
+	opts.SetOnConnectHandler(func(c mqtt.Client) {
+		mqttMu.Lock()
+		mqttLastError = ""
+		mqttMu.Unlock()
+		mqttOnConnect(c, cfg)
+	})
+	opts.SetConnectionLostHandler(func(c mqtt.Client, err error) {
+		log.Printf("MQTT connection lost: %v", err)
+		mqttMu.Lock()
+		mqttLastError = err.Error()
+		mqttMu.Unlock()
+	})
+
+	client := mqtt.NewClient(opts)
+	mqttMu.Lock()
+	mqttClient = client
+	mqttMu.Unlock()
+
+	token := client.Connect()
+	go func() {
+		token.Wait()
+		if err := token.Error(); err != nil {
+			log.Printf("MQTT connect error: %v", err)
+			mqttMu.Lock()
+			mqttLastError = err.Error()
+			mqttMu.Unlock()
+		}
+	}()
+}
+
+// mqttStatus reports the connection state for the admin page.
+func mqttStatus() (connected bool, lastError string) {
+	mqttMu.Lock()
+	defer mqttMu.Unlock()
+	if mqttClient == nil {
+		return false, ""
+	}
+	return mqttClient.IsConnectionOpen(), mqttLastError
+}
+
+// mqttPublish sends a message if the integration is connected. It does not
+// wait for the broker, so it is safe to call from event subscribers.
+func mqttPublish(topic string, retained bool, payload interface{}) {
+	mqttMu.Lock()
+	client := mqttClient
+	mqttMu.Unlock()
+	if client == nil || !client.IsConnectionOpen() {
+		return
+	}
+	client.Publish(topic, 1, retained, payload)
+}
+
+func mqttOnConnect(c mqtt.Client, cfg mqttConfig) {
+	c.Publish(cfg.BaseTopic+"/status", 1, true, "online")
+	mqttPublishAllUsers()
+	if cfg.Commands {
+		c.Subscribe(cfg.BaseTopic+"/award", 1, func(_ mqtt.Client, msg mqtt.Message) {
+			mqttHandleAwardCommand(cfg, msg.Topic(), msg.Payload())
+		})
+	}
+}
+
+// mqttPublishAllUsers republishes discovery and state for every kid in every family.
+func mqttPublishAllUsers() {
+	users, err := getAllUsers(0)
+	if err != nil {
+		return
+	}
+	for i := range users {
+		mqttPublishUser(&users[i])
+	}
+}
+
+// mqttPublishUser publishes a kid's discovery configs and current state.
+func mqttPublishUser(u *User) {
+	if u.IsAdmin {
+		return
+	}
+	cfg := getMQTTConfig()
+	for _, sensor := range mqttSensors(cfg, u) {
+		payload, _ := json.Marshal(sensor.config)
+		mqttPublish(sensor.topic, true, payload)
+	}
+	balance, earned, err := ledgerBalance(db, u.ID, 0)
+	if err != nil {
+		return
+	}
+	mqttPublishState(cfg, u.Username, balance, earned, getUserReserved(u.ID, 0))
+}
+
+// mqttRemoveUser clears a deleted kid's retained discovery configs and state.
+func mqttRemoveUser(u *User) {
+	if u.IsAdmin {
+		return
+	}
+	cfg := getMQTTConfig()
+	for _, sensor := range mqttSensors(cfg, u) {
+		mqttPublish(sensor.topic, true, "")
+	}
+	mqttPublish(cfg.BaseTopic+"/"+mqttTopicSegment(u.Username), true, "")
+}
+
+func mqttPublishState(cfg mqttConfig, username string, balance, earned, reserved int) {
+	payload, _ := json.Marshal(map[string]int{
+		"CurrentStars":  balance,
+		"StarCount":     earned,
+		"ReservedStars": reserved,
+	})
+	mqttPublish(cfg.BaseTopic+"/"+mqttTopicSegment(username), true, payload)
+}
+
+type mqttSensor struct {
+	topic  string
+	config map[string]interface{}
+}
+
+// mqttSensors builds the Home Assistant discovery configs for a kid.
+func mqttSensors(cfg mqttConfig, u *User) []mqttSensor {
+	segment := mqttTopicSegment(u.Username)
+	nodeID := fmt.Sprintf("star_app_%d", u.ID)
+	device := map[string]interface{}{
+		"identifiers":  []string{nodeID},
+		"name":         fmt.Sprintf("%s Stars", getUserText(u.ID, "en")),
+		"manufacturer": "Star Tracker",
+	}
+	sensor := func(objectID, name, field string) mqttSensor {
+		return mqttSensor{
+			topic: fmt.Sprintf("%s/sensor/%s/%s/config", cfg.DiscoveryPrefix, nodeID, objectID),
+			config: map[string]interface{}{
+				"name":                  name,
+				"unique_id":             nodeID + "_" + objectID,
+				"state_topic":           cfg.BaseTopic + "/" + segment,
+				"value_template":        "{{ value_json." + field + " }}",
+				"availability_topic":    cfg.BaseTopic + "/status",
+				"unit_of_measurement":   "stars",
+				"state_class":           "measurement",
+				"icon":                  "mdi:star",
+				"device":                device,
+				"json_attributes_topic": cfg.BaseTopic + "/" + segment,
+			},
+		}
+	}
+	return []mqttSensor{
+		sensor("current_stars", "Current stars", "CurrentStars"),
+		sensor("star_count", "Stars earned", "StarCount"),
+	}
+}
+
+// mqttHandleEvent keeps kids' retained state topics current.
+func mqttHandleEvent(e Event) {
+	if e.Type != eventBalanceChanged {
+		return
+	}
+	userID, _ := e.Data["user_id"].(int)
+	user, err := getUserByID(userID)
+	if err != nil || user.IsAdmin {
+		return
+	}
+	balance, _ := e.Data["balance"].(int)
+	earned, _ := e.Data["earned"].(int)
+	reserved, _ := e.Data["reserved"].(int)
+	mqttPublishState(getMQTTConfig(), user.Username, balance, earned, reserved)
+}
+
+// mqttHandleAwardCommand awards stars from a command message:
+//
+//	{"username": "theo", "reason_key": "helped_with_dishes", "stars": 2}
+//
+// reason_id or a free-text reason may be given instead of reason_key, as with
+// POST /api/stars, and a currency key for a new free-text reason. The outcome is published to <base>/award/result.
+func mqttHandleAwardCommand(cfg mqttConfig, topic string, payload []byte) {
+	var cmd struct {
+		Username  string `json:"username"`
+		ReasonKey string `json:"reason_key"`
+		ReasonID  *int   `json:"reason_id"`
+		Reason    string `json:"reason"`
+		Stars     int    `json:"stars"`
+		Currency  string `json:"currency"`
+	}
+	result := func(fields map[string]interface{}) {
+		body, _ := json.Marshal(fields)
+		mqttPublish(cfg.BaseTopic+"/award/result", false, body)
+	}
+	fail := func(msg string) {
+		log.Printf("MQTT award command rejected: %s", msg)
+		result(map[string]interface{}{"status": "error", "error": msg})
+	}
+
+	if err := json.Unmarshal(payload, &cmd); err != nil {
+		fail("invalid JSON")
+		return
+	}
+	if cmd.Username == "" || (cmd.ReasonKey == "" && cmd.ReasonID == nil && cmd.Reason == "") {
+		fail("username and reason_key (or reason_id, or reason) required")
+		return
+	}
+	user, err := getUserByUsername(cmd.Username)
+	if err != nil {
+		fail("user not found: " + cmd.Username)
+		return
+	}
+	// Reason keys are looked up in the kid's own family
+	if cmd.ReasonKey != "" {
+		id, err := getReasonIDByKey(user.FamilyID, cmd.ReasonKey)
+		if err != nil {
+			fail("reason not found: " + cmd.ReasonKey)
+			return
+		}
+		cmd.ReasonID = &id
+	}
+	currencyID, err := getCurrencyIDByKey(user.FamilyID, cmd.Currency)
+	if err != nil {
+		fail("currency not found: " + cmd.Currency)
+		return
+	}
+
+	starID, err := awardIntegrationStar(cmd.Username, cmd.ReasonID, cmd.Reason, currencyID, cmd.Stars, 0)
+	if err != nil {
+		fail(err.Error())
+		return
+	}
+	if star, err := getStarByID(int(starID)); err == nil {
+		writeAudit(AuditEntry{
+			Action:      "star.award",
+			TargetType:  "user",
+			TargetID:    star.UserID,
+			Target:      cmd.Username,
+			Source:      "mqtt",
+			SourceLabel: topic,
+			FamilyID:    user.FamilyID,
+		}, nil, starSnapshot(star))
+	}
+	result(map[string]interface{}{"status": "ok", "username": cmd.Username, "starId": starID})
+}
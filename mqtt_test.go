@@ -0,0 +1,115 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+)
+
+// mqttRecorder keeps the last message seen on each topic.
+type mqttRecorder struct {
+	mu       sync.Mutex
+	messages map[string][]byte
+}
+
+func (rec *mqttRecorder) handle(_ mqtt.Client, msg mqtt.Message) {
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+	rec.messages[msg.Topic()] = msg.Payload()
+}
+
+// wait returns the last message on topic once check accepts it.
+func (rec *mqttRecorder) wait(t *testing.T, topic string, check func(map[string]interface{}) bool) map[string]interface{} {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		rec.mu.Lock()
+		payload, ok := rec.messages[topic]
+		rec.mu.Unlock()
+		var fields map[string]interface{}
+		if ok && json.Unmarshal(payload, &fields) == nil && check(fields) {
+			return fields
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+	t.Fatalf("no matching message on %s, last: %s", topic, rec.messages[topic])
+	return nil
+}
+
+func TestMQTTEmbeddedBroker(t *testing.T) {
+	openTestDB(t)
+	server, addr, err := startEmbeddedMQTTBroker("127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { server.Close() })
+
+	setSetting("mqtt_enabled", "1")
+	setSetting("mqtt_broker", "tcp://"+addr)
+	setSetting("mqtt_commands", "1")
+	startMQTT()
+	t.Cleanup(func() {
+		setSetting("mqtt_enabled", "0")
+		restartMQTT()
+	})
+
+	rec := &mqttRecorder{messages: map[string][]byte{}}
+	client := mqtt.NewClient(mqtt.NewClientOptions().AddBroker("tcp://" + addr).SetClientID("star-app-test"))
+	if token := client.Connect(); token.WaitTimeout(5*time.Second) && token.Error() != nil {
+		t.Fatal(token.Error())
+	}
+	defer client.Disconnect(100)
+	filters := map[string]byte{"star-app/#": 1, "homeassistant/#": 1}
+	if token := client.SubscribeMultiple(filters, rec.handle); token.WaitTimeout(5*time.Second) && token.Error() != nil {
+		t.Fatal(token.Error())
+	}
+
+	theo, err := getUserByUsername("theo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	rec.wait(t, "star-app/theo", func(state map[string]interface{}) bool {
+		return state["CurrentStars"] == 0.0 && state["StarCount"] == 0.0
+	})
+	config := rec.wait(t, fmt.Sprintf("homeassistant/sensor/star_app_%d/current_stars/config", theo.ID), func(map[string]interface{}) bool { return true })
+	if config["state_topic"] != "star-app/theo" || config["availability_topic"] != "star-app/status" {
+		t.Errorf("current stars discovery config = %v", config)
+	}
+	rec.wait(t, fmt.Sprintf("homeassistant/sensor/star_app_%d/star_count/config", theo.ID), func(config map[string]interface{}) bool {
+		return config["value_template"] == "{{ value_json.StarCount }}"
+	})
+	rec.mu.Lock()
+	for _, parent := range []string{"dad", "mom"} {
+		if _, ok := rec.messages["star-app/"+parent]; ok {
+			t.Errorf("state published for parent %s", parent)
+		}
+	}
+	rec.mu.Unlock()
+
+	// Commands are only read once the integration has subscribed on connect
+	deadline := time.Now().Add(5 * time.Second)
+	for connected, _ := mqttStatus(); !connected && time.Now().Before(deadline); connected, _ = mqttStatus() {
+		time.Sleep(20 * time.Millisecond)
+	}
+	time.Sleep(100 * time.Millisecond)
+
+	client.Publish("star-app/award", 1, false, `{"username": "theo", "reason": "Fed the cat", "stars": 2}`).WaitTimeout(5 * time.Second)
+	result := rec.wait(t, "star-app/award/result", func(map[string]interface{}) bool { return true })
+	if result["status"] != "ok" || result["username"] != "theo" {
+		t.Fatalf("award result = %v", result)
+	}
+	rec.wait(t, "star-app/theo", func(state map[string]interface{}) bool {
+		return state["CurrentStars"] == 2.0 && state["StarCount"] == 2.0
+	})
+
+	client.Publish("star-app/award", 1, false, `{"username": "nobody", "reason": "Fed the cat", "stars": 1}`).WaitTimeout(5 * time.Second)
+	rec.wait(t, "star-app/award/result", func(result map[string]interface{}) bool {
+		return result["status"] == "error" && result["error"] == "user not found: nobody"
+	})
+}
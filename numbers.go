@@ -0,0 +1,326 @@
+package main
+
+import (
+	"strings"
+)
+
+// numberSpeller spells out whole numbers in one language. A language file
+// picks one by name in "numbers".
+type numberSpeller struct {
+	minus string // put in front of negative numbers
+	spell func(n uint64) string
+}
+
+var numberSpellers = map[string]numberSpeller{
+	"en":      {"minus ", spellEnglish},
+	"de":      {"minus ", spellGerman},
+	"es":      {"menos ", spellSpanish},
+	"ja":      {"マイナス", spellJapanese},
+	"zh-Hans": {"负", func(n uint64) string { return spellChinese(n, simplifiedChinese) }},
+	"zh-Hant": {"負", func(n uint64) string { return spellChinese(n, traditionalChinese) }},
+}
+
+// spellNumber writes n out in words with the named speller.
+func spellNumber(n int, speller numberSpeller) string {
+	if n < 0 {
+		return speller.minus + speller.spell(uint64(-(n+1))+1)
+	}
+	return speller.spell(uint64(n))
+}
+
+var (
+	englishOnes = []string{"zero", "one", "two", "three", "four", "five", "six", "seven", "eight", "nine",
+		"ten", "eleven", "twelve", "thirteen", "fourteen", "fifteen", "sixteen", "seventeen", "eighteen", "nineteen"}
+	englishTens   = []string{"", "", "twenty", "thirty", "forty", "fifty", "sixty", "seventy", "eighty", "ninety"}
+	englishScales = []string{"", "thousand", "million", "billion", "trillion", "quadrillion", "quintillion"}
+)
+
+// spellEnglish writes n the American way: "one hundred twenty-five".
+func spellEnglish(n uint64) string {
+	if n == 0 {
+		return englishOnes[0]
+	}
+	var parts []string
+	for scale := 0; n > 0; scale++ {
+		if group := n % 1000; group > 0 {
+			part := englishBelowThousand(group)
+			if scale > 0 {
+				part += " " + englishScales[scale]
+			}
+			parts = append([]string{part}, parts...)
+		}
+		n /= 1000
+	}
+	return strings.Join(parts, " ")
+}
+
+func englishBelowThousand(n uint64) string {
+	var words []string
+	if n >= 100 {
+		words = append(words, englishOnes[n/100], "hundred")
+		n %= 100
+	}
+	switch {
+	case n >= 20:
+		word := englishTens[n/10]
+		if n%10 > 0 {
+			word += "-" + englishOnes[n%10]
+		}
+		words = append(words, word)
+	case n > 0:
+		words = append(words, englishOnes[n])
+	}
+	return strings.Join(words, " ")
+}
+
+var (
+	germanOnes = []string{"", "ein", "zwei", "drei", "vier", "fünf", "sechs", "sieben", "acht", "neun",
+		"zehn", "elf", "zwölf", "dreizehn", "vierzehn", "fünfzehn", "sechzehn", "siebzehn", "achtzehn", "neunzehn"}
+	germanTens = []string{"", "zehn", "zwanzig", "dreißig", "vierzig", "fünfzig", "sechzig", "siebzig", "achtzig", "neunzig"}
+	// Millions and up are separate nouns, singular and plural
+	germanScales = [][2]string{{"Million", "Millionen"}, {"Milliarde", "Milliarden"}, {"Billion", "Billionen"},
+		{"Billiarde", "Billiarden"}, {"Trillion", "Trillionen"}}
+)
+
+// spellGerman writes numbers below a million as one word,
+// "einhundertfünfundzwanzig", and larger ones with "Millionen" and up.
+func spellGerman(n uint64) string {
+	if n == 0 {
+		return "null"
+	}
+	var parts []string
+	for scale := -1; n > 0; scale++ {
+		if scale < 0 {
+			if below := n % 1000000; below > 0 {
+				word := ""
+				if thousands := below / 1000; thousands > 0 {
+					word = germanBelowThousand(thousands) + "tausend"
+				}
+				if rest := below % 1000; rest > 0 {
+					word += germanBelowThousand(rest)
+					// A number ending in one ends in "eins": "hunderteins"
+					if rest%100 == 1 {
+						word += "s"
+					}
+				}
+				parts = append(parts, word)
+			}
+			n /= 1000000
+			continue
+		}
+		if group := n % 1000; group == 1 {
+			parts = append([]string{"eine " + germanScales[scale][0]}, parts...)
+		} else if group > 0 {
+			parts = append([]string{germanBelowThousand(group) + " " + germanScales[scale][1]}, parts...)
+		}
+		n /= 1000
+	}
+	return strings.Join(parts, " ")
+}
+
+func germanBelowThousand(n uint64) string {
+	word := ""
+	if n >= 100 {
+		word = germanOnes[n/100] + "hundert"
+		n %= 100
+	}
+	switch {
+	case n >= 20 && n%10 > 0:
+		word += germanOnes[n%10] + "und" + germanTens[n/10]
+	case n >= 20:
+		word += germanTens[n/10]
+	case n > 0:
+		word += germanOnes[n]
+	}
+	return word
+}
+
+var (
+	// Counts agree with "estrellas", so ones and hundreds are feminine
+	spanishBelowThirty = []string{"cero", "una", "dos", "tres", "cuatro", "cinco", "seis", "siete", "ocho", "nueve",
+		"diez", "once", "doce", "trece", "catorce", "quince", "dieciséis", "diecisiete", "dieciocho", "diecinueve",
+		"veinte", "veintiuna", "veintidós", "veintitrés", "veinticuatro", "veinticinco", "veintiséis", "veintisiete", "veintiocho", "veintinueve"}
+	spanishTens     = []string{"", "", "", "treinta", "cuarenta", "cincuenta", "sesenta", "setenta", "ochenta", "noventa"}
+	spanishHundreds = []string{"", "ciento", "doscientas", "trescientas", "cuatrocientas", "quinientas", "seiscientas", "setecientas", "ochocientas", "novecientas"}
+	// Spanish counts millions in groups of six digits
+	spanishScales = [][2]string{{"millón", "millones"}, {"billón", "billones"}, {"trillón", "trillones"}}
+)
+
+// spellSpanish writes n in feminine forms, "veintiuna", "doscientas", to agree
+// with the stars being counted. Millions and up are masculine nouns.
+func spellSpanish(n uint64) string {
+	if n == 0 {
+		return spanishBelowThirty[0]
+	}
+	var parts []string
+	for scale := -1; n > 0; scale++ {
+		group := n % 1000000
+		n /= 1000000
+		switch {
+		case group == 0:
+		case scale < 0:
+			parts = append(parts, spanishBelowMillion(group, true))
+		case group == 1:
+			parts = append([]string{"un " + spanishScales[scale][0]}, parts...)
+		default:
+			parts = append([]string{spanishBelowMillion(group, false) + " " + spanishScales[scale][1]}, parts...)
+		}
+	}
+	return strings.Join(parts, " ")
+}
+
+func spanishBelowMillion(n uint64, feminine bool) string {
+	var words []string
+	if thousands := n / 1000; thousands == 1 {
+		words = append(words, "mil")
+	} else if thousands > 1 {
+		words = append(words, spanishBelowThousand(thousands, feminine), "mil")
+	}
+	if rest := n % 1000; rest > 0 {
+		words = append(words, spanishBelowThousand(rest, feminine))
+	}
+	return strings.Join(words, " ")
+}
+
+func spanishBelowThousand(n uint64, feminine bool) string {
+	var words []string
+	if n == 100 {
+		return "cien"
+	}
+	if n >= 100 {
+		words = append(words, spanishHundreds[n/100])
+		n %= 100
+	}
+	switch {
+	case n >= 30:
+		words = append(words, spanishTens[n/10])
+		if n%10 > 0 {
+			words = append(words, "y", spanishBelowThirty[n%10])
+		}
+	case n > 0:
+		words = append(words, spanishBelowThirty[n])
+	}
+	word := strings.Join(words, " ")
+	if !feminine {
+		// Before a masculine noun: "veintiún millones", "doscientos millones"
+		word = strings.ReplaceAll(word, "ientas", "ientos")
+		if strings.HasSuffix(word, "veintiuna") {
+			word = strings.TrimSuffix(word, "veintiuna") + "veintiún"
+		} else if strings.HasSuffix(word, "una") {
+			word = strings.TrimSuffix(word, "una") + "un"
+		}
+	}
+	return word
+}
+
+// chineseNumerals are the characters that differ between simplified and
+// traditional Chinese.
+type chineseNumerals struct {
+	two   string   // 两 or 兩, said before measure words and large units
+	units []string // 万, 亿, … for each group of four digits
+}
+
+var (
+	chineseDigits      = []string{"零", "一", "二", "三", "四", "五", "六", "七", "八", "九"}
+	chinesePlaces      = []string{"千", "百", "十", ""}
+	simplifiedChinese  = chineseNumerals{two: "两", units: []string{"", "万", "亿", "万亿", "亿亿"}}
+	traditionalChinese = chineseNumerals{two: "兩", units: []string{"", "萬", "億", "兆", "京"}}
+)
+
+// spellChinese writes n in groups of four digits, 一万零五, with 两 for two
+// on its own and in front of 百, 千 and the large units, and 十 rather than
+// 一十 at the start.
+func spellChinese(n uint64, numerals chineseNumerals) string {
+	if n == 0 {
+		return chineseDigits[0]
+	}
+	var groups []uint64
+	for ; n > 0; n /= 10000 {
+		groups = append(groups, n%10000)
+	}
+	var b strings.Builder
+	zero := false
+	for i := len(groups) - 1; i >= 0; i-- {
+		group := groups[i]
+		if group == 0 {
+			zero = b.Len() > 0
+			continue
+		}
+		// A group short of four digits after a larger one reads with a 零
+		if b.Len() > 0 && (zero || group < 1000) {
+			b.WriteString(chineseDigits[0])
+		}
+		zero = false
+		if group == 2 && (i > 0 || b.Len() == 0) {
+			b.WriteString(numerals.two)
+		} else {
+			b.WriteString(chineseGroup(group, b.Len() == 0, numerals))
+		}
+		b.WriteString(numerals.units[i])
+	}
+	return b.String()
+}
+
+func chineseGroup(group uint64, first bool, numerals chineseNumerals) string {
+	digits := []uint64{group / 1000, group / 100 % 10, group / 10 % 10, group % 10}
+	var b strings.Builder
+	zero := false
+	for place, d := range digits {
+		if d == 0 {
+			zero = b.Len() > 0
+			continue
+		}
+		if zero {
+			b.WriteString(chineseDigits[0])
+			zero = false
+		}
+		switch {
+		case d == 1 && place == 2 && first && b.Len() == 0:
+			// 十五, not 一十五
+		case d == 2 && place < 2 && b.Len() == 0:
+			b.WriteString(numerals.two)
+		default:
+			b.WriteString(chineseDigits[d])
+		}
+		b.WriteString(chinesePlaces[place])
+	}
+	return b.String()
+}
+
+var (
+	japaneseDigits = []string{"", "一", "二", "三", "四", "五", "六", "七", "八", "九"}
+	japanesePlaces = []string{"千", "百", "十", ""}
+	japaneseUnits  = []string{"", "万", "億", "兆", "京"}
+)
+
+// spellJapanese writes n in kanji in groups of four digits, leaving out the
+// 一 in front of 十, 百 and 千 as Japanese does: 百二十五, 一万五千.
+func spellJapanese(n uint64) string {
+	if n == 0 {
+		return "ゼロ"
+	}
+	var groups []uint64
+	for ; n > 0; n /= 10000 {
+		groups = append(groups, n%10000)
+	}
+	var b strings.Builder
+	for i := len(groups) - 1; i >= 0; i-- {
+		group := groups[i]
+		if group == 0 {
+			continue
+		}
+		digits := []uint64{group / 1000, group / 100 % 10, group / 10 % 10, group % 10}
+		for place, d := range digits {
+			if d == 0 {
+				continue
+			}
+			// 一千万 keeps its 一, since 千万 alone reads oddly
+			if d > 1 || place == 3 || (place == 0 && i > 0) {
+				b.WriteString(japaneseDigits[d])
+			}
+			b.WriteString(japanesePlaces[place])
+		}
+		b.WriteString(japaneseUnits[i])
+	}
+	return b.String()
+}
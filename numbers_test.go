@@ -0,0 +1,83 @@
+package main
+
+import (
+	"math"
+	"testing"
+)
+
+func TestNumberSpellers(t *testing.T) {
+	tests := []struct {
+		speller string
+		n       uint64
+		want    string
+	}{
+		{"en", 0, "zero"},
+		{"en", 1, "one"},
+		{"en", 21, "twenty-one"},
+		{"en", 125, "one hundred twenty-five"},
+		{"en", 1000001, "one million one"},
+
+		{"de", 1, "eins"},
+		{"de", 21, "einundzwanzig"},
+		{"de", 101, "einhunderteins"},
+		{"de", 125, "einhundertfünfundzwanzig"},
+		{"de", 2001, "zweitausendeins"},
+		{"de", 1000000, "eine Million"},
+		{"de", 2000000, "zwei Millionen"},
+		{"de", 1000001, "eine Million eins"},
+
+		// Feminine, agreeing with "estrellas", except before millions
+		{"es", 1, "una"},
+		{"es", 21, "veintiuna"},
+		{"es", 100, "cien"},
+		{"es", 101, "ciento una"},
+		{"es", 200, "doscientas"},
+		{"es", 1000000, "un millón"},
+		{"es", 21000000, "veintiún millones"},
+		{"es", 200000000, "doscientos millones"},
+
+		{"zh-Hans", 2, "两"},
+		{"zh-Hans", 12, "十二"},
+		{"zh-Hans", 20, "二十"},
+		{"zh-Hans", 200, "两百"},
+		{"zh-Hans", 10010, "一万零一十"},
+		{"zh-Hans", 20000, "两万"},
+		{"zh-Hant", 2, "兩"},
+		{"zh-Hant", 20, "二十"},
+		{"zh-Hant", 200, "兩百"},
+		{"zh-Hant", 10010, "一萬零一十"},
+		{"zh-Hant", 20000, "兩萬"},
+
+		{"ja", 0, "ゼロ"},
+		{"ja", 125, "百二十五"},
+		{"ja", 10000, "一万"},
+		{"ja", 10000000, "一千万"},
+	}
+	for _, tt := range tests {
+		if got := numberSpellers[tt.speller].spell(tt.n); got != tt.want {
+			t.Errorf("%s %d = %q, want %q", tt.speller, tt.n, got, tt.want)
+		}
+	}
+}
+
+func TestSpellNegativeNumbers(t *testing.T) {
+	tests := []struct {
+		speller string
+		n       int
+		want    string
+	}{
+		{"en", -5, "minus five"},
+		{"en", math.MinInt, "minus nine quintillion two hundred twenty-three quadrillion three hundred seventy-two trillion " +
+			"thirty-six billion eight hundred fifty-four million seven hundred seventy-five thousand eight hundred eight"},
+		{"de", -1, "minus eins"},
+		{"es", -21, "menos veintiuna"},
+		{"zh-Hans", -2, "负两"},
+		{"zh-Hant", -2, "負兩"},
+		{"ja", -125, "マイナス百二十五"},
+	}
+	for _, tt := range tests {
+		if got := spellNumber(tt.n, numberSpellers[tt.speller]); got != tt.want {
+			t.Errorf("%s %d = %q, want %q", tt.speller, tt.n, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// A session ends 30 days after login, or after a week without use, whichever
+// comes first. The cookie is given the same lifetime.
+const (
+	sessionLifetime     = 30 * 24 * time.Hour
+	sessionIdleTimeout  = 7 * 24 * time.Hour
+	sessionTouchEvery   = time.Minute
+	sessionCleanupEvery = time.Hour
+)
+
+var errSessionExpired = errors.New("session expired")
+
+// startSessionCleanup drops expired sessions every sessionCleanupEvery.
+func startSessionCleanup() {
+	go func() {
+		for {
+			now := time.Now()
+			pruneSessions(now.Add(-sessionLifetime), now.Add(-sessionIdleTimeout))
+			time.Sleep(sessionCleanupEvery)
+		}
+	}()
+}
+
+// expired reports whether s is past its lifetime or has been idle too long.
+func (s *Session) expired(now time.Time) bool {
+	return now.Sub(s.CreatedAt) > sessionLifetime || now.Sub(s.LastSeenAt) > sessionIdleTimeout
+}
+
+// setSessionCookie gives the browser the session token, or clears it when
+// token is "".
+func setSessionCookie(w http.ResponseWriter, r *http.Request, token string) {
+	maxAge := int(sessionLifetime / time.Second)
+	if token == "" {
+		maxAge = -1
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name:     "session",
+		Value:    token,
+		Path:     "/",
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+		Secure:   sessionCookieSecure(r),
+		MaxAge:   maxAge,
+	})
+}
+
+// describeUserAgent names the browser and system in a User-Agent header,
+// "Firefox on Windows", falling back to the header itself.
+func describeUserAgent(ua string) string {
+	browser := ""
+	// Checked in order, since most browsers also claim to be Safari or Chrome
+	for _, b := range []struct{ token, name string }{
+		{"Edg/", "Edge"},
+		{"OPR/", "Opera"},
+		{"Firefox/", "Firefox"},
+		{"FxiOS/", "Firefox"},
+		{"CriOS/", "Chrome"},
+		{"Chrome/", "Chrome"},
+		{"Safari/", "Safari"},
+		{"Home Assistant", "Home Assistant"},
+		{"curl/", "curl"},
+	} {
+		if strings.Contains(ua, b.token) {
+			browser = b.name
+			break
+		}
+	}
+	system := ""
+	for _, s := range []struct{ token, name string }{
+		{"iPhone", "iPhone"},
+		{"iPad", "iPad"},
+		{"Android", "Android"},
+		{"CrOS", "ChromeOS"},
+		{"Windows", "Windows"},
+		{"Mac OS X", "macOS"},
+		{"Linux", "Linux"},
+	} {
+		if strings.Contains(ua, s.token) {
+			system = s.name
+			break
+		}
+	}
+	switch {
+	case browser != "" && system != "":
+		return browser + " on " + system
+	case browser != "":
+		return browser
+	case system != "":
+		return system
+	}
+	return ua
+}
+
+// sessionView is a session as listed on the account page.
+type sessionView struct {
+	ID         int
+	Device     string
+	UserAgent  string
+	IP         string
+	CreatedAt  time.Time
+	LastSeenAt time.Time
+	Current    bool
+}
+
+// sessionViews lists the user's sessions, marking the one with token.
+func sessionViews(userID int, token string) []sessionView {
+	sessions, _ := getUserSessions(userID)
+	now := time.Now()
+	var views []sessionView
+	for _, s := range sessions {
+		if s.expired(now) {
+			continue
+		}
+		views = append(views, sessionView{
+			ID:         s.ID,
+			Device:     describeUserAgent(s.UserAgent),
+			UserAgent:  s.UserAgent,
+			IP:         s.IP,
+			CreatedAt:  s.CreatedAt,
+			LastSeenAt: s.LastSeenAt,
+			Current:    s.Token == token,
+		})
+	}
+	return views
+}
+
+// sessionToken returns the request's session token, "" if it has none.
+func sessionToken(r *http.Request) string {
+	if cookie, err := r.Cookie("session"); err == nil {
+		return cookie.Value
+	}
+	return ""
+}
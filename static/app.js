@@ -1,3 +1,19 @@
+// Requests that change something carry the page's CSRF token
+(function() {
+    var meta = document.querySelector('meta[name="csrf-token"]');
+    if (!meta) return;
+    var send = window.fetch;
+    window.fetch = function(url, options) {
+        options = options || {};
+        var method = (options.method || 'GET').toUpperCase();
+        if (method !== 'GET' && method !== 'HEAD') {
+            options.headers = new Headers(options.headers || {});
+            options.headers.set('X-CSRF-Token', meta.content);
+        }
+        return send.call(window, url, options);
+    };
+})();
+
 var selectedUsers = [];
 var selectionMode = 'individual'; // 'individual' or 'multiple'
 
@@ -47,8 +63,7 @@ function updateSelection() {
             if (card) {
                 var nameEl = card.querySelector('.user-name');
                 if (nameEl) {
-                    var langKey = currentLang === 'zh-CN' ? 'zh-cn' : (currentLang === 'zh-TW' ? 'zh-tw' : 'en');
-                    return nameEl.getAttribute('data-' + langKey) || username;
+                    return trText(nameEl) || username;
                 }
             }
             return username;
@@ -144,6 +159,23 @@ function updateStarCounts(counts) {
         if (!card) return;
         card.querySelector('.star-number').textContent = c.CurrentStars;
         card.querySelector('.star-total').textContent = c.StarCount + ' total earned';
+        var reserved = card.querySelector('.star-reserved');
+        if (reserved) {
+            reserved.querySelector('.reserved-number').textContent = c.ReservedStars || 0;
+            reserved.style.display = c.ReservedStars ? '' : 'none';
+        }
+        (c.Balances || []).forEach(function(b) {
+            var chip = card.querySelector('.currency-chip[data-currency-id="' + b.CurrencyID + '"] .currency-number');
+            if (chip) chip.textContent = b.Balance;
+        });
+        var goal = card.querySelector('.goal');
+        if (goal && c.Goal) {
+            goal.classList.toggle('reached', c.Goal.Reached);
+            goal.querySelector('.goal-fill').style.width = c.Goal.Percent + '%';
+            goal.querySelector('.goal-remaining-number').textContent = c.Goal.Remaining;
+            goal.querySelector('.goal-ready').style.display = c.Goal.Reached ? '' : 'none';
+            goal.querySelector('.goal-progress').style.display = c.Goal.Reached ? 'none' : '';
+        }
     });
 }
 
@@ -158,11 +190,100 @@ function playStarAnim(username, emoji) {
     }
 }
 
+// translatedCell builds a table cell that applyLang can re-translate later.
+// texts maps language codes to text; fallback is shown where it has none.
+function translatedCell(className, texts, fallback) {
+    var td = document.createElement('td');
+    td.className = className;
+    texts = texts || {};
+    if (fallback && !texts.en) texts.en = fallback;
+    td.setAttribute('data-tr', JSON.stringify(texts));
+    td.textContent = trText(td);
+    return td;
+}
+
+// itemTexts reads the translations a template put on a reason or reward.
+function itemTexts(item) {
+    if (!item) return null;
+    try { return JSON.parse(item.getAttribute('data-tr') || '{}'); } catch (e) { return null; }
+}
+
+// memberNameCell shows a member's display name, taken from their card.
+function memberNameCell(username) {
+    var nameEl = document.querySelector('.member-card[data-username="' + username + '"] .user-name');
+    return translatedCell('user-name', itemTexts(nameEl), username);
+}
+
+function timeCell(isoTime) {
+    var td = document.createElement('td');
+    td.className = 'local-time';
+    td.setAttribute('data-time', isoTime);
+    return td;
+}
+
+// undoCell holds a remove button for parents, except on their own rows.
+function undoCell(username, title, onUndo) {
+    var td = document.createElement('td');
+    var self = document.querySelector('.member-card[data-self="true"]');
+    if (document.getElementById('actionBar') && !(self && self.dataset.username === username)) {
+        var btn = document.createElement('button');
+        btn.className = 'btn-undo';
+        btn.title = title;
+        btn.textContent = '✕';
+        btn.onclick = onUndo;
+        td.appendChild(btn);
+    }
+    return td;
+}
+
+// addStarRow prepends a star to Recent Stars unless it is already listed.
+function addStarRow(s) {
+    var tbody = document.getElementById('starRows');
+    if (!tbody || tbody.querySelector('tr[data-star-id="' + s.id + '"]')) return;
+    if (tbody.querySelector('td[colspan]')) tbody.innerHTML = '';
+    var tr = document.createElement('tr');
+    tr.dataset.starId = s.id;
+    tr.dataset.username = s.username;
+    tr.appendChild(memberNameCell(s.username));
+    tr.appendChild(translatedCell('star-reason', s.reasons, s.reason));
+    tr.appendChild(s.awardedBy ? memberNameCell(s.awardedBy) : translatedCell('user-name', null, ''));
+    tr.appendChild(timeCell(s.time));
+    tr.appendChild(undoCell(s.username, 'Remove this star', function() { undoStar(s.id); }));
+    tbody.insertBefore(tr, tbody.firstChild);
+    formatLocalTimes();
+    playStarAnim(s.username, s.icon || '⭐');
+}
+
+// addRedemptionRow prepends a redemption to Recent Redemptions unless it is already listed.
+function addRedemptionRow(rd) {
+    var tbody = document.getElementById('redemptionRows');
+    if (!tbody || tbody.querySelector('tr[data-redemption-id="' + rd.id + '"]')) return;
+    if (tbody.querySelector('td[colspan]')) tbody.innerHTML = '';
+    var tr = document.createElement('tr');
+    tr.dataset.redemptionId = rd.id;
+    tr.dataset.username = rd.username;
+    tr.appendChild(memberNameCell(rd.username));
+    tr.appendChild(translatedCell('reward-name', rd.rewards, rd.reward));
+    var cost = document.createElement('td');
+    cost.textContent = rd.cost + ' ' + (rd.icon || '⭐');
+    tr.appendChild(cost);
+    tr.appendChild(timeCell(rd.time));
+    if (document.getElementById('actionBar')) {
+        tr.appendChild(undoCell(rd.username, 'Remove this redemption', function() { undoRedemption(rd.id); }));
+    }
+    tbody.insertBefore(tr, tbody.firstChild);
+    formatLocalTimes();
+    playStarAnim(rd.username, '🎁');
+}
+
+function removeRow(selector) {
+    var row = document.querySelector(selector);
+    if (row) row.remove();
+}
+
 function submitStarByReason(reasonId) {
     var item = document.querySelector('.reason-item[data-reason-id="' + reasonId + '"]');
-    var langKey = currentLang === 'zh-CN' ? 'zh-cn' : (currentLang === 'zh-TW' ? 'zh-tw' : 'en');
-    var reasonText = item.getAttribute('data-' + langKey) || item.getAttribute('data-en');
-    submitStar(reasonText, reasonId);
+    submitStar(trText(item), reasonId);
 }
 
 function submitStar(reason, reasonId, stars) {
@@ -180,6 +301,10 @@ function submitStar(reason, reasonId, stars) {
         if (stars && parseInt(stars) !== 0) {
             body.append('stars', stars);
         }
+        var cur = document.getElementById('customCurrency');
+        if (!reasonId && cur) {
+            body.append('currency_id', cur.value);
+        }
 
         fetch("/star", {
             method: "POST",
@@ -193,78 +318,16 @@ function submitStar(reason, reasonId, stars) {
         .then(function(data) {
             if (!data) return;
             updateStarCounts(data.counts);
-            var tbody = document.querySelectorAll('table')[1].querySelector('tbody');
-            var noRows = tbody.querySelector('td[colspan]');
-            if (noRows) tbody.innerHTML = '';
-            var tr = document.createElement('tr');
-            tr.dataset.username = username;
-            tr.dataset.starId = data.starId || '';
-            var now = new Date();
-            var isoTime = now.toISOString();
-
-            // Format time using current language
-            var locale = currentLang === 'zh-CN' ? 'zh-CN' : (currentLang === 'zh-TW' ? 'zh-TW' : 'en-US');
-            var options = { month: 'short', day: 'numeric', hour: '2-digit', minute: '2-digit', hour12: false };
-            var time = now.toLocaleString(locale, options);
-            if (currentLang === 'en') {
-                time = time.replace(',', '');
-            }
-
-            // Get user translations for username
-            var userCard = document.querySelector('.member-card[data-username="' + username + '"]');
-            var usernameHtml = '<td class="user-name"';
-            if (userCard) {
-                var userNameEl = userCard.querySelector('.user-name');
-                if (userNameEl) {
-                    usernameHtml += ' data-en="' + (userNameEl.getAttribute('data-en') || '') + '"';
-                    usernameHtml += ' data-zh-cn="' + (userNameEl.getAttribute('data-zh-cn') || '') + '"';
-                    usernameHtml += ' data-zh-tw="' + (userNameEl.getAttribute('data-zh-tw') || '') + '"';
-                    usernameHtml += '>' + (userNameEl.getAttribute('data-' + (currentLang === 'zh-CN' ? 'zh-cn' : (currentLang === 'zh-TW' ? 'zh-tw' : 'en'))) || username);
-                } else {
-                    usernameHtml += '>' + username;
-                }
-            } else {
-                usernameHtml += '>' + username;
-            }
-            usernameHtml += '</td>';
-
-            // Get translations from the reason item if it's a predefined reason
-            var reasonTd = '<td class="star-reason"';
-            if (reasonId) {
-                var item = document.querySelector('.reason-item[data-reason-id="' + reasonId + '"]');
-                if (item) {
-                    reasonTd += ' data-en="' + (item.getAttribute('data-en') || '') + '"';
-                    reasonTd += ' data-zh-cn="' + (item.getAttribute('data-zh-cn') || '') + '"';
-                    reasonTd += ' data-zh-tw="' + (item.getAttribute('data-zh-tw') || '') + '"';
-                }
-            }
-            reasonTd += '>' + reason + '</td>';
-
-            // Get awarded by user translations
-            var awardedByHtml = '<td class="user-name"';
-            var awardedByCard = document.querySelector('.member-card[data-username="' + data.awardedBy + '"]');
-            if (awardedByCard) {
-                var awardedByNameEl = awardedByCard.querySelector('.user-name');
-                if (awardedByNameEl) {
-                    awardedByHtml += ' data-en="' + (awardedByNameEl.getAttribute('data-en') || '') + '"';
-                    awardedByHtml += ' data-zh-cn="' + (awardedByNameEl.getAttribute('data-zh-cn') || '') + '"';
-                    awardedByHtml += ' data-zh-tw="' + (awardedByNameEl.getAttribute('data-zh-tw') || '') + '"';
-                    awardedByHtml += '>' + (awardedByNameEl.getAttribute('data-' + (currentLang === 'zh-CN' ? 'zh-cn' : (currentLang === 'zh-TW' ? 'zh-tw' : 'en'))) || data.awardedBy);
-                } else {
-                    awardedByHtml += '>' + data.awardedBy;
-                }
-            } else {
-                awardedByHtml += '>' + data.awardedBy;
-            }
-            awardedByHtml += '</td>';
-
-            // Check if user is admin to add undo button
-            var actionBar = document.getElementById('actionBar');
-            var actionTd = actionBar ? '<td><button class="btn-undo" onclick="undoStar(' + (data.starId || '') + ')" title="Remove this star">✕</button></td>' : '<td></td>';
-
-            tr.innerHTML = usernameHtml + reasonTd + awardedByHtml + '<td class="local-time" data-time="' + isoTime + '">' + time + '</td>' + actionTd;
-            tbody.insertBefore(tr, tbody.firstChild);
-            playStarAnim(username, '⭐');
+            var item = reasonId ? document.querySelector('.reason-item[data-reason-id="' + reasonId + '"]') : null;
+            addStarRow({
+                id: data.starId,
+                username: username,
+                reason: reason,
+                reasons: itemTexts(item),
+                awardedBy: data.awardedBy,
+                icon: data.icon,
+                time: new Date().toISOString()
+            });
             awardNext(i + 1);
         });
     }
@@ -275,11 +338,11 @@ function submitStar(reason, reasonId, stars) {
     if (si) si.value = '1';
 }
 
-function submitRedeem(rewardId, rewardName, cost) {
+function submitRedeem(rewardId, rewardName, cost, icon) {
     var targets = getSelectedNonSelf();
     if (targets.length === 0) return;
     var names = targets.join(', ');
-    if (!confirm("Spend " + cost + " stars each for " + names + " on \"" + rewardName + "\"?")) return;
+    if (!confirm("Spend " + cost + " " + (icon || "stars") + " each for " + names + " on \"" + rewardName + "\"?")) return;
 
     function redeemNext(i) {
         if (i >= targets.length) return;
@@ -297,49 +360,94 @@ function submitRedeem(rewardId, rewardName, cost) {
         .then(function(data) {
             if (!data) return;
             updateStarCounts(data.counts);
-            var tbody = document.querySelectorAll('table')[0].querySelector('tbody');
-            var noRows = tbody.querySelector('td[colspan]');
-            if (noRows) tbody.innerHTML = '';
-            var tr = document.createElement('tr');
-            tr.dataset.username = username;
-            var now = new Date();
-            var isoTime = now.toISOString();
-
-            // Format time using current language
-            var locale = currentLang === 'zh-CN' ? 'zh-CN' : (currentLang === 'zh-TW' ? 'zh-TW' : 'en-US');
-            var options = { month: 'short', day: 'numeric', hour: '2-digit', minute: '2-digit', hour12: false };
-            var time = now.toLocaleString(locale, options);
-            if (currentLang === 'en') {
-                time = time.replace(',', '');
-            }
-
-            // Get user translations for username
-            var userCard = document.querySelector('.member-card[data-username="' + username + '"]');
-            var usernameHtml = '<td class="user-name"';
-            if (userCard) {
-                var userNameEl = userCard.querySelector('.user-name');
-                if (userNameEl) {
-                    usernameHtml += ' data-en="' + (userNameEl.getAttribute('data-en') || '') + '"';
-                    usernameHtml += ' data-zh-cn="' + (userNameEl.getAttribute('data-zh-cn') || '') + '"';
-                    usernameHtml += ' data-zh-tw="' + (userNameEl.getAttribute('data-zh-tw') || '') + '"';
-                    usernameHtml += '>' + (userNameEl.getAttribute('data-' + (currentLang === 'zh-CN' ? 'zh-cn' : (currentLang === 'zh-TW' ? 'zh-tw' : 'en'))) || username);
-                } else {
-                    usernameHtml += '>' + username;
-                }
-            } else {
-                usernameHtml += '>' + username;
-            }
-            usernameHtml += '</td>';
-
-            tr.innerHTML = usernameHtml + '<td>' + data.rewardName + '</td><td>' + data.cost + ' ⭐</td><td class="local-time" data-time="' + isoTime + '">' + time + '</td>';
-            tbody.insertBefore(tr, tbody.firstChild);
-            playStarAnim(username, '🎁');
+            var item = document.querySelector('#redeemPanel .reason-item[data-reward-id="' + rewardId + '"]');
+            addRedemptionRow({
+                id: data.redemptionId,
+                username: username,
+                reward: data.rewardName,
+                rewards: itemTexts(item),
+                cost: data.cost,
+                icon: data.icon,
+                time: new Date().toISOString()
+            });
             redeemNext(i + 1);
         });
     }
     redeemNext(0);
 }
 
+function requestRedemption(rewardId, rewardName, cost, icon) {
+    var dict = translations[currentLang] || translations.en;
+    var msg = (dict.confirm_request_reward || "Ask a parent for \"{reward}\" ({cost})?").replace("{reward}", rewardName).replace("{cost}", cost + " " + (icon || "⭐"));
+    if (!confirm(msg)) return;
+    var body = new URLSearchParams({reward_id: rewardId});
+    fetch("/redeem/request", {
+        method: "POST",
+        body: body
+    })
+    .then(function(resp) {
+        if (!resp.ok) return resp.text().then(function(t) { alert(t); return null; });
+        return resp.json();
+    })
+    .then(function(data) {
+        if (!data) return;
+        location.reload();
+    });
+}
+
+function setGoal(rewardId, username) {
+    var body = new URLSearchParams({reward_id: rewardId});
+    if (username) body.append('username', username);
+    fetch("/goal", {
+        method: "POST",
+        body: body
+    })
+    .then(function(resp) {
+        if (!resp.ok) return resp.text().then(function(t) { alert(t); return null; });
+        return resp.json();
+    })
+    .then(function(counts) {
+        if (!counts) return;
+        location.reload();
+    });
+}
+
+function clearGoal(username) {
+    fetch("/goal?username=" + encodeURIComponent(username), { method: "DELETE" })
+    .then(function(resp) {
+        if (!resp.ok) return resp.text().then(function(t) { alert(t); return null; });
+        return resp.json();
+    })
+    .then(function(counts) {
+        if (!counts) return;
+        location.reload();
+    });
+}
+
+function cancelRedemptionRequest(id) {
+    fetch("/redeem/request/" + id, { method: "DELETE" })
+    .then(function(resp) {
+        if (!resp.ok) return resp.text().then(function(t) { alert(t); return null; });
+        return resp.json();
+    })
+    .then(function(counts) {
+        if (!counts) return;
+        location.reload();
+    });
+}
+
+function reviewRedemptionRequest(id, action) {
+    fetch("/redeem/request/" + id + "/" + action, { method: "POST" })
+    .then(function(resp) {
+        if (!resp.ok) return resp.text().then(function(t) { alert(t); return null; });
+        return resp.json();
+    })
+    .then(function(counts) {
+        if (!counts) return;
+        location.reload();
+    });
+}
+
 function editUserTrans(userId, lang, cell) {
     var currentText = cell.textContent;
     var input = document.createElement('input');
@@ -380,6 +488,16 @@ function editUserTrans(userId, lang, cell) {
     input.select();
 }
 
+function setUserLanguage(userId, lang) {
+    fetch("/admin/user/" + userId + "/language", {
+        method: "PUT",
+        body: new URLSearchParams({lang: lang})
+    })
+    .then(function(resp) {
+        if (!resp.ok) return resp.text().then(function(t) { alert(t); });
+    });
+}
+
 function editReasonTrans(reasonId, lang, cell) {
     var currentText = cell.textContent;
     var input = document.createElement('input');
@@ -501,6 +619,36 @@ function undoRedemption(id) {
     });
 }
 
+function completeChore(id) {
+    fetch("/chore/" + id + "/done", { method: "POST" })
+    .then(function(resp) {
+        if (!resp.ok) return resp.text().then(function(t) { alert(t); return null; });
+        return resp.json();
+    })
+    .then(function(data) {
+        if (!data) return;
+        location.reload();
+    });
+}
+
+function reviewChore(id, action) {
+    fetch("/chore/completion/" + id + "/" + action, { method: "POST" })
+    .then(function(resp) {
+        if (!resp.ok) return resp.text().then(function(t) { alert(t); return null; });
+        return resp.json();
+    })
+    .then(function(counts) {
+        if (!counts) return;
+        location.reload();
+    });
+}
+
+function deleteChore(id) {
+    if (!confirm("Delete this chore?")) return;
+    fetch("/admin/chore/" + id, { method: "DELETE" })
+        .then(function() { location.reload(); });
+}
+
 function editRewardTrans(rewardId, lang, cell) {
     var currentText = cell.textContent;
     var input = document.createElement('input');
@@ -590,24 +738,160 @@ function deleteReward(id) {
         .then(function() { location.reload(); });
 }
 
+function editCurrencyTrans(currencyId, lang, cell) {
+    var currentText = cell.textContent;
+    var input = document.createElement('input');
+    input.type = 'text';
+    input.value = currentText;
+    input.style.width = '100%';
+
+    function save() {
+        var newText = input.value.trim();
+        if (newText && newText !== currentText) {
+            var body = new URLSearchParams({lang: lang, text: newText});
+            fetch("/admin/currency/" + currencyId, {
+                method: "PUT",
+                body: body
+            })
+            .then(function(resp) { return resp.json(); })
+            .then(function() {
+                cell.textContent = newText;
+            });
+        } else {
+            cell.textContent = currentText;
+        }
+    }
+
+    input.onblur = save;
+    input.onkeydown = function(e) {
+        if (e.key === 'Enter') {
+            e.preventDefault();
+            save();
+        } else if (e.key === 'Escape') {
+            cell.textContent = currentText;
+        }
+    };
+
+    cell.textContent = '';
+    cell.appendChild(input);
+    input.focus();
+    input.select();
+}
+
+function editCurrencyIcon(currencyId, cell) {
+    var icon = prompt("Icon", cell.textContent.trim());
+    if (!icon || !icon.trim()) return;
+    fetch("/admin/currency/" + currencyId, {
+        method: "PUT",
+        body: new URLSearchParams({icon: icon.trim()})
+    })
+    .then(function(resp) { return resp.json(); })
+    .then(function() { cell.textContent = icon.trim(); });
+}
+
+function deleteCurrency(id) {
+    if (!confirm("Delete this currency?")) return;
+    fetch("/admin/currency/" + id, { method: "DELETE" })
+        .then(function(resp) {
+            if (!resp.ok) return resp.text().then(function(t) { alert(t); });
+            location.reload();
+        });
+}
+
 function deleteKey(id) {
     if (!confirm("Revoke this API key?")) return;
     fetch("/admin/apikey/" + id, { method: "DELETE" })
         .then(function() { location.reload(); });
 }
 
+function deleteWebhook(id) {
+    if (!confirm("Delete this webhook and its delivery log?")) return;
+    fetch("/admin/webhook/" + id, { method: "DELETE" })
+        .then(function() { location.reload(); });
+}
+
+function toggleWebhook(id) {
+    fetch("/admin/webhook/" + id + "/toggle", { method: "POST" })
+        .then(function() { location.reload(); });
+}
+
+function testWebhook(id) {
+    fetch("/admin/webhook/" + id + "/test", { method: "POST" })
+        .then(function() { setTimeout(function() { location.reload(); }, 1500); });
+}
+
+function retryDelivery(id) {
+    fetch("/admin/webhook/delivery/" + id + "/retry", { method: "POST" })
+        .then(function() { setTimeout(function() { location.reload(); }, 1500); });
+}
+
+function retryAnnouncementDelivery(id) {
+    fetch("/admin/announce/delivery/" + id + "/retry", { method: "POST" })
+        .then(function() { setTimeout(function() { location.reload(); }, 1500); });
+}
+
+// padPress types a key of a PIN or picture pad into the input with id.
+function padPress(id, value) {
+    var input = document.getElementById(id);
+    if (input) input.value += value;
+}
+
+function padClear(id) {
+    var input = document.getElementById(id);
+    if (input) input.value = '';
+}
+
+// announcementForm collects the template next to a preview or test button.
+function announcementForm(event, lang, button) {
+    return new URLSearchParams({
+        event: event,
+        lang: lang,
+        template: button.closest('tr').querySelector('input').value
+    });
+}
+
+function showAnnouncementResult(text) {
+    document.getElementById('announce-preview').textContent = text;
+}
+
+function previewAnnouncement(event, lang, button) {
+    fetch("/admin/announce/preview", { method: "POST", body: announcementForm(event, lang, button) })
+        .then(function(resp) {
+            if (!resp.ok) return resp.text().then(showAnnouncementResult);
+            return resp.json().then(function(data) { showAnnouncementResult(data.message); });
+        });
+}
+
+function testAnnouncement(event, lang, button) {
+    button.disabled = true;
+    fetch("/admin/announce/test", { method: "POST", body: announcementForm(event, lang, button) })
+        .then(function(resp) {
+            if (!resp.ok) return resp.text().then(showAnnouncementResult);
+            return resp.json().then(function(data) {
+                var lines = [data.message];
+                Object.keys(data.results).sort().forEach(function(kind) {
+                    lines.push(kind + ": " + data.results[kind]);
+                });
+                showAnnouncementResult(lines.join("\n"));
+            });
+        })
+        .finally(function() { button.disabled = false; });
+}
+
 function toggleAnnounce() {
     fetch("/admin/toggle-announce", { method: "POST" })
     .then(function(resp) { return resp.json(); })
-    .then(function(data) {
-        var btn = document.getElementById('announceToggle');
-        var on = data.ha_enabled === '1';
-        btn.classList.toggle('on', on);
-        var span = btn.querySelector('span');
-        var dict = translations[currentLang] || translations.en;
-        span.textContent = on ? (dict.announce_on || 'On') : (dict.announce_off || 'Off');
-        span.setAttribute('data-i18n', on ? 'announce_on' : 'announce_off');
-    });
+    .then(function(data) { setAnnounceToggle(data.ha_enabled === '1'); });
+}
+
+function setAnnounceToggle(on) {
+    var btn = document.getElementById('announceToggle');
+    if (!btn) return;
+    btn.classList.toggle('on', on);
+    var span = btn.querySelector('span');
+    var dict = translations[currentLang] || translations.en;
+    span.textContent = on ? (dict.announce_on || 'On') : (dict.announce_off || 'Off');
+    span.setAttribute('data-i18n', on ? 'announce_on' : 'announce_off');
 }
 
 function toggleAdultOnly(rewardId, checked) {
@@ -695,6 +979,55 @@ function sortReasonsByUser() {
     });
 }
 
+// connectLiveUpdates keeps the dashboard in sync with changes made on other devices.
+function connectLiveUpdates() {
+    if (!window.EventSource) return;
+    var source = new EventSource('/api/events');
+    var dropped = false;
+    source.onerror = function() { dropped = true; };
+    // Events sent while disconnected are lost, so start fresh after reconnecting
+    source.onopen = function() { if (dropped) location.reload(); };
+    function on(type, fn) {
+        source.addEventListener(type, function(e) { fn(JSON.parse(e.data)); });
+    }
+    on('balance.changed', function(msg) {
+        if (msg.counts) updateStarCounts(msg.counts);
+    });
+    on('star.awarded', function(msg) {
+        var d = msg.display || {};
+        addStarRow({
+            id: msg.data.star_id,
+            username: msg.data.username,
+            reason: msg.data.reason,
+            reasons: d.reason_translations,
+            awardedBy: msg.data.awarded_by,
+            icon: d.icon,
+            time: msg.created_at
+        });
+    });
+    on('star.deleted', function(msg) {
+        removeRow('tr[data-star-id="' + msg.data.star_id + '"]');
+    });
+    on('reward.redeemed', function(msg) {
+        var d = msg.display || {};
+        addRedemptionRow({
+            id: msg.data.redemption_id,
+            username: msg.data.username,
+            reward: msg.data.reward_name,
+            rewards: d.reward_translations,
+            cost: msg.data.cost,
+            icon: d.icon,
+            time: msg.created_at
+        });
+    });
+    on('redemption.deleted', function(msg) {
+        removeRow('tr[data-redemption-id="' + msg.data.redemption_id + '"]');
+    });
+    on('settings.changed', function(msg) {
+        if ('ha_enabled' in msg.data.changed) setAnnounceToggle(msg.data.changed.ha_enabled === '1');
+    });
+}
+
 // Auto-select self for non-admin users on page load; sync UI for all
 document.addEventListener('DOMContentLoaded', function() {
     // Only run selection logic on the dashboard (where member cards exist)
@@ -707,4 +1040,5 @@ document.addEventListener('DOMContentLoaded', function() {
         }
     }
     updateSelection();
+    connectLiveUpdates();
 });
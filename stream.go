@@ -0,0 +1,238 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Dashboards keep a server-sent events connection open so awards, redemptions,
+// deletions and settings changes made elsewhere show up without a reload.
+const (
+	streamKeepAlive  = 25 * time.Second
+	streamBufferSize = 32
+)
+
+// streamEventTypes are the events forwarded to live dashboards.
+var streamEventTypes = map[string]bool{
+	eventStarAwarded:       true,
+	eventStarDeleted:       true,
+	eventRewardRedeemed:    true,
+	eventRedemptionDeleted: true,
+	eventBalanceChanged:    true,
+	eventSettingsChanged:   true,
+}
+
+type streamClient struct {
+	send     chan []byte
+	familyID int
+	session  string // the session token it was opened with, "" for API keys
+	// sees reports whether the client may receive an event about userID
+	sees func(eventType string, userID int) bool
+}
+
+var (
+	streamMu      sync.Mutex
+	streamClients = make(map[*streamClient]struct{})
+)
+
+// startStream forwards published events to connected dashboards.
+func startStream() {
+	subscribeEvents(broadcastStreamEvent)
+}
+
+func addStreamClient(c *streamClient) {
+	streamMu.Lock()
+	defer streamMu.Unlock()
+	streamClients[c] = struct{}{}
+}
+
+func removeStreamClient(c *streamClient) {
+	streamMu.Lock()
+	defer streamMu.Unlock()
+	delete(streamClients, c)
+}
+
+// dropStreamClient disconnects c, unless that already happened.
+// streamMu must be held.
+func dropStreamClient(c *streamClient) {
+	if _, ok := streamClients[c]; ok {
+		delete(streamClients, c)
+		close(c.send)
+	}
+}
+
+// sessionLive reports whether the session with token may still be used.
+func sessionLive(token string, now time.Time) bool {
+	session, err := getSession(token)
+	return err == nil && !session.expired(now)
+}
+
+// closeRevokedStreams disconnects the streams of sessions that have been
+// logged out, so a signed-out device stops getting the family's events.
+func closeRevokedStreams() {
+	streamMu.Lock()
+	var clients []*streamClient
+	for c := range streamClients {
+		if c.session != "" {
+			clients = append(clients, c)
+		}
+	}
+	streamMu.Unlock()
+
+	now := time.Now()
+	for _, c := range clients {
+		if !sessionLive(c.session, now) {
+			streamMu.Lock()
+			dropStreamClient(c)
+			streamMu.Unlock()
+		}
+	}
+}
+
+func broadcastStreamEvent(e Event) {
+	if !streamEventTypes[e.Type] {
+		return
+	}
+	streamMu.Lock()
+	idle := len(streamClients) == 0
+	streamMu.Unlock()
+	if idle {
+		return
+	}
+	msg, err := streamMessage(e)
+	if err != nil {
+		return
+	}
+
+	streamMu.Lock()
+	defer streamMu.Unlock()
+	userID, _ := e.Data["user_id"].(int)
+	for c := range streamClients {
+		if e.FamilyID != c.familyID {
+			continue
+		}
+		if userID != 0 && !c.sees(e.Type, userID) {
+			continue
+		}
+		select {
+		case c.send <- msg:
+		default:
+			// A client this far behind reconnects and reloads rather than block publishers
+			dropStreamClient(c)
+		}
+	}
+}
+
+// streamMessage encodes an event as an SSE frame. Star and reward events carry
+// their translations and balance events the member's dashboard card, so pages
+// can render them without another request.
+func streamMessage(e Event) ([]byte, error) {
+	payload := map[string]interface{}{
+		"id":         e.ID,
+		"type":       e.Type,
+		"created_at": e.CreatedAt.Format(time.RFC3339),
+		"data":       e.Data,
+	}
+	switch e.Type {
+	case eventStarAwarded:
+		starID, _ := e.Data["star_id"].(int)
+		if star, err := getStarByID(starID); err == nil {
+			payload["display"] = map[string]interface{}{
+				"reason_translations": getReasonTexts(star.ReasonID, star.ReasonText),
+				"icon":                currencyIcon(star.CurrencyID),
+			}
+		}
+	case eventRewardRedeemed:
+		rewardID, _ := e.Data["reward_id"].(int)
+		redemptionID, _ := e.Data["redemption_id"].(int)
+		display := map[string]interface{}{
+			"reward_translations": getRewardTexts(rewardID),
+		}
+		if rd, err := getRedemptionByID(redemptionID); err == nil {
+			display["icon"] = rd.CurrencyIcon
+		}
+		payload["display"] = display
+	case eventBalanceChanged:
+		userID, _ := e.Data["user_id"].(int)
+		counts, _ := getUserStarCounts(e.FamilyID)
+		for _, c := range counts {
+			if c.UserID == userID {
+				payload["counts"] = []UserStarCount{c}
+			}
+		}
+	}
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return nil, err
+	}
+	return []byte(fmt.Sprintf("id: %s\nevent: %s\ndata: %s\n\n", e.ID, e.Type, body)), nil
+}
+
+// handleEventStream streams live events. Sessions see what their dashboard
+// shows: parents everything, kids their own stars and redemptions plus every
+// balance. API keys see the users they are allowed to. A session's stream
+// ends when the session does.
+func handleEventStream(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming unsupported", http.StatusInternalServerError)
+		return
+	}
+
+	user := getContextUser(r)
+	apiKey := getContextAPIKey(r)
+	session := ""
+	if apiKey == nil {
+		session = sessionToken(r)
+	}
+	client := &streamClient{
+		send:     make(chan []byte, streamBufferSize),
+		familyID: getContextFamilyID(r),
+		session:  session,
+		sees: func(eventType string, userID int) bool {
+			if apiKey != nil {
+				return apiKeyAllowsUser(r, userID)
+			}
+			return user.IsAdmin || eventType == eventBalanceChanged || userID == user.ID
+		},
+	}
+	addStreamClient(client)
+	defer removeStreamClient(client)
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	// Stop reverse proxies such as nginx from buffering the stream
+	w.Header().Set("X-Accel-Buffering", "no")
+	fmt.Fprintf(w, "retry: 5000\n\n")
+	flusher.Flush()
+
+	ticker := time.NewTicker(streamKeepAlive)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case msg, ok := <-client.send:
+			if !ok {
+				return
+			}
+			if _, err := w.Write(msg); err != nil {
+				return
+			}
+			flusher.Flush()
+		case <-ticker.C:
+			// Sessions that expired or were removed without closing the stream
+			if client.session != "" && !sessionLive(client.session, time.Now()) {
+				return
+			}
+			if _, err := fmt.Fprintf(w, ": keep-alive\n\n"); err != nil {
+				return
+			}
+			flusher.Flush()
+		}
+	}
+}
@@ -0,0 +1,37 @@
+package main
+
+import "testing"
+
+func TestCloseRevokedStreams(t *testing.T) {
+	openTestDB(t)
+	theo, err := getUserByUsername("theo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, token := range []string{"kept", "revoked"} {
+		if err := createSession(token, theo.ID, theo.FamilyID, "test", "127.0.0.1"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	clients := map[string]*streamClient{}
+	for _, session := range []string{"kept", "revoked", ""} {
+		c := &streamClient{send: make(chan []byte, 1), familyID: theo.FamilyID, session: session}
+		addStreamClient(c)
+		t.Cleanup(func() { removeStreamClient(c) })
+		clients[session] = c
+	}
+
+	deleteSession("revoked")
+	closeRevokedStreams()
+
+	for session, wantOpen := range map[string]bool{"kept": true, "revoked": false, "": true} {
+		open := true
+		select {
+		case _, open = <-clients[session].send:
+		default:
+		}
+		if open != wantOpen {
+			t.Errorf("stream of session %q open = %v, want %v", session, open, wantOpen)
+		}
+	}
+}
@@ -0,0 +1,273 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"html/template"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"rsc.io/qr"
+)
+
+// Parents can turn on two-factor login: after their password they enter a
+// 6-digit code from an authenticator app (RFC 6238, SHA-1, 30 second steps),
+// or one of the single-use recovery codes they got when turning it on. The
+// secret is kept in the user's settings, the recovery codes as SHA-256 hashes.
+const (
+	totpIssuer        = "Star Tracker"
+	totpStep          = 30 * time.Second
+	totpDigits        = 6
+	totpSkew          = 1 // steps either side of now a code is still accepted
+	recoveryCodeCount = 10
+	loginChallengeTTL = 5 * time.Minute
+)
+
+var totpEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// newTOTPSecret makes a random 160-bit secret, base32 as authenticator apps
+// expect it.
+func newTOTPSecret() (string, error) {
+	b := make([]byte, 20)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return totpEncoding.EncodeToString(b), nil
+}
+
+// totpCode is the code for secret at the given time step.
+func totpCode(secret string, step int64) string {
+	key, err := totpEncoding.DecodeString(strings.ToUpper(secret))
+	if err != nil {
+		return ""
+	}
+	var msg [8]byte
+	binary.BigEndian.PutUint64(msg[:], uint64(step))
+	mac := hmac.New(sha1.New, key)
+	mac.Write(msg[:])
+	sum := mac.Sum(nil)
+	offset := sum[len(sum)-1] & 0x0f
+	n := binary.BigEndian.Uint32(sum[offset:]) & 0x7fffffff
+	return fmt.Sprintf("%0*d", totpDigits, n%1000000)
+}
+
+// matchTOTP returns the time step code is right for at now, or 0 if it is
+// wrong. Steps up to after are refused, so a code can't be used twice.
+func matchTOTP(secret, code string, now time.Time, after int64) int64 {
+	code = strings.ReplaceAll(code, " ", "")
+	if len(code) != totpDigits {
+		return 0
+	}
+	current := now.Unix() / int64(totpStep/time.Second)
+	for step := current - totpSkew; step <= current+totpSkew; step++ {
+		if step > after && subtle.ConstantTimeCompare([]byte(totpCode(secret, step)), []byte(code)) == 1 {
+			return step
+		}
+	}
+	return 0
+}
+
+// totpURI is the otpauth:// link an authenticator app reads from the QR code.
+func totpURI(username, secret string) string {
+	label := url.PathEscape(totpIssuer + ":" + username)
+	q := url.Values{"secret": {secret}, "issuer": {totpIssuer}}
+	return "otpauth://totp/" + label + "?" + q.Encode()
+}
+
+// totpQRCode renders uri as a PNG data URL for the account page.
+func totpQRCode(uri string) template.URL {
+	code, err := qr.Encode(uri, qr.M)
+	if err != nil {
+		return ""
+	}
+	code.Scale = 6
+	return template.URL("data:image/png;base64," + base64.StdEncoding.EncodeToString(code.PNG()))
+}
+
+// twoFactorEnabled reports whether user has to enter a code after their
+// password. Only parents can turn it on.
+func twoFactorEnabled(u *User) bool {
+	return u.IsAdmin && getUserSetting(u.ID, "totp_secret") != ""
+}
+
+// twoFactorRequired reports whether a family's parents must all use
+// two-factor login.
+func twoFactorRequired(familyID int) bool {
+	return getFamilySetting(familyID, "require_2fa") == "1"
+}
+
+// needsTwoFactorSetup reports whether user has to turn on two-factor login
+// before doing anything else.
+func needsTwoFactorSetup(u *User) bool {
+	return u.IsAdmin && twoFactorRequired(u.FamilyID) && !twoFactorEnabled(u)
+}
+
+// enableTwoFactor turns on two-factor login for userID with secret, whose
+// code was just used at step, and returns new recovery codes.
+func enableTwoFactor(userID int, secret string, step int64) ([]string, error) {
+	if err := setUserSetting(userID, "totp_secret", secret); err != nil {
+		return nil, err
+	}
+	setUserSetting(userID, "totp_pending", "")
+	setUserSetting(userID, "totp_last_step", fmt.Sprint(step))
+	return newRecoveryCodes(userID)
+}
+
+func disableTwoFactor(userID int) error {
+	for _, key := range []string{"totp_secret", "totp_pending", "totp_last_step", "totp_recovery"} {
+		if err := setUserSetting(userID, key, ""); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// newRecoveryCodes replaces userID's recovery codes, returning them in the
+// only form they are ever shown.
+func newRecoveryCodes(userID int) ([]string, error) {
+	codes := make([]string, recoveryCodeCount)
+	hashes := make([]string, recoveryCodeCount)
+	for i := range codes {
+		raw, err := randomHex(4)
+		if err != nil {
+			return nil, err
+		}
+		codes[i] = raw[:4] + "-" + raw[4:]
+		hashes[i] = hashRecoveryCode(codes[i])
+	}
+	if err := setUserSetting(userID, "totp_recovery", strings.Join(hashes, ",")); err != nil {
+		return nil, err
+	}
+	return codes, nil
+}
+
+// hashRecoveryCode hashes a recovery code ignoring case, spaces and dashes.
+func hashRecoveryCode(code string) string {
+	code = strings.ToLower(strings.NewReplacer("-", "", " ", "").Replace(code))
+	sum := sha256.Sum256([]byte(code))
+	return hex.EncodeToString(sum[:])
+}
+
+func recoveryCodesLeft(userID int) int {
+	if hashes := getUserSetting(userID, "totp_recovery"); hashes != "" {
+		return len(strings.Split(hashes, ","))
+	}
+	return 0
+}
+
+// useRecoveryCode checks code against userID's recovery codes, using it up if
+// it is one.
+func useRecoveryCode(userID int, code string) bool {
+	hash := hashRecoveryCode(code)
+	hashes := strings.Split(getUserSetting(userID, "totp_recovery"), ",")
+	for i, h := range hashes {
+		if h != "" && subtle.ConstantTimeCompare([]byte(h), []byte(hash)) == 1 {
+			hashes = append(hashes[:i], hashes[i+1:]...)
+			setUserSetting(userID, "totp_recovery", strings.Join(hashes, ","))
+			return true
+		}
+	}
+	return false
+}
+
+// checkTwoFactorCode checks a code from user's authenticator app, or else a
+// recovery code, and reports whether it was right.
+func checkTwoFactorCode(u *User, code string, now time.Time) bool {
+	code = strings.TrimSpace(code)
+	secret := getUserSetting(u.ID, "totp_secret")
+	if secret == "" {
+		return false
+	}
+	var last int64
+	fmt.Sscan(getUserSetting(u.ID, "totp_last_step"), &last)
+	if step := matchTOTP(secret, code, now, last); step != 0 {
+		setUserSetting(u.ID, "totp_last_step", fmt.Sprint(step))
+		return true
+	}
+	return useRecoveryCode(u.ID, code)
+}
+
+// loginChallenge is a login whose password was right and which is waiting for
+// its second factor. They are kept in memory only: a restart means logging in
+// again.
+type loginChallenge struct {
+	UserID  int
+	Expires time.Time
+}
+
+var (
+	loginChallengesMu sync.Mutex
+	loginChallenges   = map[string]loginChallenge{}
+)
+
+// newLoginChallenge starts the second step of user's login.
+func newLoginChallenge(u *User, now time.Time) (string, error) {
+	token, err := randomHex(32)
+	if err != nil {
+		return "", err
+	}
+	loginChallengesMu.Lock()
+	defer loginChallengesMu.Unlock()
+	for t, c := range loginChallenges {
+		if now.After(c.Expires) {
+			delete(loginChallenges, t)
+		}
+	}
+	loginChallenges[token] = loginChallenge{UserID: u.ID, Expires: now.Add(loginChallengeTTL)}
+	return token, nil
+}
+
+// loginChallengeFor returns the live challenge with token.
+func loginChallengeFor(token string, now time.Time) (loginChallenge, bool) {
+	loginChallengesMu.Lock()
+	defer loginChallengesMu.Unlock()
+	c, ok := loginChallenges[token]
+	if ok && now.After(c.Expires) {
+		delete(loginChallenges, token)
+		return c, false
+	}
+	return c, ok
+}
+
+func endLoginChallenge(token string) {
+	loginChallengesMu.Lock()
+	defer loginChallengesMu.Unlock()
+	delete(loginChallenges, token)
+}
+
+// twoFactorSnapshot describes a user's two-factor login for the audit log,
+// without the secret or codes.
+func twoFactorSnapshot(u *User) map[string]interface{} {
+	return map[string]interface{}{
+		"two_factor":     twoFactorEnabled(u),
+		"recovery_codes": recoveryCodesLeft(u.ID),
+	}
+}
+
+// twoFactorView is a parent's two-factor login for the admin page.
+type twoFactorView struct {
+	UserID        int
+	Username      string
+	Enabled       bool
+	RecoveryCodes int
+}
+
+func twoFactorViews(users []User) []twoFactorView {
+	var views []twoFactorView
+	for i := range users {
+		if users[i].IsAdmin {
+			views = append(views, twoFactorView{users[i].ID, users[i].Username, twoFactorEnabled(&users[i]), recoveryCodesLeft(users[i].ID)})
+		}
+	}
+	return views
+}
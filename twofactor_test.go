@@ -0,0 +1,99 @@
+package main
+
+import (
+	"context"
+	"html/template"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+// accountRequest posts form to the account page handler h as user.
+func accountRequest(t *testing.T, h http.HandlerFunc, user *User, form url.Values) *httptest.ResponseRecorder {
+	t.Helper()
+	r := httptest.NewRequest("POST", "/account", strings.NewReader(form.Encode()))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	ctx := context.WithValue(r.Context(), userContextKey, user)
+	ctx = context.WithValue(ctx, familyContextKey, user.FamilyID)
+	w := httptest.NewRecorder()
+	h(w, r.WithContext(ctx))
+	return w
+}
+
+// parentWithTwoFactor turns two-factor login on for dad, returning him and
+// the secret, with the account page ready to render.
+func parentWithTwoFactor(t *testing.T) (*User, string) {
+	t.Helper()
+	openTestDB(t)
+	templates = map[string]*template.Template{}
+	t.Cleanup(func() { templates = nil })
+	templates["account.html"] = template.Must(template.New("account.html").Funcs(templateFuncs).ParseFS(templateFS, "templates/layout.html", "templates/account.html"))
+	dad, err := getUserByUsername("dad")
+	if err != nil {
+		t.Fatal(err)
+	}
+	secret, err := newTOTPSecret()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := enableTwoFactor(dad.ID, secret, 0); err != nil {
+		t.Fatal(err)
+	}
+	return dad, secret
+}
+
+func TestAccountTwoFactorCodesAreThrottled(t *testing.T) {
+	dad, secret := parentWithTwoFactor(t)
+
+	// Setting up again would replace the secret without knowing a code
+	accountRequest(t, handleTwoFactorSetup, dad, nil)
+	if pending := getUserSetting(dad.ID, "totp_pending"); pending != "" {
+		t.Errorf("setup with two-factor on stored a new secret")
+	}
+
+	recovery := getUserSetting(dad.ID, "totp_recovery")
+	accountRequest(t, handleRecoveryCodes, dad, url.Values{"code": {"000000"}})
+	if throttle, _ := getLoginThrottle("totp", "dad"); throttle.Failures != 1 {
+		t.Fatalf("wrong code counted %d failures, want 1", throttle.Failures)
+	}
+	for i := 1; i < loginPolicies["totp"].lockAfter; i++ {
+		recordLoginFailure("totp", "dad", dad, "192.0.2.1", time.Now())
+	}
+	clearLoginThrottle("ip", "192.0.2.1")
+	right := totpCode(secret, time.Now().Unix()/int64(totpStep/time.Second))
+	if w := accountRequest(t, handleRecoveryCodes, dad, url.Values{"code": {right}}); w.Code != http.StatusTooManyRequests {
+		t.Errorf("right code while locked out: status %d, want %d", w.Code, http.StatusTooManyRequests)
+	}
+	if got := getUserSetting(dad.ID, "totp_recovery"); got != recovery {
+		t.Errorf("recovery codes replaced while locked out")
+	}
+	if w := accountRequest(t, handleTwoFactorDisable, dad, url.Values{"password": {"test-password"}, "code": {right}}); w.Code != http.StatusTooManyRequests {
+		t.Errorf("disable while locked out: status %d, want %d", w.Code, http.StatusTooManyRequests)
+	}
+	if !twoFactorEnabled(dad) {
+		t.Errorf("two-factor login turned off while locked out")
+	}
+}
+
+func TestTwoFactorDisablePasswordIsThrottled(t *testing.T) {
+	dad, secret := parentWithTwoFactor(t)
+
+	accountRequest(t, handleTwoFactorDisable, dad, url.Values{"password": {"wrong"}, "code": {"000000"}})
+	if throttle, _ := getLoginThrottle("user", "dad"); throttle.Failures != 1 {
+		t.Fatalf("wrong password counted %d failures, want 1", throttle.Failures)
+	}
+	for i := 1; i < loginPolicies["user"].lockAfter; i++ {
+		recordLoginFailure("user", "dad", dad, "192.0.2.1", time.Now())
+	}
+	clearLoginThrottle("ip", "192.0.2.1")
+	right := totpCode(secret, time.Now().Unix()/int64(totpStep/time.Second))
+	if w := accountRequest(t, handleTwoFactorDisable, dad, url.Values{"password": {"test-password"}, "code": {right}}); w.Code != http.StatusTooManyRequests {
+		t.Errorf("right password while locked out: status %d, want %d", w.Code, http.StatusTooManyRequests)
+	}
+	if !twoFactorEnabled(dad) {
+		t.Errorf("two-factor login turned off while locked out")
+	}
+}
@@ -0,0 +1,292 @@
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"syscall"
+	"time"
+)
+
+// Webhooks receive every subscribed event as a signed JSON POST. Deliveries
+// are queued in SQLite so they survive restarts, and failed attempts are
+// retried with exponential backoff until webhookMaxAttempts is reached.
+const (
+	webhookMaxAttempts  = 8
+	webhookBaseBackoff  = 30 * time.Second
+	webhookMaxBackoff   = time.Hour
+	webhookPollInterval = 15 * time.Second
+	webhookLogRetention = 30 * 24 * time.Hour
+)
+
+// Delivery states of webhooks and announcements
+const (
+	deliveryPending   = "pending"
+	deliveryDelivered = "delivered"
+	deliveryFailed    = "failed"
+)
+
+var webhookClient = &http.Client{Timeout: 10 * time.Second}
+
+// Webhooks of the super-admin's family may reach the local network, where
+// Home Assistant or Node-RED usually run. Other families' webhooks only reach
+// public addresses, checked as each connection is made so a host name can't
+// be pointed somewhere else after it was saved.
+var publicWebhookClient = &http.Client{
+	Timeout: 10 * time.Second,
+	Transport: &http.Transport{
+		DialContext:         (&net.Dialer{Timeout: 10 * time.Second, Control: dialPublicOnly}).DialContext,
+		TLSHandshakeTimeout: 10 * time.Second,
+	},
+}
+
+var errPrivateAddress = errors.New("webhook address is not public")
+
+// publicIP reports whether ip is reachable on the internet rather than this
+// machine or its local network.
+func publicIP(ip net.IP) bool {
+	return !(ip.IsLoopback() || ip.IsPrivate() || ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() ||
+		ip.IsInterfaceLocalMulticast() || ip.IsMulticast() || ip.IsUnspecified())
+}
+
+func dialPublicOnly(network, address string, _ syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	if ip := net.ParseIP(host); ip == nil || !publicIP(ip) {
+		return errPrivateAddress
+	}
+	return nil
+}
+
+// webhookClientFor is the client that delivers familyID's webhooks.
+func webhookClientFor(familyID int) *http.Client {
+	if familyID == superAdminFamilyID() {
+		return webhookClient
+	}
+	return publicWebhookClient
+}
+
+// webhookURLAllowed reports whether familyID may send webhooks to raw's host:
+// anywhere for the super-admin's family, else only to public addresses.
+func webhookURLAllowed(familyID int, raw string) bool {
+	if familyID == superAdminFamilyID() {
+		return true
+	}
+	u, err := url.Parse(raw)
+	if err != nil {
+		return false
+	}
+	ips, err := net.LookupIP(u.Hostname())
+	if err != nil || len(ips) == 0 {
+		return false
+	}
+	for _, ip := range ips {
+		if !publicIP(ip) {
+			return false
+		}
+	}
+	return true
+}
+
+// webhookWake nudges the worker when new deliveries are queued.
+var webhookWake = make(chan struct{}, 1)
+
+// startWebhooks subscribes webhooks to events and starts the delivery worker.
+func startWebhooks() {
+	subscribeEvents(enqueueWebhookEvent)
+	go runWebhookWorker()
+}
+
+func wakeWebhookWorker() {
+	select {
+	case webhookWake <- struct{}{}:
+	default:
+	}
+}
+
+// validWebhookURL accepts absolute http and https URLs.
+func validWebhookURL(raw string) bool {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return false
+	}
+	return (u.Scheme == "http" || u.Scheme == "https") && u.Host != ""
+}
+
+// webhookWantsEvent reports whether a webhook subscribes to eventType.
+// A webhook with no event filter receives everything.
+func webhookWantsEvent(h *Webhook, eventType string) bool {
+	if len(h.Events) == 0 {
+		return true
+	}
+	for _, t := range h.Events {
+		if t == eventType {
+			return true
+		}
+	}
+	return false
+}
+
+func enqueueWebhookEvent(e Event) {
+	hooks, err := getWebhooks(e.FamilyID)
+	if err != nil || len(hooks) == 0 {
+		return
+	}
+	payload, err := eventJSON(e)
+	if err != nil {
+		log.Printf("Failed to encode %s event: %v", e.Type, err)
+		return
+	}
+	queued := false
+	for i := range hooks {
+		h := &hooks[i]
+		if !h.Enabled || !webhookWantsEvent(h, e.Type) {
+			continue
+		}
+		if err := addWebhookDelivery(h.ID, e, payload); err != nil {
+			log.Printf("Failed to queue webhook %d delivery: %v", h.ID, err)
+			continue
+		}
+		queued = true
+	}
+	if queued {
+		wakeWebhookWorker()
+	}
+}
+
+// queueWebhookPing sends a ping event to a single webhook so its receiver can be tested.
+func queueWebhookPing(h *Webhook) error {
+	e, err := newEvent(h.FamilyID, eventPing, map[string]interface{}{
+		"webhook_id": h.ID,
+		"url":        h.URL,
+	})
+	if err != nil {
+		return err
+	}
+	payload, err := eventJSON(e)
+	if err != nil {
+		return err
+	}
+	if err := addWebhookDelivery(h.ID, e, payload); err != nil {
+		return err
+	}
+	wakeWebhookWorker()
+	return nil
+}
+
+func runWebhookWorker() {
+	ticker := time.NewTicker(webhookPollInterval)
+	defer ticker.Stop()
+	for {
+		deliverDueWebhooks()
+		pruneWebhookDeliveries(time.Now().Add(-webhookLogRetention))
+		select {
+		case <-ticker.C:
+		case <-webhookWake:
+		}
+	}
+}
+
+func deliverDueWebhooks() {
+	deliveries, err := getDueWebhookDeliveries(time.Now(), 50)
+	if err != nil {
+		log.Printf("Failed to load webhook deliveries: %v", err)
+		return
+	}
+	for i := range deliveries {
+		attemptWebhookDelivery(&deliveries[i])
+	}
+}
+
+func attemptWebhookDelivery(d *WebhookDelivery) {
+	hook, err := getWebhookByID(d.WebhookID)
+	if err != nil {
+		return
+	}
+	statusCode, sendErr := sendWebhook(hook, d)
+	d.Attempts++
+	switch {
+	case sendErr == nil:
+		err = updateWebhookDelivery(d.ID, deliveryDelivered, d.Attempts, statusCode, "", nil)
+	case d.Attempts >= webhookMaxAttempts:
+		log.Printf("Webhook delivery %d to %s failed after %d attempts: %v", d.ID, hook.URL, d.Attempts, sendErr)
+		err = updateWebhookDelivery(d.ID, deliveryFailed, d.Attempts, statusCode, sendErr.Error(), nil)
+	default:
+		next := time.Now().Add(webhookBackoff(d.Attempts))
+		err = updateWebhookDelivery(d.ID, deliveryPending, d.Attempts, statusCode, sendErr.Error(), &next)
+	}
+	if err != nil {
+		log.Printf("Failed to record webhook delivery %d: %v", d.ID, err)
+	}
+}
+
+// webhookBackoff doubles the wait after each failed attempt, up to webhookMaxBackoff.
+func webhookBackoff(attempts int) time.Duration {
+	return exponentialBackoff(attempts, webhookBaseBackoff, webhookMaxBackoff)
+}
+
+// exponentialBackoff waits base after the first failed attempt and twice as
+// long after each one since, up to max.
+func exponentialBackoff(attempts int, base, max time.Duration) time.Duration {
+	if attempts < 1 {
+		attempts = 1
+	}
+	wait := base
+	for i := 1; i < attempts && wait < max; i++ {
+		wait *= 2
+	}
+	if wait > max {
+		wait = max
+	}
+	return wait
+}
+
+// signWebhookPayload returns the X-Star-Signature value: an HMAC-SHA256 of
+// "<timestamp>.<body>" keyed with the webhook secret.
+func signWebhookPayload(secret string, timestamp int64, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	fmt.Fprintf(mac, "%d.", timestamp)
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// sendWebhook posts one delivery and returns the response status code.
+// Any non-2xx response is treated as a failure.
+func sendWebhook(h *Webhook, d *WebhookDelivery) (int, error) {
+	body := []byte(d.Payload)
+	timestamp := time.Now().Unix()
+
+	req, err := http.NewRequest("POST", h.URL, bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "star-app-webhooks")
+	req.Header.Set("X-Star-Event", d.EventType)
+	req.Header.Set("X-Star-Event-Id", d.EventID)
+	req.Header.Set("X-Star-Delivery", strconv.Itoa(d.ID))
+	req.Header.Set("X-Star-Timestamp", strconv.FormatInt(timestamp, 10))
+	req.Header.Set("X-Star-Signature", signWebhookPayload(h.Secret, timestamp, body))
+
+	resp, err := webhookClientFor(h.FamilyID).Do(req)
+	if err != nil {
+		return 0, err
+	}
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return resp.StatusCode, fmt.Errorf("receiver returned status %d", resp.StatusCode)
+	}
+	return resp.StatusCode, nil
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestWebhookURLAllowed(t *testing.T) {
+	openTestDB(t)
+	home := superAdminFamilyID()
+	other, err := createFamily("Other", "other-parent", "test-password")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		familyID int
+		url      string
+		want     bool
+	}{
+		{home, "http://127.0.0.1:8123/api/webhook/stars", true},
+		{home, "http://192.168.1.10:1880/stars", true},
+		{other, "http://127.0.0.1:8123/api/webhook/stars", false},
+		{other, "http://[::1]:8123/", false},
+		{other, "http://169.254.169.254/latest/meta-data/", false},
+		{other, "http://10.0.0.5/", false},
+		{other, "http://192.168.1.10:1880/stars", false},
+		{other, "http://[::ffff:127.0.0.1]/", false},
+		{other, "https://93.184.216.34/hook", true},
+	}
+	for _, tt := range tests {
+		if got := webhookURLAllowed(tt.familyID, tt.url); got != tt.want {
+			t.Errorf("family %d %s allowed = %v, want %v", tt.familyID, tt.url, got, tt.want)
+		}
+	}
+}
+
+func TestPublicWebhookClientRefusesLocalAddresses(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer server.Close()
+
+	if _, err := publicWebhookClient.Get(server.URL); !errors.Is(err, errPrivateAddress) {
+		t.Errorf("public client reached %s: %v", server.URL, err)
+	}
+	resp, err := webhookClient.Get(server.URL)
+	if err != nil {
+		t.Fatalf("local client: %v", err)
+	}
+	resp.Body.Close()
+}